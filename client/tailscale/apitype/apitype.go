@@ -5,11 +5,58 @@
 // Package apitype contains types for the Tailscale LocalAPI and control plane API.
 package apitype
 
-import "tailscale.com/tailcfg"
+import (
+	"time"
+
+	"tailscale.com/tailcfg"
+)
 
 // LocalAPIHost is the Host header value used by the LocalAPI.
 const LocalAPIHost = "local-tailscaled.sock"
 
+// VerboseErrorsHeader is the request header that, when set to "1" by a
+// LocalAPI caller with write access, asks for error responses to include
+// the chain of underlying errors rather than just the top-level message.
+const VerboseErrorsHeader = "Tailscale-Verbose-Errors"
+
+// ConnIntentHeader is the request header with which a LocalAPI client says
+// how it means to use its connection, so that tailscaled can tune its
+// keep-alive handling. Its value is ConnIntentPersistent or
+// ConnIntentOneShot; without it, tailscaled treats the connection as it
+// would a persistent one.
+const ConnIntentHeader = "Tailscale-Conn-Intent"
+
+const (
+	// ConnIntentPersistent is for clients, like GUIs, that keep their
+	// connection for a series of requests or long polls.
+	ConnIntentPersistent = "persistent"
+
+	// ConnIntentOneShot is for clients, like the CLI, that make a request
+	// or a few and exit. tailscaled closes their connections after each
+	// response rather than keeping them idle, so that on Windows they don't
+	// lock out other users while idle.
+	ConnIntentOneShot = "one-shot"
+)
+
+// IdleTimeoutHeader is the request header with which a LocalAPI client
+// asks tailscaled to keep its connection open while idle for longer (or
+// shorter) than usual, such as "2m", for GUIs on unreliable transports
+// that would rather not reconnect. It applies to the rest of the
+// connection. Values above the server's maximum are rejected with 400 Bad
+// Request; servers without a maximum ignore the header.
+const IdleTimeoutHeader = "Tailscale-Idle-Timeout"
+
+// WatcherIDHeader is the response header on a watcher subscription, such
+// as the IPN bus, giving the watcher's ID. The watcher can pass it to the
+// LocalAPI watcher-close endpoint to end the subscription gracefully.
+const WatcherIDHeader = "Tailscale-Watcher-Id"
+
+// StreamClosedHeader is the request header with which a watcher
+// subscription, such as the IPN bus, asks for a final StreamClosed message
+// if tailscaled ends the stream. It must be "1". Without it, no such
+// message is sent, as older clients would decode it as a notification.
+const StreamClosedHeader = "Tailscale-Stream-Closed"
+
 // WhoIsResponse is the JSON type returned by tailscaled debug server's /whois?ip=$IP handler.
 type WhoIsResponse struct {
 	Node        *tailcfg.Node
@@ -33,3 +80,275 @@ type WaitingFile struct {
 	Name string
 	Size int64
 }
+
+// Watcher describes a long-lived LocalAPI subscription, such as an IPN bus
+// watcher, as returned by the LocalAPI watchers/ endpoint.
+type Watcher struct {
+	// ID identifies the watcher for the lifetime of the tailscaled
+	// process. It can be used to terminate the watcher.
+	ID int64
+
+	// Path is the LocalAPI path of the subscription.
+	Path string
+
+	// UserID is the userid (uid on Unix, SID on Windows) of the
+	// watching process, if known.
+	UserID string `json:",omitempty"`
+
+	// PID is the process ID of the watching process, if known.
+	PID int `json:",omitempty"`
+
+	// Started is when the subscription began.
+	Started time.Time
+}
+
+// ProxyTunnel is an active CONNECT tunnel through tailscaled's LocalAPI
+// server, as used by the Windows GUI to reach the log server when an exit
+// node is in use.
+type ProxyTunnel struct {
+	// ID identifies the tunnel for the lifetime of the tailscaled process.
+	ID int64
+
+	// Target is the "host:port" the tunnel connects to.
+	Target string
+
+	// UserID is the userid (SID on Windows) of the process that opened the
+	// tunnel, if known.
+	UserID string `json:",omitempty"`
+
+	// Username is the name of the user that opened the tunnel, if known.
+	Username string `json:",omitempty"`
+
+	// PID is the process ID of the process that opened the tunnel, if
+	// known.
+	PID int `json:",omitempty"`
+
+	// Started is when the tunnel was established.
+	Started time.Time
+
+	// BytesSent is the number of bytes copied from the client to Target.
+	BytesSent int64
+
+	// BytesReceived is the number of bytes copied from Target to the
+	// client.
+	BytesReceived int64
+}
+
+// UserSwitch is a change of the user using tailscaled, as returned by the
+// LocalAPI user-switches endpoint. Only Windows has one user at a time.
+type UserSwitch struct {
+	// Time is when the new user's first request arrived.
+	Time time.Time
+
+	// PrevUserID is the SID of the previous user, or empty if there
+	// was none since tailscaled started.
+	PrevUserID string `json:",omitempty"`
+
+	// UserID is the SID of the new user.
+	UserID string
+
+	// Reset is whether the backend's state was reset for the switch, so
+	// as not to leak the previous user's state to the new one.
+	Reset bool
+}
+
+// LockHolder is the JSON type returned by the LocalAPI lock-holder
+// endpoint. It describes the connection holding tailscaled, which serves
+// only one user at a time (on Windows) and denies other users while held.
+type LockHolder struct {
+	// InUse is whether any connection holds tailscaled. If false, the
+	// other fields are zero.
+	InUse bool
+
+	// Username is the name of the user holding tailscaled, if known.
+	Username string `json:",omitempty"`
+
+	// PID is the process ID of the holding process, if known.
+	PID int `json:",omitempty"`
+
+	// Since is when the holder's oldest in-flight request began.
+	Since time.Time
+}
+
+// WouldAccept is the JSON type returned by the LocalAPI would-accept
+// endpoint. It reports whether tailscaled would currently accept a LocalAPI
+// connection from a given process, and if not, why.
+type WouldAccept struct {
+	// PID is the process ID that was asked about.
+	PID int
+
+	// Username is the name of the user owning the process, if known.
+	Username string `json:",omitempty"`
+
+	// Accepted is whether a connection from the process would be accepted.
+	Accepted bool
+
+	// Reason is why the connection would be rejected, if it would be.
+	Reason string `json:",omitempty"`
+
+	// OtherUser and OtherPID are the user and process holding tailscaled,
+	// if that's why the connection would be rejected and they're known.
+	OtherUser string `json:",omitempty"`
+	OtherPID  int    `json:",omitempty"`
+}
+
+// VersionResponse is the JSON type returned by the LocalAPI version
+// endpoint.
+type VersionResponse struct {
+	// Short and Long are the daemon's short ("1.2.3") and long
+	// ("1.2.3-tabcdef") versions.
+	Short string
+	Long  string
+
+	// GitCommit is the git commit the daemon was built from, if known.
+	GitCommit string `json:",omitempty"`
+
+	// MinRecommendedClientVersion is the oldest client version the daemon
+	// recommends using with it. Clients older than this should suggest
+	// that the user upgrade.
+	MinRecommendedClientVersion string
+
+	// BuildFeatures are the optional, build-tag-gated subsystems compiled
+	// into the daemon, such as "fault-injection".
+	BuildFeatures []string `json:",omitempty"`
+}
+
+// CertStatus is the JSON type returned by the LocalAPI cert-status
+// endpoint.
+type CertStatus struct {
+	// Available is whether tailscaled can fetch TLS certs at all,
+	// regardless of the caller's permission.
+	Available bool
+
+	// Reason is why certs aren't available, if they aren't.
+	Reason string `json:",omitempty"`
+
+	// Permitted is whether the caller may fetch certs.
+	Permitted bool
+}
+
+// LogUploadStatus is the JSON type returned by the LocalAPI
+// log-upload-status endpoint.
+type LogUploadStatus struct {
+	// LogID is the backend's public log ID, to give to support.
+	LogID string
+
+	// State is LogUploadIdle, LogUploadUploading, or LogUploadUnknown if
+	// tailscaled doesn't report its log uploads.
+	State string
+
+	// LastSuccess and LastFailure are when an upload last succeeded and
+	// failed, or zero values if none has. LastError is why the last
+	// failure failed.
+	LastSuccess time.Time
+	LastFailure time.Time
+	LastError   string `json:",omitempty"`
+
+	// BytesUploaded is the number of bytes of logs uploaded, as sent.
+	BytesUploaded int64
+}
+
+// Values of LogUploadStatus.State.
+const (
+	LogUploadIdle      = "idle"
+	LogUploadUploading = "uploading"
+	LogUploadUnknown   = "unknown"
+)
+
+// LocalAPIRoute describes a LocalAPI endpoint, as returned by the LocalAPI
+// schema endpoint.
+type LocalAPIRoute struct {
+	// Path is the endpoint's path, such as "/localapi/v0/status". If it
+	// ends in a slash, the endpoint also handles paths beneath it.
+	Path string
+
+	// Methods are the HTTP methods the endpoint accepts.
+	Methods []string
+
+	// Permission is the permission a caller needs: "none", "read",
+	// "write", or "cert" (write, or the cert-fetching grant).
+	Permission string
+
+	// Description is a one-line description of the endpoint.
+	Description string
+}
+
+// StreamClosed is the final message tailscaled sends on a streaming
+// LocalAPI response, such as the IPN bus, when it ends the stream itself
+// rather than the client going away. It's only sent to clients that ask
+// for it with StreamClosedHeader.
+type StreamClosed struct {
+	// Closed is why the stream was closed: "shutdown" if tailscaled is
+	// shutting down, "terminated" if the stream was ended through the
+	// watchers endpoint, or "requested" if the watcher itself asked for
+	// it through the watcher-close endpoint.
+	Closed string `json:"closed"`
+}
+
+// InUseOtherUserCode is the InUseOtherUserError.Code value.
+const InUseOtherUserCode = "in_use_other_user"
+
+// InUseOtherUserError is the JSON body of the 401 Unauthorized response
+// to a LocalAPI request refused because another local user is using
+// tailscaled.
+type InUseOtherUserError struct {
+	// Code is always InUseOtherUserCode, distinguishing this error from
+	// other refusals.
+	Code string `json:"code"`
+
+	// Error is a human-readable description of the error.
+	Error string `json:"error"`
+
+	// OtherUser is the username of the user using tailscaled, if known.
+	OtherUser string `json:"otherUser,omitempty"`
+
+	// OtherPID is the process ID of the other user's client, if known.
+	OtherPID int `json:"otherPid,omitempty"`
+}
+
+// Ways tailscaled authenticates LocalAPI connections, as listed in
+// AuthInfo.Methods.
+const (
+	// AuthMethodPeerCreds is the unix socket peer's credentials.
+	AuthMethodPeerCreds = "peer-creds"
+	// AuthMethodProcessOwner is the user owning the connecting process,
+	// as used for localhost TCP on Windows.
+	AuthMethodProcessOwner = "process-owner"
+	// AuthMethodInProcess is for connections from within tailscaled.
+	AuthMethodInProcess = "in-process"
+	// AuthMethodCustom is an embedder-provided identity resolver.
+	AuthMethodCustom = "custom"
+)
+
+// AuthInfo is the JSON type returned by the LocalAPI auth-info endpoint,
+// which tells a client how its connection is authenticated before it
+// makes other requests.
+type AuthInfo struct {
+	// Transport is the kind of connection the client is on, such as
+	// "unix" or "tcp".
+	Transport string
+
+	// Methods are the ways tailscaled authenticates connections on
+	// Transport, most specific first. It's empty if it has none, in
+	// which case connections on Transport get no access.
+	Methods []string
+
+	// Satisfied is whether the client's connection is authenticated well
+	// enough for at least read access.
+	Satisfied bool
+
+	// Permission is the client's access: "none", "read" or "write".
+	Permission string
+}
+
+// ConnTrace is the JSON type returned by the LocalAPI conn-trace
+// endpoint, describing the running trace of one user's LocalAPI
+// connections.
+type ConnTrace struct {
+	// UserID is the userid (uid, or SID on Windows) being traced, or
+	// empty if there's no trace running.
+	UserID string `json:",omitempty"`
+
+	// Until is when the trace expires.
+	Until time.Time `json:",omitempty"`
+}
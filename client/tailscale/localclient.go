@@ -66,6 +66,16 @@ type LocalClient struct {
 	// connecting to the GUI client variants.
 	UseSocketOnly bool
 
+	// ConnIntent optionally says how the client uses its connections to
+	// tailscaled: apitype.ConnIntentPersistent or
+	// apitype.ConnIntentOneShot. If empty, no intent is sent.
+	ConnIntent string
+
+	// IdleTimeout, if positive, asks tailscaled to keep the client's
+	// connections open while idle for this long, within the server's
+	// maximum; see apitype.IdleTimeoutHeader.
+	IdleTimeout time.Duration
+
 	// tsClient does HTTP requests to the local Tailscale daemon.
 	// It's lazily initialized on first use.
 	tsClient     *http.Client
@@ -126,6 +136,12 @@ func (lc *LocalClient) DoLocalRequest(req *http.Request) (*http.Response, error)
 	if _, token, err := safesocket.LocalTCPPortAndToken(); err == nil {
 		req.SetBasicAuth("", token)
 	}
+	if lc.ConnIntent != "" {
+		req.Header.Set(apitype.ConnIntentHeader, lc.ConnIntent)
+	}
+	if lc.IdleTimeout > 0 {
+		req.Header.Set(apitype.IdleTimeoutHeader, lc.IdleTimeout.String())
+	}
 	return lc.tsClient.Do(req)
 }
 
@@ -239,6 +255,38 @@ func decodeJSON[T any](b []byte) (ret T, err error) {
 	return ret, nil
 }
 
+// DaemonVersion returns the version of the local tailscaled, along with the
+// oldest client version it recommends using with it.
+func (lc *LocalClient) DaemonVersion(ctx context.Context) (*apitype.VersionResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/version")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.VersionResponse](body)
+}
+
+// LockHolder returns which user, if any, is currently using tailscaled. On
+// Windows, where tailscaled serves one user at a time, a GUI can use it to
+// explain why it's been denied access. It's available to all users.
+func (lc *LocalClient) LockHolder(ctx context.Context) (*apitype.LockHolder, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/lock-holder")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.LockHolder](body)
+}
+
+// WouldAccept reports whether tailscaled would currently accept a LocalAPI
+// connection from process pid, and if not, why. It's only supported on
+// Windows.
+func (lc *LocalClient) WouldAccept(ctx context.Context, pid int) (*apitype.WouldAccept, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/would-accept?pid="+strconv.Itoa(pid))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.WouldAccept](body)
+}
+
 // WhoIs returns the owner of the remoteAddr, which must be an IP or IP:port.
 func (lc *LocalClient) WhoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
 	body, err := lc.get200(ctx, "/localapi/v0/whois?addr="+url.QueryEscape(remoteAddr))
@@ -407,6 +455,25 @@ func (lc *LocalClient) StatusWithoutPeers(ctx context.Context) (*ipnstate.Status
 	return lc.status(ctx, "?peers=false")
 }
 
+// StatusSummary returns the Tailscale daemon's status summary: the
+// lightweight subset of its status describing only this node, which is
+// quicker to fetch than the full status on large tailnets.
+func (lc *LocalClient) StatusSummary(ctx context.Context) (*ipnstate.StatusSummary, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/status?summary=1")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.StatusSummary](body)
+}
+
+// StatusWithFields returns the Tailscale daemon's status with only the given
+// field groups populated ("self", "health", "tailnet", "exitnode", "peers"
+// or "peercount") along with its version and backend state. It's cheaper
+// than Status on large tailnets when peer details aren't needed.
+func (lc *LocalClient) StatusWithFields(ctx context.Context, fields ...string) (*ipnstate.Status, error) {
+	return lc.status(ctx, "?fields="+url.QueryEscape(strings.Join(fields, ",")))
+}
+
 func (lc *LocalClient) status(ctx context.Context, queryString string) (*ipnstate.Status, error) {
 	body, err := lc.get200(ctx, "/localapi/v0/status"+queryString)
 	if err != nil {
@@ -709,6 +776,36 @@ func GetCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	return defaultLocalClient.GetCertificate(hi)
 }
 
+// CertDomains returns the DNS domains that CertPair can currently fetch
+// certificates for, given the node's configuration.
+func (lc *LocalClient) CertDomains(ctx context.Context) ([]string, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/cert-domains")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]string](body)
+}
+
+// LogUploadStatus returns the backend's log ID and the status of the
+// uploads of its logs, as for a bug report.
+func (lc *LocalClient) LogUploadStatus(ctx context.Context) (*apitype.LogUploadStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/log-upload-status")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.LogUploadStatus](body)
+}
+
+// CertStatus reports whether tailscaled can fetch TLS certificates at all,
+// and whether the caller may fetch them.
+func (lc *LocalClient) CertStatus(ctx context.Context) (*apitype.CertStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/cert-status")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.CertStatus](body)
+}
+
 // GetCertificate fetches a TLS certificate for the TLS ClientHello in hi.
 //
 // It returns a cached certificate from disk if it's still valid.
@@ -1008,6 +1105,7 @@ func (lc *LocalClient) WatchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt)
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set(apitype.StreamClosedHeader, "1")
 	res, err := lc.doLocalRequestNiceError(req)
 	if err != nil {
 		return nil, err
@@ -1017,8 +1115,11 @@ func (lc *LocalClient) WatchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt)
 		return nil, errors.New(res.Status)
 	}
 	dec := json.NewDecoder(res.Body)
+	id, _ := strconv.ParseInt(res.Header.Get(apitype.WatcherIDHeader), 10, 64)
 	return &IPNBusWatcher{
 		ctx:     ctx,
+		lc:      lc,
+		id:      id,
 		httpRes: res,
 		dec:     dec,
 	}, nil
@@ -1030,6 +1131,8 @@ func (lc *LocalClient) WatchIPNBus(ctx context.Context, mask ipn.NotifyWatchOpt)
 // It must be closed when done.
 type IPNBusWatcher struct {
 	ctx     context.Context // from original WatchIPNBus call
+	lc      *LocalClient
+	id      int64 // tailscaled's ID for the watcher, or 0 if unknown
 	httpRes *http.Response
 	dec     *json.Decoder
 
@@ -1048,15 +1151,62 @@ func (w *IPNBusWatcher) Close() error {
 	return w.httpRes.Body.Close()
 }
 
+// CloseGracefully asks tailscaled to end the watch, reads any remaining
+// messages up to tailscaled's acknowledgment that the stream has ended, and
+// then closes the watcher. Unlike Close, it doesn't cut off tailscaled
+// mid-notification. Messages read are discarded; callers wanting them
+// should stop calling Next only once it returns a *StreamClosedError.
+//
+// With a tailscaled too old to support it, it's equivalent to Close.
+func (w *IPNBusWatcher) CloseGracefully(ctx context.Context) error {
+	if w.id == 0 {
+		return w.Close()
+	}
+	errc := make(chan error, 1)
+	go func() {
+		// Sent concurrently with reading the stream, as tailscaled doesn't
+		// respond until it has written its final message.
+		_, err := w.lc.send(ctx, "POST", "/localapi/v0/watcher-close?id="+strconv.FormatInt(w.id, 10), http.StatusNoContent, nil)
+		errc <- err
+	}()
+	for {
+		// Read through to tailscaled's final StreamClosed message, or to
+		// whatever else ended the stream.
+		if _, err := w.Next(); err != nil {
+			break
+		}
+	}
+	w.Close()
+	return <-errc
+}
+
 // Next returns the next ipn.Notify from the stream.
 // If the context from LocalClient.WatchIPNBus is done, that error is returned.
+// If tailscaled ended the stream, a *StreamClosedError is returned.
 func (w *IPNBusWatcher) Next() (ipn.Notify, error) {
-	var n ipn.Notify
+	var n struct {
+		ipn.Notify
+		apitype.StreamClosed
+	}
 	if err := w.dec.Decode(&n); err != nil {
 		if cerr := w.ctx.Err(); cerr != nil {
 			err = cerr
 		}
 		return ipn.Notify{}, err
 	}
-	return n, nil
+	if n.Closed != "" {
+		return ipn.Notify{}, &StreamClosedError{Reason: n.Closed}
+	}
+	return n.Notify, nil
+}
+
+// StreamClosedError is returned by IPNBusWatcher.Next when tailscaled ended
+// the stream itself, such as when it's shutting down.
+type StreamClosedError struct {
+	// Reason is why tailscaled closed the stream. See apitype.StreamClosed.
+	Reason string
+}
+
+func (e *StreamClosedError) Error() string {
+	return "tailscaled closed the IPN bus stream: " + e.Reason
 }
@@ -23,6 +23,7 @@
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"golang.org/x/exp/slices"
 	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
 	"tailscale.com/paths"
 	"tailscale.com/version/distro"
@@ -211,6 +212,7 @@ func Run(args []string) (err error) {
 	}
 
 	localClient.Socket = rootArgs.socket
+	localClient.ConnIntent = apitype.ConnIntentOneShot
 	rootfs.Visit(func(f *flag.Flag) {
 		if f.Name == "socket" {
 			localClient.UseSocketOnly = true
@@ -475,6 +475,27 @@ func run() error {
 	configureTaildrop(logf, lb)
 
 	srv := ipnserver.New(logf, logid)
+	srv.KeepSocketOnShutdown = envknob.Bool("TS_DEBUG_KEEP_SOCKET")
+	srv.LogUploadStatus = pol.Logtail.UploadStatus
+	if n, err := strconv.Atoi(envknob.String("TS_LOCALAPI_MAX_HEADER_BYTES")); err == nil && n > 0 {
+		srv.MaxHeaderBytes = n
+	}
+	if d, err := time.ParseDuration(envknob.String("TS_IDLE_EXIT_TIMEOUT")); err == nil && d > 0 {
+		srv.IdleExitTimeout = d
+		srv.IdleExitCountsTraffic = envknob.Bool("TS_IDLE_EXIT_COUNTS_TRAFFIC")
+	}
+	if path := envknob.String("TS_LOCALAPI_PERMISSIONS_FILE"); path != "" {
+		srv.PermissionsFile = path
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := srv.ReloadPermissions(); err != nil {
+					logf("reloading LocalAPI permissions: %v", err)
+				}
+			}
+		}()
+	}
 	srv.SetLocalBackend(lb)
 	ns.SetLocalBackend(lb)
 	if err := ns.Start(); err != nil {
@@ -8,10 +8,12 @@
 
 import (
 	"tailscale.com/chirp"
+	"tailscale.com/version"
 	"tailscale.com/wgengine"
 )
 
 func init() {
+	version.RegisterBuildFeature("bird")
 	createBIRDClient = func(ctlSocket string) (wgengine.BIRDClient, error) {
 		return chirp.New(ctlSocket)
 	}
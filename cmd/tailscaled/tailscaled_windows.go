@@ -668,6 +668,9 @@ func ipnServerRunWithRetries(ctx context.Context, logf logger.Logf, ln net.Liste
 	}
 
 	server := ipnserver.New(logf, logid)
+	// Subprocesses are only started by the service (see runWindowsService),
+	// but can't detect that themselves.
+	server.WindowsService = "true"
 
 	lb, err := ipnlocal.NewLocalBackend(logf, logid, store, "", dialer, eng, opts.LoginFlags)
 	if err != nil {
@@ -11,9 +11,11 @@
 	"os"
 
 	"tailscale.com/cmd/tailscale/cli"
+	"tailscale.com/version"
 )
 
 func init() {
+	version.RegisterBuildFeature("cli")
 	beCLI = func() {
 		args := os.Args[1:]
 		if err := cli.Run(args); err != nil {
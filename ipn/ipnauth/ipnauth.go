@@ -15,6 +15,7 @@
 	"runtime"
 	"strconv"
 	"syscall"
+	"time"
 
 	"inet.af/peercred"
 	"tailscale.com/envknob"
@@ -37,6 +38,7 @@ type ConnIdentity struct {
 	// Fields used when NotWindows:
 	isUnixSock bool            // Conn is a *net.UnixConn
 	creds      *peercred.Creds // or nil
+	uid        string          // if non-empty, the peer's uid in place of creds; see NewUnixConnIdentity
 
 	// Used on Windows:
 	// TODO(bradfitz): merge these into the peercreds package and
@@ -44,8 +46,94 @@ type ConnIdentity struct {
 	pid    int
 	userID ipn.WindowsUserID
 	user   *user.User
+
+	// pidStart is the start time of the peer process, captured when the
+	// identity was extracted, or the zero value if unknown.
+	pidStart time.Time
+
+	// trusted is whether the peer is in this process and so gets full
+	// access without consulting the OS. See TrustedConnIdentity.
+	trusted bool
+}
+
+// TrustedConnIdentity returns a ConnIdentity for c whose peer is this same
+// process, such as the client end of an in-memory connection used in tests
+// or when embedding a client and daemon in one binary. There are no OS
+// credentials to extract from such a connection, so the identity is marked
+// as trusted: IsReadonlyConn reports false for it and IsTrusted reports true.
+func TrustedConnIdentity(c net.Conn) *ConnIdentity {
+	ci := &ConnIdentity{
+		conn:       c,
+		notWindows: runtime.GOOS != "windows",
+		pid:        os.Getpid(),
+		trusted:    true,
+	}
+	ci.setPidStart(ci.pid)
+	return ci
 }
 
+// NewWindowsConnIdentity returns a ConnIdentity for c whose peer is process
+// pid running as the Windows user uid, for transports whose peer is
+// identified by some means other than GetConnIdentity. If u is nil, a
+// placeholder user named after uid is used.
+func NewWindowsConnIdentity(c net.Conn, pid int, uid ipn.WindowsUserID, u *user.User) *ConnIdentity {
+	if u == nil {
+		u = &user.User{Uid: string(uid), Username: string(uid)}
+	}
+	return &ConnIdentity{
+		conn:   c,
+		pid:    pid,
+		userID: uid,
+		user:   u,
+	}
+}
+
+// NewUnixConnIdentity returns a ConnIdentity for c whose peer is process pid
+// running as the given uid, for transports whose peer is identified by some
+// means other than GetConnIdentity. The connection is authorized as if it
+// were a unix socket connection with those peer credentials.
+func NewUnixConnIdentity(c net.Conn, pid int, uid string) *ConnIdentity {
+	return &ConnIdentity{
+		conn:       c,
+		notWindows: true,
+		isUnixSock: true,
+		pid:        pid,
+		uid:        uid,
+	}
+}
+
+// NewUnixCredsConnIdentity returns a ConnIdentity for the unix socket
+// connection c whose peer has the given credentials, for transports that
+// look up peer credentials themselves.
+func NewUnixCredsConnIdentity(c net.Conn, creds *peercred.Creds) *ConnIdentity {
+	ci := &ConnIdentity{
+		conn:       c,
+		notWindows: true,
+		isUnixSock: true,
+		creds:      creds,
+	}
+	if creds != nil {
+		ci.pid, _ = creds.PID()
+	}
+	return ci
+}
+
+// UnixUserID returns the uid of the peer process on non-Windows platforms,
+// from its peer credentials or as given to NewUnixConnIdentity. It reports
+// false if unknown.
+func (ci *ConnIdentity) UnixUserID() (uid string, ok bool) {
+	if ci.uid != "" {
+		return ci.uid, true
+	}
+	if ci.creds != nil {
+		return ci.creds.UserID()
+	}
+	return "", false
+}
+
+// IsTrusted reports whether ci was created by TrustedConnIdentity.
+func (ci *ConnIdentity) IsTrusted() bool { return ci.trusted }
+
 // WindowsUserID returns the local machine's userid of the connection
 // if it's on Windows. Otherwise it returns the empty string.
 //
@@ -73,6 +161,36 @@ func (ci *ConnIdentity) Pid() int               { return ci.pid }
 func (ci *ConnIdentity) IsUnixSock() bool       { return ci.isUnixSock }
 func (ci *ConnIdentity) Creds() *peercred.Creds { return ci.creds }
 
+// PidStartTime returns the start time of the peer process, as observed when
+// the connection's identity was extracted. It's best effort and returns the
+// zero time if unknown.
+//
+// As PIDs can be reused, callers making decisions based on a PID can compare
+// this against the start time of the process currently holding that PID to
+// detect reuse.
+func (ci *ConnIdentity) PidStartTime() time.Time { return ci.pidStart }
+
+// processStartTime returns the start time of the process with the given pid.
+// It's a variable for tests.
+var processStartTime = processStartTimeOS
+
+// ProcessStartTime returns the start time of the process with the given pid,
+// for comparison against ConnIdentity.PidStartTime.
+func ProcessStartTime(pid int) (time.Time, error) {
+	return processStartTime(pid)
+}
+
+// setPidStart records the start time of process pid in ci, if it can be
+// determined.
+func (ci *ConnIdentity) setPidStart(pid int) {
+	if pid <= 0 {
+		return
+	}
+	if t, err := processStartTime(pid); err == nil {
+		ci.pidStart = t
+	}
+}
+
 // GetConnIdentity returns the localhost TCP connection's identity information
 // (pid, userid, user). If it's not Windows (for now), it returns a nil error
 // and a ConnIdentity with NotWindows set true. It's only an error if we expected
@@ -83,6 +201,11 @@ func GetConnIdentity(logf logger.Logf, c net.Conn) (ci *ConnIdentity, err error)
 		ci.notWindows = true
 		_, ci.isUnixSock = c.(*net.UnixConn)
 		ci.creds, _ = peercred.Get(c)
+		if ci.creds != nil {
+			if pid, ok := ci.creds.PID(); ok {
+				ci.setPidStart(pid)
+			}
+		}
 		return ci, nil
 	}
 	la, err := netip.ParseAddrPort(c.LocalAddr().String())
@@ -104,22 +227,43 @@ func GetConnIdentity(logf logger.Logf, c net.Conn) (ci *ConnIdentity, err error)
 	if pid == 0 {
 		return ci, errors.New("no local process found matching localhost connection")
 	}
+	return ci, ci.setWindowsPID(logf, pid)
+}
+
+// ConnIdentityForPID returns the identity GetConnIdentity would return for
+// a localhost connection from process pid, without there being one. It's
+// for diagnosing why a process can't connect, and is only supported on
+// Windows.
+func ConnIdentityForPID(logf logger.Logf, pid int) (*ConnIdentity, error) {
+	if runtime.GOOS != "windows" {
+		return nil, errors.New("only supported on Windows")
+	}
+	ci := &ConnIdentity{}
+	if err := ci.setWindowsPID(logf, pid); err != nil {
+		return nil, err
+	}
+	return ci, nil
+}
+
+// setWindowsPID sets ci's process to pid and its user to pid's owner.
+func (ci *ConnIdentity) setWindowsPID(logf logger.Logf, pid int) error {
 	ci.pid = pid
+	ci.setPidStart(pid)
 	uid, err := pidowner.OwnerOfPID(pid)
 	if err != nil {
 		var hint string
 		if runtime.GOOS == "windows" {
 			hint = " (WSL?)"
 		}
-		return ci, fmt.Errorf("failed to map connection's pid to a user%s: %w", hint, err)
+		return fmt.Errorf("failed to map connection's pid to a user%s: %w", hint, err)
 	}
 	ci.userID = ipn.WindowsUserID(uid)
 	u, err := LookupUserFromID(logf, uid)
 	if err != nil {
-		return ci, fmt.Errorf("failed to look up user from userid: %w", err)
+		return fmt.Errorf("failed to look up user from userid: %w", err)
 	}
 	ci.user = u
-	return ci, nil
+	return nil
 }
 
 // LookupUserFromID is a wrapper around os/user.LookupId that works around some
@@ -156,6 +300,9 @@ func LookupUserFromID(logf logger.Logf, uid string) (*user.User, error) {
 //
 // TODO(bradfitz): rename it? Also make Windows use this.
 func (ci *ConnIdentity) IsReadonlyConn(operatorUID string, logf logger.Logf) bool {
+	if ci.trusted {
+		return false
+	}
 	if runtime.GOOS == "windows" {
 		// Windows doesn't need/use this mechanism, at least yet. It
 		// has a different last-user-wins auth model.
@@ -166,12 +313,11 @@ func (ci *ConnIdentity) IsReadonlyConn(operatorUID string, logf logger.Logf) boo
 	if !safesocket.PlatformUsesPeerCreds() {
 		return rw
 	}
-	creds := ci.creds
-	if creds == nil {
+	if ci.creds == nil && ci.uid == "" {
 		logf("connection from unknown peer; read-only")
 		return ro
 	}
-	uid, ok := creds.UserID()
+	uid, ok := ci.UnixUserID()
 	if !ok {
 		logf("connection from peer with unknown userid; read-only")
 		return ro
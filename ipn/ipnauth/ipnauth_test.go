@@ -0,0 +1,131 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnauth
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// unixSockPair returns the server side of a connected unix socket pair.
+func unixSockPair(t *testing.T) net.Conn {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "s")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cc, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	sc, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sc.Close() })
+	return sc
+}
+
+func TestPidStartTime(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("test requires peercred PIDs on unix sockets")
+	}
+	want := time.Date(2022, 11, 28, 1, 2, 3, 0, time.UTC)
+	var gotPid int
+	old := processStartTime
+	processStartTime = func(pid int) (time.Time, error) {
+		gotPid = pid
+		return want, nil
+	}
+	t.Cleanup(func() { processStartTime = old })
+
+	ci, err := GetConnIdentity(t.Logf, unixSockPair(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotPid != os.Getpid() {
+		t.Errorf("start time looked up for pid %d; want %d", gotPid, os.Getpid())
+	}
+	if got := ci.PidStartTime(); !got.Equal(want) {
+		t.Errorf("PidStartTime = %v; want %v", got, want)
+	}
+
+	// Lookup failures are not fatal.
+	processStartTime = func(int) (time.Time, error) { return time.Time{}, errors.New("boom") }
+	ci, err = GetConnIdentity(t.Logf, unixSockPair(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ci.PidStartTime(); !got.IsZero() {
+		t.Errorf("PidStartTime = %v; want zero", got)
+	}
+}
+
+func TestProcessStartTimeOS(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		t.Skip("unsupported platform")
+	}
+	st, err := processStartTimeOS(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if now := time.Now(); st.After(now.Add(time.Second)) || st.Before(now.Add(-24*time.Hour)) {
+		t.Errorf("start time of test process = %v; implausible (now %v)", st, now)
+	}
+}
+
+func TestTrustedConnIdentity(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	ci := TrustedConnIdentity(c1)
+	if !ci.IsTrusted() {
+		t.Error("IsTrusted = false")
+	}
+	if ci.IsReadonlyConn("", t.Logf) {
+		t.Error("trusted identity is read-only")
+	}
+	if got, want := ci.Pid(), os.Getpid(); got != want {
+		t.Errorf("Pid = %d; want %d", got, want)
+	}
+	if ci.IsUnixSock() {
+		t.Error("IsUnixSock = true for in-memory conn")
+	}
+}
+
+func TestNewWindowsConnIdentity(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	ci := NewWindowsConnIdentity(nil, 123, "S-1-5-21-1", nil)
+	if got := ci.WindowsUserID(); got != "S-1-5-21-1" {
+		t.Errorf("WindowsUserID = %q; want S-1-5-21-1", got)
+	}
+	if got := ci.Pid(); got != 123 {
+		t.Errorf("Pid = %d; want 123", got)
+	}
+	if u := ci.User(); u == nil || u.Username == "" {
+		t.Errorf("User = %+v; want placeholder user", u)
+	}
+}
+
+func TestNewUnixConnIdentity(t *testing.T) {
+	ci := NewUnixConnIdentity(nil, 123, "1000")
+	if uid, ok := ci.UnixUserID(); !ok || uid != "1000" {
+		t.Errorf("UnixUserID = %q, %v; want 1000, true", uid, ok)
+	}
+	if !ci.IsUnixSock() {
+		t.Error("IsUnixSock = false")
+	}
+	if ci.IsReadonlyConn("1000", t.Logf) {
+		t.Error("operator uid identity is read-only")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnauth
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/util/strs"
+)
+
+// userHZ is the kernel's USER_HZ, the unit of the starttime field in
+// /proc/<pid>/stat. It's 100 on all Linux architectures we care about;
+// reading the real value requires sysconf(_SC_CLK_TCK) and thus cgo.
+const userHZ = 100
+
+func processStartTimeOS(pid int) (time.Time, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return time.Time{}, err
+	}
+	// The second field (comm) is parenthesized and may itself contain
+	// spaces or parens, so start after its final ')'.
+	i := bytes.LastIndexByte(stat, ')')
+	if i == -1 {
+		return time.Time{}, errors.New("malformed /proc/pid/stat")
+	}
+	f := strings.Fields(string(stat[i+1:]))
+	// f[0] is field 3 (state); starttime is field 22.
+	const startTimeIdx = 22 - 3
+	if len(f) <= startTimeIdx {
+		return time.Time{}, errors.New("short /proc/pid/stat")
+	}
+	ticks, err := strconv.ParseUint(f[startTimeIdx], 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing starttime: %w", err)
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return boot.Add(time.Duration(ticks) * time.Second / userHZ), nil
+}
+
+// bootTime returns the system boot time from the btime line of /proc/stat.
+func bootTime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	bs := bufio.NewScanner(f)
+	for bs.Scan() {
+		if v, ok := strs.CutPrefix(bs.Text(), "btime "); ok {
+			sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("parsing btime: %w", err)
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	if err := bs.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, errors.New("no btime in /proc/stat")
+}
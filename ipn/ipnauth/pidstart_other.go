@@ -0,0 +1,16 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !windows
+
+package ipnauth
+
+import (
+	"errors"
+	"time"
+)
+
+func processStartTimeOS(pid int) (time.Time, error) {
+	return time.Time{}, errors.New("process start time not supported on this platform")
+}
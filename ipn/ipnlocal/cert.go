@@ -32,6 +32,7 @@
 	"time"
 
 	"golang.org/x/crypto/acme"
+	"golang.org/x/exp/slices"
 	"tailscale.com/envknob"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/types/logger"
@@ -54,6 +55,18 @@
 // certDir returns (creating if needed) the directory in which cached
 // cert keypairs are stored.
 func (b *LocalBackend) certDir() (string, error) {
+	full, err := b.certDirPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(full, 0700); err != nil {
+		return "", err
+	}
+	return full, nil
+}
+
+// certDirPath returns the directory certDir uses, without creating it.
+func (b *LocalBackend) certDirPath() (string, error) {
 	d := b.TailscaleVarRoot()
 
 	// As a workaround for Synology DSM6 not having a "var" directory, use the
@@ -65,11 +78,7 @@ func (b *LocalBackend) certDir() (string, error) {
 	if d == "" {
 		return "", errors.New("no TailscaleVarRoot")
 	}
-	full := filepath.Join(d, "certs")
-	if err := os.MkdirAll(full, 0700); err != nil {
-		return "", err
-	}
-	return full, nil
+	return filepath.Join(d, "certs"), nil
 }
 
 var acmeDebug = envknob.RegisterBool("TS_DEBUG_ACME")
@@ -80,7 +89,7 @@ func (b *LocalBackend) GetCertPEM(ctx context.Context, domain string) (*TLSCertK
 	if !validLookingCertDomain(domain) {
 		return nil, errors.New("invalid domain")
 	}
-	logf := logger.WithPrefix(b.logf, fmt.Sprintf("cert(%q): ", domain))
+	logf := logger.WithPrefix(b.logfCtx(ctx), fmt.Sprintf("cert(%q): ", domain))
 	dir, err := b.certDir()
 	if err != nil {
 		logf("failed to get certDir: %v", err)
@@ -423,26 +432,86 @@ func validLookingCertDomain(name string) bool {
 	return true
 }
 
-func checkCertDomain(st *ipnstate.Status, domain string) error {
-	if domain == "" {
-		return errors.New("missing domain name")
+// CertDomains returns the domains that GetCertPEM may currently fetch
+// certificates for, given the node's netmap.
+func (b *LocalBackend) CertDomains() []string {
+	return certDomains(b.StatusWithoutPeers())
+}
+
+// CheckCertServing reports whether GetCertPEM can work at all, regardless
+// of who's asking: it returns an error saying why not if there's nowhere
+// writable to store certs, or if HTTPS isn't enabled for the tailnet (so
+// the control server gave the node no domains to get certs for).
+//
+// It's called for every cert status request, so it only inspects the cert
+// storage, without creating or writing anything there.
+func (b *LocalBackend) CheckCertServing() error {
+	dir, err := b.certDirPath()
+	if err != nil {
+		return fmt.Errorf("no cert storage: %w", err)
 	}
-	for _, d := range st.CertDomains {
-		if d == domain {
-			return nil
+	if err := checkWritableDir(dir); err != nil {
+		return fmt.Errorf("cert storage not writable: %w", err)
+	}
+	b.mu.Lock()
+	httpsEnabled := b.netMap != nil && len(b.netMap.DNS.CertDomains) > 0
+	b.mu.Unlock()
+	if !httpsEnabled {
+		return errors.New("no domains to get TLS certs for; HTTPS may not be enabled for this tailnet")
+	}
+	return nil
+}
+
+// checkWritableDir returns an error if dir, or if it doesn't exist yet the
+// nearest existing directory above it (in which certDir would create it),
+// isn't a directory its owner can write to. It's a cheap check by file
+// mode, without writing anything, so it can't catch every failure, such as
+// when tailscaled doesn't own the directory.
+func checkWritableDir(dir string) error {
+	for {
+		fi, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return err
+			}
+			dir = parent
+			continue
 		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+		if fi.Mode().Perm()&0200 == 0 {
+			return fmt.Errorf("%s is read-only", dir)
+		}
+		return nil
 	}
+}
+
+// certDomains returns the domains permitted by checkCertDomain for st.
+func certDomains(st *ipnstate.Status) []string {
+	okay := append([]string(nil), st.CertDomains...)
 	// Transitional way while server doesn't yet populate CertDomains: also permit the client
 	// attempting Self.DNSName.
-	okay := st.CertDomains[:len(st.CertDomains):len(st.CertDomains)]
 	if st.Self != nil {
-		if v := strings.Trim(st.Self.DNSName, "."); v != "" {
-			if v == domain {
-				return nil
-			}
+		if v := strings.Trim(st.Self.DNSName, "."); v != "" && !slices.Contains(okay, v) {
 			okay = append(okay, v)
 		}
 	}
+	return okay
+}
+
+func checkCertDomain(st *ipnstate.Status, domain string) error {
+	if domain == "" {
+		return errors.New("missing domain name")
+	}
+	okay := certDomains(st)
+	if slices.Contains(okay, domain) {
+		return nil
+	}
 	switch len(okay) {
 	case 0:
 		return errors.New("your Tailscale account does not support getting TLS certs")
@@ -13,6 +13,14 @@ type TLSCertKeyPair struct {
 	CertPEM, KeyPEM []byte
 }
 
+func (b *LocalBackend) CertDomains() []string {
+	return nil
+}
+
+func (b *LocalBackend) CheckCertServing() error {
+	return errors.New("not implemented for js/wasm")
+}
+
 func (b *LocalBackend) GetCertPEM(ctx context.Context, domain string) (*TLSCertKeyPair, error) {
 	return nil, errors.New("not implemented for js/wasm")
 }
@@ -6,7 +6,20 @@
 
 package ipnlocal
 
-import "testing"
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/types/netmap"
+	"tailscale.com/wgengine"
+)
 
 func TestValidLookingCertDomain(t *testing.T) {
 	tests := []struct {
@@ -27,3 +40,96 @@ func TestValidLookingCertDomain(t *testing.T) {
 		}
 	}
 }
+
+func TestCertDomains(t *testing.T) {
+	tests := []struct {
+		name string
+		st   *ipnstate.Status
+		want []string
+	}{
+		{"none", &ipnstate.Status{}, nil},
+		{
+			name: "self-only",
+			st:   &ipnstate.Status{Self: &ipnstate.PeerStatus{DNSName: "foo.tail-scale.ts.net."}},
+			want: []string{"foo.tail-scale.ts.net"},
+		},
+		{
+			name: "cert-domains-and-self",
+			st: &ipnstate.Status{
+				CertDomains: []string{"foo.tail-scale.ts.net", "bar.example.com"},
+				Self:        &ipnstate.PeerStatus{DNSName: "foo.tail-scale.ts.net."},
+			},
+			want: []string{"foo.tail-scale.ts.net", "bar.example.com"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := certDomains(tt.st)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("certDomains = %q; want %q", got, tt.want)
+			}
+			for _, d := range got {
+				if err := checkCertDomain(tt.st, d); err != nil {
+					t.Errorf("checkCertDomain(%q) = %v; want nil", d, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCertServing(t *testing.T) {
+	logf := tstest.WhileTestRunningLogger(t)
+	eng, err := wgengine.NewFakeUserspaceEngine(logf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(eng.Close)
+	b, err := NewLocalBackend(logf, "logid", new(mem.Store), "", nil, eng, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Shutdown)
+
+	if runtime.GOOS == "linux" {
+		if err := b.CheckCertServing(); err == nil || !strings.Contains(err.Error(), "no cert storage") {
+			t.Errorf("without var root: err = %v; want no cert storage", err)
+		}
+	}
+
+	varRoot := t.TempDir()
+	b.SetVarRoot(varRoot)
+	if err := b.CheckCertServing(); err == nil || !strings.Contains(err.Error(), "no domains") {
+		t.Errorf("without netmap: err = %v; want no domains", err)
+	}
+
+	// A node with a DNS name but no cert domains from control is in a
+	// tailnet without HTTPS enabled.
+	b.mu.Lock()
+	b.netMap = &netmap.NetworkMap{Name: "foo.tail-scale.ts.net."}
+	b.mu.Unlock()
+	if err := b.CheckCertServing(); err == nil || !strings.Contains(err.Error(), "no domains") {
+		t.Errorf("without cert domains: err = %v; want no domains", err)
+	}
+
+	b.mu.Lock()
+	b.netMap = &netmap.NetworkMap{
+		DNS: tailcfg.DNSConfig{CertDomains: []string{"foo.tail-scale.ts.net"}},
+	}
+	b.mu.Unlock()
+	if err := b.CheckCertServing(); err != nil {
+		t.Errorf("with storage and cert domains: err = %v; want nil", err)
+	}
+	if ents, err := os.ReadDir(varRoot); err != nil || len(ents) != 0 {
+		t.Errorf("var root after checks = %v, %v; want it untouched", ents, err)
+	}
+
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(varRoot, 0500); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(varRoot, 0700)
+		if err := b.CheckCertServing(); err == nil || !strings.Contains(err.Error(), "not writable") {
+			t.Errorf("with read-only var root: err = %v; want not writable", err)
+		}
+	}
+}
@@ -149,6 +149,10 @@ type LocalBackend struct {
 	containsViaIPFuncAtomic      syncs.AtomicValue[func(netip.Addr) bool]
 	shouldInterceptTCPPortAtomic syncs.AtomicValue[func(uint16) bool]
 
+	// busy is the number of operations in progress during which the
+	// backend's state may be inconsistent; see Busy.
+	busy atomic.Int32
+
 	// The mutex protects the following elements.
 	mu             sync.Mutex
 	filterHash     deephash.Sum
@@ -184,6 +188,13 @@ type LocalBackend struct {
 	fileWaiters      set.HandleSet[context.CancelFunc] // of wake-up funcs
 	notifyWatchers   set.HandleSet[chan *ipn.Notify]
 	lastStatusTime   time.Time // status.AsOf value of the last processed status update
+	localAPIConns    int       // open LocalAPI connections, as last reported by SetLocalAPIConns
+	localAPIConnPeak int       // the most localAPIConns has been, as last reported
+
+	// localAPITransports are the kinds of LocalAPI listener, as reported
+	// by SetLocalAPITransports.
+	localAPITransports []string
+
 	// directFileRoot, if non-empty, means to write received files
 	// directly to this directory, without staging them in an
 	// intermediate buffered directory for "pick-up" later. If
@@ -568,6 +579,8 @@ func (b *LocalBackend) updateStatus(sb *ipnstate.StatusBuilder, extraLocked func
 		s.Version = version.Long
 		s.BackendState = b.state.String()
 		s.AuthURL = b.authURLSticky
+		s.LocalAPIConns = b.localAPIConns
+		s.LocalAPIConnsPeak = b.localAPIConnPeak
 		if err := health.OverallError(); err != nil {
 			switch e := err.(type) {
 			case multierr.Error:
@@ -823,7 +836,7 @@ func (b *LocalBackend) setClientStatus(st controlclient.Status) {
 		if err := b.pm.DeleteProfile(b.pm.CurrentProfile().ID); err != nil {
 			b.logf("error deleting profile: %v", err)
 		}
-		b.resetForProfileChangeLockedOnEntry()
+		b.resetForProfileChangeLockedOnEntry(context.Background())
 		return
 	}
 
@@ -1113,14 +1126,21 @@ func (b *LocalBackend) startIsNoopLocked(opts ipn.Options) bool {
 // actually a supported operation (it should be, but it's very unclear
 // from the following whether or not that is a safe transition).
 func (b *LocalBackend) Start(opts ipn.Options) error {
+	return b.StartContext(context.Background(), opts)
+}
+
+// StartContext is like Start, but tags its log lines with the LocalAPI
+// request ID carried by ctx, if any.
+func (b *LocalBackend) StartContext(ctx context.Context, opts ipn.Options) error {
+	logf := b.logfCtx(ctx)
 	if opts.LegacyMigrationPrefs == nil && !b.pm.CurrentPrefs().Valid() {
 		return errors.New("no prefs provided")
 	}
 
 	if opts.LegacyMigrationPrefs != nil {
-		b.logf("Start: %v", opts.LegacyMigrationPrefs.Pretty())
+		logf("Start: %v", opts.LegacyMigrationPrefs.Pretty())
 	} else {
-		b.logf("Start")
+		logf("Start")
 	}
 
 	b.mu.Lock()
@@ -1143,7 +1163,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	// case and not restart the world (which takes a few seconds).
 	// Instead, just send a notify with the state that iOS needs.
 	if b.startIsNoopLocked(opts) && profileID == b.lastProfileID {
-		b.logf("Start: already running; sending notify")
+		logf("Start: already running; sending notify")
 		nm := b.netMap
 		state := b.state
 		b.mu.Unlock()
@@ -1192,7 +1212,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 		newPrefs.Persist = oldPrefs.Persist()
 		pv := newPrefs.View()
 		if err := b.pm.SetPrefs(pv); err != nil {
-			b.logf("failed to save UpdatePrefs state: %v", err)
+			logf("failed to save UpdatePrefs state: %v", err)
 		}
 		b.setAtomicValuesFromPrefsLocked(pv)
 	}
@@ -1209,7 +1229,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 
 	serverURL := prefs.ControlURLOrDefault()
 	if inServerMode := prefs.ForceDaemon(); inServerMode || runtime.GOOS == "windows" {
-		b.logf("Start: serverMode=%v", inServerMode)
+		logf("Start: serverMode=%v", inServerMode)
 	}
 	b.applyPrefsToHostinfoLocked(hostinfo, prefs)
 
@@ -1291,7 +1311,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	endpoints := b.endpoints
 
 	if err := b.initTKALocked(); err != nil {
-		b.logf("initTKALocked: %v", err)
+		logf("initTKALocked: %v", err)
 	}
 	var tkaHead string
 	if b.tka != nil {
@@ -1312,7 +1332,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	b.e.SetNetInfoCallback(b.setNetInfo)
 
 	blid := b.backendLogID
-	b.logf("Backend: logs: be:%v fe:%v", blid, opts.FrontendLogID)
+	logf("Backend: logs: be:%v fe:%v", blid, opts.FrontendLogID)
 	b.send(ipn.Notify{BackendLogID: &blid})
 	b.send(ipn.Notify{Prefs: &prefs})
 
@@ -1961,8 +1981,11 @@ func (b *LocalBackend) initMachineKeyLocked() (err error) {
 
 // migrateStateLocked migrates state from the frontend to the backend.
 // It is a no-op if prefs is nil
-// b.mu must be held.
+// b.mu must be held, and stays held throughout.
 func (b *LocalBackend) migrateStateLocked(prefs *ipn.Prefs) (err error) {
+	// BeginBusy doesn't take b.mu, so it's safe to call with it held.
+	endBusy := b.BeginBusy()
+	defer endBusy()
 	if prefs == nil && !b.pm.CurrentPrefs().Valid() {
 		return fmt.Errorf("no prefs provided and no current profile")
 	}
@@ -2112,13 +2135,19 @@ func (b *LocalBackend) Login(token *tailcfg.Oauth2Token) {
 // in progress, in which case StartLoginInteractive attempts to pick
 // up the in-progress flow where it left off.
 func (b *LocalBackend) StartLoginInteractive() {
+	b.StartLoginInteractiveContext(context.Background())
+}
+
+// StartLoginInteractiveContext is like StartLoginInteractive, but tags its
+// log lines with the LocalAPI request ID carried by ctx, if any.
+func (b *LocalBackend) StartLoginInteractiveContext(ctx context.Context) {
 	b.mu.Lock()
 	b.assertClientLocked()
 	b.interact = true
 	url := b.authURL
 	cc := b.cc
 	b.mu.Unlock()
-	b.logf("StartLoginInteractive: url=%v", url != "")
+	b.logfCtx(ctx)("StartLoginInteractive: url=%v", url != "")
 
 	if url != "" {
 		b.popBrowserAuthNow()
@@ -2142,6 +2171,7 @@ func (b *LocalBackend) Ping(ctx context.Context, ip netip.Addr, pingType tailcfg
 			PeerAPIURL:     base,
 		}
 		if err != nil {
+			b.logfCtx(ctx)("Ping: peerapi ping of %v: %v", ip, err)
 			pr.Err = err.Error()
 		}
 		if node != nil {
@@ -2241,6 +2271,34 @@ func (b *LocalBackend) shouldUploadServices() bool {
 	return !p.ShieldsUp() && b.netMap.CollectServices
 }
 
+// SetLocalAPIConns records the number of open LocalAPI connections and the
+// most there have been at once, as reported periodically by the IPN server,
+// for inclusion in status.
+func (b *LocalBackend) SetLocalAPIConns(open, peak int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.localAPIConns = open
+	b.localAPIConnPeak = peak
+}
+
+// SetLocalAPITransports records the kinds of connection ("unix", "tcp",
+// ...) on which the IPN server accepts LocalAPI clients, as it reports
+// when it starts serving, so that the backend can apply transport-aware
+// policy.
+func (b *LocalBackend) SetLocalAPITransports(transports []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.localAPITransports = append([]string(nil), transports...)
+}
+
+// LocalAPITransports returns the kinds of connection on which the IPN
+// server accepts LocalAPI clients, or nil if it hasn't reported them.
+func (b *LocalBackend) LocalAPITransports() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.localAPITransports...)
+}
+
 // SetCurrentUserID is used to implement support for multi-user systems (only
 // Windows 2022-11-25). On such systems, the uid is used to determine which
 // user's state should be used. The current user is maintained by active
@@ -2257,17 +2315,24 @@ func (b *LocalBackend) shouldUploadServices() bool {
 // changed.
 //
 // On non-multi-user systems, the uid should be set to empty string.
-func (b *LocalBackend) SetCurrentUserID(uid ipn.WindowsUserID) {
+//
+// It returns an error if uid's profile couldn't be loaded, in which case
+// no user is left set, so that a later call for uid tries again.
+func (b *LocalBackend) SetCurrentUserID(uid ipn.WindowsUserID) error {
 	b.mu.Lock()
 	if b.pm.CurrentUserID() == uid {
 		b.mu.Unlock()
-		return
+		return nil
 	}
 	if err := b.pm.SetCurrentUserID(uid); err != nil {
+		if rerr := b.pm.SetCurrentUserID(""); rerr != nil {
+			b.logf("SetCurrentUserID: clearing user after failing to switch to %q: %v", uid, rerr)
+		}
 		b.mu.Unlock()
-		return
+		return fmt.Errorf("loading profile of user %q: %w", uid, err)
 	}
-	b.resetForProfileChangeLockedOnEntry()
+	b.resetForProfileChangeLockedOnEntry(context.Background())
+	return nil
 }
 
 func (b *LocalBackend) CheckPrefs(p *ipn.Prefs) error {
@@ -2368,6 +2433,13 @@ func (b *LocalBackend) isDefaultServerLocked() bool {
 }
 
 func (b *LocalBackend) EditPrefs(mp *ipn.MaskedPrefs) (ipn.PrefsView, error) {
+	return b.EditPrefsContext(context.Background(), mp)
+}
+
+// EditPrefsContext is like EditPrefs, but tags its log lines with the
+// LocalAPI request ID carried by ctx, if any.
+func (b *LocalBackend) EditPrefsContext(ctx context.Context, mp *ipn.MaskedPrefs) (ipn.PrefsView, error) {
+	logf := b.logfCtx(ctx)
 	b.mu.Lock()
 	if mp.EggSet {
 		mp.EggSet = false
@@ -2379,20 +2451,20 @@ func (b *LocalBackend) EditPrefs(mp *ipn.MaskedPrefs) (ipn.PrefsView, error) {
 	p1.ApplyEdits(mp)
 	if err := b.checkPrefsLocked(p1); err != nil {
 		b.mu.Unlock()
-		b.logf("EditPrefs check error: %v", err)
+		logf("EditPrefs check error: %v", err)
 		return ipn.PrefsView{}, err
 	}
 	if p1.RunSSH && !envknob.CanSSHD() {
 		b.mu.Unlock()
-		b.logf("EditPrefs requests SSH, but disabled by envknob; returning error")
+		logf("EditPrefs requests SSH, but disabled by envknob; returning error")
 		return ipn.PrefsView{}, errors.New("Tailscale SSH server administratively disabled.")
 	}
 	if p1.View().Equals(p0) {
 		b.mu.Unlock()
 		return stripKeysFromPrefs(p0), nil
 	}
-	b.logf("EditPrefs: %v", mp.Pretty())
-	newPrefs := b.setPrefsLockedOnEntry("EditPrefs", p1) // does a b.mu.Unlock
+	logf("EditPrefs: %v", mp.Pretty())
+	newPrefs := b.setPrefsLockedOnEntry(ctx, "EditPrefs", p1) // does a b.mu.Unlock
 
 	// Note: don't perform any actions for the new prefs here. Not
 	// every prefs change goes through EditPrefs. Put your actions
@@ -2426,7 +2498,7 @@ func (b *LocalBackend) SetPrefs(newp *ipn.Prefs) {
 		panic("SetPrefs got nil prefs")
 	}
 	b.mu.Lock()
-	b.setPrefsLockedOnEntry("SetPrefs", newp)
+	b.setPrefsLockedOnEntry(context.Background(), "SetPrefs", newp)
 }
 
 // wantIngressLocked reports whether this node has ingress configured. This bool
@@ -2445,8 +2517,10 @@ func (b *LocalBackend) wantIngressLocked() bool {
 
 // setPrefsLockedOnEntry requires b.mu be held to call it, but it
 // unlocks b.mu when done. newp ownership passes to this function.
-// It returns a readonly copy of the new prefs.
-func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn.PrefsView {
+// It returns a readonly copy of the new prefs. Its log lines are
+// tagged with the LocalAPI request ID carried by ctx, if any.
+func (b *LocalBackend) setPrefsLockedOnEntry(ctx context.Context, caller string, newp *ipn.Prefs) ipn.PrefsView {
+	logf := b.logfCtx(ctx)
 	netMap := b.netMap
 	b.setAtomicValuesFromPrefsLocked(newp.View())
 
@@ -2469,7 +2543,7 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn
 
 	// [GRINDER STATS LINE] - please don't remove (used for log parsing)
 	if caller == "SetPrefs" {
-		b.logf("SetPrefs: %v", newp.Pretty())
+		logf("SetPrefs: %v", newp.Pretty())
 	}
 	b.updateFilterLocked(netMap, newp.View())
 
@@ -2483,10 +2557,10 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn
 		up := netMap.UserProfiles[netMap.User]
 		if login := up.LoginName; login != "" {
 			if newp.Persist == nil {
-				b.logf("active login: %s", login)
+				logf("active login: %s", login)
 			} else {
 				if newp.Persist.LoginName != login {
-					b.logf("active login: %q (changed from %q)", login, newp.Persist.LoginName)
+					logf("active login: %q (changed from %q)", login, newp.Persist.LoginName)
 					newp.Persist.LoginName = login
 				}
 				newp.Persist.UserProfile = up
@@ -2496,7 +2570,7 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn
 
 	prefs := newp.View()
 	if err := b.pm.SetPrefs(prefs); err != nil {
-		b.logf("failed to save new controlclient state: %v", err)
+		logf("failed to save new controlclient state: %v", err)
 	}
 	b.lastProfileID = b.pm.CurrentProfile().ID
 	b.mu.Unlock()
@@ -2510,7 +2584,7 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn
 	}
 
 	if !oldp.WantRunning() && newp.WantRunning {
-		b.logf("transitioning to running; doing Login...")
+		logf("transitioning to running; doing Login...")
 		cc.Login(nil, controlclient.LoginDefault)
 	}
 
@@ -3478,15 +3552,26 @@ func (b *LocalBackend) Logout() {
 }
 
 func (b *LocalBackend) LogoutSync(ctx context.Context) error {
+	b.logfCtx(ctx)("logout requested")
 	return b.logout(ctx, true)
 }
 
+// logfCtx returns b.logf, prefixed with the LocalAPI request ID carried by
+// ctx, if any, so log lines about an operation can be correlated with the
+// request that triggered it.
+func (b *LocalBackend) logfCtx(ctx context.Context) logger.Logf {
+	if id := ipn.RequestIDFromContext(ctx); id != "" {
+		return logger.WithPrefix(b.logf, "[req "+string(id)+"] ")
+	}
+	return b.logf
+}
+
 func (b *LocalBackend) logout(ctx context.Context, sync bool) error {
 	b.mu.Lock()
 	cc := b.cc
 	b.mu.Unlock()
 
-	b.EditPrefs(&ipn.MaskedPrefs{
+	b.EditPrefsContext(ctx, &ipn.MaskedPrefs{
 		WantRunningSet: true,
 		LoggedOutSet:   true,
 		Prefs:          ipn.Prefs{WantRunning: false, LoggedOut: true},
@@ -4083,6 +4168,7 @@ func (b *LocalBackend) SetExpirySooner(ctx context.Context, expiry time.Time) er
 	if cc == nil {
 		return errors.New("not running")
 	}
+	b.logfCtx(ctx)("setting key expiry to %v", expiry)
 	return cc.SetExpirySooner(ctx, expiry)
 }
 
@@ -4127,6 +4213,48 @@ func (b *LocalBackend) DebugReSTUN() error {
 	return nil
 }
 
+// DebugDump writes a human-readable summary of the backend's internal
+// state to w, for support engineers: its state, prefs, netmap summary,
+// LocalAPI connections and health. Private keys and auth URLs are left
+// out.
+func (b *LocalBackend) DebugDump(w io.Writer) {
+	b.mu.Lock()
+	state := b.state
+	prefs := stripKeysFromPrefs(b.pm.CurrentPrefs())
+	nm := b.netMap
+	authURLPending := b.authURLSticky != ""
+	conns, connPeak := b.localAPIConns, b.localAPIConnPeak
+	transports := append([]string(nil), b.localAPITransports...)
+	b.mu.Unlock()
+
+	fmt.Fprintf(w, "state: %v\n", state)
+	if prefs.Valid() {
+		fmt.Fprintf(w, "prefs: %s\n", prefs.Pretty())
+	} else {
+		fmt.Fprintf(w, "prefs: none\n")
+	}
+	fmt.Fprintf(w, "auth URL pending: %v\n", authURLPending)
+	if nm != nil {
+		var self string
+		if nm.SelfNode != nil {
+			self = nm.SelfNode.Name
+		}
+		var regions int
+		if nm.DERPMap != nil {
+			regions = len(nm.DERPMap.Regions)
+		}
+		fmt.Fprintf(w, "netmap: self %q, %d peers, %d DERP regions\n", self, len(nm.Peers), regions)
+	} else {
+		fmt.Fprintf(w, "netmap: none\n")
+	}
+	fmt.Fprintf(w, "localapi: %d conns (peak %d), transports %q\n", conns, connPeak, transports)
+	if err := health.OverallError(); err != nil {
+		fmt.Fprintf(w, "health: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "health: ok\n")
+	}
+}
+
 func (b *LocalBackend) magicConn() (*magicsock.Conn, error) {
 	ig, ok := b.e.(wgengine.InternalsGetter)
 	if !ok {
@@ -4334,19 +4462,48 @@ func (b *LocalBackend) ShouldInterceptTCPPort(port uint16) bool {
 	return b.shouldInterceptTCPPortAtomic.Load()(port)
 }
 
+// Busy reports whether the backend is in the middle of an operation, such
+// as migrating prefs or switching profiles, during which its state may be
+// inconsistent. The LocalAPI server refuses state-mutating requests while
+// it's busy.
+func (b *LocalBackend) Busy() bool {
+	return b.busy.Load() > 0
+}
+
+// BeginBusy marks the backend busy (see Busy) until the returned func is
+// called. Calls may nest.
+//
+// Neither BeginBusy nor the func it returns take b.mu, so they may be
+// called with or without it held, and a busy period may span code that
+// releases and reacquires it.
+func (b *LocalBackend) BeginBusy() (end func()) {
+	b.busy.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { b.busy.Add(-1) })
+	}
+}
+
 // SwitchProfile switches to the profile with the given id.
 // It will restart the backend on success.
 // If the profile is not known, it returns an errProfileNotFound.
 func (b *LocalBackend) SwitchProfile(profile ipn.ProfileID) error {
+	return b.SwitchProfileContext(context.Background(), profile)
+}
+
+// SwitchProfileContext is like SwitchProfile, but tags its log lines with
+// the LocalAPI request ID carried by ctx, if any.
+func (b *LocalBackend) SwitchProfileContext(ctx context.Context, profile ipn.ProfileID) error {
 	if b.CurrentProfile().ID == profile {
 		return nil
 	}
+	b.logfCtx(ctx)("SwitchProfile: %v", profile)
 	b.mu.Lock()
 	if err := b.pm.SwitchProfile(profile); err != nil {
 		b.mu.Unlock()
 		return err
 	}
-	return b.resetForProfileChangeLockedOnEntry()
+	return b.resetForProfileChangeLockedOnEntry(ctx)
 }
 
 func (b *LocalBackend) initTKALocked() error {
@@ -4394,35 +4551,54 @@ func (b *LocalBackend) initTKALocked() error {
 }
 
 // resetForProfileChangeLockedOnEntry resets the backend for a profile change.
-func (b *LocalBackend) resetForProfileChangeLockedOnEntry() error {
+// b.mu must be held on entry; it's released before returning.
+//
+// The backend is busy (see Busy) from entry until the restart is done:
+// enterStateLockedOnEntry releases b.mu partway, and StartContext then
+// reacquires it, so other callers can run in between and must not see the
+// half-reset state as settled.
+func (b *LocalBackend) resetForProfileChangeLockedOnEntry(ctx context.Context) error {
+	endBusy := b.BeginBusy()
 	b.setNetMapLocked(nil) // Reset netmap.
 	// Reset the NetworkMap in the engine
 	b.e.SetNetworkMap(new(netmap.NetworkMap))
 	if err := b.initTKALocked(); err != nil {
+		b.mu.Unlock()
+		endBusy()
 		return err
 	}
 	b.lastServeConfJSON = mem.B(nil)
 	b.serveConfig = ipn.ServeConfigView{}
-	b.enterStateLockedOnEntry(ipn.NoState) // Reset state.
-	return b.Start(ipn.Options{})
+	b.enterStateLockedOnEntry(ipn.NoState) // Reset state; releases b.mu.
+	err := b.StartContext(ctx, ipn.Options{})
+	endBusy()
+	return err
 }
 
 // DeleteProfile deletes a profile with the given ID.
 // If the profile is not known, it is a no-op.
 func (b *LocalBackend) DeleteProfile(p ipn.ProfileID) error {
+	return b.DeleteProfileContext(context.Background(), p)
+}
+
+// DeleteProfileContext is like DeleteProfile, but tags its log lines with
+// the LocalAPI request ID carried by ctx, if any.
+func (b *LocalBackend) DeleteProfileContext(ctx context.Context, p ipn.ProfileID) error {
+	b.logfCtx(ctx)("DeleteProfile: %v", p)
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	needToRestart := b.pm.CurrentProfile().ID == p
 	if err := b.pm.DeleteProfile(p); err != nil {
+		b.mu.Unlock()
 		if err == errProfileNotFound {
 			return nil
 		}
 		return err
 	}
 	if !needToRestart {
+		b.mu.Unlock()
 		return nil
 	}
-	return b.resetForProfileChangeLockedOnEntry()
+	return b.resetForProfileChangeLockedOnEntry(ctx)
 }
 
 // CurrentProfile returns the current LoginProfile.
@@ -4435,9 +4611,16 @@ func (b *LocalBackend) CurrentProfile() ipn.LoginProfile {
 
 // NewProfile creates and switches to the new profile.
 func (b *LocalBackend) NewProfile() error {
+	return b.NewProfileContext(context.Background())
+}
+
+// NewProfileContext is like NewProfile, but tags its log lines with the
+// LocalAPI request ID carried by ctx, if any.
+func (b *LocalBackend) NewProfileContext(ctx context.Context) error {
+	b.logfCtx(ctx)("NewProfile")
 	b.mu.Lock()
 	b.pm.NewProfile()
-	return b.resetForProfileChangeLockedOnEntry()
+	return b.resetForProfileChangeLockedOnEntry(ctx)
 }
 
 // ListProfiles returns a list of all LoginProfiles.
@@ -5,6 +5,7 @@
 package ipnlocal
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -19,8 +20,10 @@
 	"tailscale.com/net/interfaces"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/persist"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/filter"
 	"tailscale.com/wgengine/wgcfg"
@@ -743,3 +746,77 @@ func TestPacketFilterPermitsUnlockedNodes(t *testing.T) {
 	}
 
 }
+
+func TestLogfCtx(t *testing.T) {
+	var got string
+	b := &LocalBackend{logf: func(format string, args ...any) { got = fmt.Sprintf(format, args...) }}
+
+	b.logfCtx(context.Background())("hello")
+	if got != "hello" {
+		t.Errorf("without request ID, logged %q", got)
+	}
+	b.logfCtx(ipn.WithRequestID(context.Background(), "abc123"))("hello")
+	if want := "[req abc123] hello"; got != want {
+		t.Errorf("with request ID, logged %q; want %q", got, want)
+	}
+}
+
+func TestSetCurrentUserIDFails(t *testing.T) {
+	logf := tstest.WhileTestRunningLogger(t)
+	store := new(mem.Store)
+	eng, err := wgengine.NewFakeUserspaceEngine(logf, 0)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	t.Cleanup(eng.Close)
+	b, err := NewLocalBackend(logf, "logid", store, "", nil, eng, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(b.Shutdown)
+
+	// Save a profile for user1, then corrupt its prefs so that switching
+	// to user1 fails.
+	pm, err := newProfileManagerWithGOOS(store, logf, "", "windows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.SetCurrentUserID("user1"); err != nil {
+		t.Fatal(err)
+	}
+	p := pm.CurrentPrefs().AsStruct()
+	p.Persist = &persist.Persist{
+		LoginName: "user1@example.com",
+		UserProfile: tailcfg.UserProfile{
+			ID:        1,
+			LoginName: "user1@example.com",
+		},
+	}
+	if err := pm.SetPrefs(p.View()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteState(pm.CurrentProfile().Key, []byte("not prefs")); err != nil {
+		t.Fatal(err)
+	}
+	pm, err = newProfileManagerWithGOOS(store, logf, "", "windows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	b.pm = pm
+	b.mu.Unlock()
+
+	// Each attempt fails, leaving no user set so that the next one
+	// tries again.
+	for i := 0; i < 2; i++ {
+		if err := b.SetCurrentUserID("user1"); err == nil {
+			t.Fatalf("attempt %d: SetCurrentUserID succeeded with corrupt prefs", i)
+		}
+		b.mu.Lock()
+		uid := b.pm.CurrentUserID()
+		b.mu.Unlock()
+		if uid != "" {
+			t.Fatalf("attempt %d: CurrentUserID = %q after failed switch; want none", i, uid)
+		}
+	}
+}
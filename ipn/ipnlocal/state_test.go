@@ -1077,3 +1077,50 @@ func TestWGEngineStatusRace(t *testing.T) {
 	wg.Wait()
 	wantState(ipn.Running)
 }
+
+func TestProfileChangeBusy(t *testing.T) {
+	logf := tstest.WhileTestRunningLogger(t)
+	e, err := wgengine.NewFakeUserspaceEngine(logf, 0)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	t.Cleanup(e.Close)
+	b, err := NewLocalBackend(logf, "logid", new(mem.Store), "", nil, e, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(b.Shutdown)
+	b.SetControlClientGetterForTesting(func(opts controlclient.Options) (controlclient.Client, error) {
+		return newClient(t, opts), nil
+	})
+	if err := b.Start(ipn.Options{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	// The NoState notification is sent after the reset has released b.mu
+	// but before the backend has restarted, so the backend must still
+	// report itself busy.
+	var sawNoState, busyAtNoState atomic.Bool
+	b.SetNotifyCallback(func(n ipn.Notify) {
+		if n.State != nil && *n.State == ipn.NoState {
+			sawNoState.Store(true)
+			busyAtNoState.Store(b.Busy())
+		}
+	})
+	if err := b.NewProfile(); err != nil {
+		t.Fatalf("NewProfile: %v", err)
+	}
+	if !sawNoState.Load() {
+		t.Fatal("no NoState notification during profile change")
+	}
+	if !busyAtNoState.Load() {
+		t.Error("backend not busy partway through profile change")
+	}
+	if b.Busy() {
+		t.Error("backend still busy after profile change")
+	}
+	if !b.mu.TryLock() {
+		t.Fatal("b.mu still held after profile change")
+	}
+	b.mu.Unlock()
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"time"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+// AcceptWindow is a period of the day during which Server accepts new
+// LocalAPI connections. See Server.AcceptWindows.
+type AcceptWindow struct {
+	// Days are the days of the week the window applies to. If empty, it
+	// applies every day.
+	Days []time.Weekday
+
+	// Start and End are the window's bounds, as offsets from midnight in
+	// local time, such as 9*time.Hour. The window includes Start but not
+	// End. If End is before Start, the window spans midnight, and Days
+	// refers to the day it starts. If they're equal, the window is the
+	// whole day.
+	Start, End time.Duration
+}
+
+// contains reports whether t is in w.
+func (w AcceptWindow) contains(t time.Time) bool {
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	day := t.Weekday()
+	switch {
+	case w.Start == w.End:
+		return w.onDay(day)
+	case w.Start < w.End:
+		return w.onDay(day) && offset >= w.Start && offset < w.End
+	}
+	// Spans midnight: the evening part is on day, the morning part on
+	// the day before.
+	if offset >= w.Start {
+		return w.onDay(day)
+	}
+	return offset < w.End && w.onDay((day+6)%7)
+}
+
+func (w AcceptWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// errOutsideAllowedHours is the error for connections denied by
+// Server.AcceptWindows.
+var errOutsideAllowedHours = errors.New("connection refused: outside allowed hours")
+
+// checkAcceptWindows returns an error if s.AcceptWindows is set and the
+// current time isn't in any of them. Trusted connections are exempt.
+func (s *Server) checkAcceptWindows(ci *ipnauth.ConnIdentity) error {
+	if len(s.AcceptWindows) == 0 || ci.IsTrusted() {
+		return nil
+	}
+	now := s.now()
+	for _, w := range s.AcceptWindows {
+		if w.contains(now) {
+			return nil
+		}
+	}
+	return errOutsideAllowedHours
+}
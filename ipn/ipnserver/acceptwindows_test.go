@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/tstest"
+)
+
+func TestAcceptWindowContains(t *testing.T) {
+	weekdays := []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+	// 2022-11-07 is a Monday.
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2022, 11, 7+day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		w    AcceptWindow
+		t    time.Time
+		want bool
+	}{
+		{"business-hours-in", AcceptWindow{Days: weekdays, Start: 9 * time.Hour, End: 17 * time.Hour}, at(0, 9, 0), true},
+		{"business-hours-end", AcceptWindow{Days: weekdays, Start: 9 * time.Hour, End: 17 * time.Hour}, at(0, 17, 0), false},
+		{"business-hours-early", AcceptWindow{Days: weekdays, Start: 9 * time.Hour, End: 17 * time.Hour}, at(0, 8, 59), false},
+		{"business-hours-saturday", AcceptWindow{Days: weekdays, Start: 9 * time.Hour, End: 17 * time.Hour}, at(5, 12, 0), false},
+		{"every-day", AcceptWindow{Start: 9 * time.Hour, End: 17 * time.Hour}, at(6, 12, 0), true},
+		{"whole-day", AcceptWindow{Days: []time.Weekday{time.Sunday}}, at(6, 3, 0), true},
+		{"overnight-evening", AcceptWindow{Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 2 * time.Hour}, at(4, 23, 0), true},
+		{"overnight-morning", AcceptWindow{Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 2 * time.Hour}, at(5, 1, 0), true},
+		{"overnight-wrong-morning", AcceptWindow{Days: []time.Weekday{time.Friday}, Start: 22 * time.Hour, End: 2 * time.Hour}, at(4, 1, 0), false},
+		{"overnight-midday", AcceptWindow{Start: 22 * time.Hour, End: 2 * time.Hour}, at(2, 12, 0), false},
+	}
+	for _, tt := range tests {
+		if got := tt.w.contains(tt.t); got != tt.want {
+			t.Errorf("%s: contains(%v) = %v; want %v", tt.name, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptWindows(t *testing.T) {
+	clock := &tstest.Clock{Start: time.Date(2022, 11, 7, 12, 0, 0, 0, time.UTC)}
+	s := newTestServer(t)
+	s.timeNow = clock.Now
+	s.AcceptWindows = []AcceptWindow{{Start: 9 * time.Hour, End: 17 * time.Hour}}
+	s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+		return ipnauth.NewUnixConnIdentity(c, 1, "1001"), nil
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	serve := func(ctx context.Context) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/localapi/v0/server-stats", nil)
+		r.Host = apitype.LocalAPIHost
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r.WithContext(ctx))
+		return rec
+	}
+
+	inside := s.connContext(context.Background(), c1)
+	if rec := serve(inside); strings.Contains(rec.Body.String(), "outside allowed hours") {
+		t.Fatalf("inside window: %d %q", rec.Code, rec.Body.String())
+	}
+
+	clock.Advance(8 * time.Hour) // 20:00
+	rec := serve(s.connContext(context.Background(), c1))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "outside allowed hours") {
+		t.Errorf("outside window: %d %q; want 403 outside allowed hours", rec.Code, rec.Body.String())
+	}
+	if rec := serve(inside); strings.Contains(rec.Body.String(), "outside allowed hours") {
+		t.Errorf("connection accepted inside window refused later: %d %q", rec.Code, rec.Body.String())
+	}
+	if err := s.checkAcceptWindows(ipnauth.TrustedConnIdentity(nil)); err != nil {
+		t.Errorf("trusted connection refused outside window: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// AccessLogEntry is a record of a LocalAPI request, or of a connection
+// denied when accepted, as passed to Server.AccessLog.
+type AccessLogEntry struct {
+	Time time.Time
+
+	// Principal is as much of the peer's identity as could be determined.
+	// For denied connections it may be incomplete.
+	Principal Principal
+
+	// Method and Path are those of the request. They're empty for an
+	// entry about a connection denied when accepted.
+	Method, Path string
+
+	// Denied is why the connection or request was denied, or empty if the
+	// request was served.
+	Denied DenyReason
+
+	// Detail is a human-readable explanation of the denial, if any.
+	Detail string
+}
+
+// connDenial records why connContext denied a connection, for the
+// responses to requests on it. The denial is logged once, when the
+// connection is accepted, not again for each request.
+type connDenial struct {
+	reason    DenyReason
+	principal *Principal // partial
+}
+
+// connDenialContextKey is the http.Request.Context's context.Value key for
+// the *connDenial of a denied connection.
+type connDenialContextKey struct{}
+
+// requestPrincipal returns as much as is known of the identity of r's
+// peer.
+func requestPrincipal(r *http.Request) *Principal {
+	ctx := r.Context()
+	if p := PrincipalFromContext(ctx); p != nil {
+		return p
+	}
+	if d, ok := ctx.Value(connDenialContextKey{}).(*connDenial); ok {
+		return d.principal
+	}
+	c, _ := ctx.Value(connContextKey{}).(net.Conn)
+	return &Principal{Transport: transportOf(c)}
+}
+
+// logAccess records a request (or if r is nil, a denied connection) from p
+// with s.AccessLog, if set, and as an audit event. reason is empty for
+// served requests.
+func (s *Server) logAccess(p *Principal, r *http.Request, reason DenyReason, detail string) {
+	e := AccessLogEntry{
+		Time:      time.Now(),
+		Principal: *p,
+		Denied:    reason,
+		Detail:    detail,
+	}
+	if r != nil {
+		e.Method, e.Path = r.Method, r.URL.Path
+	}
+	kind := AuditRequest
+	if reason != "" {
+		kind = AuditDenial
+	}
+	s.audit(AuditEvent{
+		Kind:      kind,
+		Time:      e.Time,
+		Principal: e.Principal,
+		Method:    e.Method,
+		Path:      e.Path,
+		Denied:    e.Denied,
+		Detail:    e.Detail,
+	})
+	if s.AccessLog != nil {
+		s.AccessLog(e)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestAccessLogDenied(t *testing.T) {
+	s := newTestServer(t)
+	var entries []AccessLogEntry
+	s.AccessLog = func(e AccessLogEntry) { entries = append(entries, e) }
+	s.AllowedUsers = []string{"1002"}
+	s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+		return ipnauth.NewUnixConnIdentity(c, 42, "1001"), nil
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	ctx := s.connContext(context.Background(), c1)
+	if len(entries) != 1 {
+		t.Fatalf("got %d access log entries for denied connection; want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Denied != DenyUnauthorized || e.Detail == "" {
+		t.Errorf("denial = %q, %q; want unauthorized with detail", e.Denied, e.Detail)
+	}
+	if e.Principal.UserID != "1001" || e.Principal.PID != 42 {
+		t.Errorf("principal = %+v; want partial identity of uid 1001, pid 42", e.Principal)
+	}
+	if e.Path != "" {
+		t.Errorf("connection entry has path %q", e.Path)
+	}
+
+	// Requests on the denied connection aren't logged again.
+	r := httptest.NewRequest("GET", "/localapi/v0/status", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d; want 401", rec.Code)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d access log entries after a request; want 1", len(entries))
+	}
+}
+
+func TestAccessLogServed(t *testing.T) {
+	s := newTestServer(t)
+	var entries []AccessLogEntry
+	s.AccessLog = func(e AccessLogEntry) { entries = append(entries, e) }
+	s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+		return ipnauth.TrustedConnIdentity(c), nil
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	r := httptest.NewRequest("GET", "/localapi/v0/status", nil)
+	r.Host = apitype.LocalAPIHost
+	r = r.WithContext(s.connContext(r.Context(), c1))
+	s.serveHTTP(httptest.NewRecorder(), r)
+	if len(entries) != 1 {
+		t.Fatalf("got %d access log entries; want 1", len(entries))
+	}
+	if e := entries[0]; e.Denied != "" || e.Path != "/localapi/v0/status" || !e.Principal.Trusted {
+		t.Errorf("entry = %+v; want served status request from trusted peer", e)
+	}
+}
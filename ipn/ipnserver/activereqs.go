@@ -0,0 +1,91 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// DefaultActiveRequestsThreshold is the default value of
+// Server.ActiveRequestsThreshold. It's far above what even many clients
+// with several watchers each have in flight, so exceeding it almost
+// certainly means requests are leaking.
+const DefaultActiveRequestsThreshold = 1024
+
+// activeRequestsSummaryLen is how many of the oldest in-flight requests
+// are summarized when the threshold is exceeded.
+const activeRequestsSummaryLen = 5
+
+// errTooManyActiveRequests is the error for connections denied while more
+// than Server.ActiveRequestsThreshold requests are in flight.
+var errTooManyActiveRequests = errors.New("too many LocalAPI requests in flight; try again later")
+
+// noteActiveRequestsLocked warns, once each time it's exceeded, when
+// more than s.ActiveRequestsThreshold requests are in flight. It's called
+// whenever s.activeReqs changes.
+//
+// s.mu must be held.
+func (s *Server) noteActiveRequestsLocked() {
+	limit := s.ActiveRequestsThreshold
+	if limit <= 0 || len(s.activeReqs) <= limit {
+		s.activeReqsWarned = false
+		return
+	}
+	if s.activeReqsWarned {
+		return
+	}
+	s.activeReqsWarned = true
+	s.logf("warning: %d LocalAPI requests in flight, more than %d; possible leak; oldest: %s",
+		len(s.activeReqs), limit, s.oldestActiveRequestsLocked(activeRequestsSummaryLen))
+}
+
+// oldestActiveRequestsLocked returns a summary of the n oldest in-flight
+// requests for logs, such as "GET /localapi/v0/status by 1001 for 5m0s".
+//
+// s.mu must be held.
+func (s *Server) oldestActiveRequestsLocked(n int) string {
+	type entry struct {
+		desc  string
+		start time.Time
+	}
+	entries := make([]entry, 0, len(s.activeReqs))
+	for r, ar := range s.activeReqs {
+		user := connUserID(ar.ci)
+		if user == "" {
+			user = "unknown-user"
+		}
+		entries = append(entries, entry{
+			desc:  fmt.Sprintf("%s %s by %s for %v", r.Method, r.URL.Path, user, time.Since(ar.start).Round(time.Second)),
+			start: ar.start,
+		})
+	}
+	slices.SortFunc(entries, func(a, b entry) bool { return a.start.Before(b.start) })
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	descs := make([]string, len(entries))
+	for i, e := range entries {
+		descs[i] = e.desc
+	}
+	return strings.Join(descs, "; ")
+}
+
+// admitActiveRequests reports whether a new connection may be accepted
+// given the requests in flight: always, unless
+// s.RejectOverActiveRequestsThreshold is set and there are more than
+// s.ActiveRequestsThreshold.
+func (s *Server) admitActiveRequests() bool {
+	if !s.RejectOverActiveRequestsThreshold || s.ActiveRequestsThreshold <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.activeReqs) <= s.ActiveRequestsThreshold
+}
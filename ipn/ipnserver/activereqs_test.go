@@ -0,0 +1,94 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestActiveRequestsThreshold(t *testing.T) {
+	s := newTestServer(t)
+	s.ActiveRequestsThreshold = 2
+	s.RejectOverActiveRequestsThreshold = true
+	s.IdentityResolver = trustedResolver
+	var (
+		mu       sync.Mutex
+		warnings []string
+	)
+	s.logf = func(format string, args ...any) {
+		if msg := fmt.Sprintf(format, args...); strings.Contains(msg, "possible leak") {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings = append(warnings, msg)
+		}
+	}
+	numWarnings := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(warnings)
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	rejected := func() bool {
+		_, err := s.connContext(context.Background(), c1).Value(connIdentityContextKey{}).(error)
+		return err
+	}
+
+	ci := ipnauth.NewUnixConnIdentity(nil, 1, "1001")
+	var dones []func()
+	add := func(path string) {
+		t.Helper()
+		onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", path, nil), ci)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dones = append(dones, onDone)
+	}
+	add("/localapi/v0/watch-ipn-bus")
+	add("/localapi/v0/status")
+	if n := numWarnings(); n != 0 {
+		t.Fatalf("at threshold: got %d warnings; want 0", n)
+	}
+	if rejected() {
+		t.Fatal("connection rejected at threshold")
+	}
+
+	add("/localapi/v0/prefs")
+	add("/localapi/v0/prefs")
+	if n := numWarnings(); n != 1 {
+		t.Fatalf("past threshold: got %d warnings; want 1", n)
+	}
+	if w := warnings[0]; !strings.Contains(w, "4 LocalAPI requests") || !strings.Contains(w, "GET /localapi/v0/watch-ipn-bus by 1001") {
+		t.Errorf("warning = %q; want count and oldest request", w)
+	}
+	if !rejected() {
+		t.Error("connection accepted past threshold")
+	}
+
+	// Draining back to the threshold accepts connections again, and a
+	// later excursion warns again.
+	for _, onDone := range dones[2:] {
+		onDone()
+	}
+	if rejected() {
+		t.Error("connection rejected after draining")
+	}
+	add("/localapi/v0/prefs")
+	if n := numWarnings(); n != 2 {
+		t.Errorf("second excursion: got %d warnings; want 2", n)
+	}
+	for _, i := range []int{0, 1, 4} {
+		dones[i]()
+	}
+}
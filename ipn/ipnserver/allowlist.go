@@ -0,0 +1,59 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+// allowedUserIDSet returns the set of userids in s.AllowedUsers, resolving
+// usernames to userids the first time it's called. Entries that can't be
+// resolved are logged and left out, so they admit no one.
+func (s *Server) allowedUserIDSet() map[string]bool {
+	s.allowedUsersOnce.Do(func() {
+		s.allowedUserIDs = make(map[string]bool, len(s.AllowedUsers))
+		for _, v := range s.AllowedUsers {
+			uid, err := allowListUserID(v)
+			if err != nil {
+				s.logf("AllowedUsers: ignoring user %q: %v", v, err)
+				continue
+			}
+			s.allowedUserIDs[uid] = true
+		}
+	})
+	return s.allowedUserIDs
+}
+
+// allowListUserID returns the userid for an AllowedUsers entry: Windows SIDs
+// as is, else as parsed by userIDFromString.
+func allowListUserID(v string) (string, error) {
+	if v == "" {
+		return "", errors.New("empty user")
+	}
+	if strings.HasPrefix(v, "S-1-") {
+		return v, nil
+	}
+	return userIDFromString(v)
+}
+
+// checkAllowedUser returns an error if s.AllowedUsers is set and ci's user
+// isn't in it.
+func (s *Server) checkAllowedUser(ci *ipnauth.ConnIdentity) error {
+	if len(s.AllowedUsers) == 0 || ci.IsTrusted() {
+		return nil
+	}
+	uid := connUserID(ci)
+	if uid != "" && s.allowedUserIDSet()[uid] {
+		return nil
+	}
+	if uid == "" {
+		return fmt.Errorf("connection rejected: peer's user is unknown and the server only admits an allow-list of users")
+	}
+	return fmt.Errorf("connection rejected: user %s is not in the server's allow-list", uid)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestAllowedUsers(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	tests := []struct {
+		name    string
+		goos    string
+		ci      *ipnauth.ConnIdentity
+		allowed bool
+	}{
+		{"listed-uid", "linux", ipnauth.NewUnixConnIdentity(c1, 1, "1001"), true},
+		{"unlisted-uid", "linux", ipnauth.NewUnixConnIdentity(c1, 1, "1002"), false},
+		{"unknown-uid", "linux", ipnauth.NewUnixConnIdentity(c1, 1, ""), false},
+		{"trusted", "linux", ipnauth.TrustedConnIdentity(c1), true},
+		{"listed-sid", "windows", ipnauth.NewWindowsConnIdentity(c1, 1, "S-1-5-21-7", nil), true},
+		{"unlisted-sid", "windows", ipnauth.NewWindowsConnIdentity(c1, 1, "S-1-5-21-8", nil), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TS_DEBUG_FAKE_GOOS", tt.goos)
+			s := New(t.Logf, "logid")
+			s.AllowedUsers = []string{"1001", "S-1-5-21-7", "no-such-user-for-test"}
+			s.IdentityResolver = func(net.Conn) (*ipnauth.ConnIdentity, error) { return tt.ci, nil }
+			v := s.connContext(context.Background(), c1).Value(connIdentityContextKey{})
+			if _, rejected := v.(error); rejected == tt.allowed {
+				t.Errorf("identity = %v; want allowed = %v", v, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestAllowedUsersUnset(t *testing.T) {
+	s := New(t.Logf, "logid")
+	if err := s.checkAllowedUser(ipnauth.NewUnixConnIdentity(nil, 1, "1002")); err != nil {
+		t.Errorf("with no allow-list, got %v", err)
+	}
+}
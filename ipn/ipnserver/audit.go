@@ -0,0 +1,136 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"tailscale.com/types/logger"
+)
+
+// AuditEventKind is the kind of an AuditEvent.
+type AuditEventKind string
+
+const (
+	// AuditConnection is a LocalAPI connection accepted with a known
+	// identity.
+	AuditConnection AuditEventKind = "connection"
+
+	// AuditRequest is a LocalAPI request the Server served.
+	AuditRequest AuditEventKind = "request"
+
+	// AuditDenial is a connection or request the Server denied.
+	AuditDenial AuditEventKind = "denial"
+
+	// AuditUserSwitch is a change of the local user using the server,
+	// which only happens on Windows.
+	AuditUserSwitch AuditEventKind = "user_switch"
+
+	// AuditProxyTunnel is a CONNECT tunnel the Server established, which
+	// only happens on Windows; see Server.ProxyTunnels.
+	AuditProxyTunnel AuditEventKind = "proxy_tunnel"
+)
+
+// AuditEvent is an auditable event, as passed to each of Server.AuditSinks.
+type AuditEvent struct {
+	Kind AuditEventKind
+	Time time.Time
+
+	// Principal is as much of the peer's identity as could be determined.
+	// For denied connections it may be incomplete. For user switches, only
+	// its UserID, the new user, is set.
+	Principal Principal
+
+	// Method and Path are those of the request, for request events and
+	// denials of requests.
+	Method, Path string `json:",omitempty"`
+
+	// Denied is why a denial event's connection or request was denied.
+	Denied DenyReason `json:",omitempty"`
+
+	// Detail is a human-readable explanation of a denial, if any.
+	Detail string `json:",omitempty"`
+
+	// PrevUserID is the previous user of a user switch event, or empty if
+	// there was none.
+	PrevUserID string `json:",omitempty"`
+
+	// Target is the "host:port" a proxy tunnel event's tunnel connects to.
+	Target string `json:",omitempty"`
+}
+
+// String returns a one-line description of e, as logged by text-based
+// sinks.
+func (e AuditEvent) String() string {
+	var sb strings.Builder
+	sb.WriteString(string(e.Kind))
+	if e.Method != "" {
+		fmt.Fprintf(&sb, " %s %s", e.Method, e.Path)
+	}
+	if e.Target != "" {
+		fmt.Fprintf(&sb, " to %s", e.Target)
+	}
+	switch e.Kind {
+	case AuditUserSwitch:
+		fmt.Fprintf(&sb, " from %q to %q", e.PrevUserID, e.Principal.UserID)
+	default:
+		fmt.Fprintf(&sb, " from %v", e.Principal)
+	}
+	if e.Denied != "" {
+		fmt.Fprintf(&sb, ": %s", e.Denied)
+		if e.Detail != "" {
+			fmt.Fprintf(&sb, " (%s)", e.Detail)
+		}
+	}
+	return sb.String()
+}
+
+// AuditSink receives the Server's audit events; see Server.AuditSinks.
+type AuditSink interface {
+	// Audit records e. It's called synchronously as events happen, so it
+	// should be quick, and it must not call back into the Server.
+	Audit(e AuditEvent)
+}
+
+// AuditFunc is an AuditSink implemented by a func.
+type AuditFunc func(AuditEvent)
+
+// Audit calls f(e).
+func (f AuditFunc) Audit(e AuditEvent) { f(e) }
+
+// LogfAuditSink returns an AuditSink that logs events of the given kinds,
+// or of all kinds if none are given, to logf.
+func LogfAuditSink(logf logger.Logf, kinds ...AuditEventKind) AuditSink {
+	return AuditFunc(func(e AuditEvent) {
+		if len(kinds) > 0 && !containsKind(kinds, e.Kind) {
+			return
+		}
+		logf("audit: %v", e)
+	})
+}
+
+func containsKind(kinds []AuditEventKind, k AuditEventKind) bool {
+	for _, v := range kinds {
+		if v == k {
+			return true
+		}
+	}
+	return false
+}
+
+// audit sends e to each of s's audit sinks, setting its time if unset.
+func (s *Server) audit(e AuditEvent) {
+	if len(s.AuditSinks) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, sink := range s.AuditSinks {
+		sink.Audit(e)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// DefaultAuditFileMaxSize is the size at which a FileAuditSink rotates its
+// file when given no other size.
+const DefaultAuditFileMaxSize = 10 << 20
+
+// FileAuditSink is an AuditSink that appends events as JSON lines to a
+// file, rotating it to the same name plus ".1" (replacing any previous
+// rotated file) when it would grow beyond a maximum size.
+type FileAuditSink struct {
+	path    string
+	maxSize int64
+
+	mu   sync.Mutex
+	f    *os.File // nil once closed, or if reopening after rotation failed
+	size int64
+}
+
+// NewFileAuditSink returns a FileAuditSink appending to the file at path,
+// which it creates if needed. If maxSize is zero, DefaultAuditFileMaxSize
+// is used. The caller must Close it when done.
+func NewFileAuditSink(path string, maxSize int64) (*FileAuditSink, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultAuditFileMaxSize
+	}
+	fs := &FileAuditSink{path: path, maxSize: maxSize}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileAuditSink) open() error {
+	f, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fs.f, fs.size = f, fi.Size()
+	return nil
+}
+
+// Audit appends e to the file. Errors are dropped: auditing must not fail
+// the LocalAPI request being audited.
+func (fs *FileAuditSink) Audit(e AuditEvent) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return
+	}
+	if fs.size > 0 && fs.size+int64(len(line)) > fs.maxSize {
+		fs.f.Close()
+		fs.f = nil
+		os.Rename(fs.path, fs.path+".1")
+		if err := fs.open(); err != nil {
+			return
+		}
+	}
+	n, _ := fs.f.Write(line)
+	fs.size += int64(n)
+}
+
+// Close closes the file. Later events are dropped.
+func (fs *FileAuditSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return nil
+	}
+	err := fs.f.Close()
+	fs.f = nil
+	return err
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && !plan9 && !js
+
+package ipnserver
+
+import "log/syslog"
+
+// NewSyslogAuditSink returns an AuditSink that sends events to the local
+// syslog daemon with the given tag, in the auth facility. Denials are
+// logged as warnings and other events as info.
+func NewSyslogAuditSink(tag string) (AuditSink, error) {
+	w, err := syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return AuditFunc(func(e AuditEvent) {
+		if e.Kind == AuditDenial {
+			w.Warning(e.String())
+		} else {
+			w.Info(e.String())
+		}
+	}), nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestAuditCallbackSink(t *testing.T) {
+	s := newTestServer(t)
+	var events []AuditEvent
+	s.AuditSinks = []AuditSink{AuditFunc(func(e AuditEvent) { events = append(events, e) })}
+	s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+		return ipnauth.NewUnixConnIdentity(c, 42, "1001"), nil
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	ctx := s.connContext(context.Background(), c1)
+	newReq := func(method, path string) *http.Request {
+		r := httptest.NewRequest(method, path, nil).WithContext(ctx)
+		r.Host = apitype.LocalAPIHost
+		return r
+	}
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, newReq("GET", "/localapi/v0/status"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: code %d, %s", rec.Code, rec.Body.Bytes())
+	}
+	s.SetMaintenance(true, "down for maintenance")
+	rec = httptest.NewRecorder()
+	s.serveHTTP(rec, newReq("POST", "/localapi/v0/check-prefs"))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("check-prefs in maintenance: code %d", rec.Code)
+	}
+
+	want := []struct {
+		kind   AuditEventKind
+		path   string
+		denied DenyReason
+	}{
+		{AuditConnection, "", ""},
+		{AuditRequest, "/localapi/v0/status", ""},
+		{AuditDenial, "/localapi/v0/check-prefs", DenyMaintenance},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events %+v; want %d", len(events), events, len(want))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Kind != w.kind || e.Path != w.path || e.Denied != w.denied {
+			t.Errorf("event %d = %+v; want %+v", i, e, w)
+		}
+		if e.Principal.UserID != "1001" || e.Principal.PID != 42 || e.Time.IsZero() {
+			t.Errorf("event %d principal/time = %+v, %v", i, e.Principal, e.Time)
+		}
+	}
+}
+
+func TestAuditUserSwitch(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	s := newTestServer(t)
+	s.resetOnZero = false
+	var events []AuditEvent
+	s.AuditSinks = []AuditSink{AuditFunc(func(e AuditEvent) { events = append(events, e) })}
+	for _, uid := range []string{"S-1-5-21-alice", "S-1-5-21-bob"} {
+		ci := ipnauth.NewWindowsConnIdentity(nil, 1, ipn.WindowsUserID(uid), nil)
+		onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/localapi/v0/status", nil), ci)
+		if err != nil {
+			t.Fatalf("request from %s: %v", uid, err)
+		}
+		onDone()
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events %+v; want 2 user switches", len(events), events)
+	}
+	e := events[1]
+	if e.Kind != AuditUserSwitch || e.PrevUserID != "S-1-5-21-alice" || e.Principal.UserID != "S-1-5-21-bob" {
+		t.Errorf("second event = %+v; want switch from alice to bob", e)
+	}
+}
+
+func TestAuditDefaultOff(t *testing.T) {
+	var logged []string
+	logf := func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+	s := New(logf, "logid")
+	e := AuditEvent{Kind: AuditDenial, Path: "/localapi/v0/status", Denied: DenyUnauthorized}
+	s.audit(e)
+	if len(logged) != 0 {
+		t.Errorf("with no audit sinks, logged %q; want nothing", logged)
+	}
+
+	s.AuditSinks = []AuditSink{LogfAuditSink(logf, AuditDenial)}
+	s.audit(e)
+	s.audit(AuditEvent{Kind: AuditRequest, Path: "/localapi/v0/status"})
+	if len(logged) != 1 || !strings.HasPrefix(logged[0], "audit: denial") {
+		t.Errorf("with a logf sink for denials, logged %q; want one denial", logged)
+	}
+}
+
+func TestFileAuditSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	fs, err := NewFileAuditSink(path, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.Close()
+	for i := 0; i < 5; i++ {
+		fs.Audit(AuditEvent{Kind: AuditRequest, Method: "GET", Path: "/localapi/v0/status"})
+	}
+
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Errorf("no rotated file: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, _ := f.Stat()
+	if fi.Size() > 200 {
+		t.Errorf("current file is %d bytes; want at most 200", fi.Size())
+	}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Bytes(), err)
+		}
+		if e.Kind != AuditRequest || e.Path != "/localapi/v0/status" {
+			t.Errorf("event = %+v", e)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/localapi"
+)
+
+// authInfoPath is the LocalAPI path of serveAuthInfo. Like lockHolderPath,
+// serveHTTP serves it without adding the request to activeReqs, so that
+// clients can ask how they'd be authenticated before anything else.
+const authInfoPath = "/localapi/v0/auth-info"
+
+// authMethods returns how s authenticates connections on transport t; see
+// apitype.AuthInfo.Methods.
+func (s *Server) authMethods(t Transport) []string {
+	var ret []string
+	if s.IdentityResolver != nil {
+		ret = append(ret, apitype.AuthMethodCustom)
+	}
+	switch t {
+	case TransportUnix:
+		ret = append(ret, apitype.AuthMethodPeerCreds)
+	case TransportTCP:
+		if envknob.GOOS() == "windows" {
+			ret = append(ret, apitype.AuthMethodProcessOwner)
+		}
+	case TransportMemory:
+		ret = append(ret, apitype.AuthMethodInProcess)
+	}
+	return ret
+}
+
+// serveAuthInfo serves the caller's apitype.AuthInfo as JSON. It needs no
+// permissions: it reveals only how the caller itself is authenticated.
+func (s *Server) serveAuthInfo(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	var t Transport
+	if p := PrincipalFromContext(r.Context()); p != nil {
+		t = p.Transport
+	}
+	perm := localapi.PermNone
+	switch {
+	case h.PermitWrite:
+		perm = localapi.PermWrite
+	case h.PermitRead:
+		perm = localapi.PermRead
+	}
+	methods := s.authMethods(t)
+	if methods == nil {
+		methods = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apitype.AuthInfo{
+		Transport:  string(t),
+		Methods:    methods,
+		Satisfied:  h.PermitRead,
+		Permission: perm,
+	})
+}
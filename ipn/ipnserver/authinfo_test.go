@@ -0,0 +1,105 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestAuthInfo(t *testing.T) {
+	getAuthInfo := func(t *testing.T, s *Server, tr Transport, ci *ipnauth.ConnIdentity) apitype.AuthInfo {
+		t.Helper()
+		r := httptest.NewRequest("GET", authInfoPath, nil)
+		r.Host = apitype.LocalAPIHost
+		ctx := context.WithValue(r.Context(), connIdentityContextKey{}, ci)
+		ctx = context.WithValue(ctx, principalContextKey{}, newPrincipal(nil, ci))
+		PrincipalFromContext(ctx).Transport = tr
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r.WithContext(ctx))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("auth-info status = %d; body: %s", rec.Code, rec.Body.Bytes())
+		}
+		var ai apitype.AuthInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &ai); err != nil {
+			t.Fatal(err)
+		}
+		return ai
+	}
+	check := func(t *testing.T, got, want apitype.AuthInfo) {
+		t.Helper()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("AuthInfo = %+v; want %+v", got, want)
+		}
+	}
+
+	t.Run("unix", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("no unix socket peer credentials on Windows")
+		}
+		s := newTestServer(t)
+		got := getAuthInfo(t, s, TransportUnix, ipnauth.NewUnixConnIdentity(nil, 42, "4242"))
+		check(t, got, apitype.AuthInfo{
+			Transport:  "unix",
+			Methods:    []string{apitype.AuthMethodPeerCreds},
+			Satisfied:  true,
+			Permission: "read",
+		})
+	})
+
+	t.Run("windows-tcp", func(t *testing.T) {
+		t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+		s := newTestServer(t)
+		alice := ipnauth.NewWindowsConnIdentity(nil, 1, "S-1-5-21-alice", nil)
+		bob := ipnauth.NewWindowsConnIdentity(nil, 2, "S-1-5-21-bob", nil)
+		want := apitype.AuthInfo{
+			Transport:  "tcp",
+			Methods:    []string{apitype.AuthMethodProcessOwner},
+			Satisfied:  true,
+			Permission: "write",
+		}
+		check(t, getAuthInfo(t, s, TransportTCP, bob), want)
+
+		// While alice is using the server, bob's connection isn't enough.
+		onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/", nil), alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer onDone()
+		want.Satisfied, want.Permission = false, "none"
+		check(t, getAuthInfo(t, s, TransportTCP, bob), want)
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		s := newTestServer(t)
+		got := getAuthInfo(t, s, TransportMemory, ipnauth.TrustedConnIdentity(nil))
+		check(t, got, apitype.AuthInfo{
+			Transport:  "memory",
+			Methods:    []string{apitype.AuthMethodInProcess},
+			Satisfied:  true,
+			Permission: "write",
+		})
+	})
+
+	t.Run("custom-resolver", func(t *testing.T) {
+		s := newTestServer(t)
+		s.IdentityResolver = trustedResolver
+		got := getAuthInfo(t, s, TransportOther, ipnauth.TrustedConnIdentity(nil))
+		check(t, got, apitype.AuthInfo{
+			Transport:  "other",
+			Methods:    []string{apitype.AuthMethodCustom},
+			Satisfied:  true,
+			Permission: "write",
+		})
+	})
+}
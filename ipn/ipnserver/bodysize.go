@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// sizeBucket is a bucket of a body size histogram: a counter of bodies of
+// at most le bytes.
+type sizeBucket struct {
+	le     int64
+	metric *clientmetric.Metric
+}
+
+// requestBodySizeBuckets and responseBodySizeBuckets are histograms of
+// the sizes of LocalAPI request and response bodies, excluding streaming
+// endpoints, as counters of bodies of at most each bucket's size. Larger
+// bodies are counted by the matching _gt_4mb counter.
+var (
+	requestBodySizeBuckets = []sizeBucket{
+		{0, clientmetric.NewCounter("ipnserver_request_body_bytes_le_0")},
+		{1 << 10, clientmetric.NewCounter("ipnserver_request_body_bytes_le_1kb")},
+		{16 << 10, clientmetric.NewCounter("ipnserver_request_body_bytes_le_16kb")},
+		{256 << 10, clientmetric.NewCounter("ipnserver_request_body_bytes_le_256kb")},
+		{4 << 20, clientmetric.NewCounter("ipnserver_request_body_bytes_le_4mb")},
+	}
+	responseBodySizeBuckets = []sizeBucket{
+		{0, clientmetric.NewCounter("ipnserver_response_body_bytes_le_0")},
+		{1 << 10, clientmetric.NewCounter("ipnserver_response_body_bytes_le_1kb")},
+		{16 << 10, clientmetric.NewCounter("ipnserver_response_body_bytes_le_16kb")},
+		{256 << 10, clientmetric.NewCounter("ipnserver_response_body_bytes_le_256kb")},
+		{4 << 20, clientmetric.NewCounter("ipnserver_response_body_bytes_le_4mb")},
+	}
+
+	metricRequestBodySizeOver  = clientmetric.NewCounter("ipnserver_request_body_bytes_gt_4mb")
+	metricResponseBodySizeOver = clientmetric.NewCounter("ipnserver_response_body_bytes_gt_4mb")
+)
+
+// observeSize counts a body of n bytes in the histogram buckets, or in
+// over if it's larger than the last bucket.
+func observeSize(buckets []sizeBucket, over *clientmetric.Metric, n int64) {
+	m := over
+	for _, b := range buckets {
+		if n <= b.le {
+			m = b.metric
+			break
+		}
+	}
+	m.Add(1)
+}
+
+// isStreamingPath reports whether requests to path stream or tunnel data
+// for as long as the client wants, rather than exchanging a request and a
+// response.
+func isStreamingPath(path string) bool {
+	return watcherPaths[path] || path == "/localapi/v0/dial"
+}
+
+// withBodySizes returns w and r updated to count the sizes of r's body
+// and its response, and a func to call once r's handler returns to record
+// them in the body size histograms. Streaming endpoints aren't counted.
+func withBodySizes(w http.ResponseWriter, r *http.Request) (_ http.ResponseWriter, _ *http.Request, done func()) {
+	if isStreamingPath(r.URL.Path) {
+		return w, r, func() {}
+	}
+	body := &countingBody{ReadCloser: r.Body}
+	r2 := r.WithContext(r.Context())
+	r2.Body = body
+	cw := &countingResponseWriter{responseWriter: responseWriter{w}}
+	return cw, r2, func() {
+		if cw.hijacked {
+			return
+		}
+		observeSize(requestBodySizeBuckets, metricRequestBodySizeOver, body.n)
+		observeSize(responseBodySizeBuckets, metricResponseBodySizeOver, cw.n)
+	}
+}
+
+// countingBody is a request body that counts the bytes read from it.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// countingResponseWriter is an http.ResponseWriter that counts the bytes
+// of body written through it.
+type countingResponseWriter struct {
+	responseWriter
+	n        int64
+	hijacked bool
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, brw, err := w.responseWriter.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return c, brw, err
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/util/clientmetric"
+)
+
+func TestBodySizeMetrics(t *testing.T) {
+	var flushable bool
+	serverHandlers["test-echo"] = func(_ *Server, _ *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		_, flushable = w.(http.Flusher)
+		io.WriteString(w, strings.Repeat("x", 100))
+	}
+	t.Cleanup(func() { delete(serverHandlers, "test-echo") })
+
+	s := newTestServer(t)
+	s.IdentityResolver = trustedResolver
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	ctx := s.connContext(context.Background(), c1)
+
+	metrics := []*clientmetric.Metric{
+		requestBodySizeBuckets[1].metric,  // le_1kb
+		requestBodySizeBuckets[2].metric,  // le_16kb
+		responseBodySizeBuckets[0].metric, // le_0
+		responseBodySizeBuckets[1].metric, // le_1kb
+	}
+	values := func() []int64 {
+		var ret []int64
+		for _, m := range metrics {
+			ret = append(ret, m.Value())
+		}
+		return ret
+	}
+	before := values()
+
+	r := httptest.NewRequest("POST", "/localapi/v0/test-echo", strings.NewReader(strings.Repeat("y", 2000))).WithContext(ctx)
+	r.Host = apitype.LocalAPIHost
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	if rec.Code != http.StatusOK || rec.Body.Len() != 100 {
+		t.Fatalf("status %d, %d bytes; want 200, 100 bytes", rec.Code, rec.Body.Len())
+	}
+	if !flushable {
+		t.Error("handler's ResponseWriter isn't an http.Flusher")
+	}
+
+	after := values()
+	for i, want := range []int64{0, 1, 0, 1} {
+		if got := after[i] - before[i]; got != want {
+			t.Errorf("%s increased by %d; want %d", metrics[i].Name(), got, want)
+		}
+	}
+
+	if !isStreamingPath("/localapi/v0/watch-ipn-bus") {
+		t.Error("watch-ipn-bus isn't counted as streaming")
+	}
+}
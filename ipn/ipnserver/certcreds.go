@@ -0,0 +1,37 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+
+	"inet.af/peercred"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/util/clientmetric"
+)
+
+var metricCertPeerUIDUnknown = clientmetric.NewCounter("ipnserver_cert_peer_uid_unknown")
+
+// errCertPeerUIDUnknown is why TS_PERMIT_CERT_UID can't be checked for a
+// connection whose peer credentials lack a user ID.
+var errCertPeerUIDUnknown = errors.New("peer credentials have no user ID on this platform, so TS_PERMIT_CERT_UID can't be checked")
+
+// peerCredsUserID returns the user ID in c, reporting false if c doesn't
+// include one, as on some platforms. It's a variable for tests.
+var peerCredsUserID = func(c *peercred.Creds) (uid string, ok bool) {
+	return c.UserID()
+}
+
+// noteCertPeerUIDUnknown records that TS_PERMIT_CERT_UID couldn't be checked
+// for ci because its peer credentials lack a user ID, and returns
+// errCertPeerUIDUnknown. It logs the first time only, as it's a property
+// of the platform rather than of the connection.
+func (s *Server) noteCertPeerUIDUnknown(ci *ipnauth.ConnIdentity) error {
+	metricCertPeerUIDUnknown.Add(1)
+	if !s.certUIDUnknownLogged.Swap(true) {
+		s.logf("cert access: %v (peer pid %d); denying. Set StrictCertPeerCreds to fail such requests loudly.", errCertPeerUIDUnknown, ci.Pid())
+	}
+	return errCertPeerUIDUnknown
+}
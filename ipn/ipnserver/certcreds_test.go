@@ -0,0 +1,59 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"inet.af/peercred"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestCertPeerUIDUnknown(t *testing.T) {
+	var credsUID string
+	var credsOK bool
+	old := peerCredsUserID
+	peerCredsUserID = func(*peercred.Creds) (string, bool) { return credsUID, credsOK }
+	defer func() { peerCredsUserID = old }()
+	t.Setenv("TS_PERMIT_CERT_UID", "1001")
+
+	ci := ipnauth.NewUnixCredsConnIdentity(nil, &peercred.Creds{})
+	s := newTestServer(t)
+
+	credsUID, credsOK = "1001", true
+	if ok, err := s.connCanFetchCerts(ci); !ok || err != nil {
+		t.Errorf("with uid 1001: connCanFetchCerts = %v, %v; want true, nil", ok, err)
+	}
+
+	credsUID, credsOK = "", false
+	if ok, err := s.connCanFetchCerts(ci); ok || err != errCertPeerUIDUnknown {
+		t.Errorf("without uid: connCanFetchCerts = %v, %v; want false, %v", ok, err, errCertPeerUIDUnknown)
+	}
+
+	fetch := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/localapi/v0/cert/foo.example.ts.net", nil)
+		r.Host = apitype.LocalAPIHost
+		rec := httptest.NewRecorder()
+		s.newLocalAPIHandler(s.mustBackend(), r, ci).ServeHTTP(rec, r)
+		return rec
+	}
+	if rec := fetch(); rec.Code != http.StatusForbidden {
+		t.Errorf("default: status %d, %q; want 403", rec.Code, rec.Body.Bytes())
+	}
+	s.StrictCertPeerCreds = true
+	rec := fetch()
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "TS_PERMIT_CERT_UID") {
+		t.Errorf("strict: status %d, %q; want 500 naming TS_PERMIT_CERT_UID", rec.Code, rec.Body.Bytes())
+	}
+
+	t.Setenv("TS_PERMIT_CERT_UID", "")
+	if ok, err := s.connCanFetchCerts(ci); ok || err != nil {
+		t.Errorf("without TS_PERMIT_CERT_UID: connCanFetchCerts = %v, %v; want false, nil", ok, err)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// CloseReason is why the server ended a watcher's stream, as sent to the
+// client in a final apitype.StreamClosed message.
+type CloseReason string
+
+const (
+	// CloseShutdown is when the server is shutting down.
+	CloseShutdown CloseReason = "shutdown"
+
+	// CloseTerminated is when the watcher was ended with TerminateWatcher.
+	CloseTerminated CloseReason = "terminated"
+
+	// CloseRequested is when the watcher asked to be closed, through the
+	// watcher-close endpoint.
+	CloseRequested CloseReason = "requested"
+)
+
+// watcherCloseReason returns why w's stream ended, or the empty string if
+// the server didn't end it (the client went away, or the handler returned
+// on its own).
+func (s *Server) watcherCloseReason(w *watcher) CloseReason {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.closeReason != "" {
+		return w.closeReason
+	}
+	if s.runCtx != nil && s.runCtx.Err() != nil {
+		return CloseShutdown
+	}
+	return ""
+}
+
+// writeCloseReason sends the final message of a watcher stream that the
+// server ended for the given reason. Errors are ignored: the client may
+// well be gone already.
+func writeCloseReason(w http.ResponseWriter, reason CloseReason) {
+	json.NewEncoder(w).Encode(apitype.StreamClosed{Closed: string(reason)})
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/mak"
+)
+
+var (
+	metricConnsOpen = clientmetric.NewGauge("ipnserver_conns_open")
+	metricConnsPeak = clientmetric.NewGauge("ipnserver_conns_peak")
+)
+
+// errTooManyConns is the error for connections denied by Server.MaxConns.
+var errTooManyConns = errors.New("too many open LocalAPI connections; try again later")
+
+// admitConn counts the newly accepted connection c as open, reporting
+// whether it's within s.MaxConns. Connections are counted until connState sees
+// them closed or hijacked.
+func (s *Server) admitConn(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.openConns++
+	mak.Set(&s.connKinds, c, connKind{
+		transport:  transportOf(c),
+		permission: permissionUnknown,
+		clientType: clientTypeUnknown,
+	})
+	metricConnsOpen.Set(int64(s.openConns))
+	s.updateGoroutinesMetricLocked()
+	if s.openConns > s.peakConns {
+		s.peakConns = s.openConns
+		metricConnsPeak.Set(int64(s.peakConns))
+	}
+	return s.MaxConns <= 0 || s.openConns <= s.MaxConns
+}
+
+// noteConnClosedLocked counts c as no longer open.
+//
+// s.mu must be held.
+func (s *Server) noteConnClosedLocked(c net.Conn) {
+	if s.openConns > 0 {
+		s.openConns--
+	}
+	delete(s.connKinds, c)
+	metricConnsOpen.Set(int64(s.openConns))
+	s.updateGoroutinesMetricLocked()
+}
+
+// reportConnCounts reports the open connection count and its peak to the
+// LocalBackend every s.ConnCountReportInterval, when they've changed, until
+// done is closed.
+func (s *Server) reportConnCounts(done <-chan struct{}) {
+	t := time.NewTicker(s.ConnCountReportInterval)
+	defer t.Stop()
+	lastOpen, lastPeak := -1, -1
+	for {
+		select {
+		case <-t.C:
+		case <-done:
+			return
+		}
+		s.mu.Lock()
+		open, peak := s.openConns, s.peakConns
+		s.mu.Unlock()
+		lb := s.lb.Load()
+		if lb == nil || (open == lastOpen && peak == lastPeak) {
+			continue
+		}
+		lb.SetLocalAPIConns(open, peak)
+		lastOpen, lastPeak = open, peak
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestMaxConns(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxConns = 2
+	s.IdentityResolver = trustedResolver
+
+	var conns []net.Conn
+	var ctxs []context.Context
+	for i := 0; i < 3; i++ {
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		conns = append(conns, c1)
+		ctxs = append(ctxs, s.connContext(context.Background(), c1))
+	}
+	st := s.Stats()
+	if st.OpenConns != 3 || st.PeakConns != 3 || st.MaxConns != 2 {
+		t.Errorf("open, peak, max = %d, %d, %d; want 3, 3, 2", st.OpenConns, st.PeakConns, st.MaxConns)
+	}
+
+	serve := func(ctx context.Context) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/localapi/v0/status", nil).WithContext(ctx)
+		r.Host = apitype.LocalAPIHost
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		return rec
+	}
+	if rec := serve(ctxs[0]); rec.Code != http.StatusOK {
+		t.Errorf("first conn: status %d; want 200", rec.Code)
+	}
+	rec := serve(ctxs[2])
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("conn over cap: status %d; want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Connection"); got != "close" {
+		t.Errorf("conn over cap: Connection = %q; want close", got)
+	}
+
+	s.connState(conns[2], http.StateClosed)
+	s.connState(conns[1], http.StateClosed)
+	st = s.Stats()
+	if st.OpenConns != 1 || st.PeakConns != 3 {
+		t.Errorf("after closing: open, peak = %d, %d; want 1, 3", st.OpenConns, st.PeakConns)
+	}
+	if !s.admitConn(conns[0]) {
+		t.Error("conn under cap after closing was not admitted")
+	}
+}
+
+func TestConnCountReports(t *testing.T) {
+	s := newTestServer(t)
+	s.ConnCountReportInterval = 10 * time.Millisecond
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	lb := s.mustBackend()
+	reported := func(open, peak int) func() bool {
+		return func() bool {
+			st := lb.StatusWithoutPeers()
+			return st.LocalAPIConns == open && st.LocalAPIConnsPeak == peak
+		}
+	}
+	c, err := dial(context.Background(), "tcp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "backend to be told of the open conn", reported(1, 1))
+	c.Close()
+	waitFor(t, "backend to be told the conn closed", reported(0, 1))
+}
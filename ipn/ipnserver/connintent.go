@@ -0,0 +1,68 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/util/mak"
+)
+
+// applyConnIntent tunes the keep-alive handling of r's connection according
+// to the intent the client declared in its apitype.ConnIntentHeader.
+//
+// Connections of one-shot clients, such as the CLI, are closed after each
+// response rather than kept idle: on Windows, an idle connection locks the
+// server into serving its user (see DefaultIdleTimeout), and a CLI that has
+// exited has no use for it. Persistent and untagged connections get the
+// usual per-Transport idle timeout.
+func applyConnIntent(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(apitype.ConnIntentHeader) == apitype.ConnIntentOneShot {
+		w.Header().Set("Connection", "close")
+	}
+}
+
+// applyClientIdleTimeout applies the idle timeout that r's client
+// requested in its apitype.IdleTimeoutHeader, if any, to the rest of r's
+// connection, in place of its Transport's. It reports whether r may
+// proceed: if the value is invalid or above s.MaxClientIdleTimeout, it
+// writes a 400 Bad Request and returns false. Without a
+// MaxClientIdleTimeout, the header is ignored.
+func (s *Server) applyClientIdleTimeout(w http.ResponseWriter, r *http.Request) bool {
+	v := r.Header.Get(apitype.IdleTimeoutHeader)
+	if v == "" || s.MaxClientIdleTimeout <= 0 {
+		return true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		http.Error(w, fmt.Sprintf("invalid %s %q", apitype.IdleTimeoutHeader, v), http.StatusBadRequest)
+		return false
+	}
+	if d > s.MaxClientIdleTimeout {
+		http.Error(w, fmt.Sprintf("%s %v exceeds the maximum of %v", apitype.IdleTimeoutHeader, d, s.MaxClientIdleTimeout), http.StatusBadRequest)
+		return false
+	}
+	if c, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+		s.mu.Lock()
+		mak.Set(&s.connIdleTimeouts, c, d)
+		s.mu.Unlock()
+	}
+	return true
+}
+
+// connIdleTimeoutLocked returns how long c is kept open while idle: as
+// its client requested, if it did, else per its Transport.
+//
+// s.mu must be held.
+func (s *Server) connIdleTimeoutLocked(c net.Conn) time.Duration {
+	if d, ok := s.connIdleTimeouts[c]; ok {
+		return d
+	}
+	return s.idleTimeout(transportOf(c))
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestConnIntent(t *testing.T) {
+	for _, tt := range []struct {
+		intent    string
+		wantClose bool
+	}{
+		{"", false},
+		{apitype.ConnIntentPersistent, false},
+		{apitype.ConnIntentOneShot, true},
+	} {
+		t.Run("intent="+tt.intent, func(t *testing.T) {
+			s := newTestServer(t)
+			ln, dial := NewMemListener()
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error, 1)
+			go func() { errc <- s.Run(ctx, ln) }()
+			defer func() {
+				cancel()
+				<-errc
+			}()
+
+			hc := &http.Client{Transport: &http.Transport{DialContext: dial}}
+			defer hc.CloseIdleConnections()
+			req, err := http.NewRequest("GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/server-stats", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.intent != "" {
+				req.Header.Set(apitype.ConnIntentHeader, tt.intent)
+			}
+			res, err := hc.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("status %d", res.StatusCode)
+			}
+			if res.Close != tt.wantClose {
+				t.Errorf("response Close = %v; want %v", res.Close, tt.wantClose)
+			}
+			if tt.wantClose {
+				waitFor(t, "one-shot conn to close", func() bool { return s.Stats().OpenConns == 0 })
+			} else if n := s.Stats().OpenConns; n != 1 {
+				t.Errorf("open conns after response = %d; want the conn kept idle", n)
+			}
+		})
+	}
+}
+
+func TestClientIdleTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantKept   bool
+	}{
+		{"none", "", http.StatusOK, false},
+		{"within-max", "1m", http.StatusOK, true},
+		{"at-max", "2m", http.StatusOK, true},
+		{"above-max", "3m", http.StatusBadRequest, false},
+		{"invalid", "forever", http.StatusBadRequest, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.IdleTimeouts = map[Transport]time.Duration{TransportMemory: 10 * time.Millisecond}
+			s.MaxClientIdleTimeout = 2 * time.Minute
+			ln, dial := NewMemListener()
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error, 1)
+			go func() { errc <- s.Run(ctx, ln) }()
+			defer func() {
+				cancel()
+				<-errc
+			}()
+
+			hc := &http.Client{Transport: &http.Transport{DialContext: dial}}
+			defer hc.CloseIdleConnections()
+			req, err := http.NewRequest("GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/server-stats", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set(apitype.IdleTimeoutHeader, tt.header)
+			}
+			res, err := hc.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("status %d; want %d", res.StatusCode, tt.wantStatus)
+			}
+			if tt.wantKept {
+				time.Sleep(100 * time.Millisecond) // well past the transport's idle timeout
+				if n := s.Stats().OpenConns; n != 1 {
+					t.Errorf("open conns = %d; want the conn kept idle", n)
+				}
+			} else {
+				waitFor(t, "idle conn to close", func() bool { return s.Stats().OpenConns == 0 })
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/localapi"
+)
+
+// connKind is what the ipnserver_active_conns metric labels a connection
+// with.
+//
+// Each label must only take a small, fixed set of values, so that the
+// number of series stays small however many clients connect and however
+// long tailscaled runs. Per-client dimensions, such as PIDs, userids and
+// usernames, must never be labels: each new client would add series for
+// good, swamping the scraper. They're available from the watchers and
+// server-stats endpoints instead.
+type connKind struct {
+	transport  Transport       // one of allTransports
+	permission PermissionLevel // from the connection's last request, or permissionUnknown
+	clientType string          // an apitype.ConnIntent* value, or clientTypeUnknown
+}
+
+// connMetricLabels are the labels of the ipnserver_active_conns metric, in
+// order. See connKind for why there are no others.
+var connMetricLabels = []string{"transport", "permission", "client_type"}
+
+const (
+	// permissionUnknown is the permission label of a connection that
+	// hasn't made a request yet.
+	permissionUnknown PermissionLevel = "unknown"
+
+	// clientTypeUnknown is the client_type label of a connection that
+	// didn't declare its intent (see apitype.ConnIntentHeader).
+	clientTypeUnknown = "unknown"
+)
+
+// noteConnKind records the permission level granted by h and the intent
+// declared by r's client as the kind of r's connection.
+func (s *Server) noteConnKind(r *http.Request, h *localapi.Handler) {
+	c, ok := r.Context().Value(connContextKey{}).(net.Conn)
+	if !ok {
+		return
+	}
+	perm := PermissionNone
+	switch {
+	case h.PermitWrite:
+		perm = PermissionWrite
+	case h.PermitCert:
+		perm = PermissionCert
+	case h.PermitRead:
+		perm = PermissionRead
+	}
+	// The header is client-controlled, so only known values make it into
+	// a label.
+	clientType := clientTypeUnknown
+	switch v := r.Header.Get(apitype.ConnIntentHeader); v {
+	case apitype.ConnIntentPersistent, apitype.ConnIntentOneShot:
+		clientType = v
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.connKinds[c]; ok { // else already closed
+		s.connKinds[c] = connKind{transportOf(c), perm, clientType}
+	}
+}
+
+// writeConnMetrics writes the ipnserver_active_conns gauge, the number of
+// open connections of each connKind, in the Prometheus text exposition
+// format. Kinds without open connections are omitted.
+func (s *Server) writeConnMetrics(w io.Writer) {
+	s.mu.Lock()
+	counts := make(map[connKind]int)
+	for _, k := range s.connKinds {
+		counts[k]++
+	}
+	s.mu.Unlock()
+
+	kinds := make([]connKind, 0, len(counts))
+	for k := range counts {
+		kinds = append(kinds, k)
+	}
+	slices.SortFunc(kinds, func(a, b connKind) bool {
+		if a.transport != b.transport {
+			return a.transport < b.transport
+		}
+		if a.permission != b.permission {
+			return a.permission < b.permission
+		}
+		return a.clientType < b.clientType
+	})
+	fmt.Fprintf(w, "# TYPE ipnserver_active_conns gauge\n")
+	for _, k := range kinds {
+		fmt.Fprintf(w, "ipnserver_active_conns{%s=%q,%s=%q,%s=%q} %d\n",
+			connMetricLabels[0], k.transport,
+			connMetricLabels[1], k.permission,
+			connMetricLabels[2], k.clientType,
+			counts[k])
+	}
+}
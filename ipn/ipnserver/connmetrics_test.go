@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestConnMetricsLabels(t *testing.T) {
+	s := newTestServer(t)
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	hc := &http.Client{Transport: &http.Transport{DialContext: dial}}
+	defer hc.CloseIdleConnections()
+	get := func(path, intent string) string {
+		t.Helper()
+		req, err := http.NewRequest("GET", "http://"+apitype.LocalAPIHost+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(apitype.ConnIntentHeader, intent)
+		res, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil || res.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: %v, %v", path, res.Status, err)
+		}
+		return string(b)
+	}
+	// A bogus intent mustn't become a label value.
+	get("/localapi/v0/server-stats", "pid-12345")
+	metrics := get("/localapi/v0/metrics", apitype.ConnIntentPersistent)
+
+	series := regexp.MustCompile(`(?m)^ipnserver_active_conns\{(.*)\} (\d+)$`).FindAllStringSubmatch(metrics, -1)
+	if len(series) == 0 {
+		t.Fatalf("no ipnserver_active_conns series in:\n%s", metrics)
+	}
+	labelRx := regexp.MustCompile(`(\w+)="([^"]*)"`)
+	var sawThis bool
+	for _, m := range series {
+		var names []string
+		for _, l := range labelRx.FindAllStringSubmatch(m[1], -1) {
+			names = append(names, l[1])
+			if l[1] == "client_type" && !slices.Contains([]string{"persistent", "one-shot", "unknown"}, l[2]) {
+				t.Errorf("series %q has client_type %q", m[0], l[2])
+			}
+		}
+		if !slices.Equal(names, connMetricLabels) {
+			t.Errorf("series %q has labels %q; want exactly %q", m[0], names, connMetricLabels)
+		}
+		if strings.Contains(m[1], `transport="memory",permission="write",client_type="persistent"`) {
+			sawThis = true
+		}
+	}
+	if !sawThis {
+		t.Errorf("no series for this connection in:\n%s", metrics)
+	}
+}
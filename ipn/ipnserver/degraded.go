@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/clientmetric"
+)
+
+var metricBackendPanics = clientmetric.NewCounter("ipnserver_backend_panics")
+
+// warnDegraded is the health warning set once the server has recovered
+// from a panic, if Server.DegradeOnPanic is set.
+var warnDegraded = health.NewWarnable()
+
+// errBackendPanicked is returned by callBackend when the call panicked.
+var errBackendPanicked = errors.New("internal error in tailscaled's backend")
+
+// backendStatus returns lb's status. It's a variable for tests.
+var backendStatus = (*ipnlocal.LocalBackend).Status
+
+// callBackend calls fn, a call into the LocalBackend described by what (such
+// as "Status"), recovering from any panic in it. On panic, it logs the
+// panic, enters the degraded state if s.DegradeOnPanic is set, and returns
+// errBackendPanicked.
+func (s *Server) callBackend(what string, fn func()) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			metricBackendPanics.Add(1)
+			s.logf("panic in LocalBackend.%s: %v\n%s", what, p, debug.Stack())
+			s.noteRecoveredPanic("LocalBackend." + what)
+			err = errBackendPanicked
+		}
+	}()
+	fn()
+	return nil
+}
+
+// status returns lb's status, or errBackendPanicked if getting it panicked.
+func (s *Server) status(lb *ipnlocal.LocalBackend) (st *ipnstate.Status, err error) {
+	err = s.callBackend("Status", func() { st = backendStatus(lb) })
+	return st, err
+}
+
+// noteRecoveredPanic records that the server recovered from a panic in
+// where, entering the degraded state if s.DegradeOnPanic is set. The first
+// such panic is kept as the reason.
+//
+// It doesn't acquire s.mu, which the panicking code may have held.
+func (s *Server) noteRecoveredPanic(where string) {
+	if !s.DegradeOnPanic {
+		return
+	}
+	reason := "recovered from a panic in " + where
+	if !s.degraded.CompareAndSwap(nil, &reason) {
+		return
+	}
+	warnDegraded.Set(fmt.Errorf("tailscaled %s and may be degraded; restart it if problems persist", reason))
+}
+
+// Degraded returns why the server is degraded, or the empty string if it
+// isn't. See Server.DegradeOnPanic.
+//
+// It doesn't acquire s.mu, so may be called with it held.
+func (s *Server) Degraded() string {
+	if p := s.degraded.Load(); p != nil {
+		return *p
+	}
+	return ""
+}
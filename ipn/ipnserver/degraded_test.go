@@ -0,0 +1,82 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestBackendPanicDegrades(t *testing.T) {
+	old := backendStatus
+	backendStatus = func(*ipnlocal.LocalBackend) *ipnstate.Status { panic("boom") }
+	t.Cleanup(func() {
+		backendStatus = old
+		warnDegraded.Set(nil)
+	})
+
+	s := newTestServer(t)
+	serve := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost:41112/", nil)
+		rec := httptest.NewRecorder()
+		s.ServeHTMLStatus(rec, req)
+		return rec
+	}
+
+	if rec := serve(); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d; want 500", rec.Code)
+	}
+	if d := s.Degraded(); d != "" {
+		t.Errorf("degraded without DegradeOnPanic: %q", d)
+	}
+
+	s.DegradeOnPanic = true
+	rec := serve()
+	if rec.Code != http.StatusInternalServerError || strings.Contains(rec.Body.String(), "boom") {
+		t.Errorf("status %d, %q; want 500 with a generic message", rec.Code, rec.Body.Bytes())
+	}
+	if d := s.Stats().Degraded; !strings.Contains(d, "LocalBackend.Status") {
+		t.Errorf("Stats().Degraded = %q; want it to name LocalBackend.Status", d)
+	}
+	if err := health.OverallError(); err == nil || !strings.Contains(err.Error(), "degraded") {
+		t.Errorf("health.OverallError() = %v; want a degraded warning", err)
+	}
+}
+
+func TestPanicWithLockHeldDegrades(t *testing.T) {
+	t.Cleanup(func() { warnDegraded.Set(nil) })
+	s := newTestServer(t)
+	s.DegradeOnPanic = true
+	h := s.recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		panic("boom")
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/localapi/v0/status", nil))
+		done <- rec
+	}()
+	select {
+	case rec := <-done:
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status %d; want 500", rec.Code)
+		}
+		s.mu.Unlock() // left locked by the panicking handler
+	case <-time.After(5 * time.Second):
+		t.Fatal("recovering from a panic with s.mu held hung")
+	}
+	if d := s.Degraded(); !strings.Contains(d, "/localapi/v0/status") {
+		t.Errorf("Degraded = %q; want it to name the handler", d)
+	}
+}
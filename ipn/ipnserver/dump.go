@@ -0,0 +1,82 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/localapi"
+)
+
+// dumpActiveReqs logs a summary of the server's in-flight LocalAPI requests,
+// for debugging after a request handler panics (see recoverPanics), when
+// Run panics on its way to crashing the process, and when Run shuts down
+// with requests stuck in flight.
+//
+// It's safe to call while recovering from a panic, including one raised
+// while s.mu was held and never released: it never blocks on s.mu, and gives
+// up if the lock is unavailable.
+func (s *Server) dumpActiveReqs() {
+	if !s.mu.TryLock() {
+		s.logf("active requests: unavailable; server lock busy")
+		return
+	}
+	type entry struct {
+		desc, method, path string
+		start              time.Time
+	}
+	ents := make([]entry, 0, len(s.activeReqs))
+	for r, ar := range s.activeReqs {
+		ents = append(ents, entry{describeConnIdentity(ar.ci), r.Method, r.URL.Path, ar.start})
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(ents, func(a, b entry) bool { return a.start.Before(b.start) })
+	now := time.Now()
+	s.logf("active requests: %d", len(ents))
+	for _, e := range ents {
+		s.logf("active request: %s %s from %s, age %v", e.method, e.path, e.desc, now.Sub(e.start).Round(time.Millisecond))
+	}
+}
+
+// backendDump writes lb's debug dump to w. It's a variable for tests.
+var backendDump = (*ipnlocal.LocalBackend).DebugDump
+
+// serveBackendDump handles POST /localapi/v0/backend-dump, returning the
+// backend's debug dump (see LocalBackend.DebugDump) as text, or with
+// ?log=1, writing it to tailscaled's log instead for support to collect
+// later.
+func (s *Server) serveBackendDump(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "backend-dump access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	lb := s.mustBackend()
+	var buf bytes.Buffer
+	if err := s.callBackend("DebugDump", func() { backendDump(lb, &buf) }); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.FormValue("log") == "" {
+		w.Write(buf.Bytes())
+		return
+	}
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		s.logf("backend dump: %s", sc.Text())
+	}
+	io.WriteString(w, "backend dump written to the log\n")
+}
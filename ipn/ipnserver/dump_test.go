@@ -0,0 +1,181 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/ipnlocal"
+)
+
+func TestDumpActiveReqs(t *testing.T) {
+	var logs []string
+	s := &Server{logf: func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}}
+	r := httptest.NewRequest("GET", "/localapi/v0/watch-ipn-bus", nil)
+	s.activeReqs = map[*http.Request]*activeRequest{
+		r: {ci: &ipnauth.ConnIdentity{}, start: time.Now().Add(-time.Minute)},
+	}
+
+	s.dumpActiveReqs()
+	got := strings.Join(logs, "\n")
+	if !strings.Contains(got, "active requests: 1") || !strings.Contains(got, "GET /localapi/v0/watch-ipn-bus") {
+		t.Errorf("unexpected dump:\n%s", got)
+	}
+
+	// Must not deadlock if the lock is already held, as when panicking
+	// from within a critical section.
+	logs = nil
+	s.mu.Lock()
+	s.dumpActiveReqs()
+	s.mu.Unlock()
+	if len(logs) != 1 || !strings.Contains(logs[0], "lock busy") {
+		t.Errorf("dump with lock held logged %q", logs)
+	}
+}
+
+// panickingListener is a net.Listener whose Accept panics.
+type panickingListener struct{ net.Listener }
+
+func (panickingListener) Accept() (net.Conn, error) { panic("accept boom") }
+
+func TestDumpActiveReqsOnRunPanic(t *testing.T) {
+	var mu sync.Mutex
+	var logs []string
+	s := New(func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}, "logid")
+	r := httptest.NewRequest("GET", "/localapi/v0/watch-ipn-bus", nil)
+	s.activeReqs = map[*http.Request]*activeRequest{
+		r: {ci: &ipnauth.ConnIdentity{}, start: time.Now().Add(-time.Minute)},
+	}
+	ln, _ := NewMemListener()
+
+	func() {
+		defer func() {
+			if p := recover(); p != "accept boom" {
+				t.Errorf("Run panicked with %v; want the listener's panic", p)
+			}
+		}()
+		s.Run(context.Background(), panickingListener{ln})
+	}()
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(logs, "\n"); !strings.Contains(got, "active requests: 1") || !strings.Contains(got, "GET /localapi/v0/watch-ipn-bus") {
+		t.Errorf("Run panic didn't dump active requests:\n%s", got)
+	}
+}
+
+func TestDumpActiveReqsOnHandlerPanic(t *testing.T) {
+	var logs []string
+	s := &Server{logf: func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}}
+	other := httptest.NewRequest("GET", "/localapi/v0/watch-ipn-bus", nil)
+	s.activeReqs = map[*http.Request]*activeRequest{
+		other: {ci: &ipnauth.ConnIdentity{}, start: time.Now().Add(-time.Minute)},
+	}
+
+	serve := func(h http.HandlerFunc) {
+		t.Helper()
+		logs = nil
+		rec := httptest.NewRecorder()
+		s.recoverPanics(h).ServeHTTP(rec, httptest.NewRequest("POST", "/localapi/v0/prefs", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("status = %d; want 500", rec.Code)
+		}
+	}
+
+	serve(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	got := strings.Join(logs, "\n")
+	if !strings.Contains(got, "active requests: 1") || !strings.Contains(got, "GET /localapi/v0/watch-ipn-bus") {
+		t.Errorf("handler panic didn't dump active requests:\n%s", got)
+	}
+
+	// A handler that panics with s.mu held must not deadlock the dump.
+	serve(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		panic("boom")
+	})
+	s.mu.Unlock()
+	if got := strings.Join(logs, "\n"); !strings.Contains(got, "lock busy") {
+		t.Errorf("handler panic with lock held logged:\n%s", got)
+	}
+}
+
+func TestBackendDump(t *testing.T) {
+	s := newTestServer(t)
+	var real strings.Builder
+	s.mustBackend().DebugDump(&real)
+	if !strings.Contains(real.String(), "state: ") {
+		t.Errorf("DebugDump = %q; want a state line", real.String())
+	}
+
+	calls := 0
+	old := backendDump
+	backendDump = func(lb *ipnlocal.LocalBackend, w io.Writer) {
+		calls++
+		io.WriteString(w, "fake dump\n")
+	}
+	t.Cleanup(func() { backendDump = old })
+	var logs []string
+	s.logf = func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+
+	post := func(path string, ci *ipnauth.ConnIdentity) *httptest.ResponseRecorder {
+		s.IdentityResolver = func(net.Conn) (*ipnauth.ConnIdentity, error) { return ci, nil }
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		r := httptest.NewRequest("POST", path, nil).WithContext(s.connContext(context.Background(), c1))
+		r.Host = apitype.LocalAPIHost
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		return rec
+	}
+
+	if rec := post("/localapi/v0/backend-dump", ipnauth.NewUnixConnIdentity(nil, 42, "4242")); rec.Code != http.StatusForbidden {
+		t.Errorf("read-only: status %d; want 403", rec.Code)
+	}
+	if calls != 0 {
+		t.Errorf("backend dumped %d times for a read-only caller", calls)
+	}
+
+	trusted := ipnauth.TrustedConnIdentity(nil)
+	rec := post("/localapi/v0/backend-dump", trusted)
+	if rec.Code != http.StatusOK || rec.Body.String() != "fake dump\n" {
+		t.Errorf("trusted: status %d, %q; want 200, the dump", rec.Code, rec.Body.Bytes())
+	}
+	if rec := post("/localapi/v0/backend-dump?log=1", trusted); rec.Code != http.StatusOK {
+		t.Errorf("to log: status %d; want 200", rec.Code)
+	}
+	if calls != 2 {
+		t.Errorf("backend dumped %d times; want 2", calls)
+	}
+	var logged bool
+	for _, l := range logs {
+		if l == "backend dump: fake dump" {
+			logged = true
+		}
+	}
+	if !logged {
+		t.Errorf("dump not logged; logs: %q", logs)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build ts_debug_faults
+
+package ipnserver
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/version"
+)
+
+// Fault describes a synthetic failure injected into LocalAPI requests to let
+// client developers exercise their error handling and retry logic against a
+// real tailscaled.
+//
+// Fault injection is only compiled in with the ts_debug_faults build tag.
+type Fault struct {
+	// PathPrefix, if non-empty, restricts the fault to requests whose
+	// URL path starts with PathPrefix.
+	PathPrefix string
+
+	// Probability is the chance, in [0,1], that a matching request
+	// gets the fault.
+	Probability float64
+
+	// Latency, if non-zero, is how long to stall before handling (or
+	// failing) the request.
+	Latency time.Duration
+
+	// StatusCode, if non-zero, is the HTTP status code to fail the
+	// request with instead of handling it.
+	StatusCode int
+
+	// DropConn, if true, aborts the connection without writing a
+	// response.
+	DropConn bool
+}
+
+// faultsEnabled is whether fault injection was compiled in.
+const faultsEnabled = true
+
+func init() {
+	version.RegisterBuildFeature("fault-injection")
+}
+
+type faultInjector struct {
+	mu     sync.Mutex
+	faults []Fault
+	rnd    *rand.Rand // or nil to use the global source
+}
+
+// SetFaults replaces the set of faults injected into LocalAPI requests.
+// A nil or empty slice disables fault injection.
+func (s *Server) SetFaults(faults []Fault) {
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+	s.faults.faults = append([]Fault(nil), faults...)
+}
+
+// pick returns the first configured fault matching urlPath that fires,
+// if any.
+func (fi *faultInjector) pick(urlPath string) (f Fault, ok bool) {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	for _, f := range fi.faults {
+		if !strings.HasPrefix(urlPath, f.PathPrefix) {
+			continue
+		}
+		var v float64
+		if fi.rnd != nil {
+			v = fi.rnd.Float64()
+		} else {
+			v = rand.Float64()
+		}
+		if v < f.Probability {
+			return f, true
+		}
+	}
+	return Fault{}, false
+}
+
+// injectFault applies a configured fault to r, if one fires. It reports
+// whether the request was fully handled by the fault, in which case the
+// caller must not process it further.
+func (s *Server) injectFault(w http.ResponseWriter, r *http.Request) (handled bool) {
+	f, ok := s.faults.pick(r.URL.Path)
+	if !ok {
+		return false
+	}
+	s.logf("injecting fault %+v into %s %s", f, r.Method, r.URL.Path)
+	if f.Latency > 0 {
+		t := time.NewTimer(f.Latency)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+			return true
+		}
+	}
+	if f.DropConn {
+		panic(http.ErrAbortHandler)
+	}
+	if f.StatusCode != 0 {
+		http.Error(w, "injected fault", f.StatusCode)
+		return true
+	}
+	return false
+}
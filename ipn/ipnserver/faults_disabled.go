@@ -0,0 +1,18 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !ts_debug_faults
+
+package ipnserver
+
+import "net/http"
+
+// faultsEnabled is whether fault injection was compiled in.
+const faultsEnabled = false
+
+type faultInjector struct{}
+
+func (s *Server) injectFault(w http.ResponseWriter, r *http.Request) (handled bool) {
+	return false
+}
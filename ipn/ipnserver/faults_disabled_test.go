@@ -0,0 +1,23 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !ts_debug_faults
+
+package ipnserver
+
+import (
+	"net/http/httptest"
+	"testing"
+	"unsafe"
+)
+
+func TestFaultInjectionCompiledOut(t *testing.T) {
+	if n := unsafe.Sizeof(faultInjector{}); n != 0 {
+		t.Errorf("faultInjector size = %d; want 0 without ts_debug_faults", n)
+	}
+	s := &Server{}
+	if s.injectFault(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)) {
+		t.Error("fault injected without ts_debug_faults")
+	}
+}
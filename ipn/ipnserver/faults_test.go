@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build ts_debug_faults
+
+package ipnserver
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFaultInjectionRate(t *testing.T) {
+	s := &Server{logf: t.Logf}
+	s.faults.rnd = rand.New(rand.NewSource(1))
+	s.SetFaults([]Fault{{
+		PathPrefix:  "/localapi/v0/status",
+		Probability: 0.25,
+		StatusCode:  http.StatusTeapot,
+	}})
+
+	const n = 2000
+	var injected int
+	for i := 0; i < n; i++ {
+		rec := httptest.NewRecorder()
+		if s.injectFault(rec, httptest.NewRequest("GET", "/localapi/v0/status", nil)) {
+			if rec.Code != http.StatusTeapot {
+				t.Fatalf("injected status = %d; want %d", rec.Code, http.StatusTeapot)
+			}
+			injected++
+		}
+	}
+	if got := float64(injected) / n; got < 0.20 || got > 0.30 {
+		t.Errorf("injected fault rate = %v; want ~0.25", got)
+	}
+
+	// Other paths are unaffected.
+	for i := 0; i < 100; i++ {
+		if s.injectFault(httptest.NewRecorder(), httptest.NewRequest("GET", "/localapi/v0/prefs", nil)) {
+			t.Fatal("fault injected into non-matching path")
+		}
+	}
+
+	// Disabled.
+	s.SetFaults(nil)
+	for i := 0; i < 100; i++ {
+		if s.injectFault(httptest.NewRecorder(), httptest.NewRequest("GET", "/localapi/v0/status", nil)) {
+			t.Fatal("fault injected after SetFaults(nil)")
+		}
+	}
+}
+
+func TestFaultInjectionLatencyAndDrop(t *testing.T) {
+	s := &Server{logf: t.Logf}
+	s.SetFaults([]Fault{{Probability: 1, Latency: 50 * time.Millisecond}})
+	t0 := time.Now()
+	if s.injectFault(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil)) {
+		t.Error("latency-only fault handled the request")
+	}
+	if d := time.Since(t0); d < 50*time.Millisecond {
+		t.Errorf("latency = %v; want >= 50ms", d)
+	}
+
+	s.SetFaults([]Fault{{Probability: 1, DropConn: true}})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.injectFault(w, r)
+	}))
+	defer ts.Close()
+	if res, err := http.Get(ts.URL); err == nil {
+		res.Body.Close()
+		t.Error("expected dropped connection; got response")
+	}
+}
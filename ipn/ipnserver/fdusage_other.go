@@ -0,0 +1,11 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !(linux || darwin || freebsd || openbsd)
+
+package ipnserver
+
+func fdUsageOS() (open, limit int) {
+	return -1, -1
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin || freebsd || openbsd
+
+package ipnserver
+
+import (
+	"os"
+	"syscall"
+)
+
+func fdUsageOS() (open, limit int) {
+	open, limit = -1, -1
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err == nil {
+		limit = int(rl.Cur)
+	}
+	// Listing the directory needs a file descriptor itself, so this fails
+	// when the limit has been reached exactly.
+	if ents, err := os.ReadDir("/dev/fd"); err == nil {
+		open = len(ents)
+	}
+	return open, limit
+}
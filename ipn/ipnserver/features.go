@@ -0,0 +1,100 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/version"
+)
+
+// Features reports which optional Server behaviors are enabled, keyed by
+// a short stable feature name. It's derived only from the Server's
+// configuration and contains no secrets, so it's safe to log and to show
+// to any LocalAPI reader.
+func (s *Server) Features() map[string]bool {
+	return map[string]bool{
+		"accept-windows":          len(s.AcceptWindows) > 0,
+		"access-log":              s.AccessLog != nil,
+		"active-requests-valve":   s.RejectOverActiveRequestsThreshold && s.ActiveRequestsThreshold > 0,
+		"audit-sinks":             len(s.AuditSinks) > 0,
+		"buffered-response-limit": s.MaxBufferedResponseBytes > 0,
+		"client-ancestry":         len(s.AllowedClientAncestors) > 0,
+		"client-mode":             s.resetOnZero,
+		"conn-count-reports":      s.ConnCountReportInterval > 0,
+		"custom-identity":         s.IdentityResolver != nil,
+		"degrade-on-panic":        s.DegradeOnPanic,
+		"identity-permissions":    s.PermissionsFile != "",
+		"idle-exit":               s.IdleExitTimeout > 0,
+		"invalid-prefs-policy":    s.InvalidPrefsPolicy != InvalidPrefsWait,
+		"fault-injection":         faultsEnabled,
+		"keep-socket-on-shutdown": s.KeepSocketOnShutdown,
+		"max-client-idle-timeout": s.MaxClientIdleTimeout > 0,
+		"max-conns":               s.MaxConns > 0,
+		"max-goroutines":          s.MaxGoroutines > 0,
+		"max-request-duration":    s.MaxRequestDuration > 0,
+		"middleware":              s.Middleware != nil,
+		"path-prefix":             s.PathPrefix != "",
+		"per-user-request-limit":  s.MaxRequestsPerUser > 0,
+		"require-peer-creds":      s.RequirePeerCreds,
+		"restricted-root":         s.RootPolicy != RootPolicyDefault,
+		"status-rate-limit":       s.StatusQueryInterval > 0,
+		"strict-cert-peer-creds":  s.StrictCertPeerCreds,
+		"user-allow-list":         len(s.AllowedUsers) > 0,
+		"user-switch-grace":       s.UserSwitchGrace > 0,
+		"user-switch-reset-limit": s.MinUserSwitchResetInterval > 0,
+		"windows-service":         s.windowsService(),
+		"write-timeout":           s.WriteTimeout > 0,
+	}
+}
+
+// Describe returns a one-line summary of s's configuration, as logged when
+// Run starts.
+func (s *Server) Describe() string {
+	feats := s.Features()
+	names := make([]string, 0, len(feats))
+	for name := range feats {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "ipnserver: root-policy=%v %v features:", s.RootPolicy, s.Timeouts())
+	for _, name := range names {
+		sign := "-"
+		if feats[name] {
+			sign = "+"
+		}
+		fmt.Fprintf(&sb, " %s%s", sign, name)
+	}
+	if path, mode := s.socketMode(); path != "" {
+		if mode == "" {
+			mode = "unknown"
+		}
+		fmt.Fprintf(&sb, " socket=%s mode=%s", path, mode)
+	}
+	if bf := version.BuildFeatures(); len(bf) > 0 {
+		fmt.Fprintf(&sb, " build-features: %s", strings.Join(bf, ","))
+	}
+	return sb.String()
+}
+
+// serveFeatures serves the Server's Features as JSON.
+func (s *Server) serveFeatures(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "server-features access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Features())
+}
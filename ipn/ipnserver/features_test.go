@@ -0,0 +1,62 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	s := New(t.Logf, "logid")
+	s.resetOnZero = false // as on non-Windows
+	feats := s.Features()
+	for name, want := range map[string]bool{
+		"accept-windows":          false,
+		"access-log":              false,
+		"active-requests-valve":   false,
+		"audit-sinks":             false,
+		"buffered-response-limit": true,
+		"client-ancestry":         false,
+		"client-mode":             false,
+		"conn-count-reports":      false,
+		"custom-identity":         false,
+		"degrade-on-panic":        false,
+		"idle-exit":               false,
+		"invalid-prefs-policy":    false,
+		"fault-injection":         faultsEnabled,
+		"keep-socket-on-shutdown": false,
+		"max-client-idle-timeout": false,
+		"max-conns":               false,
+		"max-goroutines":          false,
+		"max-request-duration":    false,
+		"middleware":              false,
+		"per-user-request-limit":  true,
+		"require-peer-creds":      false,
+		"restricted-root":         false,
+		"status-rate-limit":       true,
+		"strict-cert-peer-creds":  false,
+		"user-allow-list":         false,
+		"write-timeout":           true,
+	} {
+		if got, ok := feats[name]; !ok || got != want {
+			t.Errorf("default feature %q = %v, %v; want %v, true", name, got, ok, want)
+		}
+	}
+
+	s.RequirePeerCreds = true
+	s.RootPolicy = RootPolicyDeny
+	s.WriteTimeout = 0
+	feats = s.Features()
+	if !feats["require-peer-creds"] || !feats["restricted-root"] || feats["write-timeout"] {
+		t.Errorf("features after configuring = %v", feats)
+	}
+	d := s.Describe()
+	for _, want := range []string{"+require-peer-creds", "+restricted-root", "-write-timeout", "-client-mode"} {
+		if !strings.Contains(d, want) {
+			t.Errorf("Describe() = %q; missing %q", d, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+
+	"tailscale.com/util/clientmetric"
+)
+
+var metricConnGoroutines = clientmetric.NewGauge("ipnserver_conn_goroutines")
+
+// errTooManyGoroutines is the error for connections and CONNECT tunnels
+// denied by Server.MaxGoroutines.
+var errTooManyGoroutines = errors.New("too many LocalAPI goroutines running; try again later")
+
+// tunnelGoroutines is how many goroutines a CONNECT tunnel runs: its
+// handler, which outlives the hijacked connection's accounting, and the
+// two copying in each direction.
+const tunnelGoroutines = 3
+
+// connGoroutinesLocked returns the number of goroutines attributable to
+// connection handling: one serving each open connection, plus those of
+// CONNECT tunnels.
+//
+// s.mu must be held.
+func (s *Server) connGoroutinesLocked() int {
+	return s.openConns + s.tunnelGoroutines
+}
+
+// goroutinesFitLocked reports whether n more connection goroutines are
+// within s.MaxGoroutines.
+//
+// s.mu must be held.
+func (s *Server) goroutinesFitLocked(n int) bool {
+	return s.MaxGoroutines <= 0 || s.connGoroutinesLocked()+n <= s.MaxGoroutines
+}
+
+// updateGoroutinesMetricLocked updates the ipnserver_conn_goroutines gauge.
+//
+// s.mu must be held.
+func (s *Server) updateGoroutinesMetricLocked() {
+	metricConnGoroutines.Set(int64(s.connGoroutinesLocked()))
+}
+
+// admitConnGoroutine reports whether the goroutine serving a connection
+// just counted by admitConn is within s.MaxGoroutines.
+func (s *Server) admitConnGoroutine() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.goroutinesFitLocked(0)
+}
+
+// acquireTunnelGoroutines counts a new CONNECT tunnel's goroutines,
+// reporting whether they're within s.MaxGoroutines. If it returns true,
+// the caller must call releaseTunnelGoroutines when the tunnel closes.
+func (s *Server) acquireTunnelGoroutines() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.goroutinesFitLocked(tunnelGoroutines) {
+		return false
+	}
+	s.tunnelGoroutines += tunnelGoroutines
+	s.updateGoroutinesMetricLocked()
+	return true
+}
+
+// releaseTunnelGoroutines undoes acquireTunnelGoroutines.
+func (s *Server) releaseTunnelGoroutines() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tunnelGoroutines -= tunnelGoroutines
+	s.updateGoroutinesMetricLocked()
+}
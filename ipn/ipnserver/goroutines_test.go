@@ -0,0 +1,79 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestMaxGoroutines(t *testing.T) {
+	s := newTestServer(t)
+	s.MaxGoroutines = 4
+	s.IdentityResolver = trustedResolver
+
+	newConn := func() (net.Conn, context.Context) {
+		c1, c2 := net.Pipe()
+		t.Cleanup(func() {
+			c1.Close()
+			c2.Close()
+		})
+		return c1, s.connContext(context.Background(), c1)
+	}
+	serve := func(ctx context.Context) int {
+		r := httptest.NewRequest("GET", "/localapi/v0/status", nil).WithContext(ctx)
+		r.Host = apitype.LocalAPIHost
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		return rec.Code
+	}
+	checkCount := func(want int) {
+		t.Helper()
+		if got := s.Stats().ConnGoroutines; got != want {
+			t.Errorf("ConnGoroutines = %d; want %d", got, want)
+		}
+		if got := metricConnGoroutines.Value(); got != int64(want) {
+			t.Errorf("ipnserver_conn_goroutines = %d; want %d", got, want)
+		}
+	}
+
+	c1, _ := newConn()
+	_, ctx2 := newConn()
+	checkCount(2)
+	if s.acquireTunnelGoroutines() {
+		t.Fatal("tunnel admitted over the limit")
+	}
+	checkCount(2)
+
+	s.connState(c1, http.StateClosed)
+	checkCount(1)
+	if !s.acquireTunnelGoroutines() {
+		t.Fatal("tunnel within the limit not admitted")
+	}
+	checkCount(4)
+
+	before := metricRejectedTooManyGoroutines.Value()
+	_, ctx3 := newConn()
+	if code := serve(ctx3); code != http.StatusServiceUnavailable {
+		t.Errorf("conn over the limit: status %d; want 503", code)
+	}
+	if got := metricRejectedTooManyGoroutines.Value() - before; got != 1 {
+		t.Errorf("rejections increased by %d; want 1", got)
+	}
+	if code := serve(ctx2); code != http.StatusOK {
+		t.Errorf("conn within the limit: status %d; want 200", code)
+	}
+
+	s.releaseTunnelGoroutines()
+	_, ctx4 := newConn()
+	if code := serve(ctx4); code != http.StatusOK {
+		t.Errorf("conn after tunnel closed: status %d; want 200", code)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/util/clientmetric"
+)
+
+// connUserID returns the userid of the process on the other end of the
+// connection ci: its SID on Windows, or its uid elsewhere when peer
+// credentials are available. It returns the empty string if unknown.
+func connUserID(ci *ipnauth.ConnIdentity) string {
+	if ci == nil {
+		return ""
+	}
+	if uid := ci.WindowsUserID(); uid != "" {
+		return string(uid)
+	}
+	if uid, ok := ci.UnixUserID(); ok {
+		return uid
+	}
+	return ""
+}
+
+// connPID returns the process ID of the process on the other end of the
+// connection ci, or 0 if unknown.
+func connPID(ci *ipnauth.ConnIdentity) int {
+	if ci == nil {
+		return 0
+	}
+	if pid := ci.Pid(); pid != 0 {
+		return pid
+	}
+	if c := ci.Creds(); c != nil {
+		if pid, ok := c.PID(); ok {
+			return pid
+		}
+	}
+	return 0
+}
+
+// describeConnIdentity returns a short human-readable summary of ci, for
+// logging.
+func describeConnIdentity(ci *ipnauth.ConnIdentity) string {
+	if ci == nil {
+		return "nil identity"
+	}
+	uid, pid := connUserID(ci), connPID(ci)
+	if uid == "" && pid == 0 {
+		return "peer without credentials"
+	}
+	return fmt.Sprintf("uid %s, pid %d", uid, pid)
+}
+
+// DefaultSlowIdentityThreshold is the default value of
+// Server.SlowIdentityThreshold.
+const DefaultSlowIdentityThreshold = 500 * time.Millisecond
+
+// identityLatencyBuckets is a histogram of how long determining a
+// connection's identity took, as counters of lookups taking at most
+// each bucket's duration. Lookups slower than the last bucket are
+// counted by metricIdentityLatencyOver.
+var identityLatencyBuckets = []struct {
+	le     time.Duration
+	metric *clientmetric.Metric
+}{
+	{time.Millisecond, clientmetric.NewCounter("ipnserver_identity_lookup_le_1ms")},
+	{10 * time.Millisecond, clientmetric.NewCounter("ipnserver_identity_lookup_le_10ms")},
+	{100 * time.Millisecond, clientmetric.NewCounter("ipnserver_identity_lookup_le_100ms")},
+	{time.Second, clientmetric.NewCounter("ipnserver_identity_lookup_le_1s")},
+}
+
+var metricIdentityLatencyOver = clientmetric.NewCounter("ipnserver_identity_lookup_gt_1s")
+
+// noteIdentityLatency records that determining the identity of the peer
+// on c took d, logging if that exceeded s.SlowIdentityThreshold.
+func (s *Server) noteIdentityLatency(c net.Conn, d time.Duration) {
+	m := metricIdentityLatencyOver
+	for _, b := range identityLatencyBuckets {
+		if d <= b.le {
+			m = b.metric
+			break
+		}
+	}
+	m.Add(1)
+	if th := s.SlowIdentityThreshold; th > 0 && d > th {
+		logf := s.slowIdentityLogf
+		if logf == nil {
+			logf = s.logf
+		}
+		logf("slow identity lookup for connection from %v: took %v (threshold %v)", c.RemoteAddr(), d.Round(time.Millisecond), th)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+func identityLookups() int64 {
+	n := metricIdentityLatencyOver.Value()
+	for _, b := range identityLatencyBuckets {
+		n += b.metric.Value()
+	}
+	return n
+}
+
+func TestSlowIdentityLogged(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	for _, slow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("slow=%v", slow), func(t *testing.T) {
+			var mu sync.Mutex
+			var logs strings.Builder
+			s := New(func(format string, args ...any) {
+				mu.Lock()
+				defer mu.Unlock()
+				fmt.Fprintf(&logs, format+"\n", args...)
+			}, "logid")
+			s.SlowIdentityThreshold = 50 * time.Millisecond
+			s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+				if slow {
+					time.Sleep(100 * time.Millisecond)
+				}
+				return ipnauth.TrustedConnIdentity(c), nil
+			}
+			before := identityLookups()
+			s.connContext(context.Background(), c1)
+			if got := identityLookups() - before; got != 1 {
+				t.Errorf("identity lookup histogram grew by %d; want 1", got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if logged := strings.Contains(logs.String(), "slow identity lookup"); logged != slow {
+				t.Errorf("slow lookup logged = %v; want %v; logs:\n%s", logged, slow, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnauth"
+)
+
+// PermissionLevel is the LocalAPI access granted to a user by the
+// Server.PermissionsFile.
+type PermissionLevel string
+
+const (
+	PermissionNone  PermissionLevel = "none"  // no access
+	PermissionRead  PermissionLevel = "read"  // read-only access
+	PermissionCert  PermissionLevel = "cert"  // read access, plus fetching TLS certs
+	PermissionWrite PermissionLevel = "write" // full access, including fetching TLS certs
+)
+
+func (l PermissionLevel) valid() bool {
+	switch l {
+	case PermissionNone, PermissionRead, PermissionCert, PermissionWrite:
+		return true
+	}
+	return false
+}
+
+// identityPerms maps userids to their configured permission level.
+type identityPerms map[string]PermissionLevel
+
+// loadIdentityPerms reads a permissions file: a JSON object mapping users
+// (Windows SIDs, numeric uids, or usernames) to PermissionLevels, such as
+//
+//	{"caddy": "cert", "1001": "read", "S-1-5-21-...": "write"}
+//
+// Usernames that can't be resolved are logged and skipped.
+func (s *Server) loadIdentityPerms(path string) (identityPerms, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]PermissionLevel
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	perms := make(identityPerms, len(raw))
+	for user, level := range raw {
+		if !level.valid() {
+			return nil, fmt.Errorf("%s: invalid permission level %q for %q", path, level, user)
+		}
+		uid, err := allowListUserID(user)
+		if err != nil {
+			s.logf("%s: ignoring user %q: %v", path, user, err)
+			continue
+		}
+		perms[uid] = level
+	}
+	return perms, nil
+}
+
+// ReloadPermissions (re)reads s.PermissionsFile, as Run does when it
+// starts. tailscaled calls it on SIGHUP. If the file can't be read or is
+// invalid, the previously loaded permissions remain in effect and an
+// error is returned. It does nothing if PermissionsFile is empty.
+func (s *Server) ReloadPermissions() error {
+	if s.PermissionsFile == "" {
+		return nil
+	}
+	perms, err := s.loadIdentityPerms(s.PermissionsFile)
+	if err != nil {
+		return err
+	}
+	s.identityPerms.Store(&perms)
+	s.logf("loaded LocalAPI permissions for %d users from %s", len(perms), s.PermissionsFile)
+	return nil
+}
+
+// configuredPermission returns the permission level configured for ci's
+// user in s.PermissionsFile, if any.
+func (s *Server) configuredPermission(ci *ipnauth.ConnIdentity) (_ PermissionLevel, ok bool) {
+	p := s.identityPerms.Load()
+	if p == nil || ci.IsTrusted() {
+		return "", false
+	}
+	uid := connUserID(ci)
+	if uid == "" {
+		return "", false
+	}
+	level, ok := (*p)[uid]
+	return level, ok
+}
+
+// applyConfiguredPermission returns the permissions read and write, as
+// computed by default for ci, replaced by any configured for ci's user. On
+// Windows, a user denied because another user is using the server stays
+// denied.
+func (s *Server) applyConfiguredPermission(ci *ipnauth.ConnIdentity, read, write bool) (_, _ bool) {
+	level, ok := s.configuredPermission(ci)
+	if !ok {
+		return read, write
+	}
+	if envknob.GOOS() == "windows" && !read && !write {
+		return false, false
+	}
+	switch level {
+	case PermissionWrite:
+		return true, true
+	case PermissionRead, PermissionCert:
+		return true, false
+	}
+	return false, false
+}
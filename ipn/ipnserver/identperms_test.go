@@ -0,0 +1,66 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestPermissionsFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("tests Unix peer permissions")
+	}
+	path := filepath.Join(t.TempDir(), "perms.json")
+	writeFile := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(`{"1001": "read", "1002": "write", "1003": "cert", "1004": "none", "0": "read"}`)
+
+	s := newTestServer(t)
+	s.PermissionsFile = path
+	if err := s.ReloadPermissions(); err != nil {
+		t.Fatal(err)
+	}
+
+	type perms struct{ read, write, cert bool }
+	check := func(uid string, want perms) {
+		t.Helper()
+		ci := ipnauth.NewUnixConnIdentity(nil, 1, uid)
+		var got perms
+		got.read, got.write = s.localAPIPermissions(ci)
+		got.cert, _ = s.connCanFetchCerts(ci)
+		if got != want {
+			t.Errorf("uid %s: got %+v; want %+v", uid, got, want)
+		}
+	}
+	check("1001", perms{read: true})
+	check("1002", perms{read: true, write: true, cert: true})
+	check("1003", perms{read: true, cert: true})
+	check("1004", perms{})
+	check("0", perms{read: true})    // restricted below root's default
+	check("1005", perms{read: true}) // unconfigured: the default
+	check("", perms{read: true})     // unknown user: the default
+
+	// Reloading picks up changes; an invalid file keeps the old config.
+	writeFile(`{"1001": "write"}`)
+	if err := s.ReloadPermissions(); err != nil {
+		t.Fatal(err)
+	}
+	check("1001", perms{read: true, write: true, cert: true})
+	check("1002", perms{read: true})
+	writeFile(`{"1001": "admin"}`)
+	if err := s.ReloadPermissions(); err == nil {
+		t.Error("ReloadPermissions succeeded with an invalid level")
+	}
+	check("1001", perms{read: true, write: true, cert: true})
+}
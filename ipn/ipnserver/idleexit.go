@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// idleExitCheckInterval is the longest Run waits between checks of whether
+// it's been idle for IdleExitTimeout.
+const idleExitCheckInterval = time.Minute
+
+func (s *Server) now() time.Time {
+	if s.timeNow != nil {
+		return s.timeNow()
+	}
+	return time.Now()
+}
+
+// noteActivityLocked records that the server is in use now, postponing any
+// idle exit.
+//
+// s.mu must be held.
+func (s *Server) noteActivityLocked() {
+	s.lastActivity = s.now()
+}
+
+// idleExitDue reports whether the server has been idle for
+// IdleExitTimeout. Requests in flight and open CONNECT tunnels, and if
+// IdleExitCountsTraffic is set, traffic with peers since the last check,
+// count as activity.
+func (s *Server) idleExitDue() bool {
+	var traffic int64
+	if s.IdleExitCountsTraffic {
+		if lb := s.lb.Load(); lb != nil {
+			if st, err := s.status(lb); err == nil {
+				traffic = peerTrafficBytes(st)
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.activeReqs) > 0 || len(s.tunnels) > 0 || traffic != s.lastTrafficBytes {
+		s.lastTrafficBytes = traffic
+		s.noteActivityLocked()
+		return false
+	}
+	return s.now().Sub(s.lastActivity) >= s.IdleExitTimeout
+}
+
+// waitIdleExit blocks until the server has been idle for IdleExitTimeout,
+// returning true, or until done is closed, returning false.
+func (s *Server) waitIdleExit(done <-chan struct{}) bool {
+	interval := s.idleCheckInterval
+	if interval == 0 {
+		interval = idleExitCheckInterval
+		if d := s.IdleExitTimeout / 4; d > 0 && d < interval {
+			interval = d
+		}
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return false
+		case <-t.C:
+			if s.idleExitDue() {
+				return true
+			}
+		}
+	}
+}
+
+// peerTrafficBytes returns the total bytes sent to and received from
+// peers in st.
+func peerTrafficBytes(st *ipnstate.Status) int64 {
+	var n int64
+	for _, ps := range st.Peer {
+		n += ps.TxBytes + ps.RxBytes
+	}
+	return n
+}
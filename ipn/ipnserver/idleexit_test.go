@@ -0,0 +1,73 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/tstest"
+)
+
+func TestIdleExit(t *testing.T) {
+	clock := &tstest.Clock{}
+	s := New(t.Logf, "logid")
+	s.timeNow = clock.Now
+	s.idleCheckInterval = time.Millisecond
+	s.IdleExitTimeout = time.Hour
+	ln, _ := NewMemListener()
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(context.Background(), ln) }()
+
+	clock.Advance(30 * time.Minute)
+	select {
+	case err := <-errc:
+		t.Fatalf("Run returned after half the idle period: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(31 * time.Minute)
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Run = %v; want nil after idle exit", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after the idle period")
+	}
+}
+
+func TestIdleExitActivity(t *testing.T) {
+	clock := &tstest.Clock{}
+	s := newTestServer(t)
+	s.timeNow = clock.Now
+	s.IdleExitTimeout = time.Hour
+	s.mu.Lock()
+	s.noteActivityLocked()
+	s.mu.Unlock()
+
+	ci := ipnauth.TrustedConnIdentity(nil)
+	onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/localapi/v0/status", nil), ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Hour)
+	if s.idleExitDue() {
+		t.Error("idle exit due with a request in flight")
+	}
+	clock.Advance(30 * time.Minute)
+	onDone()
+	clock.Advance(59 * time.Minute)
+	if s.idleExitDue() {
+		t.Error("idle exit due less than IdleExitTimeout after the last request finished")
+	}
+	clock.Advance(2 * time.Minute)
+	if !s.idleExitDue() {
+		t.Error("idle exit not due IdleExitTimeout after the last request finished")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnlocal"
+)
+
+// InvalidPrefsPolicy controls what the Server does when its LocalBackend
+// has no valid prefs when Run starts, so it can't be started as usual.
+type InvalidPrefsPolicy int
+
+const (
+	// InvalidPrefsWait leaves the backend unstarted until a client starts
+	// it.
+	InvalidPrefsWait InvalidPrefsPolicy = iota
+
+	// InvalidPrefsStartLoginOnly starts the backend with default prefs
+	// and WantRunning false, so that clients can log in but the node
+	// doesn't connect until told to.
+	InvalidPrefsStartLoginOnly
+
+	// InvalidPrefsFail makes Run fail with ErrInvalidPrefs if the backend
+	// still hasn't been started after Server.InvalidPrefsGrace.
+	InvalidPrefsFail
+)
+
+func (p InvalidPrefsPolicy) String() string {
+	switch p {
+	case InvalidPrefsWait:
+		return "wait"
+	case InvalidPrefsStartLoginOnly:
+		return "start-login-only"
+	case InvalidPrefsFail:
+		return "fail"
+	}
+	return "unknown"
+}
+
+// DefaultInvalidPrefsGrace is the Server.InvalidPrefsGrace used if it's
+// zero.
+const DefaultInvalidPrefsGrace = 30 * time.Second
+
+// ErrInvalidPrefs is returned by Run with InvalidPrefsFail when the
+// backend's prefs were invalid and no client started it in time.
+var ErrInvalidPrefs = errors.New("backend prefs invalid and backend not started")
+
+// backendPrefsValid reports whether lb has prefs it can be started with.
+// It's a variable for tests.
+var backendPrefsValid = func(lb *ipnlocal.LocalBackend) bool {
+	return lb.Prefs().Valid()
+}
+
+// startBackend starts lb with opts. It's a variable for tests.
+var startBackend = func(lb *ipnlocal.LocalBackend, opts ipn.Options) error {
+	return lb.Start(opts)
+}
+
+// loginOnlyPrefs returns the prefs InvalidPrefsStartLoginOnly starts the
+// backend with.
+func loginOnlyPrefs() *ipn.Prefs {
+	p := ipn.NewPrefs()
+	p.WantRunning = false
+	return p
+}
+
+// invalidPrefsGrace returns how long Run waits for the backend to be
+// started under InvalidPrefsFail.
+func (s *Server) invalidPrefsGrace() time.Duration {
+	if s.InvalidPrefsGrace > 0 {
+		return s.InvalidPrefsGrace
+	}
+	return DefaultInvalidPrefsGrace
+}
+
+// waitInvalidPrefs waits for s.invalidPrefsGrace and reports whether, by
+// then, the backend has invalid prefs and still hasn't been started, in
+// which case Run should fail. It returns false early if runDone is closed.
+func (s *Server) waitInvalidPrefs(runDone <-chan struct{}) bool {
+	t := time.NewTimer(s.invalidPrefsGrace())
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-runDone:
+		return false
+	}
+	lb := s.lb.Load()
+	return lb != nil && lb.State() == ipn.NoState && !backendPrefsValid(lb)
+}
+
+// invalidPrefsError returns the error Run returns under InvalidPrefsFail.
+func (s *Server) invalidPrefsError() error {
+	return fmt.Errorf("%w after %v", ErrInvalidPrefs, s.invalidPrefsGrace())
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnlocal"
+)
+
+// fakeInvalidPrefs makes backends report invalid prefs for the rest of t,
+// and records rather than performs their starts, returning a func that
+// returns the options of each start so far.
+func fakeInvalidPrefs(t *testing.T) (starts func() []ipn.Options) {
+	oldValid, oldStart := backendPrefsValid, startBackend
+	t.Cleanup(func() { backendPrefsValid, startBackend = oldValid, oldStart })
+	var (
+		mu   sync.Mutex
+		opts []ipn.Options
+	)
+	backendPrefsValid = func(*ipnlocal.LocalBackend) bool { return false }
+	startBackend = func(_ *ipnlocal.LocalBackend, o ipn.Options) error {
+		mu.Lock()
+		defer mu.Unlock()
+		opts = append(opts, o)
+		return nil
+	}
+	return func() []ipn.Options {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]ipn.Options(nil), opts...)
+	}
+}
+
+// runInvalidPrefs runs s until it returns or the test ends, returning
+// Run's error channel.
+func runInvalidPrefs(t *testing.T, s *Server) <-chan error {
+	ln, _ := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		errc <- s.Run(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return errc
+}
+
+func TestInvalidPrefsWait(t *testing.T) {
+	starts := fakeInvalidPrefs(t)
+	s := newTestServer(t)
+	s.InvalidPrefsGrace = time.Millisecond // ignored by InvalidPrefsWait
+	errc := runInvalidPrefs(t, s)
+	select {
+	case err := <-errc:
+		t.Fatalf("Run returned %v; want it to keep waiting", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if got := starts(); len(got) != 0 {
+		t.Errorf("backend started with %+v; want not started", got)
+	}
+}
+
+func TestInvalidPrefsStartLoginOnly(t *testing.T) {
+	starts := fakeInvalidPrefs(t)
+	s := newTestServer(t)
+	s.InvalidPrefsPolicy = InvalidPrefsStartLoginOnly
+	runInvalidPrefs(t, s)
+	waitFor(t, "backend to be started", func() bool { return len(starts()) > 0 })
+	got := starts()
+	if len(got) != 1 {
+		t.Fatalf("backend started %d times; want 1", len(got))
+	}
+	if p := got[0].UpdatePrefs; p == nil || p.WantRunning {
+		t.Errorf("started with UpdatePrefs %+v; want WantRunning false", p)
+	}
+}
+
+func TestInvalidPrefsFail(t *testing.T) {
+	starts := fakeInvalidPrefs(t)
+	s := newTestServer(t)
+	s.InvalidPrefsPolicy = InvalidPrefsFail
+	s.InvalidPrefsGrace = 10 * time.Millisecond
+	errc := runInvalidPrefs(t, s)
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrInvalidPrefs) {
+			t.Errorf("Run = %v; want ErrInvalidPrefs", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't fail")
+	}
+	if got := starts(); len(got) != 0 {
+		t.Errorf("backend started with %+v; want not started", got)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import "tailscale.com/ipn/ipnlocal"
+
+// Lifecycle modes, as reported in LifecycleMode.Mode.
+const (
+	// LifecycleClientEphemeral is when the backend is reset once the
+	// last LocalAPI client disconnects, as by default on Windows, where
+	// tailscaled only runs while the GUI does.
+	LifecycleClientEphemeral = "client-ephemeral"
+
+	// LifecycleServerPersistent is when the backend keeps running without
+	// any LocalAPI clients.
+	LifecycleServerPersistent = "server-persistent"
+)
+
+// LifecycleMode describes whether the server resets the backend when its
+// last client disconnects, and why.
+type LifecycleMode struct {
+	// Mode is LifecycleClientEphemeral or LifecycleServerPersistent.
+	Mode string
+
+	// Reason explains Mode in terms of the inputs below.
+	Reason string
+
+	// ResetOnZero is whether the server runs in client mode, resetting
+	// the backend when the last client disconnects unless overridden by
+	// one of the inputs below. It's true by default on Windows.
+	ResetOnZero bool
+
+	// InServerMode is whether the backend is in server mode ("unattended
+	// mode"), from its ForceDaemon pref.
+	InServerMode bool
+
+	// ServiceServerMode is whether Server.ServiceServerMode applies: it's
+	// set and the server is running as a Windows service.
+	ServiceServerMode bool
+}
+
+// LifecycleMode reports whether the server currently resets the backend
+// when its last client disconnects, and why.
+func (s *Server) LifecycleMode() LifecycleMode {
+	return s.lifecycleMode(s.lb.Load())
+}
+
+// lifecycleMode is LifecycleMode with lb as the backend, which may be nil.
+//
+// s.mu must not be held.
+func (s *Server) lifecycleMode(lb *ipnlocal.LocalBackend) LifecycleMode {
+	m := LifecycleMode{
+		ResetOnZero:       s.resetOnZero,
+		ServiceServerMode: s.ServiceServerMode && s.windowsService(),
+	}
+	if lb != nil {
+		m.InServerMode = lb.InServerMode()
+	}
+	switch {
+	case !m.ResetOnZero:
+		m.Mode, m.Reason = LifecycleServerPersistent, "not in client mode"
+	case m.InServerMode:
+		m.Mode, m.Reason = LifecycleServerPersistent, "in server mode (ForceDaemon)"
+	case m.ServiceServerMode:
+		m.Mode, m.Reason = LifecycleServerPersistent, "running as a Windows service"
+	default:
+		m.Mode, m.Reason = LifecycleClientEphemeral, "in client mode, without server mode or a Windows service to keep running"
+	}
+	return m
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"fmt"
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+func TestLifecycleMode(t *testing.T) {
+	for _, resetOnZero := range []bool{false, true} {
+		for _, forceDaemon := range []bool{false, true} {
+			for _, service := range []bool{false, true} {
+				t.Run(fmt.Sprintf("reset=%v,daemon=%v,service=%v", resetOnZero, forceDaemon, service), func(t *testing.T) {
+					s := newTestServer(t)
+					s.resetOnZero = resetOnZero
+					s.ServiceServerMode = true
+					s.WindowsService.Set(service)
+					if _, err := s.mustBackend().EditPrefs(&ipn.MaskedPrefs{
+						Prefs:          ipn.Prefs{ForceDaemon: forceDaemon},
+						ForceDaemonSet: true,
+					}); err != nil {
+						t.Fatal(err)
+					}
+
+					want := LifecycleClientEphemeral
+					if !resetOnZero || forceDaemon || service {
+						want = LifecycleServerPersistent
+					}
+					m := s.LifecycleMode()
+					if m.Mode != want || m.Reason == "" {
+						t.Errorf("LifecycleMode = %+v; want mode %q with a reason", m, want)
+					}
+					if m.ResetOnZero != resetOnZero || m.InServerMode != forceDaemon || m.ServiceServerMode != service {
+						t.Errorf("LifecycleMode inputs = %+v; want reset=%v, daemon=%v, service=%v", m, resetOnZero, forceDaemon, service)
+					}
+					if got := s.Stats().Lifecycle; got != m {
+						t.Errorf("Stats().Lifecycle = %+v; want %+v", got, m)
+					}
+				})
+			}
+		}
+	}
+}
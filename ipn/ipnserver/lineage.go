@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnauth"
+)
+
+// processInfo is what checkClientLineage needs to know about a process.
+type processInfo struct {
+	parentPID int
+	exe       string // executable file name, such as "tailscale-ipn.exe"
+}
+
+// processSnapshot returns the running processes, keyed by PID. It's only
+// implemented on Windows. It's a variable for tests.
+var processSnapshot = platformProcessSnapshot
+
+// maxLineageDepth bounds how many ancestors checkClientLineage looks at,
+// in case of a cycle from PID reuse.
+const maxLineageDepth = 32
+
+// errLineageUnknown is the connection error when Server.AllowedClientAncestors
+// is set but the peer's process is unknown.
+var errLineageUnknown = errors.New("connection rejected: peer's process is unknown and the server requires an allowed ancestor")
+
+// processLineage returns the executable names of the process pid and its
+// ancestors, nearest first, as of snap.
+func processLineage(snap map[int]processInfo, pid int) []string {
+	var exes []string
+	seen := map[int]bool{}
+	for pid != 0 && !seen[pid] && len(exes) < maxLineageDepth {
+		seen[pid] = true
+		p, ok := snap[pid]
+		if !ok {
+			break
+		}
+		exes = append(exes, p.exe)
+		pid = p.parentPID
+	}
+	return exes
+}
+
+// checkClientLineage returns an error if s.AllowedClientAncestors is set,
+// on Windows, and neither ci's process nor any of its ancestors is one of
+// them. The process table is only read when the option is set.
+func (s *Server) checkClientLineage(ci *ipnauth.ConnIdentity) error {
+	if len(s.AllowedClientAncestors) == 0 || ci.IsTrusted() || envknob.GOOS() != "windows" {
+		return nil
+	}
+	pid := connPID(ci)
+	if pid == 0 {
+		return errLineageUnknown
+	}
+	snap, err := processSnapshot()
+	if err != nil {
+		return fmt.Errorf("connection rejected: reading process table: %w", err)
+	}
+	lineage := processLineage(snap, pid)
+	for _, exe := range lineage {
+		for _, allowed := range s.AllowedClientAncestors {
+			if strings.EqualFold(filepath.Base(exe), allowed) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("connection rejected: process %d (lineage %s) has no allowed ancestor", pid, strings.Join(lineage, " < "))
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+
+package ipnserver
+
+import (
+	"errors"
+	"runtime"
+)
+
+func platformProcessSnapshot() (map[int]processInfo, error) {
+	return nil, errors.New("process lineage not supported on " + runtime.GOOS)
+}
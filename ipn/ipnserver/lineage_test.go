@@ -0,0 +1,51 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"testing"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestCheckClientLineage(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	snap := map[int]processInfo{
+		4:  {parentPID: 0, exe: "System"},
+		10: {parentPID: 4, exe: "explorer.exe"},
+		20: {parentPID: 10, exe: "tailscale-ipn.exe"},
+		30: {parentPID: 20, exe: "tailscale.exe"},
+		40: {parentPID: 10, exe: "evil.exe"},
+		50: {parentPID: 60, exe: "a.exe"},
+		60: {parentPID: 50, exe: "b.exe"}, // cycle, from PID reuse
+	}
+	old := processSnapshot
+	processSnapshot = func() (map[int]processInfo, error) { return snap, nil }
+	t.Cleanup(func() { processSnapshot = old })
+
+	s := New(t.Logf, "logid")
+	s.AllowedClientAncestors = []string{"Tailscale-IPN.exe"}
+	tests := []struct {
+		pid    int
+		wantOK bool
+	}{
+		{20, true}, // itself
+		{30, true}, // child
+		{40, false},
+		{10, false},
+		{50, false},
+		{99, false}, // unknown
+		{0, false},
+	}
+	for _, tt := range tests {
+		ci := ipnauth.NewWindowsConnIdentity(nil, tt.pid, "S-1-5-21-x", nil)
+		if err := s.checkClientLineage(ci); (err == nil) != tt.wantOK {
+			t.Errorf("pid %d: err = %v; want ok=%v", tt.pid, err, tt.wantOK)
+		}
+	}
+	if err := s.checkClientLineage(ipnauth.TrustedConnIdentity(nil)); err != nil {
+		t.Errorf("trusted: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func platformProcessSnapshot() (map[int]processInfo, error) {
+	h, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(h)
+	var e windows.ProcessEntry32
+	e.Size = uint32(unsafe.Sizeof(e))
+	snap := map[int]processInfo{}
+	for err = windows.Process32First(h, &e); err == nil; err = windows.Process32Next(h, &e) {
+		snap[int(e.ProcessID)] = processInfo{
+			parentPID: int(e.ParentProcessID),
+			exe:       windows.UTF16ToString(e.ExeFile[:]),
+		}
+	}
+	if !errors.Is(err, windows.ERROR_NO_MORE_FILES) {
+		return nil, err
+	}
+	return snap, nil
+}
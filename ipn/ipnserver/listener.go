@@ -0,0 +1,134 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"tailscale.com/types/logger"
+	"tailscale.com/util/clientmetric"
+)
+
+// metricAcceptFDExhausted counts episodes of Accept failing for lack of
+// file descriptors.
+var metricAcceptFDExhausted = clientmetric.NewCounter("ipnserver_accept_fd_exhausted")
+
+// Bounds on how long retryListener waits between accept attempts after a
+// transient error. They match net/http's.
+const (
+	minAcceptRetryDelay = 5 * time.Millisecond
+	maxAcceptRetryDelay = time.Second
+)
+
+// retryListener is a net.Listener that retries Accept, with backoff, when it
+// fails with a transient error (such as EINTR or running out of file
+// descriptors), rather than returning it and ending the http.Server's
+// accept loop. Other errors, such as the listener being closed, are
+// returned as usual.
+type retryListener struct {
+	net.Listener
+	logf logger.Logf
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newRetryListener(ln net.Listener, logf logger.Logf) *retryListener {
+	return &retryListener{
+		Listener: ln,
+		logf:     logf,
+		closed:   make(chan struct{}),
+	}
+}
+
+func (ln *retryListener) Accept() (net.Conn, error) {
+	var delay time.Duration
+	for {
+		c, err := ln.Listener.Accept()
+		if err == nil || !isTransientAcceptError(err) {
+			return c, err
+		}
+		first := delay == 0
+		if first {
+			delay = minAcceptRetryDelay
+		} else if delay *= 2; delay > maxAcceptRetryDelay {
+			delay = maxAcceptRetryDelay
+		}
+		if first && isFDExhaustion(err) {
+			metricAcceptFDExhausted.Add(1)
+			ln.logf("accept error: %v; %s; retrying with backoff", err, fdExhaustionHint())
+		} else {
+			ln.logf("accept error: %v; retrying in %v", err, delay)
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ln.closed:
+			t.Stop()
+			return nil, net.ErrClosed
+		}
+	}
+}
+
+func (ln *retryListener) Close() error {
+	ln.closeOnce.Do(func() { close(ln.closed) })
+	return ln.Listener.Close()
+}
+
+// isFDExhaustion reports whether err is from running out of file
+// descriptors, for the process (EMFILE) or the system (ENFILE).
+func isFDExhaustion(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// fdUsage returns the number of file descriptors the process has open
+// and its limit, each -1 if unknown. It's a variable for tests.
+var fdUsage = fdUsageOS
+
+// fdExhaustionHint returns an actionable description of the process
+// running out of file descriptors, for logging.
+func fdExhaustionHint() string {
+	open, limit := fdUsage()
+	var sb strings.Builder
+	sb.WriteString("out of file descriptors")
+	if open >= 0 {
+		fmt.Fprintf(&sb, " (%d open", open)
+	} else {
+		sb.WriteString(" (open count unknown")
+	}
+	if limit >= 0 {
+		fmt.Fprintf(&sb, ", limit %d)", limit)
+	} else {
+		sb.WriteString(", limit unknown)")
+	}
+	sb.WriteString("; if this persists, raise tailscaled's open file limit (ulimit -n, or LimitNOFILE= in its systemd unit) or look for a file descriptor leak")
+	return sb.String()
+}
+
+// isTransientAcceptError reports whether err, returned from a
+// net.Listener's Accept, is one that's expected to go away by itself
+// such that Accept should be retried.
+func isTransientAcceptError(err error) bool {
+	if errors.Is(err, net.ErrClosed) {
+		return false
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EINTR, syscall.EAGAIN, syscall.ECONNABORTED,
+			syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM:
+			return true
+		}
+		return false
+	}
+	var te interface{ Temporary() bool }
+	return errors.As(err, &te) && te.Temporary()
+}
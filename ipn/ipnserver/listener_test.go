@@ -0,0 +1,162 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// scriptedListener is a net.Listener whose Accept returns a fixed sequence
+// of results.
+type scriptedListener struct {
+	results []acceptResult
+}
+
+type acceptResult struct {
+	c   net.Conn
+	err error
+}
+
+func (ln *scriptedListener) Accept() (net.Conn, error) {
+	if len(ln.results) == 0 {
+		return nil, net.ErrClosed
+	}
+	r := ln.results[0]
+	ln.results = ln.results[1:]
+	return r.c, r.err
+}
+
+func (ln *scriptedListener) Close() error   { return nil }
+func (ln *scriptedListener) Addr() net.Addr { return &net.UnixAddr{Name: "fake", Net: "unix"} }
+
+func TestRetryListener(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	opErr := func(err error) error {
+		return &net.OpError{Op: "accept", Net: "unix", Err: os.NewSyscallError("accept", err)}
+	}
+	var retries int
+	ln := newRetryListener(&scriptedListener{results: []acceptResult{
+		{err: opErr(syscall.EINTR)},
+		{err: opErr(syscall.EMFILE)},
+		{err: opErr(syscall.ECONNABORTED)},
+		{c: c1},
+	}}, func(format string, args ...any) {
+		retries++
+	})
+
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	if c != c1 {
+		t.Fatalf("Accept returned %v; want %v", c, c1)
+	}
+	if retries != 3 {
+		t.Errorf("retried %d times; want 3", retries)
+	}
+
+	// Fatal errors are returned.
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept after script = %v; want net.ErrClosed", err)
+	}
+	fatal := errors.New("fatal")
+	ln = newRetryListener(&scriptedListener{results: []acceptResult{{err: fatal}}}, t.Logf)
+	if _, err := ln.Accept(); err != fatal {
+		t.Errorf("Accept = %v; want %v", err, fatal)
+	}
+}
+
+func TestRetryListenerCloseDuringBackoff(t *testing.T) {
+	var results []acceptResult
+	for i := 0; i < 100; i++ {
+		results = append(results, acceptResult{err: syscall.EMFILE})
+	}
+	ln := newRetryListener(&scriptedListener{results: results}, t.Logf)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	ln.Close()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept = %v; want net.ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept didn't return after Close")
+	}
+}
+
+func TestIsTransientAcceptError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{syscall.EINTR, true},
+		{fmt.Errorf("wrapped: %w", syscall.EMFILE), true},
+		{net.ErrClosed, false},
+		{&net.OpError{Op: "accept", Err: net.ErrClosed}, false},
+		{syscall.EBADF, false},
+		{errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransientAcceptError(tt.err); got != tt.want {
+			t.Errorf("isTransientAcceptError(%v) = %v; want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryListenerFDExhaustion(t *testing.T) {
+	old := fdUsage
+	fdUsage = func() (open, limit int) { return 1024, 1024 }
+	defer func() { fdUsage = old }()
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	var logs []string
+	ln := newRetryListener(&scriptedListener{results: []acceptResult{
+		{err: syscall.EMFILE},
+		{err: syscall.EMFILE},
+		{err: syscall.EMFILE},
+		{c: c1},
+	}}, func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	})
+
+	t0 := time.Now()
+	if _, err := ln.Accept(); err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	// Backs off 5ms, 10ms and 20ms rather than spinning.
+	if d, min := time.Since(t0), 35*time.Millisecond; d < min {
+		t.Errorf("Accept took %v; want at least %v of backoff", d, min)
+	}
+	if len(logs) != 3 {
+		t.Fatalf("got %d log lines; want 3: %q", len(logs), logs)
+	}
+	// Only the first failure logs the full diagnostic.
+	for _, want := range []string{"out of file descriptors", "1024 open", "limit 1024", "ulimit -n"} {
+		if !strings.Contains(logs[0], want) {
+			t.Errorf("first log %q doesn't contain %q", logs[0], want)
+		}
+	}
+	for _, l := range logs[1:] {
+		if strings.Contains(l, "ulimit") {
+			t.Errorf("repeated diagnostic in %q", l)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"net/http"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/localapi"
+)
+
+// serverHandlers are the LocalAPI handlers implemented by Server rather than
+// package localapi, because they report on or act on state owned by the
+// Server. They're keyed like localapi's handlers, by the part of the path
+// after "/localapi/v0/".
+var serverHandlers = map[string]func(*Server, *localapi.Handler, http.ResponseWriter, *http.Request){
+	"auth-info":         (*Server).serveAuthInfo,
+	"backend-dump":      (*Server).serveBackendDump,
+	"conn-trace":        (*Server).serveConnTrace,
+	"lock-holder":       (*Server).serveLockHolder,
+	"log-upload-status": (*Server).serveLogUploadStatus,
+	"proxy-tunnels":     (*Server).serveProxyTunnels,
+	"server-features":   (*Server).serveFeatures,
+	"server-stats":      (*Server).serveStats,
+	"server-timeouts":   (*Server).serveTimeouts,
+	"user-switches":     (*Server).serveUserSwitches,
+	"watcher-close":     (*Server).serveWatcherClose,
+	"watchers/":         (*Server).serveWatchers,
+	"would-accept":      (*Server).serveWouldAccept,
+}
+
+// serverRoutes describes serverHandlers for the LocalAPI schema endpoint.
+var serverRoutes = []apitype.LocalAPIRoute{
+	{
+		Path:        "/localapi/v0/auth-info",
+		Methods:     []string{"GET"},
+		Permission:  localapi.PermNone,
+		Description: "Returns how the caller's connection is authenticated and what access it has; available even to users denied access.",
+	},
+	{
+		Path:        "/localapi/v0/backend-dump",
+		Methods:     []string{"POST"},
+		Permission:  localapi.PermWrite,
+		Description: "Returns a debug dump of the backend's internal state, with secrets left out, or with ?log=1 writes it to the log.",
+	},
+	{
+		Path:        "/localapi/v0/conn-trace",
+		Methods:     []string{"GET", "POST", "DELETE"},
+		Permission:  localapi.PermWrite,
+		Description: "Reports, starts (?user=<userid>&duration=<d>) or stops a verbose trace of one user's LocalAPI connections.",
+	},
+	{
+		Path:        "/localapi/v0/lock-holder",
+		Methods:     []string{"GET"},
+		Permission:  localapi.PermNone,
+		Description: "Returns which user, if any, is using tailscaled; available even to users denied because of it.",
+	},
+	{
+		Path:        "/localapi/v0/log-upload-status",
+		Methods:     []string{"GET"},
+		Permission:  localapi.PermRead,
+		Description: "Reports the backend's log ID and whether its logs are being uploaded, with the last upload success and failure.",
+	},
+	{
+		Path:        "/localapi/v0/proxy-tunnels",
+		Methods:     []string{"GET"},
+		Permission:  localapi.PermRead,
+		Description: "Lists active CONNECT tunnels opened by the Windows GUI, with their targets and who opened them (Windows only).",
+	},
+	{
+		Path:        "/localapi/v0/server-features",
+		Methods:     []string{"GET"},
+		Permission:  localapi.PermRead,
+		Description: "Returns which optional IPN server features are enabled.",
+	},
+	{
+		Path:        "/localapi/v0/server-stats",
+		Methods:     []string{"GET"},
+		Permission:  localapi.PermRead,
+		Description: "Returns statistics about the IPN server, such as its start time and uptime.",
+	},
+	{
+		Path:        "/localapi/v0/server-timeouts",
+		Methods:     []string{"GET"},
+		Permission:  localapi.PermRead,
+		Description: "Returns the IPN server's effective timeouts, with defaults applied.",
+	},
+	{
+		Path:        "/localapi/v0/user-switches",
+		Methods:     []string{"GET"},
+		Permission:  localapi.PermWrite,
+		Description: "Returns recent changes of the user using tailscaled (Windows only), to explain state resets.",
+	},
+	{
+		Path:        "/localapi/v0/watcher-close",
+		Methods:     []string{"POST"},
+		Permission:  localapi.PermRead,
+		Description: "Gracefully ends the caller's own watcher subscription by ID, acknowledging once its stream has ended.",
+	},
+	{
+		Path:        "/localapi/v0/watchers/",
+		Methods:     []string{"GET", "DELETE"},
+		Permission:  localapi.PermWrite,
+		Description: "Lists active watcher subscriptions, or terminates one by ID.",
+	},
+	{
+		Path:        "/localapi/v0/would-accept",
+		Methods:     []string{"GET"},
+		Permission:  localapi.PermWrite,
+		Description: "Reports whether a connection from the process ?pid=<pid> would currently be accepted, and if not, why (Windows only).",
+	},
+}
+
+// localAPIExtraHandlers returns serverHandlers bound to s, for use as
+// localapi.Handler.ExtraHandlers.
+func (s *Server) localAPIExtraHandlers() map[string]localapi.HandlerFunc {
+	s.extraHandlersOnce.Do(func() {
+		s.extraHandlers = make(map[string]localapi.HandlerFunc, len(serverHandlers))
+		for name, fn := range serverHandlers {
+			fn := fn
+			s.extraHandlers[name] = func(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+				fn(s, h, w, r)
+			}
+		}
+	})
+	return s.extraHandlers
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServerRoutes(t *testing.T) {
+	for name := range serverHandlers {
+		var found bool
+		for _, r := range serverRoutes {
+			if r.Path == "/localapi/v0/"+name {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("server handler %q has no serverRoutes entry", name)
+		}
+	}
+	for _, r := range serverRoutes {
+		if _, ok := serverHandlers[strings.TrimPrefix(r.Path, "/localapi/v0/")]; !ok {
+			t.Errorf("serverRoutes entry %q has no handler", r.Path)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/localapi"
+)
+
+// lockHolderPath is the LocalAPI path of serveLockHolder. serveHTTP serves
+// it without adding the request to activeReqs, so that users denied
+// because another user holds the server can still query who that is.
+const lockHolderPath = "/localapi/v0/lock-holder"
+
+// LockHolder reports the user whose in-flight requests hold the server, as
+// checkConnIdentityLocked sees it. It deliberately includes only the
+// username and PID, as it's available to users who are otherwise denied.
+func (s *Server) LockHolder() apitype.LockHolder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var oldest *activeRequest
+	for _, ar := range s.activeReqs {
+		if oldest == nil || ar.start.Before(oldest.start) {
+			oldest = ar
+		}
+	}
+	if oldest == nil {
+		return apitype.LockHolder{}
+	}
+	ret := apitype.LockHolder{
+		InUse: true,
+		PID:   connPID(oldest.ci),
+		Since: oldest.start,
+	}
+	if oldest.ci != nil {
+		if u := oldest.ci.User(); u != nil {
+			ret.Username = u.Username
+		}
+	}
+	return ret
+}
+
+// serveLockHolder serves the Server's LockHolder as JSON.
+func (s *Server) serveLockHolder(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.LockHolder())
+}
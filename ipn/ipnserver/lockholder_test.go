@@ -0,0 +1,63 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/user"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestLockHolder(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	s := newTestServer(t)
+	alice := ipnauth.NewWindowsConnIdentity(nil, 123, "S-1-5-21-alice", &user.User{Uid: "S-1-5-21-alice", Username: `HOST\alice`})
+	bob := ipnauth.NewWindowsConnIdentity(nil, 456, "S-1-5-21-bob", nil)
+	newReq := func(path string, ci *ipnauth.ConnIdentity) *http.Request {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Host = apitype.LocalAPIHost
+		return r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ci))
+	}
+	getHolder := func(ci *ipnauth.ConnIdentity) apitype.LockHolder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, newReq(lockHolderPath, ci))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("lock-holder status = %d; body: %s", rec.Code, rec.Body.Bytes())
+		}
+		var lh apitype.LockHolder
+		if err := json.Unmarshal(rec.Body.Bytes(), &lh); err != nil {
+			t.Fatal(err)
+		}
+		return lh
+	}
+
+	if lh := getHolder(bob); lh.InUse {
+		t.Errorf("holder with no active requests = %+v; want not in use", lh)
+	}
+
+	onDone, err := s.addActiveHTTPRequest(newReq("/localapi/v0/status", alice), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer onDone()
+
+	// Bob is denied any other request, but can see who holds the lock.
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, newReq("/localapi/v0/status", bob))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("bob's status request = %d; want 401", rec.Code)
+	}
+	lh := getHolder(bob)
+	if !lh.InUse || lh.Username != `HOST\alice` || lh.PID != 123 || lh.Since.IsZero() {
+		t.Errorf("holder = %+v; want alice, pid 123", lh)
+	}
+}
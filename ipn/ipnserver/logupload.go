@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/localapi"
+)
+
+// logUploadStatus returns the status of the uploads of the backend's logs,
+// identified by s.backendLogID.
+func (s *Server) logUploadStatus() apitype.LogUploadStatus {
+	st := apitype.LogUploadStatus{
+		LogID: s.backendLogID,
+		State: apitype.LogUploadUnknown,
+	}
+	if s.LogUploadStatus == nil {
+		return st
+	}
+	us := s.LogUploadStatus()
+	st.State = apitype.LogUploadIdle
+	if us.Uploading {
+		st.State = apitype.LogUploadUploading
+	}
+	st.LastSuccess = us.LastSuccess
+	st.LastFailure = us.LastFailure
+	st.LastError = us.LastError
+	st.BytesUploaded = us.BytesUploaded
+	return st
+}
+
+// serveLogUploadStatus serves the Server's logUploadStatus as JSON.
+func (s *Server) serveLogUploadStatus(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "log-upload-status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.logUploadStatus())
+}
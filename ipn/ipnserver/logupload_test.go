@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/logtail"
+)
+
+func TestLogUploadStatus(t *testing.T) {
+	s := newTestServer(t)
+	get := func() apitype.LogUploadStatus {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/localapi/v0/log-upload-status", nil)
+		r.Host = apitype.LocalAPIHost
+		r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ipnauth.TrustedConnIdentity(nil)))
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.Bytes())
+		}
+		var st apitype.LogUploadStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	if st := get(); st.LogID != "logid" || st.State != apitype.LogUploadUnknown {
+		t.Errorf("without LogUploadStatus: %+v; want log ID and unknown state", st)
+	}
+
+	success := time.Now().Add(-time.Minute).Truncate(time.Second)
+	failure := success.Add(30 * time.Second)
+	us := logtail.UploadStatus{
+		Uploading:     true,
+		LastSuccess:   success,
+		LastFailure:   failure,
+		LastError:     "log upload failed 503",
+		BytesUploaded: 1234,
+	}
+	s.LogUploadStatus = func() logtail.UploadStatus { return us }
+	st := get()
+	if st.LogID != "logid" || st.State != apitype.LogUploadUploading {
+		t.Errorf("uploading: %+v; want log ID and uploading state", st)
+	}
+	if !st.LastSuccess.Equal(success) || !st.LastFailure.Equal(failure) || st.LastError == "" || st.BytesUploaded != 1234 {
+		t.Errorf("uploading: %+v; want %+v", st, us)
+	}
+
+	us.Uploading = false
+	if st := get(); st.State != apitype.LogUploadIdle {
+		t.Errorf("State = %q; want %q", st.State, apitype.LogUploadIdle)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import "net/http"
+
+// defaultMaintenanceMessage is served in maintenance mode if
+// SetMaintenance was given no message.
+const defaultMaintenanceMessage = "Tailscale is temporarily unavailable for maintenance"
+
+// maintenanceExemptPaths are the LocalAPI paths still served in
+// maintenance mode, so clients can keep showing the node's status and
+// health.
+var maintenanceExemptPaths = map[string]bool{
+	"/localapi/v0/status":          true,
+	"/localapi/v0/version":         true,
+	"/localapi/v0/server-features": true,
+	"/localapi/v0/server-stats":    true,
+	"/localapi/v0/server-timeouts": true,
+	lockHolderPath:                 true,
+	authInfoPath:                   true,
+}
+
+// SetMaintenance turns maintenance mode on or off. While on, the Server
+// answers LocalAPI requests other than those for status with 503 Service
+// Unavailable and message, for GUIs to show to the user. It's for planned
+// operations across a fleet.
+func (s *Server) SetMaintenance(enabled bool, message string) {
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if enabled == s.maintenance && message == s.maintenanceMsg {
+		return
+	}
+	s.maintenance, s.maintenanceMsg = enabled, message
+	if enabled {
+		s.logf("maintenance mode on: %q", message)
+	} else {
+		s.logf("maintenance mode off")
+	}
+}
+
+// maintenanceMessage returns the message to serve for r in maintenance
+// mode, or the empty string if r should be served normally.
+func (s *Server) maintenanceMessage(r *http.Request) string {
+	if maintenanceExemptPaths[r.URL.Path] {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.maintenance {
+		return ""
+	}
+	return s.maintenanceMsg
+}
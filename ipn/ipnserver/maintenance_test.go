@@ -0,0 +1,44 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestMaintenance(t *testing.T) {
+	s := newTestServer(t)
+	ci := ipnauth.TrustedConnIdentity(nil)
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Host = apitype.LocalAPIHost
+		r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ci))
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		return rec
+	}
+
+	const msg = "upgrading the fleet; back at 10:00"
+	s.SetMaintenance(true, msg)
+	rec := serve("GET", "/localapi/v0/prefs")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), msg) {
+		t.Errorf("prefs in maintenance = %d, %q; want 503 with message", rec.Code, rec.Body.String())
+	}
+	if rec := serve("GET", "/localapi/v0/status"); rec.Code != http.StatusOK {
+		t.Errorf("status in maintenance = %d; want 200", rec.Code)
+	}
+
+	s.SetMaintenance(false, "")
+	if rec := serve("GET", "/localapi/v0/prefs"); rec.Code == http.StatusServiceUnavailable {
+		t.Errorf("prefs after maintenance = 503 %q", rec.Body.String())
+	}
+}
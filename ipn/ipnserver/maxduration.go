@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"tailscale.com/util/clientmetric"
+)
+
+var metricRequestsTimedOut = clientmetric.NewCounter("ipnserver_requests_timed_out")
+
+// exemptFromMaxDuration reports whether requests to path may run longer
+// than Server.MaxRequestDuration: streaming watchers, dials, whose
+// connections are proxied for as long as they're open, and file uploads
+// and profiles, whose length is up to the client.
+func exemptFromMaxDuration(path string) bool {
+	return isStreamingPath(path) ||
+		strings.HasPrefix(path, "/localapi/v0/file-put/") ||
+		path == "/localapi/v0/pprof"
+}
+
+// withMaxDuration returns w and r updated to enforce s.MaxRequestDuration
+// on r, if set and r isn't exempt, and a func to call when r's handler
+// returns.
+//
+// Once the limit passes, r's context is canceled, and if the handler
+// hasn't started its response by then, a 504 is sent in its place. Any
+// response the handler then writes, typically about the canceled context,
+// is discarded. Handlers that ignore their context can't be interrupted,
+// but their late responses are discarded all the same.
+func (s *Server) withMaxDuration(w http.ResponseWriter, r *http.Request) (_ http.ResponseWriter, _ *http.Request, done func()) {
+	d := s.MaxRequestDuration
+	if d <= 0 || exemptFromMaxDuration(r.URL.Path) {
+		return w, r, func() {}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), d)
+	r = r.WithContext(ctx)
+	mw := &maxDurationWriter{responseWriter: responseWriter{w}, ctx: ctx}
+	return mw, r, func() {
+		if !mw.started && !mw.timedOut && mw.expired() {
+			mw.timeOut()
+		}
+		if mw.timedOut {
+			metricRequestsTimedOut.Add(1)
+			s.logf("%s %s for %s exceeded MaxRequestDuration of %v", r.Method, r.URL.Path, connIdentityString(r), d)
+		}
+		cancel()
+	}
+}
+
+// maxDurationWriter is an http.ResponseWriter that sends a 504 in place of
+// a response started after its context's deadline.
+type maxDurationWriter struct {
+	responseWriter
+	ctx      context.Context
+	started  bool // whether the handler's response was started in time
+	timedOut bool // whether a 504 was sent in place of the handler's response
+}
+
+func (w *maxDurationWriter) expired() bool {
+	return w.ctx.Err() == context.DeadlineExceeded
+}
+
+func (w *maxDurationWriter) timeOut() {
+	w.timedOut = true
+	http.Error(w.ResponseWriter, "request exceeded the server's maximum duration", http.StatusGatewayTimeout)
+}
+
+// begin reports whether the handler may write its response, sending a 504
+// instead if it's too late.
+func (w *maxDurationWriter) begin() bool {
+	if w.timedOut {
+		return false
+	}
+	if !w.started && w.expired() {
+		w.timeOut()
+		return false
+	}
+	w.started = true
+	return true
+}
+
+func (w *maxDurationWriter) WriteHeader(code int) {
+	if w.begin() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *maxDurationWriter) Write(p []byte) (int, error) {
+	if !w.begin() {
+		return 0, http.ErrHandlerTimeout
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *maxDurationWriter) Flush() {
+	if w.canFlush() && w.begin() {
+		w.responseWriter.Flush()
+	}
+}
+
+func (w *maxDurationWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if _, ok := w.ResponseWriter.(http.Hijacker); !ok {
+		return nil, nil, errHijackUnsupported
+	}
+	if !w.begin() {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	return w.responseWriter.Hijack()
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/localapi"
+)
+
+func TestMaxRequestDuration(t *testing.T) {
+	serverHandlers["test-slow"] = func(_ *Server, _ *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fast") != "" {
+			return
+		}
+		// Like most handlers, report the canceled context as an error.
+		<-r.Context().Done()
+		http.Error(w, r.Context().Err().Error(), http.StatusInternalServerError)
+	}
+	t.Cleanup(func() { delete(serverHandlers, "test-slow") })
+
+	s := newTestServer(t)
+	s.MaxRequestDuration = 50 * time.Millisecond
+	s.IdentityResolver = trustedResolver
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	ctx := s.connContext(context.Background(), c1)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil).WithContext(ctx)
+		r.Host = apitype.LocalAPIHost
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		return rec
+	}
+
+	before := metricRequestsTimedOut.Value()
+	t0 := time.Now()
+	rec := get("/localapi/v0/test-slow")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("slow request: status %d, %q; want 504", rec.Code, rec.Body.Bytes())
+	}
+	if d := time.Since(t0); d > 5*time.Second {
+		t.Errorf("slow request took %v; want it cut off at the cap", d)
+	}
+	if got := metricRequestsTimedOut.Value() - before; got != 1 {
+		t.Errorf("timed out requests increased by %d; want 1", got)
+	}
+
+	if rec := get("/localapi/v0/test-slow?fast=1"); rec.Code != http.StatusOK {
+		t.Errorf("fast request: status %d, %q; want 200", rec.Code, rec.Body.Bytes())
+	}
+	if !exemptFromMaxDuration("/localapi/v0/watch-ipn-bus") {
+		t.Error("watch-ipn-bus isn't exempt")
+	}
+}
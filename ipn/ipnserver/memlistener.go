@@ -0,0 +1,83 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// memListenerAddr is the address of listeners from NewMemListener.
+const memListenerAddr = "local-tailscaled.mem"
+
+// NewMemListener returns an in-memory listener to pass to Server.Run, along
+// with a dial func for clients, suitable for tailscale.LocalClient.Dial. The
+// dial func ignores its network and address arguments.
+//
+// It's for tests and for embedding a client and the daemon in one process.
+// Connections from it carry no OS credentials, so the Server treats their
+// peer as this process and grants it full access (see
+// ipnauth.TrustedConnIdentity).
+func NewMemListener() (ln net.Listener, dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	ml := &memListener{
+		ch:     make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	return ml, ml.dial
+}
+
+// memListener is the net.Listener returned by NewMemListener. Its
+// connections are net.Pipes.
+type memListener struct {
+	ch        chan net.Conn // server ends of dialed connections
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// memAddr is the net.Addr of a memListener.
+type memAddr string
+
+func (a memAddr) Network() string { return "mem" }
+func (a memAddr) String() string  { return string(a) }
+
+func (ln *memListener) Addr() net.Addr { return memAddr(memListenerAddr) }
+
+func (ln *memListener) Close() error {
+	ln.closeOnce.Do(func() { close(ln.closed) })
+	return nil
+}
+
+func (ln *memListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.ch:
+		return memConn{c}, nil
+	case <-ln.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// dial connects to ln, ignoring network and addr.
+func (ln *memListener) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	c, s := net.Pipe()
+	select {
+	case ln.ch <- s:
+		return c, nil
+	case <-ctx.Done():
+		c.Close()
+		s.Close()
+		return nil, ctx.Err()
+	case <-ln.closed:
+		c.Close()
+		s.Close()
+		return nil, net.ErrClosed
+	}
+}
+
+// memConn is a connection accepted from a memListener. Its distinct type is
+// how connContext recognizes in-process peers.
+type memConn struct {
+	net.Conn
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestMemListenerRoundTrip(t *testing.T) {
+	s := newTestServer(t)
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	lc := &tailscale.LocalClient{Dial: dial}
+	st, err := lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		t.Fatalf("StatusWithoutPeers: %v", err)
+	}
+	if st.BackendState == "" {
+		t.Errorf("empty BackendState in %+v", st)
+	}
+
+	// The watchers endpoint requires write access, which in-memory peers
+	// should have.
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/watchers/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := lc.DoLocalRequest(req)
+	if err != nil {
+		t.Fatalf("watchers: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("watchers status = %d; want 200", res.StatusCode)
+	}
+}
+
+func TestMemListenerClose(t *testing.T) {
+	ln, dial := NewMemListener()
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		accepted <- err
+	}()
+	ln.Close()
+	if err := <-accepted; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept after Close = %v; want net.ErrClosed", err)
+	}
+	if _, err := dial(context.Background(), "tcp", "ignored"); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("dial after Close = %v; want net.ErrClosed", err)
+	}
+}
@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// stripPathPrefix returns r with s.PathPrefix removed from the front of
+// its path, reporting false if the path isn't under the prefix. With no
+// PathPrefix, it returns r unchanged.
+func (s *Server) stripPathPrefix(r *http.Request) (_ *http.Request, ok bool) {
+	prefix := strings.TrimSuffix(s.PathPrefix, "/")
+	if prefix == "" {
+		return r, true
+	}
+	p, ok := cutPathPrefix(r.URL.Path, prefix)
+	if !ok {
+		return nil, false
+	}
+	rp, ok := cutPathPrefix(r.URL.RawPath, prefix)
+	if r.URL.RawPath != "" && !ok {
+		return nil, false
+	}
+	// As in http.StripPrefix.
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	r2.URL.RawPath = rp
+	return r2, true
+}
+
+// cutPathPrefix returns path with prefix, which doesn't end in a slash,
+// removed, reporting whether path is prefix or beneath it.
+func cutPathPrefix(path, prefix string) (string, bool) {
+	if path == prefix {
+		return "/", true
+	}
+	if rest := strings.TrimPrefix(path, prefix); rest != path && strings.HasPrefix(rest, "/") {
+		return rest, true
+	}
+	return "", false
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestPathPrefix(t *testing.T) {
+	s := newTestServer(t)
+	s.PathPrefix = "/ts/"
+	s.IdentityResolver = trustedResolver
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	ctx := s.connContext(context.Background(), c1)
+
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/ts/localapi/v0/status", http.StatusOK},
+		{"/ts/", http.StatusOK},
+		{"/ts", http.StatusOK},
+		{"/ts/localapi/v0/no-such-endpoint", http.StatusNotFound},
+		{"/localapi/v0/status", http.StatusNotFound},
+		{"/", http.StatusNotFound},
+		{"/tsx/localapi/v0/status", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest("GET", tt.path, nil).WithContext(ctx)
+		r.Host = apitype.LocalAPIHost
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("GET %s: status %d; want %d", tt.path, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestNoPathPrefix(t *testing.T) {
+	s := &Server{}
+	r := httptest.NewRequest("GET", "/localapi/v0/status", nil)
+	if got, ok := s.stripPathPrefix(r); !ok || got != r {
+		t.Errorf("stripPathPrefix without PathPrefix = %v, %v; want r unchanged", got, ok)
+	}
+}
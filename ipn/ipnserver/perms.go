@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+// RootPolicy controls how LocalAPI connections from root (uid 0) peers are
+// authorized on Unix platforms that use peer credentials. It has no effect
+// on Windows.
+//
+// The resulting permissions are:
+//
+//	                          root peer     other write-permitted peer   other peer
+//	RootPolicyDefault         read, write   read, write                  read
+//	RootPolicyRequireForWrite read, write   read                         read
+//	RootPolicyDeny            none          read, write                  read
+//	RootPolicyRequireOperator read (*)      read, write                  read
+//
+// (*) read, write if root is the operator.
+//
+// "Other write-permitted peers" are the operator, a peer with the same uid as
+// a non-root tailscaled, and local admins, as decided by
+// ipnauth.ConnIdentity.IsReadonlyConn.
+type RootPolicy int
+
+const (
+	// RootPolicyDefault grants root peers full access, like any other
+	// write-permitted peer.
+	RootPolicyDefault RootPolicy = iota
+
+	// RootPolicyRequireForWrite permits writes only from root peers. All
+	// other peers, including the operator, are read-only.
+	RootPolicyRequireForWrite
+
+	// RootPolicyDeny denies root peers all LocalAPI access.
+	RootPolicyDeny
+
+	// RootPolicyRequireOperator makes root peers subject to the operator
+	// designation like other users: they may write only if root is the
+	// operator, so that, say, cron jobs running as root can't reconfigure
+	// Tailscale. If no operator is set, root is read-only.
+	RootPolicyRequireOperator
+)
+
+func (p RootPolicy) String() string {
+	switch p {
+	case RootPolicyDefault:
+		return "default"
+	case RootPolicyRequireForWrite:
+		return "require-root-for-write"
+	case RootPolicyDeny:
+		return "deny-root"
+	case RootPolicyRequireOperator:
+		return "root-requires-operator"
+	}
+	return "unknown"
+}
+
+// applyRootPolicy adjusts the permissions read and write computed for a
+// Unix peer with userid uid (empty if unknown) according to s.RootPolicy.
+// operatorUID is the operator's userid, or empty if there's none.
+func (s *Server) applyRootPolicy(uid, operatorUID string, read, write bool) (_, _ bool) {
+	isRoot := uid == "0"
+	switch s.RootPolicy {
+	case RootPolicyRequireForWrite:
+		return read, write && isRoot
+	case RootPolicyDeny:
+		if isRoot {
+			return false, false
+		}
+	case RootPolicyRequireOperator:
+		if isRoot {
+			return read, write && operatorUID == uid
+		}
+	}
+	return read, write
+}
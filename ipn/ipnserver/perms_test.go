@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"runtime"
+	"testing"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestApplyRootPolicy(t *testing.T) {
+	tests := []struct {
+		policy    RootPolicy
+		uid       string
+		operator  string
+		rw        bool // whether IsReadonlyConn granted write
+		wantRead  bool
+		wantWrite bool
+	}{
+		{RootPolicyDefault, "0", "1000", true, true, true},
+		{RootPolicyDefault, "1000", "1000", true, true, true},
+		{RootPolicyDefault, "1001", "1000", false, true, false},
+
+		{RootPolicyRequireForWrite, "0", "1000", true, true, true},
+		{RootPolicyRequireForWrite, "1000", "1000", true, true, false}, // operator
+		{RootPolicyRequireForWrite, "1001", "1000", false, true, false},
+		{RootPolicyRequireForWrite, "", "1000", false, true, false},
+
+		{RootPolicyDeny, "0", "1000", true, false, false},
+		{RootPolicyDeny, "1000", "1000", true, true, true},
+		{RootPolicyDeny, "1001", "1000", false, true, false},
+
+		{RootPolicyRequireOperator, "0", "1000", true, true, false},
+		{RootPolicyRequireOperator, "0", "", true, true, false},
+		{RootPolicyRequireOperator, "0", "0", true, true, true},
+		{RootPolicyRequireOperator, "1000", "1000", true, true, true},
+		{RootPolicyRequireOperator, "1001", "1000", false, true, false},
+	}
+	for _, tt := range tests {
+		s := &Server{RootPolicy: tt.policy}
+		read, write := s.applyRootPolicy(tt.uid, tt.operator, true, tt.rw)
+		if read != tt.wantRead || write != tt.wantWrite {
+			t.Errorf("%v, uid %q, operator %q, rw=%v: got (read=%v, write=%v); want (%v, %v)",
+				tt.policy, tt.uid, tt.operator, tt.rw, read, write, tt.wantRead, tt.wantWrite)
+		}
+	}
+}
+
+func TestRootPolicyRootPeer(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "js" {
+		t.Skip("root policy applies to Unix peers")
+	}
+	root := ipnauth.NewUnixConnIdentity(nil, 1, "0")
+	tests := []struct {
+		policy    RootPolicy
+		wantWrite bool
+	}{
+		{RootPolicyDefault, true},
+		{RootPolicyRequireOperator, false}, // no operator set
+	}
+	for _, tt := range tests {
+		s := newTestServer(t)
+		s.RootPolicy = tt.policy
+		read, write := s.localAPIPermissions(root)
+		if !read || write != tt.wantWrite {
+			t.Errorf("%v: root peer got (read=%v, write=%v); want (true, %v)", tt.policy, read, write, tt.wantWrite)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+// Principal is the normalized identity of the peer of a LocalAPI
+// connection, derived once per connection from its ipnauth.ConnIdentity.
+type Principal struct {
+	// UserID is the peer's userid: its SID on Windows, or its uid
+	// elsewhere when peer credentials are available. It's empty if
+	// unknown.
+	UserID string
+
+	// Username is the peer's username, if already known without a lookup
+	// (currently only on Windows).
+	Username string
+
+	// PID is the peer's process ID, or 0 if unknown.
+	PID int
+
+	// Transport is the kind of connection the peer arrived on.
+	Transport Transport
+
+	// Trusted is whether the peer is this process or otherwise granted
+	// full access regardless of its user; see ipnauth.TrustedConnIdentity.
+	Trusted bool
+}
+
+// String returns a short description of p for logs, such as
+// "1001 pid 42 via unix".
+func (p Principal) String() string {
+	var sb strings.Builder
+	if p.Trusted {
+		sb.WriteString("trusted ")
+	}
+	if p.UserID != "" {
+		sb.WriteString(p.UserID)
+	} else {
+		sb.WriteString("unknown-user")
+	}
+	if p.Username != "" {
+		fmt.Fprintf(&sb, " (%s)", p.Username)
+	}
+	if p.PID != 0 {
+		fmt.Fprintf(&sb, " pid %d", p.PID)
+	}
+	if p.Transport != "" {
+		fmt.Fprintf(&sb, " via %s", p.Transport)
+	}
+	return sb.String()
+}
+
+// principalContextKey is the http.Request.Context's context.Value key for
+// the connection's *Principal.
+type principalContextKey struct{}
+
+// newPrincipal returns the Principal of ci, the identity of c's peer. ci
+// may be nil or incomplete, as for denied connections.
+func newPrincipal(c net.Conn, ci *ipnauth.ConnIdentity) *Principal {
+	if ci == nil {
+		return &Principal{Transport: transportOf(c)}
+	}
+	p := &Principal{
+		UserID:    connUserID(ci),
+		PID:       connPID(ci),
+		Transport: transportOf(c),
+		Trusted:   ci.IsTrusted(),
+	}
+	if u := ci.User(); u != nil {
+		p.Username = u.Username
+	}
+	return p
+}
+
+// PrincipalFromContext returns the principal of the LocalAPI request whose
+// context is ctx. It's available to every handler the Server runs,
+// including LocalAPI handlers.
+//
+// It returns nil if ctx isn't from such a request or if the connection's
+// identity couldn't be determined; the Server rejects requests on such
+// connections before they reach LocalAPI handlers.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return p
+}
+
+// RequestAuth is what a LocalAPI request is authorized to do, as computed
+// by the Server from its connection's identity.
+type RequestAuth struct {
+	// Read, Write and Cert are whether the request may use the read-only
+	// LocalAPI endpoints, the mutating ones, and those fetching TLS certs.
+	// Write implies the others.
+	Read, Write, Cert bool
+
+	// Identity is the identity of the peer on the request's connection.
+	Identity *ipnauth.ConnIdentity
+}
+
+type requestAuthContextKey struct{}
+
+// RequestAuthFromContext returns what the LocalAPI request whose context
+// is ctx is authorized to do, for Server.Middleware and LocalAPI handlers
+// to make decisions consistent with the Server's.
+//
+// It returns nil if ctx isn't from a LocalAPI request that has passed the
+// Server's checks.
+func RequestAuthFromContext(ctx context.Context) *RequestAuth {
+	a, _ := ctx.Value(requestAuthContextKey{}).(*RequestAuth)
+	return a
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestPrincipalFromContext(t *testing.T) {
+	if p := PrincipalFromContext(context.Background()); p != nil {
+		t.Errorf("PrincipalFromContext(Background) = %+v; want nil", p)
+	}
+
+	s := New(t.Logf, "logid")
+	s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+		return ipnauth.NewUnixConnIdentity(c, 42, "1001"), nil
+	}
+	got := make(chan *Principal, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- PrincipalFromContext(r.Context())
+	}))
+	ts.Config.ConnContext = s.connContext
+	ts.Start()
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	p := <-got
+	if p == nil {
+		t.Fatal("no principal in handler's context")
+	}
+	want := Principal{UserID: "1001", PID: 42, Transport: TransportTCP}
+	if *p != want {
+		t.Errorf("principal = %+v; want %+v", *p, want)
+	}
+}
+
+func TestRequestAuthFromContext(t *testing.T) {
+	if a := RequestAuthFromContext(context.Background()); a != nil {
+		t.Errorf("RequestAuthFromContext(Background) = %+v; want nil", a)
+	}
+
+	tests := []struct {
+		name     string
+		resolver func(net.Conn) (*ipnauth.ConnIdentity, error)
+		want     RequestAuth // without Identity
+	}{
+		{"trusted", trustedResolver, RequestAuth{Read: true, Write: true}},
+		{"unprivileged", func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+			return ipnauth.NewUnixConnIdentity(c, 42, "4242"), nil
+		}, RequestAuth{Read: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.IdentityResolver = tt.resolver
+			var got *RequestAuth
+			s.Middleware = func(h http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					got = RequestAuthFromContext(r.Context())
+					h.ServeHTTP(w, r)
+				})
+			}
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			defer c2.Close()
+			r := httptest.NewRequest("GET", "/localapi/v0/status", nil).WithContext(s.connContext(context.Background(), c1))
+			r.Host = apitype.LocalAPIHost
+			s.serveHTTP(httptest.NewRecorder(), r)
+
+			if got == nil {
+				t.Fatal("middleware not called, or no RequestAuth in its context")
+			}
+			if got.Identity == nil {
+				t.Error("RequestAuth.Identity is nil")
+			}
+			if g := (RequestAuth{Read: got.Read, Write: got.Write, Cert: got.Cert}); g != tt.want {
+				t.Errorf("RequestAuth = %+v; want %+v", g, tt.want)
+			}
+		})
+	}
+}
+
+// testSpan is what a tracing middleware records about a request.
+type testSpan struct {
+	method, path string
+	transport    Transport
+	write        bool
+	requestID    ipn.RequestID
+	status       int
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	s.IdentityResolver = trustedResolver
+	var spans []testSpan
+	s.Middleware = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			h.ServeHTTP(sw, r)
+			spans = append(spans, testSpan{
+				method:    r.Method,
+				path:      r.URL.Path,
+				transport: PrincipalFromContext(r.Context()).Transport,
+				write:     RequestAuthFromContext(r.Context()).Write,
+				requestID: ipn.RequestIDFromContext(r.Context()),
+				status:    sw.status,
+			})
+		})
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	ctx := s.connContext(context.Background(), c1)
+	for _, path := range []string{"/localapi/v0/status", "/localapi/v0/no-such-endpoint"} {
+		r := httptest.NewRequest("GET", path, nil).WithContext(ctx)
+		r.Host = apitype.LocalAPIHost
+		r.Header.Set(RequestIDHeader, "req-"+path[len("/localapi/v0/"):])
+		s.serveHTTP(httptest.NewRecorder(), r)
+	}
+
+	want := []testSpan{
+		{"GET", "/localapi/v0/status", TransportOther, true, "req-status", http.StatusOK},
+		{"GET", "/localapi/v0/no-such-endpoint", TransportOther, true, "req-no-such-endpoint", http.StatusNotFound},
+	}
+	if !reflect.DeepEqual(spans, want) {
+		t.Errorf("spans = %+v; want %+v", spans, want)
+	}
+}
@@ -11,6 +11,9 @@
 	"net"
 	"net/http"
 
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/logpolicy"
 )
 
@@ -23,6 +26,9 @@
 // "Internet Kill Switch" installed by tailscaled for exit nodes
 // precludes that from working and instead the GUI fails to dial out.
 // So, go through tailscaled (with a CONNECT request) instead.
+//
+// As that's only needed with an exit node, CONNECT requests are refused
+// with 503 Service Unavailable when the prefs select none.
 func (s *Server) handleProxyConnectConn(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	if r.Method != "CONNECT" {
@@ -38,8 +44,29 @@ func (s *Server) handleProxyConnectConn(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	tr := logpolicy.NewLogtailTransport(logHost)
-	back, err := tr.DialContext(ctx, "tcp", hostPort)
+	// The GUI only needs the proxy while an exit node is in use; without
+	// one it can dial out itself, so don't set up a tunnel.
+	if ok, err := s.exitNodeInUse(); !ok {
+		msg := "no exit node in use"
+		if err != nil {
+			msg = err.Error()
+		}
+		s.logf("refusing CONNECT to %v: %s", hostPort, msg)
+		http.Error(w, "CONNECT proxy unavailable: "+msg, http.StatusServiceUnavailable)
+		return
+	}
+
+	if !s.acquireTunnelGoroutines() {
+		s.denyRequest(w, r, DenyTooManyGoroutines, errTooManyGoroutines.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.releaseTunnelGoroutines()
+
+	dial := logpolicy.NewLogtailTransport(logHost).DialContext
+	if s.testProxyDial != nil {
+		dial = s.testProxyDial
+	}
+	back, err := dial(ctx, "tcp", hostPort)
 	if err != nil {
 		s.logf("error CONNECT dialing %v: %v", hostPort, err)
 		http.Error(w, "Connect failure", http.StatusBadGateway)
@@ -61,14 +88,45 @@ func (s *Server) handleProxyConnectConn(w http.ResponseWriter, r *http.Request)
 
 	io.WriteString(c, "HTTP/1.1 200 OK\r\n\r\n")
 
+	ci, _ := ctx.Value(connIdentityContextKey{}).(*ipnauth.ConnIdentity)
+	t, unregister := s.registerTunnel(hostPort, ci)
+	defer unregister()
+	p := PrincipalFromContext(ctx)
+	if p == nil {
+		p = newPrincipal(c, ci)
+	}
+	s.audit(AuditEvent{Kind: AuditProxyTunnel, Principal: *p, Target: hostPort})
+
 	errc := make(chan error, 2)
 	go func() {
-		_, err := io.Copy(c, back)
+		_, err := io.Copy(countingWriter{c, &t.recv, &s.tunnelBytesRecv, metricProxyTunnelBytesReceived}, back)
 		errc <- err
 	}()
 	go func() {
-		_, err := io.Copy(back, br)
+		_, err := io.Copy(countingWriter{back, &t.sent, &s.tunnelBytesSent, metricProxyTunnelBytesSent}, br)
 		errc <- err
 	}()
 	<-errc
 }
+
+// backendPrefs returns lb's prefs. It's a variable for tests.
+var backendPrefs = (*ipnlocal.LocalBackend).Prefs
+
+// exitNodeInUse reports whether the backend's prefs select an exit node.
+// It goes by the prefs rather than the status's ExitNodeStatus, which is
+// only set once the exit node is in the netmap, as the Internet Kill
+// Switch blocks the GUI's own dials as soon as an exit node is selected.
+func (s *Server) exitNodeInUse() (bool, error) {
+	lb := s.lb.Load()
+	if lb == nil {
+		return false, nil
+	}
+	var prefs ipn.PrefsView
+	if err := s.callBackend("Prefs", func() { prefs = backendPrefs(lb) }); err != nil {
+		return false, err
+	}
+	if !prefs.Valid() {
+		return false, nil
+	}
+	return !prefs.ExitNodeID().IsZero() || prefs.ExitNodeIP().IsValid(), nil
+}
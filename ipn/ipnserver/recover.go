@@ -0,0 +1,92 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+// recoverPanics returns a handler that runs h, logging any panic along with
+// the request path and connection identity and replying with a generic 500
+// error instead. Such panics, often from the LocalBackend, may leave the
+// server degraded; see Server.DegradeOnPanic. The other requests in flight
+// at the time are logged too, as they may have been involved.
+//
+// If h had already started writing its response (as streaming handlers like
+// watch-ipn-bus do) when it panicked, a 500 can no longer be sent, so the
+// connection is aborted instead, so the client doesn't mistake the truncated
+// response for a complete one.
+func (s *Server) recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &trackingWriter{responseWriter: responseWriter{w}}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			s.logf("panic serving %s %s for %s: %v\n%s", r.Method, r.URL.Path, connIdentityString(r), p, debug.Stack())
+			s.noteRecoveredPanic("the handler for " + r.URL.Path)
+			s.dumpActiveReqs()
+			if tw.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}()
+		h.ServeHTTP(tw, r)
+	})
+}
+
+// connIdentityString returns a description of the identity of the
+// connection r arrived on, for logging.
+func connIdentityString(r *http.Request) string {
+	switch v := r.Context().Value(connIdentityContextKey{}).(type) {
+	case *ipnauth.ConnIdentity:
+		return describeConnIdentity(v)
+	case error:
+		return fmt.Sprintf("unknown identity (%v)", v)
+	}
+	return "unknown identity"
+}
+
+// trackingWriter is an http.ResponseWriter that records whether the response
+// has started being written.
+type trackingWriter struct {
+	responseWriter
+	wroteHeader bool
+}
+
+func (w *trackingWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *trackingWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *trackingWriter) Flush() {
+	if w.canFlush() {
+		w.wroteHeader = true
+	}
+	w.responseWriter.Flush()
+}
+
+func (w *trackingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, brw, err := w.responseWriter.Hijack()
+	if err == nil {
+		w.wroteHeader = true
+	}
+	return c, brw, err
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"tailscale.com/util/clientmetric"
+)
+
+// DenyReason is why the Server rejected a connection or request.
+type DenyReason string
+
+const (
+	DenyIdentityError         DenyReason = "identity_error"           // the peer's identity couldn't be determined
+	DenyUnauthorized          DenyReason = "unauthorized"             // the peer isn't permitted to connect
+	DenyNoBackend             DenyReason = "no_backend"               // no LocalBackend yet
+	DenyInUseOtherUser        DenyReason = "in_use_other_user"        // another user is using the server
+	DenyShuttingDown          DenyReason = "shutting_down"            // Run is shutting down
+	DenyBackendBusy           DenyReason = "backend_busy"             // the backend is busy; see LocalBackend.Busy
+	DenyTooManyRequests       DenyReason = "too_many_requests"        // see Server.MaxRequestsPerUser
+	DenyMaintenance           DenyReason = "maintenance"              // see Server.SetMaintenance
+	DenyResetInProgress       DenyReason = "reset_in_progress"        // see Server.UserSwitchResetTimeout
+	DenyTooManyConns          DenyReason = "too_many_conns"           // see Server.MaxConns
+	DenySetUserFailed         DenyReason = "set_user_failed"          // the backend couldn't switch to the user
+	DenyTooManyGoroutines     DenyReason = "too_many_goroutines"      // see Server.MaxGoroutines
+	DenyTooManyActiveRequests DenyReason = "too_many_active_requests" // see Server.RejectOverActiveRequestsThreshold
+	DenyRestarting            DenyReason = "restarting"               // see Server.BeginBackendRestart
+	DenyOutsideHours          DenyReason = "outside_hours"            // see Server.AcceptWindows
+	DenyStatusRateLimited     DenyReason = "status_rate_limited"      // see Server.StatusQueryInterval
+)
+
+// Counters of connections and requests the Server rejects, by reason, so
+// operators can alert on spikes (which often mean misconfiguration).
+//
+// The identity_error, unauthorized, too_many_conns,
+// too_many_active_requests and outside_hours counters count connections,
+// as connContext decides those when a connection is accepted.
+// too_many_goroutines counts both connections and CONNECT requests. The
+// others count requests.
+var (
+	metricRejectedIdentityError         = clientmetric.NewCounter("ipnserver_rejected_identity_error")
+	metricRejectedUnauthorized          = clientmetric.NewCounter("ipnserver_rejected_unauthorized")
+	metricRejectedNoBackend             = clientmetric.NewCounter("ipnserver_rejected_no_backend")
+	metricRejectedInUseOtherUser        = clientmetric.NewCounter("ipnserver_rejected_in_use_other_user")
+	metricRejectedShuttingDown          = clientmetric.NewCounter("ipnserver_rejected_shutting_down")
+	metricRejectedBackendBusy           = clientmetric.NewCounter("ipnserver_rejected_backend_busy")
+	metricRejectedTooManyRequests       = clientmetric.NewCounter("ipnserver_rejected_too_many_requests")
+	metricRejectedMaintenance           = clientmetric.NewCounter("ipnserver_rejected_maintenance")
+	metricRejectedResetInProgress       = clientmetric.NewCounter("ipnserver_rejected_reset_in_progress")
+	metricRejectedTooManyConns          = clientmetric.NewCounter("ipnserver_rejected_too_many_conns")
+	metricRejectedSetUserFailed         = clientmetric.NewCounter("ipnserver_rejected_set_user_failed")
+	metricRejectedTooManyGoroutines     = clientmetric.NewCounter("ipnserver_rejected_too_many_goroutines")
+	metricRejectedTooManyActiveRequests = clientmetric.NewCounter("ipnserver_rejected_too_many_active_requests")
+	metricRejectedRestarting            = clientmetric.NewCounter("ipnserver_rejected_restarting")
+	metricRejectedOutsideHours          = clientmetric.NewCounter("ipnserver_rejected_outside_hours")
+	metricRejectedStatusRateLimited     = clientmetric.NewCounter("ipnserver_rejected_status_rate_limited")
+)
+
+// rejectedMetric maps each DenyReason to its counter.
+var rejectedMetric = map[DenyReason]*clientmetric.Metric{
+	DenyIdentityError:         metricRejectedIdentityError,
+	DenyUnauthorized:          metricRejectedUnauthorized,
+	DenyNoBackend:             metricRejectedNoBackend,
+	DenyInUseOtherUser:        metricRejectedInUseOtherUser,
+	DenyShuttingDown:          metricRejectedShuttingDown,
+	DenyBackendBusy:           metricRejectedBackendBusy,
+	DenyTooManyRequests:       metricRejectedTooManyRequests,
+	DenyMaintenance:           metricRejectedMaintenance,
+	DenyResetInProgress:       metricRejectedResetInProgress,
+	DenyTooManyConns:          metricRejectedTooManyConns,
+	DenySetUserFailed:         metricRejectedSetUserFailed,
+	DenyTooManyGoroutines:     metricRejectedTooManyGoroutines,
+	DenyTooManyActiveRequests: metricRejectedTooManyActiveRequests,
+	DenyRestarting:            metricRejectedRestarting,
+	DenyOutsideHours:          metricRejectedOutsideHours,
+	DenyStatusRateLimited:     metricRejectedStatusRateLimited,
+}
+
+// denyRequest rejects r for reason with an HTTP error, counting it and
+// recording it in the access log and Stats.
+func (s *Server) denyRequest(w http.ResponseWriter, r *http.Request, reason DenyReason, msg string, code int) {
+	rejectedMetric[reason].Add(1)
+	s.noteRejected(reason)
+	s.logAccess(requestPrincipal(r), r, reason, msg)
+	http.Error(w, msg, code)
+}
+
+// denyRequestJSON is like denyRequest, but sends body as the JSON error
+// response in place of msg.
+func (s *Server) denyRequestJSON(w http.ResponseWriter, r *http.Request, reason DenyReason, msg string, code int, body any) {
+	rejectedMetric[reason].Add(1)
+	s.noteRejected(reason)
+	s.logAccess(requestPrincipal(r), r, reason, msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// shuttingDown reports whether the current Run has begun shutting down,
+// after which new requests on still-open connections are refused.
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.shutdownT0.IsZero()
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/util/clientmetric"
+)
+
+func TestRejectionMetrics(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	serve := func(s *Server, method string, ci *ipnauth.ConnIdentity) {
+		r := httptest.NewRequest(method, "/localapi/v0/status", nil)
+		r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ci))
+		s.serveHTTP(httptest.NewRecorder(), r)
+	}
+	alice := ipnauth.NewWindowsConnIdentity(nil, 1, "S-1-5-21-alice", nil)
+	bob := ipnauth.NewWindowsConnIdentity(nil, 2, "S-1-5-21-bob", nil)
+
+	tests := []struct {
+		name   string
+		metric *clientmetric.Metric
+		do     func(t *testing.T)
+	}{
+		{"identity-error", metricRejectedIdentityError, func(t *testing.T) {
+			s := New(t.Logf, "logid")
+			s.IdentityResolver = func(net.Conn) (*ipnauth.ConnIdentity, error) {
+				return nil, errors.New("boom")
+			}
+			s.connContext(context.Background(), c1)
+		}},
+		{"unauthorized", metricRejectedUnauthorized, func(t *testing.T) {
+			s := New(t.Logf, "logid")
+			s.AllowedUsers = []string{"1002"}
+			s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+				return ipnauth.NewUnixConnIdentity(c, 1, "1001"), nil
+			}
+			s.connContext(context.Background(), c1)
+		}},
+		{"too-many-conns", metricRejectedTooManyConns, func(t *testing.T) {
+			s := New(t.Logf, "logid")
+			s.MaxConns = 1
+			s.IdentityResolver = trustedResolver
+			s.connContext(context.Background(), c1)
+			s.connContext(context.Background(), c1)
+		}},
+		{"too-many-goroutines", metricRejectedTooManyGoroutines, func(t *testing.T) {
+			s := New(t.Logf, "logid")
+			s.MaxGoroutines = 1
+			s.IdentityResolver = trustedResolver
+			s.connContext(context.Background(), c1)
+			s.connContext(context.Background(), c1)
+		}},
+		{"too-many-active-requests", metricRejectedTooManyActiveRequests, func(t *testing.T) {
+			s := newTestServer(t)
+			s.RejectOverActiveRequestsThreshold = true
+			s.ActiveRequestsThreshold = 1
+			for i := 0; i < 2; i++ {
+				onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/", nil), alice)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer onDone()
+			}
+			s.IdentityResolver = trustedResolver
+			s.connContext(context.Background(), c1)
+		}},
+		{"outside-hours", metricRejectedOutsideHours, func(t *testing.T) {
+			s := New(t.Logf, "logid")
+			s.AcceptWindows = []AcceptWindow{{Start: 9 * time.Hour, End: 17 * time.Hour}}
+			s.timeNow = func() time.Time { return time.Date(2022, 11, 1, 20, 0, 0, 0, time.UTC) }
+			s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+				return ipnauth.NewUnixConnIdentity(c, 1, "1001"), nil
+			}
+			s.connContext(context.Background(), c1)
+		}},
+		{"no-backend", metricRejectedNoBackend, func(t *testing.T) {
+			serve(New(t.Logf, "logid"), "GET", alice)
+		}},
+		{"shutting-down", metricRejectedShuttingDown, func(t *testing.T) {
+			s := newTestServer(t)
+			s.shutdownPhase(ShutdownDraining)
+			serve(s, "GET", alice)
+		}},
+		{"restarting", metricRejectedRestarting, func(t *testing.T) {
+			s := newTestServer(t)
+			defer s.BeginBackendRestart()()
+			serve(s, "GET", alice)
+		}},
+		{"status-rate-limited", metricRejectedStatusRateLimited, func(t *testing.T) {
+			s := newTestServer(t)
+			s.StatusQueryInterval, s.StatusQueryBurst = time.Hour, 1
+			serve(s, "GET", alice)
+			serve(s, "GET", alice)
+		}},
+		{"backend-busy", metricRejectedBackendBusy, func(t *testing.T) {
+			s := newTestServer(t)
+			defer s.mustBackend().BeginBusy()()
+			serve(s, "POST", alice)
+		}},
+		{"too-many-requests", metricRejectedTooManyRequests, func(t *testing.T) {
+			s := newTestServer(t)
+			s.MaxRequestsPerUser = 1
+			onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/", nil), alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer onDone()
+			serve(s, "GET", alice)
+		}},
+		{"in-use-other-user", metricRejectedInUseOtherUser, func(t *testing.T) {
+			t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+			s := newTestServer(t)
+			onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/", nil), alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer onDone()
+			serve(s, "GET", bob)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := tt.metric.Value()
+			tt.do(t)
+			if got := tt.metric.Value() - before; got != 1 {
+				t.Errorf("%s increased by %d; want 1", tt.metric.Name(), got)
+			}
+		})
+	}
+}
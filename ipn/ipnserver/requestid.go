@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"tailscale.com/ipn"
+)
+
+// RequestIDHeader is the HTTP header carrying a LocalAPI request's ID. A
+// client may set it to choose the ID; otherwise the Server generates one.
+// Either way, the Server echoes the ID in the response and passes it to
+// the backend in the request's context (see ipn.RequestIDFromContext), so
+// backend logs about the operations a request triggers can include it.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen is the longest client-chosen request ID accepted.
+const maxRequestIDLen = 64
+
+// withRequestID returns r with a request ID in its context, as chosen by
+// the client or else generated, and sets it on w's response header.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(ipn.WithRequestID(r.Context(), ipn.RequestID(id)))
+}
+
+// validRequestID reports whether id is acceptable as a client-chosen
+// request ID: short, and made only of characters safe to log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tstest"
+	"tailscale.com/wgengine"
+)
+
+func TestRequestIDInBackendContext(t *testing.T) {
+	var gotID ipn.RequestID
+	serverHandlers["test-request-id"] = func(_ *Server, _ *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+		gotID = ipn.RequestIDFromContext(r.Context())
+	}
+	t.Cleanup(func() { delete(serverHandlers, "test-request-id") })
+
+	s := newTestServer(t)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	s.IdentityResolver = trustedResolver
+	ctx := s.connContext(context.Background(), c1)
+
+	get := func(id string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/localapi/v0/test-request-id", nil).WithContext(ctx)
+		r.Host = apitype.LocalAPIHost
+		if id != "" {
+			r.Header.Set(RequestIDHeader, id)
+		}
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("code %d, %s", rec.Code, rec.Body.Bytes())
+		}
+		return rec
+	}
+
+	rec := get("client-chosen-1")
+	if gotID != "client-chosen-1" || rec.Header().Get(RequestIDHeader) != "client-chosen-1" {
+		t.Errorf("client-chosen ID: handler got %q, response header %q", gotID, rec.Header().Get(RequestIDHeader))
+	}
+
+	// Invalid IDs are replaced with generated ones.
+	rec = get("bad id\n")
+	if gotID == "" || gotID == "bad id\n" || string(gotID) != rec.Header().Get(RequestIDHeader) {
+		t.Errorf("generated ID: handler got %q, response header %q", gotID, rec.Header().Get(RequestIDHeader))
+	}
+}
+
+func trustedResolver(c net.Conn) (*ipnauth.ConnIdentity, error) {
+	return ipnauth.TrustedConnIdentity(c), nil
+}
+
+func TestRequestIDInBackendLogs(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	logf := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	eng, err := wgengine.NewFakeUserspaceEngine(logf, 0)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	t.Cleanup(eng.Close)
+	lb, err := ipnlocal.NewLocalBackend(logf, "logid", new(mem.Store), "", nil, eng, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(lb.Shutdown)
+
+	s := New(tstest.WhileTestRunningLogger(t), "logid")
+	s.SetLocalBackend(lb)
+	s.IdentityResolver = trustedResolver
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	ctx := s.connContext(context.Background(), c1)
+
+	r := httptest.NewRequest("PATCH", "/localapi/v0/prefs", strings.NewReader(`{"HostnameSet":true,"Prefs":{"Hostname":"reqid-test"}}`)).WithContext(ctx)
+	r.Host = apitype.LocalAPIHost
+	r.Header.Set(RequestIDHeader, "edit-prefs-1")
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("code %d, %s", rec.Code, rec.Body.Bytes())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, line := range lines {
+		if strings.HasPrefix(line, "[req edit-prefs-1] EditPrefs: ") {
+			return
+		}
+	}
+	t.Errorf("no EditPrefs log line tagged with the request ID; got:\n%s", strings.Join(lines, "\n"))
+}
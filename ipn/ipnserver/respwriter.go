@@ -0,0 +1,49 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+var errHijackUnsupported = errors.New("ResponseWriter does not implement http.Hijacker")
+
+// responseWriter wraps an http.ResponseWriter. It's embedded by the
+// server's ResponseWriter wrappers, which override the methods they need
+// to observe, so that all of them pass through http.Flusher and
+// http.Hijacker alike, and unwrap (as http.ResponseController does) to the
+// ResponseWriter they wrap.
+type responseWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap returns the wrapped ResponseWriter.
+func (w responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// canFlush reports whether Flush does anything: whether the wrapped
+// ResponseWriter is an http.Flusher.
+func (w responseWriter) canFlush() bool {
+	_, ok := w.ResponseWriter.(http.Flusher)
+	return ok
+}
+
+func (w responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errHijackUnsupported
+	}
+	return hj.Hijack()
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseWriterWrappers(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	wrappers := map[string]func(http.ResponseWriter) http.ResponseWriter{
+		"tracking": func(w http.ResponseWriter) http.ResponseWriter {
+			return &trackingWriter{responseWriter: responseWriter{w}}
+		},
+		"deadline": func(w http.ResponseWriter) http.ResponseWriter {
+			return &deadlineWriter{responseWriter: responseWriter{w}, c: c2, timeout: time.Minute}
+		},
+		"maxduration": func(w http.ResponseWriter) http.ResponseWriter {
+			return &maxDurationWriter{responseWriter: responseWriter{w}, ctx: context.Background()}
+		},
+		"trace": func(w http.ResponseWriter) http.ResponseWriter {
+			return &traceWriter{responseWriter: responseWriter{w}}
+		},
+		"counting": func(w http.ResponseWriter) http.ResponseWriter {
+			return &countingResponseWriter{responseWriter: responseWriter{w}}
+		},
+	}
+	for name, wrap := range wrappers {
+		t.Run(name, func(t *testing.T) {
+			inner := hijackRecorder{httptest.NewRecorder(), c1}
+			w := wrap(inner)
+			if got := w.(interface{ Unwrap() http.ResponseWriter }).Unwrap(); got != inner {
+				t.Errorf("Unwrap = %v; want the wrapped ResponseWriter", got)
+			}
+			w.(http.Flusher).Flush()
+			if !inner.Flushed {
+				t.Error("Flush not passed through")
+			}
+			if c, _, err := w.(http.Hijacker).Hijack(); err != nil || c != c1 {
+				t.Errorf("Hijack = %v, %v; want the wrapped ResponseWriter's conn", c, err)
+			}
+
+			// Wrapping a ResponseWriter that can't be hijacked.
+			w = wrap(struct{ http.ResponseWriter }{httptest.NewRecorder()})
+			if _, _, err := w.(http.Hijacker).Hijack(); err != errHijackUnsupported {
+				t.Errorf("Hijack of non-Hijacker = %v; want %v", err, errHijackUnsupported)
+			}
+		})
+	}
+}
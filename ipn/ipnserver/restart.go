@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"sync"
+
+	"tailscale.com/ipn/ipnlocal"
+)
+
+// restartingMessage is the error served to LocalAPI requests while the
+// backend is restarting.
+const restartingMessage = "tailscaled is restarting; try again shortly"
+
+// BeginBackendRestart marks the Server's LocalBackend as restarting until
+// the returned func is called. Meanwhile, LocalAPI requests fail with 503
+// Service Unavailable and a Retry-After header rather than reaching a
+// backend that's going away, or finding none. Requests already in flight
+// are unaffected. Calls may overlap; the Server is restarting until all
+// have finished.
+//
+// ReplaceLocalBackend uses it while replacing the backend; it's also for
+// other code that takes the backend down for a while, as tests do with
+// ClearLocalBackendForTest and SetLocalBackend.
+func (s *Server) BeginBackendRestart() (done func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.restarts == 0 {
+		s.logf("backend restarting")
+	}
+	s.restarts++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.restarts--
+			if s.restarts == 0 {
+				s.logf("backend restart done")
+			}
+		})
+	}
+}
+
+// backendRestarting reports whether a BeginBackendRestart is in progress.
+func (s *Server) backendRestarting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restarts > 0
+}
+
+// shutdownBackend shuts down lb. It's a variable for tests.
+var shutdownBackend = (*ipnlocal.LocalBackend).Shutdown
+
+// ReplaceLocalBackend replaces the server's LocalBackend with lb, shutting
+// down the old one, if any, as when tailscaled restarts its backend without
+// restarting the process. lb must not share the old backend's engine, which
+// is closed with it. Until lb is in place, LocalAPI requests fail as
+// described at BeginBackendRestart.
+//
+// If Run has been called, lb is started like the first backend. It must
+// not be called concurrently with SetLocalBackend, or while Run is starting.
+func (s *Server) ReplaceLocalBackend(lb *ipnlocal.LocalBackend) {
+	if lb == nil {
+		panic("nil LocalBackend")
+	}
+	defer s.BeginBackendRestart()()
+	if old := s.lb.Swap(nil); old != nil {
+		s.logf("replacing LocalBackend")
+		shutdownBackend(old)
+	}
+	s.startBackendOnce = sync.Once{}
+	s.SetLocalBackend(lb)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/ipnlocal"
+)
+
+// serveTrustedStatus serves a status request from a trusted peer with s.
+func serveTrustedStatus(s *Server) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "/localapi/v0/status", nil)
+	r.Host = apitype.LocalAPIHost
+	r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ipnauth.TrustedConnIdentity(nil)))
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	return rec
+}
+
+func TestBackendRestart(t *testing.T) {
+	s := newTestServer(t)
+	serve := func() *httptest.ResponseRecorder { return serveTrustedStatus(s) }
+	wantRestarting := func(when string) {
+		t.Helper()
+		rec := serve()
+		if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), restartingMessage) {
+			t.Errorf("%s: %d %q; want 503 restarting", when, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Retry-After"); got == "" {
+			t.Errorf("%s: no Retry-After", when)
+		}
+	}
+
+	// Simulate replacing the backend, with an overlapping restart.
+	done := s.BeginBackendRestart()
+	wantRestarting("before clearing")
+	s.ClearLocalBackendForTest()
+	wantRestarting("without a backend")
+	done2 := s.BeginBackendRestart()
+	s.SetLocalBackend(newTestBackend(t))
+	done()
+	done() // no effect
+	wantRestarting("with one restart still in progress")
+	done2()
+
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Errorf("after restart: %d %q; want 200", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReplaceLocalBackend(t *testing.T) {
+	s := newTestServer(t)
+	old := s.mustBackend()
+	shuttingDown := make(chan bool)
+	unblock := make(chan bool)
+	oldShutdown := shutdownBackend
+	shutdownBackend = func(lb *ipnlocal.LocalBackend) {
+		if lb != old {
+			t.Errorf("shut down %p; want the old backend %p", lb, old)
+		}
+		shuttingDown <- true
+		<-unblock
+		oldShutdown(lb)
+	}
+	defer func() { shutdownBackend = oldShutdown }()
+
+	lb := newTestBackend(t)
+	replaced := make(chan bool)
+	go func() {
+		s.ReplaceLocalBackend(lb)
+		replaced <- true
+	}()
+	<-shuttingDown
+	if rec := serveTrustedStatus(s); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), restartingMessage) {
+		t.Errorf("during replacement: %d %q; want 503 restarting", rec.Code, rec.Body.String())
+	}
+	close(unblock)
+	<-replaced
+
+	if got := s.mustBackend(); got != lb {
+		t.Errorf("backend after replacement = %p; want %p", got, lb)
+	}
+	if rec := serveTrustedStatus(s); rec.Code != http.StatusOK {
+		t.Errorf("after replacement: %d %q; want 200", rec.Code, rec.Body.String())
+	}
+}
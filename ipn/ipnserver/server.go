@@ -6,24 +6,29 @@
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"os/user"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unicode"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnauth"
 	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/ipn/localapi"
+	"tailscale.com/logtail"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/opt"
 	"tailscale.com/util/mak"
 	"tailscale.com/util/systemd"
 )
@@ -31,6 +36,320 @@
 // Server is an IPN backend and its set of 0 or more active localhost
 // TCP or unix socket connections talking to that backend.
 type Server struct {
+	// RootPolicy controls how LocalAPI connections from root peers are
+	// authorized on Unix. The zero value grants root full access. It must
+	// not be changed after Run is called.
+	RootPolicy RootPolicy
+
+	// WriteTimeout, if non-zero, bounds how long any single write of a
+	// LocalAPI response may block, so a client that stops reading can't
+	// wedge its handler (and on Windows, hold the server for its user)
+	// forever. Streaming responses are exempt. New sets it to
+	// DefaultWriteTimeout.
+	WriteTimeout time.Duration
+
+	// KeepSocketOnShutdown, if true, leaves a unix socket listener's file in
+	// place when Run returns instead of unlinking it. The dead socket can
+	// then be inspected (ownership, permissions, mtime) while debugging;
+	// the downside is that clients see "connection refused" rather than
+	// "no such file" until the next start replaces it, which
+	// safesocket.Listen does automatically. It has no effect on other
+	// listener types. It must not be changed after Run is called.
+	KeepSocketOnShutdown bool
+
+	// RequirePeerCreds, if true, rejects connections on non-Windows
+	// platforms whose peer credentials (in particular, the peer's uid)
+	// couldn't be determined, failing every request on them with a 401
+	// that says so. By default such connections are accepted and are
+	// authorized later with whatever identity is known, which typically
+	// means they get no write access. Note that platforms without peer
+	// credential support (see safesocket.PlatformUsesPeerCreds) have no
+	// credentials to find, so enabling it there rejects every connection.
+	// It must not be changed after Run is called.
+	RequirePeerCreds bool
+
+	// StrictCertPeerCreds, if true, makes it a hard error rather than a
+	// silent denial when TS_PERMIT_CERT_UID is set but a unix socket peer's
+	// credentials don't include its user ID, as happens on some platforms.
+	// Cert requests on such connections then fail with a 500 saying so,
+	// rather than a 403 that looks like a misconfigured uid. Either way,
+	// the first such connection is logged. It must not be changed after
+	// Run is called.
+	StrictCertPeerCreds bool
+
+	// AllowedUsers, if non-empty, is the complete list of users permitted
+	// to connect at all, for locked-down appliances. Entries are unix uids
+	// ("998"), usernames ("caddy"), or Windows SIDs. Connections from
+	// anyone else, or whose user can't be determined, fail every request
+	// with a 401 before any LocalAPI dispatch. Admitted users are then
+	// authorized as usual. It must not be changed after Run is called.
+	AllowedUsers []string
+
+	// AllowedClientAncestors, if non-empty, limits connections on Windows
+	// to those from processes that are, or were started (directly or not)
+	// by, one of these executables, such as "tailscale-ipn.exe", matched
+	// case-insensitively by file name. It's hardening against other
+	// programs of the same user controlling tailscaled, not a security
+	// boundary, as process IDs can be reused. Trusted connections are
+	// exempt. It's ignored on other platforms. It must not be changed
+	// after Run is called.
+	AllowedClientAncestors []string
+
+	// AcceptWindows, if non-empty, are the only times of day new
+	// connections are accepted, such as to lock a shared kiosk's daemon
+	// outside business hours. Connections arriving outside all of them
+	// are refused with an "outside allowed hours" error; connections
+	// already open continue. Trusted connections are exempt. If empty,
+	// connections are accepted at any time. It must not be changed after
+	// Run is called.
+	AcceptWindows []AcceptWindow
+
+	// StatusQueryInterval and StatusQueryBurst limit how often each user
+	// may query the status endpoints, which are expensive for the
+	// backend: one query per StatusQueryInterval on average, with bursts
+	// of up to StatusQueryBurst. Queries beyond that get 429 Too Many
+	// Requests with a Retry-After header. Trusted connections, and those
+	// whose user is unknown, are exempt. Zero StatusQueryInterval
+	// disables the limit. New sets them to DefaultStatusQueryInterval and
+	// DefaultStatusQueryBurst. They must not be changed after Run is
+	// called.
+	StatusQueryInterval time.Duration
+	StatusQueryBurst    int
+
+	// UserSwitchGrace, if non-zero, is how long a request from a different
+	// user than the one with requests in flight waits for those requests
+	// to finish before being denied as "in use by another user". This
+	// matters on Windows, where only one user can use the server at a
+	// time: with fast user switching, the previous user's GUI may still
+	// be winding down its connections when the new user's GUI arrives.
+	// The zero value denies immediately. It must not be changed after Run
+	// is called.
+	UserSwitchGrace time.Duration
+
+	// UserSwitchResetTimeout, if positive, bounds how long a request
+	// waits for the backend reset that happens when a different user
+	// starts using the server. Requests still waiting after that long fail
+	// with 503 Service Unavailable, and the client may retry; the reset
+	// continues in the background. Either way, no request from the new
+	// user is served until the reset completes. If zero, requests wait
+	// for the reset however long it takes.
+	UserSwitchResetTimeout time.Duration
+
+	// MinUserSwitchResetInterval, if positive, is the minimum time
+	// between the backend resets done for changes of user, to protect
+	// against reset storms from rapid switching. A reset needed sooner is
+	// deferred until the interval has passed, and further changes of user
+	// while it's deferred share it. Requests from the new user still wait
+	// for the reset as usual, so state never leaks between users. The
+	// zero value doesn't limit resets. It must not be changed after Run
+	// is called.
+	MinUserSwitchResetInterval time.Duration
+
+	// MaxRequestsPerUser, if positive, caps how many LocalAPI requests
+	// (including long-lived ones like watch-ipn-bus) may be in flight at
+	// once from a single user, so one user can't crowd out others. Further
+	// requests from that user fail with 429 Too Many Requests. Peers whose
+	// user is unknown share one allowance. New sets it to
+	// DefaultMaxRequestsPerUser.
+	MaxRequestsPerUser int
+
+	// IdentityResolver, if non-nil, is consulted first to determine the
+	// identity of the peer on each accepted connection, for embedders
+	// whose custom net.Conn types the platform can't identify. It may
+	// construct identities with ipnauth.NewUnixConnIdentity,
+	// ipnauth.NewWindowsConnIdentity or ipnauth.TrustedConnIdentity. A nil
+	// identity and nil error means it doesn't know the connection, and
+	// the platform's usual method is used instead; a non-nil error fails
+	// the connection's requests with a 401. It must not be changed after
+	// Run is called.
+	IdentityResolver func(net.Conn) (*ipnauth.ConnIdentity, error)
+
+	// Middleware, if non-nil, wraps the LocalAPI handler of each request
+	// that has passed the Server's checks, such as to add logging or
+	// further restrictions. The request's permissions are available to it
+	// from RequestAuthFromContext, its peer and transport from
+	// PrincipalFromContext, and its ID from ipn.RequestIDFromContext,
+	// which is everything a tracing middleware (such as one emitting
+	// OpenTelemetry spans) needs to describe the request. It must not be
+	// changed after Run is called.
+	Middleware func(http.Handler) http.Handler
+
+	// AccessLog, if non-nil, is called for every LocalAPI request the
+	// Server serves or denies, and for every connection it denies when
+	// accepting it, with as much of the peer's identity as could be
+	// determined and any reason for denial. It's for auditing and
+	// intrusion detection. It's called synchronously, so it should be
+	// quick. It must not be changed after Run is called.
+	AccessLog func(AccessLogEntry)
+
+	// AuditSinks are where the Server sends audit events: accepted
+	// connections, served and denied requests, denied connections,
+	// changes of user, and CONNECT tunnels established. Each event goes to
+	// every sink. If empty, audit events aren't recorded; to log them with
+	// the Server's logf, use LogfAuditSink. It must not be changed after
+	// Run is called.
+	AuditSinks []AuditSink
+
+	// WindowsService is whether tailscaled is running as a Windows
+	// service, for when the process running the Server is a subprocess of
+	// the service and so can't tell. If unset, it's detected. It's
+	// reported in Stats and Describe, and matters for ServiceServerMode.
+	// It's ignored on other platforms. It must not be changed after Run is
+	// called.
+	WindowsService opt.Bool
+
+	// ServiceServerMode, if true, makes a Server running as a Windows
+	// service (see WindowsService) stay up with its state when the last
+	// client disconnects, as in server mode, rather than resetting as
+	// Windows otherwise does when the GUI goes away. It must not be
+	// changed after Run is called.
+	ServiceServerMode bool
+
+	// ConnCountReportInterval, if positive, is how often Run reports the
+	// number of open LocalAPI connections and their peak to the
+	// LocalBackend (see LocalBackend.SetLocalAPIConns) for its status, when
+	// they've changed. It must not be changed after Run is called.
+	ConnCountReportInterval time.Duration
+
+	// DegradeOnPanic, if true, makes the server enter a degraded state
+	// the first time it recovers from a panic in a LocalAPI handler or a
+	// call into the LocalBackend, which it reports as a health warning and
+	// in Stats. Either way, such panics are logged and fail the request
+	// with a 500. It must not be changed after Run is called.
+	DegradeOnPanic bool
+
+	// MaxRequestDuration, if positive, is the longest any LocalAPI request
+	// may run, except for streaming ones like watch-ipn-bus, dials, and file
+	// uploads and profiles. Once it passes, the request's context is
+	// canceled and, unless the handler had already started responding, the
+	// client gets a 504 Gateway Timeout. The zero value means no limit. It
+	// must not be changed after Run is called.
+	MaxRequestDuration time.Duration
+
+	// MaxConns, if positive, is the most LocalAPI connections the Server
+	// keeps open at once. Requests on connections beyond it fail with 503
+	// Service Unavailable, and the connection is closed. Open connections
+	// and their peak are reported in Stats. It must not be changed after
+	// Run is called.
+	MaxConns int
+
+	// MaxGoroutines, if positive, is the most goroutines the Server runs
+	// for LocalAPI connections at once: one serving each open connection,
+	// plus three for each CONNECT tunnel, which outlive their connection's
+	// place in MaxConns. Connections and tunnels beyond it are refused
+	// with 503 Service Unavailable. The count is exported as the
+	// ipnserver_conn_goroutines metric and in Stats. It must not be
+	// changed after Run is called.
+	MaxGoroutines int
+
+	// ActiveRequestsThreshold, if positive, is how many LocalAPI requests
+	// may be in flight at once before the Server logs a warning
+	// summarizing the oldest of them, as that many usually means requests
+	// are leaking. New sets it to DefaultActiveRequestsThreshold. It must
+	// not be changed after Run is called.
+	ActiveRequestsThreshold int
+
+	// RejectOverActiveRequestsThreshold, if true, makes the Server refuse
+	// new connections with 503 Service Unavailable while more than
+	// ActiveRequestsThreshold requests are in flight, until they drain. It
+	// must not be changed after Run is called.
+	RejectOverActiveRequestsThreshold bool
+
+	// PermissionsFile, if non-empty, is the path of a JSON file mapping
+	// users to the LocalAPI permissions they're granted (see
+	// PermissionLevel), replacing the defaults for those users, such as
+	// {"caddy": "cert", "1001": "read"}. Users are Windows SIDs, uids, or
+	// usernames. Run loads it when it starts, failing if it can't, and
+	// ReloadPermissions reloads it. It must not be changed after Run is
+	// called.
+	PermissionsFile string
+
+	// PathPrefix, if non-empty, is a path prefix such as "/ts" under which
+	// the Server serves everything it otherwise serves at the root, for
+	// use behind a reverse proxy: with it, the LocalAPI is at
+	// "/ts/localapi/" and the status page at "/ts/". Requests for paths
+	// not under the prefix get 404 Not Found. It must not be changed
+	// after Run is called.
+	PathPrefix string
+
+	// ListenerCloseGrace is how long Run keeps accepting connections after
+	// its context is done before closing its listener, so that
+	// connections already being accepted under load are served rather
+	// than aborted. The zero value closes the listener immediately.
+	ListenerCloseGrace time.Duration
+
+	// OnShutdownPhase, if non-nil, is called as Run shuts down with each
+	// ShutdownPhase in order, so supervisors can follow (and if need be,
+	// extend their grace period for) a slow shutdown. It's called on
+	// Run's goroutine and must not block for long.
+	OnShutdownPhase func(ShutdownPhase)
+
+	// SlowIdentityThreshold, if positive, is how long determining a
+	// connection's identity (on Windows, mapping its PID to a user) may
+	// take before it's logged as slow, as it delays serving the
+	// connection. Such logs are rate limited. New sets it to
+	// DefaultSlowIdentityThreshold.
+	SlowIdentityThreshold time.Duration
+
+	// MaxHeaderBytes is the http.Server.MaxHeaderBytes used by Run,
+	// bounding how much a client may send in request headers. Requests
+	// exceeding it fail with 431 Request Header Fields Too Large. If zero,
+	// net/http's default (1 MB) is used. New sets it to
+	// DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+
+	// IdleTimeouts optionally overrides, per Transport, how long an idle
+	// keep-alive connection is kept open. Transports not in the map use
+	// DefaultIdleTimeout, except for unix sockets, which use
+	// DefaultUnixIdleTimeout. It must not be changed after Run is called.
+	IdleTimeouts map[Transport]time.Duration
+
+	// MaxClientIdleTimeout, if positive, is the longest idle timeout a
+	// client may request for its own connection with
+	// apitype.IdleTimeoutHeader, overriding IdleTimeouts for that
+	// connection only. Requests asking for more fail with 400 Bad Request.
+	// If zero, the header is ignored. It must not be changed after Run is
+	// called.
+	MaxClientIdleTimeout time.Duration
+
+	// MaxBufferedResponseBytes, if positive, bounds the total memory held
+	// by LocalAPI responses that are buffered in full before being
+	// written. Requests that would exceed it fail with 503 Service
+	// Unavailable until memory is freed. New sets it to
+	// DefaultMaxBufferedResponseBytes. It must not be changed after Run
+	// is called.
+	MaxBufferedResponseBytes int64
+
+	// IdleExitTimeout, if positive, makes Run return once the server has
+	// had no LocalAPI requests in flight and no CONNECT tunnels open for
+	// that long, shutting down the backend as when Run's context is done.
+	// It's for headless deployments that start tailscaled on demand, and
+	// unlike client mode's reset of the backend when the last client
+	// disconnects, it applies in server mode too.
+	IdleExitTimeout time.Duration
+
+	// IdleExitCountsTraffic is whether data-plane traffic with peers also
+	// counts as activity postponing an IdleExitTimeout exit.
+	IdleExitCountsTraffic bool
+
+	// LogUploadStatus, if non-nil, returns the status of the uploads of
+	// the backend's logs, for the LocalAPI log-upload-status endpoint,
+	// such as logpolicy.Policy.Logtail.UploadStatus. It must not be
+	// changed after Run is called.
+	LogUploadStatus func() logtail.UploadStatus
+
+	// InvalidPrefsPolicy is what Run does if the LocalBackend's prefs are
+	// invalid, so that it can't start the backend as usual. The zero value
+	// waits for a client to start it. It must not be changed after Run is
+	// called.
+	InvalidPrefsPolicy InvalidPrefsPolicy
+
+	// InvalidPrefsGrace is how long Run waits for a client to start a
+	// backend with invalid prefs under InvalidPrefsFail. If zero,
+	// DefaultInvalidPrefsGrace is used. It must not be changed after Run
+	// is called.
+	InvalidPrefsGrace time.Duration
+
 	lb           atomic.Pointer[ipnlocal.LocalBackend]
 	logf         logger.Logf
 	backendLogID string
@@ -41,14 +360,115 @@ type Server struct {
 	// is true, the ForceDaemon pref can override this.
 	resetOnZero bool
 
+	// slowIdentityLogf is the rate-limited logf for slow identity lookups.
+	// If nil, logf is used.
+	slowIdentityLogf logger.Logf
+
 	startBackendOnce sync.Once
 	runCalled        atomic.Bool
+	running          atomic.Bool // whether a call to Run is in progress
+
+	faults faultInjector // only non-empty with ts_debug_faults build tag
+
+	allowedUsersOnce sync.Once
+	allowedUserIDs   map[string]bool // see allowedUserIDSet
+
+	extraHandlersOnce sync.Once
+	extraHandlers     map[string]localapi.HandlerFunc // see localAPIExtraHandlers
+
+	respBufsOnce sync.Once
+	respBufs     *localapi.ResponseBuffers // see responseBuffers
+
+	identityPerms atomic.Pointer[identityPerms] // from PermissionsFile; nil if none
+
+	certUIDUnknownLogged atomic.Bool // see noteCertPeerUIDUnknown
+
+	winServiceOnce sync.Once
+	winService     bool // detected; see windowsService
 
 	// mu guards the fields that follow.
 	// lock order: mu, then LocalBackend.mu
-	mu         sync.Mutex
-	lastUserID ipn.WindowsUserID // tracks last userid; on change, Reset state for paranoia
-	activeReqs map[*http.Request]*ipnauth.ConnIdentity
+	mu           sync.Mutex
+	lastUserID   ipn.WindowsUserID    // tracks last userid; on change, Reset state for paranoia
+	userSwitches []apitype.UserSwitch // recent changes of lastUserID, oldest first; see noteUserSwitchLocked
+	activeReqs   map[*http.Request]*activeRequest
+	reqsDone     chan struct{} // closed (and replaced) when a request in activeReqs finishes; see waitForOtherUsersLocked
+	shutdownT0   time.Time     // when the current Run began shutting down
+
+	connIdleTimeouts map[net.Conn]time.Duration // idle timeouts clients requested; see applyClientIdleTimeout
+
+	activeReqsWarned bool // whether activeReqs was logged as over ActiveRequestsThreshold; see noteActiveRequestsLocked
+
+	lastWatcherID int64
+	watchers      map[int64]*watcher // keyed by watcher.id
+
+	lastTunnelID int64
+	tunnels      map[int64]*proxyTunnel // keyed by proxyTunnel.id
+
+	// tunnelBytesSent and tunnelBytesRecv count the bytes through all
+	// CONNECT tunnels, including closed ones. They're not guarded by mu.
+	tunnelBytesSent, tunnelBytesRecv atomic.Int64
+
+	// degraded is why the server is degraded, if it is; see
+	// noteRecoveredPanic. It's not guarded by mu, as it's set while
+	// recovering from panics, which may happen with mu held.
+	degraded atomic.Pointer[string]
+
+	statusLimiters map[string]*statusLimiter // keyed by userid; see allowStatusQuery
+
+	settingUserDone        chan struct{} // non-nil while setCurrentUserIDLocked is retrying; closed when done
+	userSwitchResetDone    chan struct{} // non-nil while a user-switch reset is in progress; closed when done
+	userSwitchResetPending chan struct{} // done chan of a user-switch reset not yet started, if any
+	lastUserSwitchReset    time.Time     // when the last user-switch reset started
+	lastResetReason        ResetReason
+	lastResetTime          time.Time
+
+	lastAccept         time.Time  // when Run's accept loop last accepted a connection; see connState
+	lastAuthorized     time.Time  // when a request last passed our checks; see noteAuthorized
+	lastRejected       time.Time  // when a connection or request was last rejected; see noteRejected
+	lastRejectedReason DenyReason // why, as of lastRejected
+
+	openConns int                   // accepted connections not yet closed; see admitConn
+	connKinds map[net.Conn]connKind // of open connections, for metrics; see writeConnMetrics
+	peakConns int                   // the most openConns has been
+
+	tunnelGoroutines int // goroutines run by CONNECT tunnels; see acquireTunnelGoroutines
+
+	traceUserID string                  // the userid whose connections are traced, if any; see TraceUser
+	traceUntil  time.Time               // when the trace of traceUserID expires
+	tracedConns map[net.Conn]tracedConn // open connections whose close is to be traced
+
+	runStart    time.Time       // when the current Run call began
+	runCtx      context.Context // the current Run call's context
+	sockRecvBuf int             // SO_RCVBUF of Run's listener, or 0 if unknown
+	sockSendBuf int             // SO_SNDBUF of Run's listener, or 0 if unknown
+
+	listenerTransport Transport // of Run's listener, once Run is called
+	sockPath          string    // of Run's listener's socket file, if any; see recordSocketPath
+
+	unresolvedUsersWarned map[string]bool // "setting=value" usernames warned about; see warnUnresolvedUser
+
+	restarts int // BeginBackendRestart calls not yet done
+
+	maintenance    bool   // whether in maintenance mode; see SetMaintenance
+	maintenanceMsg string // message served in maintenance mode
+
+	lastActivity     time.Time // when the server was last seen in use; see idleExitDue
+	lastTrafficBytes int64     // peer bytes sent and received as of lastActivity
+
+	// timeNow, if non-nil, replaces time.Now for idle exit,
+	// AcceptWindows and pruning status limiters, for tests.
+	timeNow func() time.Time
+	// idleCheckInterval, if non-zero, replaces idleExitCheckInterval.
+	idleCheckInterval time.Duration
+
+	// testProxyDial, if non-nil, replaces the dial to the log server in
+	// handleProxyConnectConn, for tests.
+	testProxyDial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// testResetBackend, if non-nil, replaces lb.ResetForClientDisconnect in
+	// resetBackend, for tests.
+	testResetBackend func()
 }
 
 func (s *Server) mustBackend() *ipnlocal.LocalBackend {
@@ -70,12 +490,35 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r2, ok := s.stripPathPrefix(r); ok {
+		r = r2
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+
+	if s.injectFault(w, r) {
+		return
+	}
+	r = withRequestID(w, r)
+	applyConnIntent(w, r)
+
 	// TODO(bradfitz): add a status HTTP handler that returns whether there's a
 	// LocalBackend yet, optionally blocking until there is one. See
 	// https://github.com/tailscale/tailscale/issues/6522
+	if s.shuttingDown() {
+		s.denyRequest(w, r, DenyShuttingDown, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if s.backendRestarting() {
+		w.Header().Set("Retry-After", "1")
+		s.denyRequest(w, r, DenyRestarting, restartingMessage, http.StatusServiceUnavailable)
+		return
+	}
+
 	lb := s.lb.Load()
 	if lb == nil {
-		http.Error(w, "no backend", http.StatusServiceUnavailable)
+		s.denyRequest(w, r, DenyNoBackend, "no backend", http.StatusServiceUnavailable)
 		return
 	}
 
@@ -83,26 +526,105 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	switch v := r.Context().Value(connIdentityContextKey{}).(type) {
 	case *ipnauth.ConnIdentity:
 		ci = v
+		var traceDone func()
+		w, traceDone = s.traceRequest(w, r, ci)
+		defer traceDone()
 	case error:
-		http.Error(w, v.Error(), http.StatusUnauthorized)
+		// Counted and logged when the connection was denied, once for
+		// all its requests.
+		code := http.StatusUnauthorized
+		if d, ok := r.Context().Value(connDenialContextKey{}).(*connDenial); ok {
+			switch d.reason {
+			case DenyTooManyConns, DenyTooManyGoroutines, DenyTooManyActiveRequests:
+				// Free the connection for others.
+				w.Header().Set("Connection", "close")
+				code = http.StatusServiceUnavailable
+			case DenyOutsideHours:
+				code = http.StatusForbidden
+			}
+		}
+		http.Error(w, v.Error(), code)
 		return
 	case nil:
 		http.Error(w, "internal error: no connIdentityContextKey", http.StatusInternalServerError)
 		return
 	}
 
+	if msg := s.maintenanceMessage(r); msg != "" {
+		s.denyRequest(w, r, DenyMaintenance, msg, http.StatusServiceUnavailable)
+		return
+	}
+
+	if lb.Busy() && !isReadOnlyMethod(r.Method) {
+		w.Header().Set("Retry-After", "1")
+		s.denyRequest(w, r, DenyBackendBusy, "temporarily unavailable, backend busy", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Path == lockHolderPath || r.URL.Path == authInfoPath {
+		// Not an active request, so as to be servable to users that
+		// addActiveHTTPRequest would deny.
+		s.logAccess(requestPrincipal(r), r, "", "")
+		s.newLocalAPIHandler(lb, r, ci).ServeHTTP(w, r)
+		return
+	}
+
+	if !s.allowStatusQuery(r.URL.Path, ci) {
+		s.denyStatusQuery(w, r)
+		return
+	}
+
 	onDone, err := s.addActiveHTTPRequest(r, ci)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		reason, code := DenyUnauthorized, http.StatusUnauthorized
+		if e, ok := err.(inUseOtherUserError); ok {
+			s.denyRequestJSON(w, r, DenyInUseOtherUser, err.Error(), code, e.apiError())
+			return
+		} else if err == errTooManyUserRequests {
+			reason, code = DenyTooManyRequests, http.StatusTooManyRequests
+		} else if err == errUserSwitchResetInProgress {
+			reason, code = DenyResetInProgress, http.StatusServiceUnavailable
+			w.Header().Set("Retry-After", "1")
+		} else if errors.As(err, new(setUserError)) {
+			reason, code = DenySetUserFailed, http.StatusInternalServerError
+		}
+		s.denyRequest(w, r, reason, err.Error(), code)
 		return
 	}
 	defer onDone()
+	if !s.applyClientIdleTimeout(w, r) {
+		return
+	}
+	s.noteAuthorized()
+	s.logAccess(requestPrincipal(r), r, "", "")
+
+	if watcherPaths[r.URL.Path] {
+		var unregister func()
+		r, unregister = s.registerWatcher(w, r, ci)
+		defer unregister()
+	}
+
+	w = s.withWriteTimeout(w, r)
+	var maxDurationDone func()
+	w, r, maxDurationDone = s.withMaxDuration(w, r)
+	defer maxDurationDone()
 
 	if strings.HasPrefix(r.URL.Path, "/localapi/") {
-		lah := localapi.NewHandler(lb, s.logf, s.backendLogID)
-		lah.PermitRead, lah.PermitWrite = s.localAPIPermissions(ci)
-		lah.PermitCert = s.connCanFetchCerts(ci)
-		lah.ServeHTTP(w, r)
+		var sizesDone func()
+		w, r, sizesDone = withBodySizes(w, r)
+		defer sizesDone()
+		lah := s.newLocalAPIHandler(lb, r, ci)
+		r = r.WithContext(context.WithValue(r.Context(), requestAuthContextKey{}, &RequestAuth{
+			Read:     lah.PermitRead,
+			Write:    lah.PermitWrite,
+			Cert:     lah.PermitCert,
+			Identity: ci,
+		}))
+		var h http.Handler = lah
+		if s.Middleware != nil {
+			h = s.Middleware(h)
+		}
+		h.ServeHTTP(w, r)
 		return
 	}
 
@@ -122,12 +644,75 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "<html><title>Tailscale</title><body><h1>Tailscale</h1>This is the local Tailscale daemon.\n")
 }
 
+// newLocalAPIHandler returns a LocalAPI handler for r, from ci.
+func (s *Server) newLocalAPIHandler(lb *ipnlocal.LocalBackend, r *http.Request, ci *ipnauth.ConnIdentity) *localapi.Handler {
+	lah := localapi.NewHandler(lb, s.logf, s.backendLogID)
+	lah.PermitRead, lah.PermitWrite = s.localAPIPermissions(ci)
+	lah.ClientConn = clientConnStatus(r, ci, lah.PermitRead, lah.PermitWrite)
+	var certErr error
+	lah.PermitCert, certErr = s.connCanFetchCerts(ci)
+	if s.tracing(ci) {
+		s.tracef(ci, "%s %s: permitted read=%v write=%v cert=%v", r.Method, r.URL.Path, lah.PermitRead, lah.PermitWrite, lah.PermitCert)
+	}
+	if s.StrictCertPeerCreds {
+		lah.CertDenied = certErr
+	}
+	lah.ExtraHandlers = s.localAPIExtraHandlers()
+	lah.ExtraRoutes = serverRoutes
+	lah.ExtraMetrics = s.writeConnMetrics
+	s.noteConnKind(r, lah)
+	lah.ResponseBuffers = s.responseBuffers()
+	return lah
+}
+
+// clientConnStatus describes the connection of r, whose peer's identity
+// is ci and which may read and write as given, to its client.
+func clientConnStatus(r *http.Request, ci *ipnauth.ConnIdentity, read, write bool) *ipnstate.ClientConnStatus {
+	p := PrincipalFromContext(r.Context())
+	if p == nil {
+		p = newPrincipal(nil, ci)
+	}
+	return &ipnstate.ClientConnStatus{
+		Transport: string(p.Transport),
+		UserID:    p.UserID,
+		Username:  p.Username,
+		CanRead:   read,
+		CanWrite:  write,
+	}
+}
+
+// isReadOnlyMethod reports whether requests with HTTP method m only read
+// state, and so may be served while the backend is busy.
+func isReadOnlyMethod(m string) bool {
+	return m == "GET" || m == "HEAD"
+}
+
+// activeRequest is an in-flight LocalAPI HTTP request.
+type activeRequest struct {
+	ci    *ipnauth.ConnIdentity
+	start time.Time
+}
+
 // inUseOtherUserError is the error type for when the server is in use
 // by a different local user.
-type inUseOtherUserError struct{ error }
+type inUseOtherUserError struct {
+	error
+	otherUser string // the other user's username, if known
+	otherPID  int    // the other user's client's PID, if known
+}
 
 func (e inUseOtherUserError) Unwrap() error { return e.error }
 
+// apiError returns e as sent to LocalAPI clients.
+func (e inUseOtherUserError) apiError() apitype.InUseOtherUserError {
+	return apitype.InUseOtherUserError{
+		Code:      apitype.InUseOtherUserCode,
+		Error:     e.Error(),
+		OtherUser: e.otherUser,
+		OtherPID:  e.otherPID,
+	}
+}
+
 // checkConnIdentityLocked checks whether the provided identity is
 // allowed to connect to the server.
 //
@@ -139,24 +724,108 @@ func (s *Server) checkConnIdentityLocked(ci *ipnauth.ConnIdentity) error {
 	// This mostly matters on Windows at the moment.
 	if len(s.activeReqs) > 0 {
 		var active *ipnauth.ConnIdentity
-		for _, active = range s.activeReqs {
+		for _, ar := range s.activeReqs {
+			active = ar.ci
 			break
 		}
 		if active != nil && ci.WindowsUserID() != active.WindowsUserID() {
-			return inUseOtherUserError{fmt.Errorf("Tailscale already in use by %s, pid %d", active.User().Username, active.Pid())}
+			var username string
+			if u := active.User(); u != nil {
+				username = u.Username
+			}
+			return inUseOtherUserError{
+				error:     fmt.Errorf("Tailscale already in use by %s, pid %d", username, active.Pid()),
+				otherUser: username,
+				otherPID:  active.Pid(),
+			}
 		}
 	}
 	if err := s.mustBackend().CheckIPNConnectionAllowed(ci); err != nil {
-		return inUseOtherUserError{err}
+		return inUseOtherUserError{error: err}
 	}
 	return nil
 }
 
+// DefaultMaxRequestsPerUser is the default value of
+// Server.MaxRequestsPerUser. It's well above what any well-behaved client
+// (a CLI, a GUI with a few watchers) uses.
+const DefaultMaxRequestsPerUser = 64
+
+// errTooManyUserRequests is returned by addActiveHTTPRequest when the
+// caller's user has Server.MaxRequestsPerUser requests in flight.
+var errTooManyUserRequests = errors.New("too many concurrent LocalAPI requests from this user")
+
+// userRequestsLocked returns the number of requests in flight from the same
+// user as ci.
+//
+// s.mu must be held.
+func (s *Server) userRequestsLocked(ci *ipnauth.ConnIdentity) int {
+	uid := connUserID(ci)
+	n := 0
+	for _, ar := range s.activeReqs {
+		if connUserID(ar.ci) == uid {
+			n++
+		}
+	}
+	return n
+}
+
+// otherUserActiveLocked reports whether any request in flight is from a
+// different user than ci.
+//
+// s.mu must be held.
+func (s *Server) otherUserActiveLocked(ci *ipnauth.ConnIdentity) bool {
+	for _, ar := range s.activeReqs {
+		if ar.ci.WindowsUserID() != ci.WindowsUserID() {
+			return true
+		}
+	}
+	return false
+}
+
+// waitForOtherUsersLocked waits up to d for requests in flight from users
+// other than ci to finish, or until ctx is done. It reports whether they
+// finished.
+//
+// s.mu must be held; it's released while waiting.
+func (s *Server) waitForOtherUsersLocked(ctx context.Context, ci *ipnauth.ConnIdentity, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	for s.otherUserActiveLocked(ci) {
+		if s.reqsDone == nil {
+			s.reqsDone = make(chan struct{})
+		}
+		done := s.reqsDone
+		s.mu.Unlock()
+		select {
+		case <-done:
+			s.mu.Lock()
+		case <-t.C:
+			s.mu.Lock()
+			return !s.otherUserActiveLocked(ci)
+		case <-ctx.Done():
+			s.mu.Lock()
+			return !s.otherUserActiveLocked(ci)
+		}
+	}
+	return true
+}
+
 // localAPIPermissions returns the permissions for the given identity accessing
-// the Tailscale local daemon API.
+// the Tailscale local daemon API: the defaults, unless the PermissionsFile
+// configures others for its user.
 //
 // s.mu must not be held.
 func (s *Server) localAPIPermissions(ci *ipnauth.ConnIdentity) (read, write bool) {
+	return s.applyConfiguredPermission(ci, s.defaultLocalAPIPermissions(ci))
+}
+
+// defaultLocalAPIPermissions returns the permissions of ci absent any
+// configured in s.PermissionsFile.
+func (s *Server) defaultLocalAPIPermissions(ci *ipnauth.ConnIdentity) (read, write bool) {
+	if ci.IsTrusted() {
+		return true, true
+	}
 	switch envknob.GOOS() {
 	case "windows":
 		s.mu.Lock()
@@ -169,23 +838,29 @@ func (s *Server) localAPIPermissions(ci *ipnauth.ConnIdentity) (read, write bool
 		return true, true
 	}
 	if ci.IsUnixSock() {
-		return true, !ci.IsReadonlyConn(s.mustBackend().OperatorUserID(), logger.Discard)
+		operatorUID := s.mustBackend().OperatorUserID()
+		read, write := true, !ci.IsReadonlyConn(operatorUID, logger.Discard)
+		return s.applyRootPolicy(connUserID(ci), operatorUID, read, write)
 	}
 	return false, false
 }
 
 // userIDFromString maps from either a numeric user id in string form
 // ("998") or username ("caddy") to its string userid ("998").
-// It returns the empty string on error.
-func userIDFromString(v string) string {
+// Usernames that can't be looked up fail, wrapping
+// errUsernameLookupUnreliable in builds that can't see every user.
+func userIDFromString(v string) (string, error) {
 	if v == "" || isAllDigit(v) {
-		return v
+		return v, nil
 	}
-	u, err := user.Lookup(v)
+	u, err := lookupUser(v)
 	if err != nil {
-		return ""
+		if !usernameLookupReliable {
+			return "", fmt.Errorf("%v: %w", err, errUsernameLookupUnreliable)
+		}
+		return "", err
 	}
-	return u.Uid
+	return u.Uid, nil
 }
 
 func isAllDigit(s string) bool {
@@ -203,18 +878,37 @@ func isAllDigit(s string) bool {
 // That is, this reports whether ci should grant additional
 // capabilities over what the conn would otherwise be able to do.
 //
-// For now this only returns true on Unix machines when
-// TS_PERMIT_CERT_UID is set the to the userid of the peer
+// It returns true for users granted "cert" or "write" in the
+// PermissionsFile. Otherwise, for now this only returns true on Unix
+// machines when TS_PERMIT_CERT_UID is set the to the userid of the peer
 // connection. It's intended to give your non-root webserver access
 // (www-data, caddy, nginx, etc) to certs.
-func (s *Server) connCanFetchCerts(ci *ipnauth.ConnIdentity) bool {
+//
+// If TS_PERMIT_CERT_UID is set but ci's peer credentials lack a user ID,
+// it returns false with errCertPeerUIDUnknown.
+func (s *Server) connCanFetchCerts(ci *ipnauth.ConnIdentity) (bool, error) {
+	if level, ok := s.configuredPermission(ci); ok {
+		return level == PermissionCert || level == PermissionWrite, nil
+	}
 	if ci.IsUnixSock() && ci.Creds() != nil {
-		connUID, ok := ci.Creds().UserID()
-		if ok && connUID == userIDFromString(envknob.String("TS_PERMIT_CERT_UID")) {
-			return true
+		permitUID := envknob.String("TS_PERMIT_CERT_UID")
+		connUID, ok := peerCredsUserID(ci.Creds())
+		if !ok {
+			if permitUID == "" {
+				return false, nil
+			}
+			return false, s.noteCertPeerUIDUnknown(ci)
+		}
+		permitID, err := userIDFromString(permitUID)
+		if err != nil {
+			s.warnUnresolvedUser("TS_PERMIT_CERT_UID", permitUID, err)
+			return false, nil
+		}
+		if connUID == permitID {
+			return true, nil
 		}
 	}
-	return false
+	return false, nil
 }
 
 // addActiveHTTPRequest adds c to the server's list of active HTTP requests.
@@ -229,48 +923,86 @@ func (s *Server) addActiveHTTPRequest(req *http.Request, ci *ipnauth.ConnIdentit
 
 	lb := s.mustBackend()
 
-	// If the connected user changes, reset the backend server state to make
-	// sure node keys don't leak between users.
-	var doReset bool
+	// Don't grant access while a reset for a change of user is still in
+	// progress, so the new user never sees the previous user's state.
+	var resetDone chan struct{}
+	var userSwitch *AuditEvent // audited once s.mu is released
 	defer func() {
-		if doReset {
-			s.logf("identity changed; resetting server")
-			lb.ResetForClientDisconnect()
+		if userSwitch != nil {
+			s.audit(*userSwitch)
+		}
+		if err != nil || resetDone == nil {
+			return
+		}
+		if werr := s.waitForUserSwitchReset(req.Context(), resetDone); werr != nil {
+			onDone()
+			onDone, err = nil, werr
 		}
 	}()
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.UserSwitchGrace > 0 && s.otherUserActiveLocked(ci) {
+		s.logf("waiting up to %v for another user's requests to finish", s.UserSwitchGrace)
+		s.waitForOtherUsersLocked(req.Context(), ci, s.UserSwitchGrace)
+	}
+	if err := s.waitForSetUserLocked(req.Context()); err != nil {
+		return nil, err
+	}
 	if err := s.checkConnIdentityLocked(ci); err != nil {
 		return nil, err
 	}
+	if limit := s.MaxRequestsPerUser; limit > 0 && s.userRequestsLocked(ci) >= limit {
+		return nil, errTooManyUserRequests
+	}
 
-	mak.Set(&s.activeReqs, req, ci)
+	mak.Set(&s.activeReqs, req, &activeRequest{ci: ci, start: time.Now()})
+	s.noteActiveRequestsLocked()
 
 	if uid := ci.WindowsUserID(); uid != "" && len(s.activeReqs) == 1 {
 		// Tell the LocalBackend about the identity we're now running as.
-		lb.SetCurrentUserID(uid)
+		if err := s.setCurrentUserIDLocked(lb, ci); err != nil {
+			delete(s.activeReqs, req)
+			return nil, err
+		}
 		if s.lastUserID != uid {
+			s.noteUserSwitchLocked(s.lastUserID, uid, s.lastUserID != "")
+			userSwitch = &AuditEvent{
+				Kind:       AuditUserSwitch,
+				Principal:  Principal{UserID: string(uid)},
+				PrevUserID: string(s.lastUserID),
+			}
 			if s.lastUserID != "" {
-				doReset = true
+				// If the connected user changes, reset the backend
+				// server state to make sure node keys don't leak
+				// between users.
+				s.logf("identity changed; resetting server")
+				s.startUserSwitchResetLocked(lb)
 			}
 			s.lastUserID = uid
 		}
 	}
+	resetDone = s.userSwitchResetDone
 
 	onDone = func() {
 		s.mu.Lock()
 		delete(s.activeReqs, req)
+		s.noteActiveRequestsLocked()
+		s.noteActivityLocked()
 		remain := len(s.activeReqs)
+		if s.reqsDone != nil {
+			close(s.reqsDone)
+			s.reqsDone = nil
+		}
 		s.mu.Unlock()
 
 		if remain == 0 && s.resetOnZero {
-			if lb.InServerMode() {
-				s.logf("client disconnected; staying alive in server mode")
+			if m := s.lifecycleMode(lb); m.Mode == LifecycleServerPersistent {
+				s.logf("client disconnected; staying alive: %s", m.Reason)
 			} else {
 				s.logf("client disconnected; stopping server")
-				lb.ResetForClientDisconnect()
+				s.resetBackend(lb, ResetLastClientDisconnected)
 			}
 		}
 	}
@@ -278,6 +1010,102 @@ func (s *Server) addActiveHTTPRequest(req *http.Request, ci *ipnauth.ConnIdentit
 	return onDone, nil
 }
 
+// errUserSwitchResetInProgress is returned by addActiveHTTPRequest when
+// the backend reset for a change of user didn't finish within
+// Server.UserSwitchResetTimeout.
+var errUserSwitchResetInProgress = errors.New("tailscaled is still resetting after a change of user; try again shortly")
+
+// startUserSwitchResetLocked starts resetting lb's state in the background
+// for a change of user, setting s.userSwitchResetDone until it's done. If
+// a reset hasn't started yet (see Server.MinUserSwitchResetInterval), it
+// covers this change of user too, so no new one is started.
+//
+// s.mu must be held.
+func (s *Server) startUserSwitchResetLocked(lb *ipnlocal.LocalBackend) {
+	if s.userSwitchResetPending != nil {
+		metricResetUserChangedCoalesced.Add(1)
+		s.logf("user-switch reset already pending; not starting another")
+		return
+	}
+	prev := s.userSwitchResetDone
+	done := make(chan struct{})
+	s.userSwitchResetDone = done
+	s.userSwitchResetPending = done
+	timeout := s.UserSwitchResetTimeout
+	minInterval := s.MinUserSwitchResetInterval
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev // resets run in order
+		}
+		s.mu.Lock()
+		var wait time.Duration
+		if minInterval > 0 && !s.lastUserSwitchReset.IsZero() {
+			wait = minInterval - time.Since(s.lastUserSwitchReset)
+		}
+		s.mu.Unlock()
+		if wait > 0 {
+			s.logf("[unexpected] users switching rapidly; deferring user-switch reset by %v", wait.Round(time.Millisecond))
+			time.Sleep(wait)
+		}
+		s.mu.Lock()
+		if s.userSwitchResetPending == done {
+			s.userSwitchResetPending = nil
+		}
+		s.lastUserSwitchReset = time.Now()
+		s.mu.Unlock()
+
+		t0 := time.Now()
+		s.resetBackend(lb, ResetUserChanged)
+		if d := time.Since(t0); timeout > 0 && d > timeout {
+			s.logf("user-switch reset took %v, longer than the %v timeout", d.Round(time.Millisecond), timeout)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.userSwitchResetDone == done {
+			s.userSwitchResetDone = nil
+		}
+	}()
+}
+
+// waitForUserSwitchReset waits for the user-switch reset signaled by done to
+// finish, giving up after s.UserSwitchResetTimeout if positive.
+func (s *Server) waitForUserSwitchReset(ctx context.Context, done <-chan struct{}) error {
+	var timeout <-chan time.Time
+	if d := s.UserSwitchResetTimeout; d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		s.logf("user-switch reset still in progress after %v; refusing request", s.UserSwitchResetTimeout)
+		return errUserSwitchResetInProgress
+	}
+}
+
+// DefaultMaxHeaderBytes is the default value of Server.MaxHeaderBytes.
+// LocalAPI requests carry few headers; 64 KB leaves plenty of room.
+const DefaultMaxHeaderBytes = 64 << 10
+
+// DefaultMaxBufferedResponseBytes is the default value of
+// Server.MaxBufferedResponseBytes. It's well above the size of a status
+// response for even large tailnets.
+const DefaultMaxBufferedResponseBytes = 64 << 20
+
+// responseBuffers returns the accounting of buffered LocalAPI response
+// memory shared by all of s's LocalAPI handlers.
+func (s *Server) responseBuffers() *localapi.ResponseBuffers {
+	s.respBufsOnce.Do(func() {
+		s.respBufs = &localapi.ResponseBuffers{Max: s.MaxBufferedResponseBytes}
+	})
+	return s.respBufs
+}
+
 // New returns a new Server.
 //
 // To start it, use the Server.Run method.
@@ -286,9 +1114,18 @@ func (s *Server) addActiveHTTPRequest(req *http.Request, ci *ipnauth.ConnIdentit
 // method must also be called before Server can do anything useful.
 func New(logf logger.Logf, logid string) *Server {
 	return &Server{
-		backendLogID: logid,
-		logf:         logf,
-		resetOnZero:  envknob.GOOS() == "windows",
+		backendLogID:             logid,
+		logf:                     logf,
+		slowIdentityLogf:         logger.RateLimitedFn(logf, time.Minute, 3, 1),
+		resetOnZero:              envknob.GOOS() == "windows",
+		WriteTimeout:             DefaultWriteTimeout,
+		MaxRequestsPerUser:       DefaultMaxRequestsPerUser,
+		SlowIdentityThreshold:    DefaultSlowIdentityThreshold,
+		MaxHeaderBytes:           DefaultMaxHeaderBytes,
+		MaxBufferedResponseBytes: DefaultMaxBufferedResponseBytes,
+		ActiveRequestsThreshold:  DefaultActiveRequestsThreshold,
+		StatusQueryInterval:      DefaultStatusQueryInterval,
+		StatusQueryBurst:         DefaultStatusQueryBurst,
 	}
 }
 
@@ -296,6 +1133,8 @@ func New(logf logger.Logf, logid string) *Server {
 //
 // If b.Run has already been called, then lb.Start will be called.
 // Otherwise Start will be called once Run is called.
+//
+// It panics if a backend is already set; see ReplaceLocalBackend.
 func (s *Server) SetLocalBackend(lb *ipnlocal.LocalBackend) {
 	if lb == nil {
 		panic("nil LocalBackend")
@@ -303,11 +1142,28 @@ func (s *Server) SetLocalBackend(lb *ipnlocal.LocalBackend) {
 	if !s.lb.CompareAndSwap(nil, lb) {
 		panic("already set")
 	}
+	s.reportListenerTransport()
 	s.startBackendIfNeeded()
 	// TODO(bradfitz): send status update to GUI long poller waiter. See
 	// https://github.com/tailscale/tailscale/issues/6522
 }
 
+// ClearLocalBackendForTest removes s's LocalBackend, so that
+// SetLocalBackend may be called again, for test harnesses that reuse a
+// Server across cases. The next backend set is started like the first if
+// Run has been called. It panics if Run is in progress.
+//
+// It's only for tests: outside them, a second SetLocalBackend panics, and
+// ReplaceLocalBackend replaces the backend instead. See BeginBackendRestart
+// for answering requests meanwhile.
+func (s *Server) ClearLocalBackendForTest() {
+	if s.running.Load() {
+		panic("ClearLocalBackendForTest called while Run is in progress")
+	}
+	s.lb.Store(nil)
+	s.startBackendOnce = sync.Once{}
+}
+
 func (b *Server) startBackendIfNeeded() {
 	if !b.runCalled.Load() {
 		return
@@ -316,10 +1172,24 @@ func (b *Server) startBackendIfNeeded() {
 	if lb == nil {
 		return
 	}
-	if lb.Prefs().Valid() {
+	if backendPrefsValid(lb) {
 		b.startBackendOnce.Do(func() {
-			lb.Start(ipn.Options{})
+			if err := startBackend(lb, ipn.Options{}); err != nil {
+				b.logf("starting backend: %v", err)
+			}
 		})
+		return
+	}
+	switch b.InvalidPrefsPolicy {
+	case InvalidPrefsStartLoginOnly:
+		b.startBackendOnce.Do(func() {
+			b.logf("backend prefs invalid; starting for login only")
+			if err := startBackend(lb, ipn.Options{UpdatePrefs: loginOnlyPrefs()}); err != nil {
+				b.logf("starting backend: %v", err)
+			}
+		})
+	default:
+		b.logf("backend prefs invalid; waiting for a client to start it")
 	}
 }
 
@@ -327,29 +1197,190 @@ func (b *Server) startBackendIfNeeded() {
 // *ipnauth.ConnIdentity or an error.
 type connIdentityContextKey struct{}
 
+// errNoPeerCreds is the connection identity error for connections without
+// peer credentials when Server.RequirePeerCreds is set.
+var errNoPeerCreds = errors.New("connection rejected: peer credentials unavailable and server requires them")
+
+// connContextKey is the http.Request.Context's context.Value key for the
+// request's underlying net.Conn.
+type connContextKey struct{}
+
+// connContext is the http.Server.ConnContext hook. It records c and its
+// identity (or the error determining it) in the connection's context.
+func (s *Server) connContext(ctx context.Context, c net.Conn) context.Context {
+	if ic, ok := c.(*idleConn); ok {
+		c = ic.Conn
+	}
+	ctx = context.WithValue(ctx, connContextKey{}, c)
+	if !s.admitConn(c) {
+		return s.denyConn(ctx, c, nil, DenyTooManyConns, errTooManyConns)
+	}
+	if !s.admitConnGoroutine() {
+		return s.denyConn(ctx, c, nil, DenyTooManyGoroutines, errTooManyGoroutines)
+	}
+	if !s.admitActiveRequests() {
+		return s.denyConn(ctx, c, nil, DenyTooManyActiveRequests, errTooManyActiveRequests)
+	}
+	t0 := time.Now()
+	ci, err := s.resolveConnIdentity(c)
+	identityLatency := time.Since(t0)
+	s.noteIdentityLatency(c, identityLatency)
+	if err != nil {
+		return s.denyConn(ctx, c, ci, DenyIdentityError, err)
+	}
+	s.traceConnAccepted(c, ci, identityLatency)
+	if s.RequirePeerCreds && !ci.IsTrusted() && envknob.GOOS() != "windows" && connUserID(ci) == "" {
+		return s.denyConn(ctx, c, ci, DenyUnauthorized, errNoPeerCreds)
+	}
+	if err := s.checkAllowedUser(ci); err != nil {
+		return s.denyConn(ctx, c, ci, DenyUnauthorized, err)
+	}
+	if err := s.checkClientLineage(ci); err != nil {
+		return s.denyConn(ctx, c, ci, DenyUnauthorized, err)
+	}
+	if err := s.checkAcceptWindows(ci); err != nil {
+		return s.denyConn(ctx, c, ci, DenyOutsideHours, err)
+	}
+	p := newPrincipal(c, ci)
+	s.audit(AuditEvent{Kind: AuditConnection, Principal: *p})
+	ctx = context.WithValue(ctx, principalContextKey{}, p)
+	return context.WithValue(ctx, connIdentityContextKey{}, ci)
+}
+
+// denyConn returns ctx updated to deny requests on c, whose peer's
+// possibly incomplete identity is ci, with err. It counts the denial and
+// records it in the access log and as an audit event.
+func (s *Server) denyConn(ctx context.Context, c net.Conn, ci *ipnauth.ConnIdentity, reason DenyReason, err error) context.Context {
+	rejectedMetric[reason].Add(1)
+	s.noteRejected(reason)
+	d := &connDenial{reason: reason, principal: newPrincipal(c, ci)}
+	s.logAccess(d.principal, nil, reason, err.Error())
+	ctx = context.WithValue(ctx, connDenialContextKey{}, d)
+	return context.WithValue(ctx, connIdentityContextKey{}, err)
+}
+
+// resolveConnIdentity returns the identity of the peer on c: as given for
+// connections from a TrustedListener, else from s.IdentityResolver if it
+// knows it, else trusted for in-memory connections, else as determined by
+// the platform.
+func (s *Server) resolveConnIdentity(c net.Conn) (*ipnauth.ConnIdentity, error) {
+	if tc, ok := c.(*trustedConn); ok {
+		return tc.ci, nil
+	}
+	if s.IdentityResolver != nil {
+		ci, err := s.IdentityResolver(c)
+		if err != nil || ci != nil {
+			return ci, err
+		}
+	}
+	if _, ok := c.(memConn); ok {
+		return ipnauth.TrustedConnIdentity(c), nil
+	}
+	return ipnauth.GetConnIdentity(s.logf, c)
+}
+
+// ErrAlreadyRunning is returned by Server.Run if another call to Run on the
+// same Server is already in progress.
+var ErrAlreadyRunning = errors.New("ipnserver: Run already in progress")
+
 // Run runs the server, accepting connections from ln forever.
 //
-// If the context is done, the listener is closed. It is also the base context
-// of all HTTP requests.
+// Only one call to Run may be in progress at a time; others fail with
+// ErrAlreadyRunning. Run may be called again once a previous call has
+// returned.
+//
+// If the context is done, the listener is closed, after ListenerCloseGrace
+// if set. The context is also the base context of all HTTP requests.
+//
+// If IdleExitTimeout is set, Run also returns, with a nil error, once the
+// server has been idle that long.
 //
 // If the Server's LocalBackend has already been set, Run starts it.
 // Otherwise, the next call to SetLocalBackend will start it.
 func (s *Server) Run(ctx context.Context, ln net.Listener) error {
+	if !s.running.CompareAndSwap(false, true) {
+		return ErrAlreadyRunning
+	}
+	defer s.running.Store(false)
 	s.runCalled.Store(true)
+	s.mu.Lock()
+	s.runStart = time.Now()
+	s.runCtx = ctx
+	s.shutdownT0 = time.Time{}
+	s.noteActivityLocked()
+	s.mu.Unlock()
 	defer func() {
+		s.shutdownPhase(ShutdownBackend)
 		if lb := s.lb.Load(); lb != nil {
 			lb.Shutdown()
 		}
+		s.shutdownPhase(ShutdownDone)
+	}()
+	defer func() {
+		if p := recover(); p != nil {
+			s.dumpActiveReqs()
+			panic(p)
+		}
 	}()
 
+	if ul, ok := ln.(*net.UnixListener); ok && s.KeepSocketOnShutdown {
+		ul.SetUnlinkOnClose(false)
+	}
+	s.recordSocketPath(ln)
+	s.logf("%s", s.Describe())
+	s.recordBufferSizes(ln)
+	s.setListenerTransport(listenerTransport(ln))
+	s.traceUserFromEnv()
+	if err := s.ReloadPermissions(); err != nil {
+		ln.Close()
+		return fmt.Errorf("loading LocalAPI permissions: %w", err)
+	}
+	ln = idleListener{newRetryListener(ln, s.logf)}
+
 	runDone := make(chan struct{})
 	defer close(runDone)
 
-	// When the context is closed or when we return, whichever is first, close our listener
-	// and all open connections.
+	if s.ConnCountReportInterval > 0 {
+		go s.reportConnCounts(runDone)
+	}
+
+	invalidPrefs := make(chan struct{})
+	if s.InvalidPrefsPolicy == InvalidPrefsFail {
+		go func() {
+			if s.waitInvalidPrefs(runDone) {
+				s.logf("backend prefs invalid and not started after %v; exiting", s.invalidPrefsGrace())
+				close(invalidPrefs)
+			}
+		}()
+	}
+
+	idleExit := make(chan struct{})
+	if s.IdleExitTimeout > 0 {
+		go func() {
+			if s.waitIdleExit(runDone) {
+				s.logf("idle for %v; exiting", s.IdleExitTimeout)
+				close(idleExit)
+			}
+		}()
+	}
+
+	// When the context is closed, when we've been idle for IdleExitTimeout,
+	// when the backend couldn't be started (see InvalidPrefsFail), or when
+	// we return, whichever is first, close our listener and all open
+	// connections.
 	go func() {
 		select {
 		case <-ctx.Done():
+			if d := s.ListenerCloseGrace; d > 0 {
+				t := time.NewTimer(d)
+				select {
+				case <-t.C:
+				case <-runDone:
+					t.Stop()
+				}
+			}
+		case <-idleExit:
+		case <-invalidPrefs:
 		case <-runDone:
 		}
 		ln.Close()
@@ -359,26 +1390,27 @@ func (s *Server) Run(ctx context.Context, ln net.Listener) error {
 	systemd.Ready()
 
 	hs := &http.Server{
-		Handler:     http.HandlerFunc(s.serveHTTP),
+		Handler:     s.recoverPanics(http.HandlerFunc(s.serveHTTP)),
 		BaseContext: func(_ net.Listener) context.Context { return ctx },
-		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
-			ci, err := ipnauth.GetConnIdentity(s.logf, c)
-			if err != nil {
-				return context.WithValue(ctx, connIdentityContextKey{}, err)
-			}
-			return context.WithValue(ctx, connIdentityContextKey{}, ci)
-		},
-		// Localhost connections are cheap; so only do
-		// keep-alives for a short period of time, as these
-		// active connections lock the server into only serving
-		// that user. If the user has this page open, we don't
-		// want another switching user to be locked out for
-		// minutes. 5 seconds is enough to let browser hit
-		// favicon.ico and such.
-		IdleTimeout: 5 * time.Second,
-		ErrorLog:    logger.StdLogger(logger.WithPrefix(s.logf, "ipnserver: ")),
-	}
-	if err := hs.Serve(ln); err != nil {
+		ConnContext: s.connContext,
+		// Idle keep-alive connections are closed after a
+		// per-connection timeout instead; see connState.
+		ConnState:      s.connState,
+		MaxHeaderBytes: s.MaxHeaderBytes,
+		ErrorLog:       logger.StdLogger(logger.WithPrefix(s.logf, "ipnserver: ")),
+	}
+	err := hs.Serve(ln)
+	s.shutdownPhase(ShutdownDraining)
+	if !s.waitForRequestsDrained(shutdownDrainTimeout) {
+		s.logf("shutdown: proceeding with requests still in flight")
+		s.dumpActiveReqs()
+	}
+	select {
+	case <-invalidPrefs:
+		return s.invalidPrefsError()
+	default:
+	}
+	if err != nil {
 		if err := ctx.Err(); err != nil {
 			return err
 		}
@@ -389,6 +1421,11 @@ func (s *Server) Run(ctx context.Context, ln net.Listener) error {
 // ServeHTMLStatus serves an HTML status page at http://localhost:41112/ for
 // Windows and via $DEBUG_LISTENER/debug/ipn when tailscaled's --debug flag
 // is used to run a debug server.
+//
+// Clients whose Accept header prefers application/json over text/html get
+// the page's data, an ipnstate.StatusPage, as JSON instead. When served on
+// the LocalAPI listener, the page includes the viewer's own connection and
+// access.
 func (s *Server) ServeHTMLStatus(w http.ResponseWriter, r *http.Request) {
 	lb := s.lb.Load()
 	if lb == nil {
@@ -406,8 +1443,75 @@ func (s *Server) ServeHTMLStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Security-Policy", `default-src 'none'; frame-ancestors 'none'; script-src 'none'; script-src-elem 'none'; script-src-attr 'none'`)
 	w.Header().Set("X-Frame-Options", "DENY")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	st := lb.Status()
+	w.Header().Set("Vary", "Accept")
+	st, err := s.status(lb)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	// TODO(bradfitz): add LogID and opts to st?
-	st.WriteHTML(w)
+	if ci, ok := r.Context().Value(connIdentityContextKey{}).(*ipnauth.ConnIdentity); ok {
+		// Served on the LocalAPI listener (as on Windows), so show the
+		// viewer what they may do.
+		read, write := s.localAPIPermissions(ci)
+		st.ClientConn = clientConnStatus(r, ci, read, write)
+	}
+	page := st.Page()
+	if prefersJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		e.Encode(page)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page.WriteHTML(w)
+}
+
+// prefersJSON reports whether r's Accept header ranks application/json
+// strictly above text/html. Requests without an Accept header, and browsers
+// (which list text/html first or fall back to */*), get HTML.
+func prefersJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	return acceptQuality(accept, "application/json") > acceptQuality(accept, "text/html")
+}
+
+// acceptQuality returns the quality value ("q") that the Accept header value
+// accept assigns to mediaType, using the most specific matching media range
+// per RFC 9110, section 12.5.1. It returns 0 if no range matches.
+func acceptQuality(accept, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, rng := range strings.Split(accept, ",") {
+		rng, params, _ := strings.Cut(rng, ";")
+		rng = strings.ToLower(strings.TrimSpace(rng))
+		var spec int
+		switch rng {
+		case mediaType:
+			spec = 2
+		case typ + "/*":
+			spec = 1
+		case "*/*":
+			spec = 0
+		default:
+			continue
+		}
+		if spec < specificity {
+			continue
+		}
+		rq := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					rq = f
+				}
+			}
+		}
+		q, specificity = rq, spec
+	}
+	return q
 }
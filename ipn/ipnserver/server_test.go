@@ -0,0 +1,815 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/types/key"
+	"tailscale.com/wgengine"
+)
+
+// newTestServer returns a Server with a LocalBackend backed by a fake
+// userspace engine. The backend is not started.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s := New(tstest.WhileTestRunningLogger(t), "logid")
+	s.SetLocalBackend(newTestBackend(t))
+	return s
+}
+
+// newTestBackend returns a LocalBackend on a fake engine, shut down when t
+// ends.
+func newTestBackend(t *testing.T) *ipnlocal.LocalBackend {
+	t.Helper()
+	logf := tstest.WhileTestRunningLogger(t)
+	eng, err := wgengine.NewFakeUserspaceEngine(logf, 0)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	t.Cleanup(eng.Close)
+	lb, err := ipnlocal.NewLocalBackend(logf, "logid", new(mem.Store), "", nil, eng, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(lb.Shutdown)
+	return lb
+}
+
+func TestServeHTMLStatusViewer(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	s := newTestServer(t)
+	serve := func(ctx context.Context) string {
+		req := httptest.NewRequest("GET", "http://localhost:41112/", nil).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		s.ServeHTMLStatus(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d; want 200", rec.Code)
+		}
+		return rec.Body.String()
+	}
+
+	// Via the debug server, there's no connection identity to show.
+	if body := serve(context.Background()); strings.Contains(body, "Viewing as") {
+		t.Errorf("without identity, body has viewer section: %s", body)
+	}
+
+	ci := ipnauth.NewWindowsConnIdentity(nil, 1, "S-1-5-21-alice", nil)
+	ctx := context.WithValue(context.Background(), connIdentityContextKey{}, ci)
+	ctx = context.WithValue(ctx, principalContextKey{}, &Principal{
+		UserID:    "S-1-5-21-alice",
+		Username:  `CORP\<alice>`,
+		PID:       42,
+		Transport: TransportTCP,
+	})
+	body := serve(ctx)
+	if want := `Viewing as: CORP\&lt;alice&gt; via tcp; access: read, write`; !strings.Contains(body, want) {
+		t.Errorf("body lacks %q: %s", want, body)
+	}
+	if strings.Contains(body, "<alice>") {
+		t.Errorf("username not escaped: %s", body)
+	}
+}
+
+func TestServeHTMLStatusAccept(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		name     string
+		accept   string
+		wantType string
+	}{
+		{"none", "", "text/html; charset=utf-8"},
+		{"html", "text/html", "text/html; charset=utf-8"},
+		{"json", "application/json", "application/json"},
+		{"browser", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "text/html; charset=utf-8"},
+		{"json-preferred", "text/html;q=0.5, application/json", "application/json"},
+		{"wildcard", "*/*", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://localhost:41112/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTMLStatus(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d; want 200", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q; want %q", got, tt.wantType)
+			}
+			for _, h := range []string{"Content-Security-Policy", "X-Frame-Options", "X-Content-Type-Options"} {
+				if rec.Header().Get(h) == "" {
+					t.Errorf("missing %s header", h)
+				}
+			}
+			if strings.HasPrefix(tt.wantType, "application/json") {
+				var page ipnstate.StatusPage
+				if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+					t.Errorf("invalid JSON: %v", err)
+				}
+			} else if !strings.Contains(rec.Body.String(), "<html") {
+				t.Errorf("body doesn't look like HTML: %q", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServeHTMLStatusModel(t *testing.T) {
+	alice := key.NewNode().Public()
+	st := &ipnstate.Status{
+		TailscaleIPs:   []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		MagicDNSSuffix: "example.ts.net",
+		Self:           &ipnstate.PeerStatus{UserID: 1},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			alice: {
+				PublicKey:    alice,
+				UserID:       1,
+				HostName:     "alice-laptop",
+				DNSName:      "alice.example.ts.net.",
+				OS:           "linux",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+				RxBytes:      10,
+				TxBytes:      20,
+				Active:       true,
+				CurAddr:      "192.0.2.1:41641",
+			},
+		},
+		User: map[tailcfg.UserID]tailcfg.UserProfile{
+			1: {LoginName: "alice@example.com"},
+		},
+	}
+	old := backendStatus
+	backendStatus = func(*ipnlocal.LocalBackend) *ipnstate.Status { return st }
+	t.Cleanup(func() { backendStatus = old })
+
+	s := newTestServer(t)
+	serve := func(accept string) []byte {
+		req := httptest.NewRequest("GET", "http://localhost:41112/", nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		s.ServeHTMLStatus(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d; want 200", rec.Code)
+		}
+		return rec.Body.Bytes()
+	}
+
+	var page ipnstate.StatusPage
+	if err := json.Unmarshal(serve("application/json"), &page); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&page, st.Page()) {
+		t.Errorf("JSON page = %+v; want %+v", page, st.Page())
+	}
+	want := []ipnstate.StatusPagePeer{{
+		PublicKey: alice.ShortString(),
+		OS:        "linux",
+		DNSName:   "alice",
+		HostName:  "alice-laptop",
+		TailAddr:  "100.64.0.2",
+		Owner:     "alice",
+		RxBytes:   10,
+		TxBytes:   20,
+		ConnType:  "direct",
+		ConnAddr:  "192.0.2.1:41641",
+	}}
+	if !reflect.DeepEqual(page.Peers, want) {
+		t.Errorf("peers = %+v; want %+v", page.Peers, want)
+	}
+
+	// The HTML is rendered from the same model as the JSON.
+	var buf bytes.Buffer
+	page.WriteHTML(&buf)
+	if got := serve("text/html"); !bytes.Equal(got, buf.Bytes()) {
+		t.Errorf("HTML differs from the JSON model's rendering:\n got: %s\nwant: %s", got, buf.Bytes())
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("direct <b>192.0.2.1:41641</b>")) {
+		t.Errorf("HTML lacks peer connection: %s", buf.Bytes())
+	}
+}
+
+func TestAcceptQuality(t *testing.T) {
+	tests := []struct {
+		accept, mediaType string
+		want              float64
+	}{
+		{"application/json", "application/json", 1},
+		{"application/json", "text/html", 0},
+		{"text/*;q=0.3, text/html;q=0.7", "text/html", 0.7},
+		{"text/*;q=0.3, */*;q=0.1", "text/plain", 0.3},
+		{"*/*;q=0.2", "application/json", 0.2},
+		{"Application/JSON; q=0.5", "application/json", 0.5},
+	}
+	for _, tt := range tests {
+		if got := acceptQuality(tt.accept, tt.mediaType); got != tt.want {
+			t.Errorf("acceptQuality(%q, %q) = %v; want %v", tt.accept, tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	var logs strings.Builder
+	s := &Server{logf: func(format string, args ...any) { fmt.Fprintf(&logs, format, args...) }}
+	h := s.recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/localapi/v0/status", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d; want 500", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "boom") {
+		t.Errorf("panic value leaked into response body: %q", rec.Body.String())
+	}
+	if got := logs.String(); !strings.Contains(got, "boom") || !strings.Contains(got, "/localapi/v0/status") {
+		t.Errorf("panic not logged with path; got %q", got)
+	}
+}
+
+func TestRecoverPanicsAfterWrite(t *testing.T) {
+	s := &Server{logf: t.Logf}
+	ts := httptest.NewServer(s.recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		panic("boom")
+	})))
+	defer ts.Close()
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		return // connection aborted before headers; fine
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d; want 200 (headers already sent)", res.StatusCode)
+	}
+	if _, err := io.ReadAll(res.Body); err == nil {
+		t.Error("truncated streaming response read without error")
+	}
+}
+
+func TestKeepSocketOnShutdown(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets only")
+	}
+	for _, keep := range []bool{false, true} {
+		t.Run(fmt.Sprintf("keep=%v", keep), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tailscaled.sock")
+			ln, err := net.Listen("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := New(t.Logf, "logid")
+			s.KeepSocketOnShutdown = keep
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error, 1)
+			go func() { errc <- s.Run(ctx, ln) }()
+			cancel()
+			if err := <-errc; err != context.Canceled {
+				t.Fatalf("Run = %v; want context.Canceled", err)
+			}
+			_, err = os.Stat(path)
+			if exists := err == nil; exists != keep {
+				t.Errorf("socket exists = %v after shutdown; want %v (stat err: %v)", exists, keep, err)
+			}
+		})
+	}
+}
+
+func TestRequirePeerCreds(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("peer credentials aren't used on Windows")
+	}
+	s := newTestServer(t)
+	c1, c2 := net.Pipe() // no peer credentials
+	defer c1.Close()
+	defer c2.Close()
+
+	ctx := s.connContext(context.Background(), c1)
+	if _, ok := ctx.Value(connIdentityContextKey{}).(*ipnauth.ConnIdentity); !ok {
+		t.Fatalf("by default, creds-less conn identity = %v; want *ipnauth.ConnIdentity", ctx.Value(connIdentityContextKey{}))
+	}
+
+	s.RequirePeerCreds = true
+	ctx = s.connContext(context.Background(), c1)
+	if got := ctx.Value(connIdentityContextKey{}); got != errNoPeerCreds {
+		t.Fatalf("with RequirePeerCreds, identity = %v; want errNoPeerCreds", got)
+	}
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, httptest.NewRequest("GET", "/localapi/v0/status", nil).WithContext(ctx))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d; want 401", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "peer credentials") {
+		t.Errorf("body = %q; want explanation of rejection", rec.Body.String())
+	}
+}
+
+func TestUserSwitchGrace(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	alice := ipnauth.NewWindowsConnIdentity(nil, 1, "S-1-5-21-alice", nil)
+	bob := ipnauth.NewWindowsConnIdentity(nil, 2, "S-1-5-21-bob", nil)
+	newReq := func() *http.Request { return httptest.NewRequest("GET", "/localapi/v0/status", nil) }
+
+	t.Run("drains", func(t *testing.T) {
+		s := newTestServer(t)
+		s.UserSwitchGrace = 5 * time.Second
+		aliceDone, err := s.addActiveHTTPRequest(newReq(), alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(50*time.Millisecond, aliceDone)
+		t0 := time.Now()
+		bobDone, err := s.addActiveHTTPRequest(newReq(), bob)
+		if err != nil {
+			t.Fatalf("bob denied after alice's request finished: %v", err)
+		}
+		defer bobDone()
+		if d := time.Since(t0); d >= s.UserSwitchGrace {
+			t.Errorf("bob waited %v; want less than the grace period", d)
+		}
+	})
+
+	t.Run("times-out", func(t *testing.T) {
+		s := newTestServer(t)
+		s.UserSwitchGrace = 50 * time.Millisecond
+		aliceDone, err := s.addActiveHTTPRequest(newReq(), alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer aliceDone()
+		_, err = s.addActiveHTTPRequest(newReq(), bob)
+		if _, ok := err.(inUseOtherUserError); !ok {
+			t.Fatalf("err = %v; want inUseOtherUserError", err)
+		}
+	})
+}
+
+func TestInUseOtherUserJSON(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	alice := ipnauth.NewWindowsConnIdentity(nil, 123, "S-1-5-21-alice", &user.User{Uid: "S-1-5-21-alice", Username: `HOST\alice`})
+	bob := ipnauth.NewWindowsConnIdentity(nil, 456, "S-1-5-21-bob", nil)
+
+	s := newTestServer(t)
+	aliceDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/", nil), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aliceDone()
+
+	r := httptest.NewRequest("GET", "/localapi/v0/status", nil)
+	r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, bob))
+	r.Host = apitype.LocalAPIHost
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status %d; want 401", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q; want application/json", ct)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("body %q: %v", rec.Body.Bytes(), err)
+	}
+	want := map[string]any{
+		"code":      "in_use_other_user",
+		"error":     `Tailscale already in use by HOST\alice, pid 123`,
+		"otherUser": `HOST\alice`,
+		"otherPid":  123.0,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("body = %v; want %v", got, want)
+	}
+}
+
+func TestMaxRequestsPerUser(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows allows only one user at a time")
+	}
+	s := newTestServer(t)
+	s.MaxRequestsPerUser = 2
+	alice := ipnauth.NewUnixConnIdentity(nil, 1, "1001")
+	bob := ipnauth.NewUnixConnIdentity(nil, 2, "1002")
+	newReq := func() *http.Request { return httptest.NewRequest("GET", "/localapi/v0/status", nil) }
+
+	for i := 0; i < s.MaxRequestsPerUser; i++ {
+		onDone, err := s.addActiveHTTPRequest(newReq(), alice)
+		if err != nil {
+			t.Fatalf("alice request %d: %v", i, err)
+		}
+		defer onDone()
+	}
+	if _, err := s.addActiveHTTPRequest(newReq(), alice); err != errTooManyUserRequests {
+		t.Errorf("alice's excess request: err = %v; want errTooManyUserRequests", err)
+	}
+	onDone, err := s.addActiveHTTPRequest(newReq(), bob)
+	if err != nil {
+		t.Fatalf("bob's request rejected: %v", err)
+	}
+	onDone()
+
+	rec := httptest.NewRecorder()
+	req := newReq()
+	req = req.WithContext(context.WithValue(req.Context(), connIdentityContextKey{}, alice))
+	s.serveHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("serveHTTP status = %d; want 429", rec.Code)
+	}
+}
+
+func TestIdentityResolver(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	custom := ipnauth.NewUnixConnIdentity(c1, 42, "4242")
+	errNope := errors.New("nope")
+
+	tests := []struct {
+		name     string
+		resolver func(net.Conn) (*ipnauth.ConnIdentity, error)
+		check    func(t *testing.T, v any)
+	}{
+		{
+			name:     "custom",
+			resolver: func(net.Conn) (*ipnauth.ConnIdentity, error) { return custom, nil },
+			check: func(t *testing.T, v any) {
+				if v != custom {
+					t.Fatalf("identity = %v; want resolver's", v)
+				}
+				if got := connUserID(v.(*ipnauth.ConnIdentity)); got != "4242" {
+					t.Errorf("connUserID = %q; want 4242", got)
+				}
+			},
+		},
+		{
+			name:     "fallback",
+			resolver: func(net.Conn) (*ipnauth.ConnIdentity, error) { return nil, nil },
+			check: func(t *testing.T, v any) {
+				// The platform default may fail to identify a pipe (as on
+				// Windows), but mustn't produce the custom identity.
+				if v == nil || v == custom {
+					t.Fatalf("identity = %v; want platform default", v)
+				}
+			},
+		},
+		{
+			name:     "error",
+			resolver: func(net.Conn) (*ipnauth.ConnIdentity, error) { return nil, errNope },
+			check: func(t *testing.T, v any) {
+				if v != errNope {
+					t.Fatalf("identity = %v; want resolver's error", v)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(t.Logf, "logid")
+			s.IdentityResolver = tt.resolver
+			ctx := s.connContext(context.Background(), c1)
+			tt.check(t, ctx.Value(connIdentityContextKey{}))
+		})
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	s := New(t.Logf, "logid")
+	s.MaxHeaderBytes = 1 << 10
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	hc := &http.Client{Transport: &http.Transport{DialContext: dial}}
+	get := func(headerLen int) int {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, "GET", "http://local-tailscaled.sock/localapi/v0/status", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Big", strings.Repeat("a", headerLen))
+		res, err := hc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	// net/http allows some slack beyond MaxHeaderBytes, so go well past it.
+	if code := get(64 << 10); code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("oversized headers: status = %d; want 431", code)
+	}
+	if code := get(100); code == http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("small headers rejected with 431")
+	}
+}
+
+func TestRunConcurrent(t *testing.T) {
+	s := New(t.Logf, "logid")
+	ln, _ := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	waitFor(t, "Run to start", func() bool { return !s.Stats().StartTime.IsZero() })
+
+	ln2, _ := NewMemListener()
+	if err := s.Run(ctx, ln2); err != ErrAlreadyRunning {
+		t.Errorf("concurrent Run = %v; want ErrAlreadyRunning", err)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("first Run = %v; want context.Canceled", err)
+	}
+
+	// A sequential Run after the first returned is fine.
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	cancel2()
+	if err := s.Run(ctx2, ln2); err != context.Canceled {
+		t.Errorf("sequential Run = %v; want context.Canceled", err)
+	}
+}
+
+func TestBackendBusy(t *testing.T) {
+	s := newTestServer(t)
+	lb := s.mustBackend()
+	ci := ipnauth.TrustedConnIdentity(nil)
+	serve := func(method, path string) int {
+		r := httptest.NewRequest(method, path, nil)
+		r.Host = apitype.LocalAPIHost
+		r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ci))
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		return rec.Code
+	}
+
+	end := lb.BeginBusy()
+	if code := serve("POST", "/localapi/v0/check-prefs"); code != http.StatusServiceUnavailable {
+		t.Errorf("POST while busy: status = %d; want 503", code)
+	}
+	if code := serve("GET", "/localapi/v0/status"); code != http.StatusOK {
+		t.Errorf("GET status while busy: status = %d; want 200", code)
+	}
+
+	end()
+	if lb.Busy() {
+		t.Fatal("backend still busy after end")
+	}
+	if code := serve("POST", "/localapi/v0/check-prefs"); code == http.StatusServiceUnavailable {
+		t.Errorf("POST after busy ended: status = 503")
+	}
+}
+
+func TestStatusClientConn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets only")
+	}
+	s := newTestServer(t)
+	s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+		return ipnauth.NewUnixConnIdentity(c, 1, "0"), nil
+	}
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "tailscaled.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	cc, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	sc, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	r := httptest.NewRequest("GET", "/localapi/v0/status?peers=false", nil)
+	r.Host = apitype.LocalAPIHost
+	r = r.WithContext(s.connContext(r.Context(), sc))
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; body: %s", rec.Code, rec.Body.Bytes())
+	}
+	var st ipnstate.Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	want := ipnstate.ClientConnStatus{Transport: "unix", UserID: "0", CanRead: true, CanWrite: true}
+	if st.ClientConn == nil || *st.ClientConn != want {
+		t.Errorf("ClientConn = %+v; want %+v", st.ClientConn, want)
+	}
+}
+
+func TestUserSwitchResetTimeout(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	alice := ipnauth.NewWindowsConnIdentity(nil, 1, "S-1-5-21-alice", nil)
+	bob := ipnauth.NewWindowsConnIdentity(nil, 2, "S-1-5-21-bob", nil)
+	newReq := func() *http.Request { return httptest.NewRequest("GET", "/localapi/v0/status", nil) }
+
+	s := newTestServer(t)
+	s.resetOnZero = false // only test the reset for the change of user
+	s.UserSwitchResetTimeout = 50 * time.Millisecond
+	var resetFinished atomic.Bool
+	release := make(chan struct{})
+	s.testResetBackend = func() {
+		<-release
+		resetFinished.Store(true)
+	}
+
+	onDone, err := s.addActiveHTTPRequest(newReq(), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onDone()
+
+	// Bob's first request starts the slow reset, and is refused after the
+	// timeout rather than waiting for it.
+	t0 := time.Now()
+	if _, err := s.addActiveHTTPRequest(newReq(), bob); err != errUserSwitchResetInProgress {
+		t.Fatalf("during slow reset: err = %v; want errUserSwitchResetInProgress", err)
+	}
+	if d := time.Since(t0); d > 5*time.Second {
+		t.Errorf("refusal took %v; want about UserSwitchResetTimeout", d)
+	}
+	if resetFinished.Load() {
+		t.Fatal("reset finished early")
+	}
+
+	// Once the reset completes, bob is admitted, and only then.
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	s.UserSwitchResetTimeout = 0
+	onDone, err = s.addActiveHTTPRequest(newReq(), bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer onDone()
+	if !resetFinished.Load() {
+		t.Error("bob admitted before the user-switch reset finished")
+	}
+}
+
+func TestMinUserSwitchResetInterval(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	const interval = 300 * time.Millisecond
+	s := newTestServer(t)
+	s.resetOnZero = false // only test the resets for changes of user
+	s.MinUserSwitchResetInterval = interval
+	s.UserSwitchResetTimeout = 10 * time.Millisecond
+	var (
+		mu     sync.Mutex
+		resets []time.Time
+	)
+	s.testResetBackend = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		resets = append(resets, time.Now())
+	}
+	numResets := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(resets)
+	}
+	use := func(uid ipn.WindowsUserID) error {
+		ci := ipnauth.NewWindowsConnIdentity(nil, 1, uid, nil)
+		onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/localapi/v0/status", nil), ci)
+		if err == nil {
+			onDone()
+		}
+		return err
+	}
+
+	if err := use("S-1-5-21-alice"); err != nil {
+		t.Fatal(err)
+	}
+	// The first change of user resets right away.
+	s.UserSwitchResetTimeout = 0
+	if err := use("S-1-5-21-bob"); err != nil {
+		t.Fatal(err)
+	}
+	if n := numResets(); n != 1 {
+		t.Fatalf("after first switch, %d resets; want 1", n)
+	}
+
+	// Rapid further switches defer the next reset, sharing it. The
+	// users' requests are refused rather than served without it.
+	s.UserSwitchResetTimeout = 10 * time.Millisecond
+	for _, uid := range []ipn.WindowsUserID{"S-1-5-21-carol", "S-1-5-21-dave", "S-1-5-21-erin"} {
+		if err := use(uid); err != errUserSwitchResetInProgress {
+			t.Fatalf("request from %s during deferred reset: err = %v; want errUserSwitchResetInProgress", uid, err)
+		}
+	}
+
+	// Erin is admitted only once the one deferred reset has happened.
+	s.UserSwitchResetTimeout = 0
+	if err := use("S-1-5-21-erin"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(resets) != 2 {
+		t.Fatalf("got %d resets; want 2", len(resets))
+	}
+	if d := resets[1].Sub(resets[0]); d < interval {
+		t.Errorf("resets %v apart; want at least %v", d, interval)
+	}
+}
+
+func TestListenerCloseGrace(t *testing.T) {
+	s := New(t.Logf, "logid")
+	s.ListenerCloseGrace = time.Second
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	waitFor(t, "Run to start", func() bool { return !s.Stats().StartTime.IsZero() })
+
+	cancel()
+	// Within the grace period, a new connection is still accepted and
+	// served.
+	c, err := dial(context.Background(), "tcp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET /localapi/v0/status HTTP/1.1\r\nHost: local-tailscaled.sock\r\n\r\n")
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatalf("reading response on conn accepted during grace: %v", err)
+	}
+	res.Body.Close()
+
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Run = %v; want context.Canceled", err)
+	}
+}
+
+func TestClearLocalBackendForTest(t *testing.T) {
+	s := newTestServer(t)
+	lb2 := newTestBackend(t)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("second SetLocalBackend didn't panic")
+			}
+		}()
+		s.SetLocalBackend(lb2)
+	}()
+
+	s.ClearLocalBackendForTest()
+	if lb := s.lb.Load(); lb != nil {
+		t.Fatalf("backend after clear = %p; want nil", lb)
+	}
+	s.SetLocalBackend(lb2)
+	if lb := s.mustBackend(); lb != lb2 {
+		t.Errorf("backend = %p; want the replacement %p", lb, lb2)
+	}
+
+	r := httptest.NewRequest("GET", "/localapi/v0/status", nil)
+	r.Host = apitype.LocalAPIHost
+	r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ipnauth.TrustedConnIdentity(nil)))
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Errorf("status with the replacement backend: %d; want 200", rec.Code)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import "time"
+
+// ShutdownPhase is a step of Server.Run shutting down, as reported to
+// Server.OnShutdownPhase.
+type ShutdownPhase string
+
+const (
+	// ShutdownDraining is when the listener has closed and Run is
+	// waiting (briefly) for in-flight requests to finish.
+	ShutdownDraining ShutdownPhase = "draining"
+
+	// ShutdownBackend is when Run is shutting down the LocalBackend,
+	// which may take a while to flush logs and tear down the network.
+	ShutdownBackend ShutdownPhase = "backend-shutdown"
+
+	// ShutdownDone is when shutdown has finished and Run is about to
+	// return.
+	ShutdownDone ShutdownPhase = "done"
+)
+
+// shutdownDrainTimeout bounds how long Run waits for in-flight requests
+// to finish once its listener has closed. Requests' contexts are canceled
+// by then, so well-behaved handlers return promptly.
+const shutdownDrainTimeout = 5 * time.Second
+
+// shutdownPhase logs that shutdown has reached phase and reports it to
+// s.OnShutdownPhase.
+func (s *Server) shutdownPhase(phase ShutdownPhase) {
+	s.mu.Lock()
+	if s.shutdownT0.IsZero() {
+		s.shutdownT0 = time.Now()
+	}
+	elapsed := time.Since(s.shutdownT0)
+	s.mu.Unlock()
+	s.logf("shutdown: %s (%v elapsed)", phase, elapsed.Round(time.Millisecond))
+	if f := s.OnShutdownPhase; f != nil {
+		f(phase)
+	}
+}
+
+// waitForRequestsDrained waits up to d for all in-flight requests to
+// finish, reporting whether they did.
+func (s *Server) waitForRequestsDrained(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.activeReqs) > 0 {
+		if s.reqsDone == nil {
+			s.reqsDone = make(chan struct{})
+		}
+		done := s.reqsDone
+		s.mu.Unlock()
+		select {
+		case <-done:
+			s.mu.Lock()
+		case <-t.C:
+			s.mu.Lock()
+			return len(s.activeReqs) == 0
+		}
+	}
+	return true
+}
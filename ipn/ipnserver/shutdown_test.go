@@ -0,0 +1,36 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestShutdownPhases(t *testing.T) {
+	s := newTestServer(t)
+	var mu sync.Mutex
+	var phases []ShutdownPhase
+	s.OnShutdownPhase = func(p ShutdownPhase) {
+		mu.Lock()
+		defer mu.Unlock()
+		phases = append(phases, p)
+	}
+	ln, _ := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	cancel()
+	<-errc
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []ShutdownPhase{ShutdownDraining, ShutdownBackend, ShutdownDone}
+	if !reflect.DeepEqual(phases, want) {
+		t.Errorf("phases = %q; want %q", phases, want)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// socketPathOf returns the path of the unix socket file ln listens on, or
+// the empty string if ln isn't a unix socket listener or its socket has no
+// file (as for Linux abstract sockets).
+func socketPathOf(ln net.Listener) string {
+	switch ln := ln.(type) {
+	case *net.UnixListener:
+		path := ln.Addr().String()
+		if strings.HasPrefix(path, "@") {
+			return ""
+		}
+		return path
+	case *trustedListener:
+		return socketPathOf(ln.Listener)
+	case *retryListener:
+		return socketPathOf(ln.Listener)
+	}
+	return ""
+}
+
+// recordSocketPath records the path of Run's listener's socket file, if
+// any, for Stats and Describe.
+func (s *Server) recordSocketPath(ln net.Listener) {
+	path := socketPathOf(ln)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sockPath = path
+}
+
+// socketMode returns the path of Run's listener's socket file and its
+// current permissions, as stat'd now, such as "0666". Both are empty if
+// the listener has no socket file; mode is empty if it can't be stat'd.
+func (s *Server) socketMode() (path, mode string) {
+	s.mu.Lock()
+	path = s.sockPath
+	s.mu.Unlock()
+	if path == "" {
+		return "", ""
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return path, ""
+	}
+	return path, fmt.Sprintf("%04o", fi.Mode().Perm())
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSocketMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix socket files on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "tailscaled.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	s := New(t.Logf, "logid")
+	if st := s.Stats(); st.SocketPath != "" || st.SocketMode != "" {
+		t.Fatalf("before recording: socket %q mode %q; want none", st.SocketPath, st.SocketMode)
+	}
+	s.recordSocketPath(newRetryListener(ln, t.Logf))
+
+	for _, tt := range []struct {
+		perm os.FileMode
+		want string
+	}{
+		{0640, "0640"},
+		{0666, "0666"},
+	} {
+		if err := os.Chmod(path, tt.perm); err != nil {
+			t.Fatal(err)
+		}
+		want := tt.want
+		st := s.Stats()
+		if st.SocketPath != path || st.SocketMode != want {
+			t.Errorf("socket %q mode %q; want %q mode %q", st.SocketPath, st.SocketMode, path, want)
+		}
+		if d := s.Describe(); !strings.Contains(d, "socket="+path+" mode="+want) {
+			t.Errorf("Describe() = %q; missing socket mode %s", d, want)
+		}
+	}
+
+	os.Remove(path)
+	if st := s.Stats(); st.SocketPath != path || st.SocketMode != "" {
+		t.Errorf("after removal: socket %q mode %q; want %q with no mode", st.SocketPath, st.SocketMode, path)
+	}
+}
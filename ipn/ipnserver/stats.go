@@ -0,0 +1,234 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/safesocket"
+	"tailscale.com/util/clientmetric"
+)
+
+// ResetReason is why the Server reset its LocalBackend's state.
+type ResetReason string
+
+const (
+	// ResetUserChanged is when a different local user connected than
+	// the one previously using the server, so state was reset to avoid
+	// leaking it between users.
+	ResetUserChanged ResetReason = "user-changed"
+
+	// ResetLastClientDisconnected is when the last client disconnected
+	// from a server running in client mode (see Server.resetOnZero).
+	ResetLastClientDisconnected ResetReason = "last-client-disconnected"
+)
+
+var (
+	metricResetUserChanged          = clientmetric.NewCounter("ipnserver_reset_user_changed")
+	metricResetUserChangedCoalesced = clientmetric.NewCounter("ipnserver_reset_user_changed_coalesced")
+	metricResetLastClientDisconnect = clientmetric.NewCounter("ipnserver_reset_last_client_disconnected")
+)
+
+// Stats are statistics about a Server, as returned by Server.Stats.
+type Stats struct {
+	// StartTime is when the current call to Run began, or the zero value
+	// if Run hasn't been called.
+	StartTime time.Time
+
+	// Uptime is how long the current call to Run has been running.
+	Uptime time.Duration
+
+	// ActiveRequests is the number of in-flight LocalAPI requests.
+	ActiveRequests int
+
+	// Watchers is the number of active watcher subscriptions.
+	Watchers int
+
+	// OpenConns is the number of open LocalAPI connections, and PeakConns
+	// the most there have been at once. MaxConns is Server.MaxConns.
+	OpenConns int
+	PeakConns int
+	MaxConns  int `json:",omitempty"`
+
+	// ConnGoroutines is the number of goroutines the server runs for
+	// LocalAPI connections. MaxGoroutines is Server.MaxGoroutines.
+	ConnGoroutines int
+	MaxGoroutines  int `json:",omitempty"`
+
+	// LastResetReason is why the server last reset the backend's
+	// state, or empty if it hasn't.
+	LastResetReason ResetReason `json:",omitempty"`
+
+	// LastResetTime is when the server last reset the backend's state,
+	// or the zero value if it hasn't.
+	LastResetTime time.Time
+
+	// LastAcceptTime is when the server last accepted a LocalAPI
+	// connection, or the zero value if it hasn't, and LastAcceptAge how
+	// long ago that was. A growing age while clients fail to connect
+	// means the accept loop is stalled, even if the process and backend
+	// are otherwise healthy.
+	LastAcceptTime time.Time
+	LastAcceptAge  time.Duration `json:",omitempty"`
+
+	// LastAuthorizedTime is when a LocalAPI request last passed the
+	// server's checks, or the zero value if none has.
+	LastAuthorizedTime time.Time
+
+	// LastRejectedTime and LastRejectedReason are when and why the server
+	// last rejected a connection or request, or zero values if it hasn't.
+	LastRejectedTime   time.Time
+	LastRejectedReason DenyReason `json:",omitempty"`
+
+	// SocketRecvBuffer and SocketSendBuffer are the kernel receive and
+	// send buffer sizes of the listening socket passed to Run, as reported
+	// by the OS, or zero if unknown (including for listeners that aren't
+	// OS sockets).
+	SocketRecvBuffer int `json:",omitempty"`
+	SocketSendBuffer int `json:",omitempty"`
+
+	// SocketPath is the path of the unix socket file Run listens on, if
+	// any, and SocketMode its permissions as of the call to Stats, such as
+	// "0666", or empty if they can't be read.
+	SocketPath string `json:",omitempty"`
+	SocketMode string `json:",omitempty"`
+
+	// ProxyBytesSent and ProxyBytesReceived are the bytes sent to and
+	// received from CONNECT tunnel targets since the server started,
+	// including by tunnels since closed. Only Windows uses tunnels; see
+	// Server.ProxyTunnels.
+	ProxyBytesSent     int64 `json:",omitempty"`
+	ProxyBytesReceived int64 `json:",omitempty"`
+
+	// WindowsService is whether the server is running as a Windows
+	// service; see Server.WindowsService.
+	WindowsService bool `json:",omitempty"`
+
+	// Lifecycle is whether the server resets the backend when its last
+	// client disconnects, and why; see Server.LifecycleMode.
+	Lifecycle LifecycleMode
+
+	// Degraded is why the server is degraded, or empty if it isn't; see
+	// Server.DegradeOnPanic.
+	Degraded string `json:",omitempty"`
+}
+
+// Stats returns statistics about s.
+func (s *Server) Stats() Stats {
+	winService := s.windowsService()
+	lifecycle := s.LifecycleMode()
+	sockPath, sockMode := s.socketMode()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var uptime, acceptAge time.Duration
+	if !s.runStart.IsZero() {
+		uptime = time.Since(s.runStart)
+	}
+	if !s.lastAccept.IsZero() {
+		acceptAge = time.Since(s.lastAccept)
+	}
+	return Stats{
+		StartTime:          s.runStart,
+		Uptime:             uptime,
+		ActiveRequests:     len(s.activeReqs),
+		Watchers:           len(s.watchers),
+		OpenConns:          s.openConns,
+		PeakConns:          s.peakConns,
+		MaxConns:           s.MaxConns,
+		ConnGoroutines:     s.connGoroutinesLocked(),
+		MaxGoroutines:      s.MaxGoroutines,
+		LastResetReason:    s.lastResetReason,
+		LastResetTime:      s.lastResetTime,
+		LastAcceptTime:     s.lastAccept,
+		LastAcceptAge:      acceptAge,
+		LastAuthorizedTime: s.lastAuthorized,
+		LastRejectedTime:   s.lastRejected,
+		LastRejectedReason: s.lastRejectedReason,
+		SocketRecvBuffer:   s.sockRecvBuf,
+		SocketSendBuffer:   s.sockSendBuf,
+		SocketPath:         sockPath,
+		SocketMode:         sockMode,
+		ProxyBytesSent:     s.tunnelBytesSent.Load(),
+		ProxyBytesReceived: s.tunnelBytesRecv.Load(),
+		WindowsService:     winService,
+		Lifecycle:          lifecycle,
+		Degraded:           s.Degraded(),
+	}
+}
+
+// recordBufferSizes records the socket buffer sizes of ln, if it's an OS
+// socket, for Stats.
+func (s *Server) recordBufferSizes(ln net.Listener) {
+	var recv, send int
+	if sc, ok := ln.(syscall.Conn); ok {
+		var err error
+		recv, send, err = safesocket.BufferSizes(sc)
+		if err != nil {
+			s.logf("getting listener buffer sizes: %v", err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sockRecvBuf, s.sockSendBuf = recv, send
+}
+
+// noteAuthorized records for Stats that a request passed the server's
+// checks.
+func (s *Server) noteAuthorized() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAuthorized = time.Now()
+}
+
+// noteRejected records for Stats that a connection or request was
+// rejected for reason.
+func (s *Server) noteRejected(reason DenyReason) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastRejected = time.Now()
+	s.lastRejectedReason = reason
+}
+
+// resetBackend resets lb's state for the given reason, recording it for
+// Stats and metrics.
+//
+// s.mu must not be held.
+func (s *Server) resetBackend(lb *ipnlocal.LocalBackend, reason ResetReason) {
+	s.mu.Lock()
+	s.lastResetReason = reason
+	s.lastResetTime = time.Now()
+	s.mu.Unlock()
+	switch reason {
+	case ResetUserChanged:
+		metricResetUserChanged.Add(1)
+	case ResetLastClientDisconnected:
+		metricResetLastClientDisconnect.Add(1)
+	}
+	if s.testResetBackend != nil {
+		s.testResetBackend()
+		return
+	}
+	lb.ResetForClientDisconnect()
+}
+
+// serveStats serves the Server's Stats as JSON.
+func (s *Server) serveStats(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "server-stats access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Stats())
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/safesocket"
+)
+
+func TestResetReason(t *testing.T) {
+	s := newTestServer(t)
+	if st := s.Stats(); st.LastResetReason != "" || !st.LastResetTime.IsZero() {
+		t.Fatalf("initial stats = %+v; want no reset", st)
+	}
+
+	// Client mode: the last client disconnecting resets the backend.
+	s.resetOnZero = true
+	before := metricResetLastClientDisconnect.Value()
+	onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/", nil), &ipnauth.ConnIdentity{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := s.Stats(); st.ActiveRequests != 1 {
+		t.Errorf("ActiveRequests = %d; want 1", st.ActiveRequests)
+	}
+	t0 := time.Now()
+	onDone()
+	st := s.Stats()
+	if st.LastResetReason != ResetLastClientDisconnected {
+		t.Errorf("LastResetReason = %q; want %q", st.LastResetReason, ResetLastClientDisconnected)
+	}
+	if st.LastResetTime.Before(t0) {
+		t.Errorf("LastResetTime = %v; want after %v", st.LastResetTime, t0)
+	}
+	if got := metricResetLastClientDisconnect.Value() - before; got != 1 {
+		t.Errorf("last-client-disconnected metric increased by %d; want 1", got)
+	}
+
+	before = metricResetUserChanged.Value()
+	s.resetBackend(s.mustBackend(), ResetUserChanged)
+	if st := s.Stats(); st.LastResetReason != ResetUserChanged {
+		t.Errorf("LastResetReason = %q; want %q", st.LastResetReason, ResetUserChanged)
+	}
+	if got := metricResetUserChanged.Value() - before; got != 1 {
+		t.Errorf("user-changed metric increased by %d; want 1", got)
+	}
+}
+
+func TestStatsUptime(t *testing.T) {
+	s := New(t.Logf, "logid")
+	if st := s.Stats(); !st.StartTime.IsZero() || st.Uptime != 0 {
+		t.Fatalf("before Run: stats = %+v; want zero StartTime and Uptime", st)
+	}
+
+	// run starts s.Run and waits for it to record its start time, returning
+	// a func that stops it.
+	run := func(prevStart time.Time) (stop func()) {
+		ln, _ := NewMemListener()
+		ctx, cancel := context.WithCancel(context.Background())
+		errc := make(chan error, 1)
+		go func() { errc <- s.Run(ctx, ln) }()
+		for s.Stats().StartTime.Equal(prevStart) {
+			time.Sleep(time.Millisecond)
+		}
+		return func() {
+			cancel()
+			<-errc
+		}
+	}
+
+	stop := run(time.Time{})
+	st1 := s.Stats()
+	time.Sleep(10 * time.Millisecond)
+	st2 := s.Stats()
+	if st2.Uptime <= st1.Uptime {
+		t.Errorf("Uptime didn't increase: %v then %v", st1.Uptime, st2.Uptime)
+	}
+	if !st2.StartTime.Equal(st1.StartTime) {
+		t.Errorf("StartTime changed within a Run: %v then %v", st1.StartTime, st2.StartTime)
+	}
+	stop()
+
+	stop = run(st1.StartTime)
+	defer stop()
+	if st3 := s.Stats(); !st3.StartTime.After(st1.StartTime) {
+		t.Errorf("second Run StartTime = %v; want after %v", st3.StartTime, st1.StartTime)
+	}
+}
+
+func TestStatsBufferSizes(t *testing.T) {
+	ln, _, err := safesocket.Listen(filepath.Join(t.TempDir(), "tailscaled.sock"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRecv, wantSend, err := safesocket.BufferSizes(ln.(syscall.Conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := New(t.Logf, "logid")
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+	waitFor(t, "buffer sizes", func() bool { return s.Stats().SocketRecvBuffer != 0 })
+	st := s.Stats()
+	if st.SocketRecvBuffer != wantRecv || st.SocketSendBuffer != wantSend {
+		t.Errorf("Stats buffer sizes = %d, %d; want %d, %d", st.SocketRecvBuffer, st.SocketSendBuffer, wantRecv, wantSend)
+	}
+}
+
+func TestLastAuthorizedAndRejected(t *testing.T) {
+	s := newTestServer(t)
+	if st := s.Stats(); !st.LastAuthorizedTime.IsZero() || !st.LastRejectedTime.IsZero() || st.LastRejectedReason != "" {
+		t.Fatalf("initial stats = %+v; want no authorized or rejected requests", st)
+	}
+	ci := ipnauth.TrustedConnIdentity(nil)
+	serve := func() int {
+		r := httptest.NewRequest("GET", "/localapi/v0/prefs", nil)
+		r.Host = apitype.LocalAPIHost
+		r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ci))
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		return rec.Code
+	}
+
+	t0 := time.Now()
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("prefs = %d; want 200", code)
+	}
+	st := s.Stats()
+	if st.LastAuthorizedTime.Before(t0) {
+		t.Errorf("LastAuthorizedTime = %v; want after %v", st.LastAuthorizedTime, t0)
+	}
+	if !st.LastRejectedTime.IsZero() {
+		t.Errorf("LastRejectedTime = %v after success; want zero", st.LastRejectedTime)
+	}
+	authorized := st.LastAuthorizedTime
+
+	t1 := time.Now()
+	s.SetMaintenance(true, "")
+	if code := serve(); code != http.StatusServiceUnavailable {
+		t.Fatalf("prefs in maintenance = %d; want 503", code)
+	}
+	st = s.Stats()
+	if st.LastRejectedTime.Before(t1) || st.LastRejectedReason != DenyMaintenance {
+		t.Errorf("last rejected = %v, %q; want after %v, %q", st.LastRejectedTime, st.LastRejectedReason, t1, DenyMaintenance)
+	}
+	if !st.LastAuthorizedTime.Equal(authorized) {
+		t.Errorf("LastAuthorizedTime = %v after rejection; want unchanged %v", st.LastAuthorizedTime, authorized)
+	}
+}
+
+func TestLastAccept(t *testing.T) {
+	s := newTestServer(t)
+	if st := s.Stats(); !st.LastAcceptTime.IsZero() || st.LastAcceptAge != 0 {
+		t.Fatalf("before Run: stats = %+v; want no accept", st)
+	}
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	var prev time.Time
+	for i := 0; i < 2; i++ {
+		c, err := dial(ctx, "tcp", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		waitFor(t, "accept to be recorded", func() bool {
+			return s.Stats().LastAcceptTime.After(prev)
+		})
+		prev = s.Stats().LastAcceptTime
+		time.Sleep(time.Millisecond) // so the next accept's time differs
+	}
+	if age := s.Stats().LastAcceptAge; age <= 0 {
+		t.Errorf("LastAcceptAge = %v; want positive", age)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/tstime/rate"
+	"tailscale.com/util/mak"
+)
+
+// DefaultStatusQueryInterval and DefaultStatusQueryBurst are the default
+// values of Server.StatusQueryInterval and Server.StatusQueryBurst: ten
+// status queries a second per user, with bursts of 50, which no GUI or
+// script polling sensibly comes near.
+const (
+	DefaultStatusQueryInterval = 100 * time.Millisecond
+	DefaultStatusQueryBurst    = 50
+)
+
+// statusLimiter is a user's status query rate limiter.
+type statusLimiter struct {
+	lim      *rate.Limiter
+	lastUsed time.Time
+}
+
+// statusQueryPaths are the status endpoints limited by
+// Server.StatusQueryInterval. "/" is the HTML status page on Windows.
+var statusQueryPaths = map[string]bool{
+	"/localapi/v0/status": true,
+	"/":                   true,
+}
+
+// allowStatusQuery reports whether a query of the status endpoint at path
+// from ci is within s.StatusQueryInterval and s.StatusQueryBurst for ci's
+// user. Other paths, trusted connections and connections whose user is
+// unknown, which would otherwise all share one limit, are always allowed.
+func (s *Server) allowStatusQuery(path string, ci *ipnauth.ConnIdentity) bool {
+	if s.StatusQueryInterval <= 0 || !statusQueryPaths[path] || ci.IsTrusted() {
+		return true
+	}
+	uid := connUserID(ci)
+	if uid == "" {
+		return true
+	}
+	now := s.now()
+	s.mu.Lock()
+	sl, ok := s.statusLimiters[uid]
+	if !ok {
+		s.pruneStatusLimitersLocked(now)
+		sl = &statusLimiter{lim: rate.NewLimiter(rate.Every(s.StatusQueryInterval), s.statusQueryBurst())}
+		mak.Set(&s.statusLimiters, uid, sl)
+	}
+	sl.lastUsed = now
+	s.mu.Unlock()
+	return sl.lim.Allow()
+}
+
+func (s *Server) statusQueryBurst() int {
+	if s.StatusQueryBurst < 1 {
+		return 1
+	}
+	return s.StatusQueryBurst
+}
+
+// pruneStatusLimitersLocked removes the limiters of users who haven't
+// queried status for long enough that their limiters have refilled, and so
+// are no different from new ones. It's called as limiters are added, which
+// keeps s.statusLimiters to about the users that queried status recently.
+// s.mu must be held.
+func (s *Server) pruneStatusLimitersLocked(now time.Time) {
+	idle := s.StatusQueryInterval * time.Duration(s.statusQueryBurst())
+	for uid, sl := range s.statusLimiters {
+		if now.Sub(sl.lastUsed) >= idle {
+			delete(s.statusLimiters, uid)
+		}
+	}
+}
+
+// denyStatusQuery rejects r, a status query over the rate limit, with 429
+// Too Many Requests, telling the client to retry once a query is allowed
+// again.
+func (s *Server) denyStatusQuery(w http.ResponseWriter, r *http.Request) {
+	retry := int(math.Ceil(s.StatusQueryInterval.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	s.denyRequest(w, r, DenyStatusRateLimited, "too many status queries; slow down", http.StatusTooManyRequests)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestStatusQueryRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	if s.StatusQueryInterval != DefaultStatusQueryInterval || s.StatusQueryBurst != DefaultStatusQueryBurst {
+		t.Errorf("default limit = %v, %d; want %v, %d", s.StatusQueryInterval, s.StatusQueryBurst, DefaultStatusQueryInterval, DefaultStatusQueryBurst)
+	}
+	s.StatusQueryInterval, s.StatusQueryBurst = time.Hour, 3
+	serve := func(path string, ci *ipnauth.ConnIdentity) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Host = apitype.LocalAPIHost
+		r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ci))
+		rec := httptest.NewRecorder()
+		s.serveHTTP(rec, r)
+		return rec
+	}
+	alice := ipnauth.NewUnixConnIdentity(nil, 1, "1001")
+	bob := ipnauth.NewUnixConnIdentity(nil, 2, "1002")
+
+	for i := 0; i < 3; i++ {
+		if rec := serve("/localapi/v0/status", alice); rec.Code == http.StatusTooManyRequests {
+			t.Fatalf("query %d within burst throttled", i)
+		}
+	}
+	rec := serve("/localapi/v0/status", alice)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("query over burst = %d; want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3600" {
+		t.Errorf("Retry-After = %q; want 3600", got)
+	}
+
+	// The limit is per user, and only for status.
+	if rec := serve("/localapi/v0/status", bob); rec.Code == http.StatusTooManyRequests {
+		t.Error("other user's status query throttled")
+	}
+	if rec := serve("/localapi/v0/prefs", alice); rec.Code == http.StatusTooManyRequests {
+		t.Error("non-status query throttled")
+	}
+	if rec := serve("/localapi/v0/status", ipnauth.TrustedConnIdentity(nil)); rec.Code == http.StatusTooManyRequests {
+		t.Error("trusted status query throttled")
+	}
+	// Connections whose user is unknown would all share one limit, so
+	// they aren't limited.
+	for i := 0; i < 5; i++ {
+		if rec := serve("/localapi/v0/status", &ipnauth.ConnIdentity{}); rec.Code == http.StatusTooManyRequests {
+			t.Fatalf("query %d with unknown user throttled", i)
+		}
+	}
+
+	s.StatusQueryInterval = 0
+	if rec := serve("/localapi/v0/status", alice); rec.Code == http.StatusTooManyRequests {
+		t.Error("status query throttled with the limit disabled")
+	}
+}
+
+func TestStatusLimitersPruned(t *testing.T) {
+	now := time.Now()
+	s := &Server{StatusQueryInterval: time.Second, StatusQueryBurst: 2, timeNow: func() time.Time { return now }}
+	alice := ipnauth.NewUnixConnIdentity(nil, 1, "1001")
+	bob := ipnauth.NewUnixConnIdentity(nil, 2, "1002")
+
+	s.allowStatusQuery("/localapi/v0/status", alice)
+	now = now.Add(time.Second)
+	s.allowStatusQuery("/localapi/v0/status", bob)
+	if len(s.statusLimiters) != 2 {
+		t.Fatalf("limiters = %d; want 2", len(s.statusLimiters))
+	}
+
+	// Once alice's limiter has had time to refill, it's dropped as
+	// another user's is added; bob's, used more recently, is kept.
+	now = now.Add(time.Second)
+	s.allowStatusQuery("/localapi/v0/status", ipnauth.NewUnixConnIdentity(nil, 3, "1003"))
+	if _, ok := s.statusLimiters["1001"]; ok {
+		t.Error("idle limiter not pruned")
+	}
+	if _, ok := s.statusLimiters["1002"]; !ok {
+		t.Error("recently used limiter pruned")
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/localapi"
+)
+
+// allTransports are the Transports, in the order Timeouts reports them.
+var allTransports = []Transport{TransportUnix, TransportTCP, TransportMemory, TransportOther}
+
+// Timeouts are a Server's effective timeouts, with defaults applied, as
+// returned by Server.Timeouts. A zero or negative duration means there's
+// no timeout.
+type Timeouts struct {
+	// Write is Server.WriteTimeout.
+	Write time.Duration
+
+	// Idle is the idle keep-alive timeout of each Transport; see
+	// Server.IdleTimeouts.
+	Idle map[Transport]time.Duration
+
+	// ShutdownDrain is how long Run waits for in-flight requests to
+	// finish when shutting down. It's not configurable.
+	ShutdownDrain time.Duration
+
+	// ListenerCloseGrace is Server.ListenerCloseGrace.
+	ListenerCloseGrace time.Duration
+
+	// UserSwitchGrace is Server.UserSwitchGrace.
+	UserSwitchGrace time.Duration
+
+	// UserSwitchReset is Server.UserSwitchResetTimeout.
+	UserSwitchReset time.Duration
+
+	// IdleExit is Server.IdleExitTimeout.
+	IdleExit time.Duration
+
+	// MaxRequest is Server.MaxRequestDuration.
+	MaxRequest time.Duration
+}
+
+// Timeouts returns s's effective timeouts.
+func (s *Server) Timeouts() Timeouts {
+	t := Timeouts{
+		Write:              s.WriteTimeout,
+		Idle:               make(map[Transport]time.Duration, len(allTransports)),
+		ShutdownDrain:      shutdownDrainTimeout,
+		ListenerCloseGrace: s.ListenerCloseGrace,
+		UserSwitchGrace:    s.UserSwitchGrace,
+		UserSwitchReset:    s.UserSwitchResetTimeout,
+		IdleExit:           s.IdleExitTimeout,
+		MaxRequest:         s.MaxRequestDuration,
+	}
+	for _, tr := range allTransports {
+		t.Idle[tr] = s.idleTimeout(tr)
+	}
+	return t
+}
+
+// String returns t in the form logged by Server.Describe, such as
+// "write-timeout=5s idle-timeout=unix:1m0s,tcp:5s,... drain-timeout=5s ...".
+func (t Timeouts) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "write-timeout=%v idle-timeout=", t.Write)
+	for i, tr := range allTransports {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s:%v", tr, t.Idle[tr])
+	}
+	fmt.Fprintf(&sb, " drain-timeout=%v listener-close-grace=%v user-switch-grace=%v user-switch-reset-timeout=%v idle-exit-timeout=%v max-request-duration=%v",
+		t.ShutdownDrain, t.ListenerCloseGrace, t.UserSwitchGrace, t.UserSwitchReset, t.IdleExit, t.MaxRequest)
+	return sb.String()
+}
+
+// serveTimeouts serves the Server's effective Timeouts as JSON.
+func (s *Server) serveTimeouts(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "server-timeouts access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Timeouts())
+}
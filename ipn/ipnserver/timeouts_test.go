@@ -0,0 +1,60 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/localapi"
+)
+
+func TestTimeouts(t *testing.T) {
+	s := New(t.Logf, "logid")
+	s.WriteTimeout = 3 * time.Second
+	s.IdleTimeouts = map[Transport]time.Duration{TransportTCP: 7 * time.Second}
+	s.ListenerCloseGrace = time.Second
+	s.UserSwitchResetTimeout = 20 * time.Second
+	s.IdleExitTimeout = time.Hour
+
+	h := localapi.NewHandler(nil, t.Logf, "")
+	h.PermitRead = true
+	rec := httptest.NewRecorder()
+	s.serveTimeouts(h, rec, httptest.NewRequest("GET", "/localapi/v0/server-timeouts", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, %s", rec.Code, rec.Body.Bytes())
+	}
+	var got Timeouts
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := Timeouts{
+		Write: 3 * time.Second,
+		Idle: map[Transport]time.Duration{
+			TransportUnix:   DefaultUnixIdleTimeout,
+			TransportTCP:    7 * time.Second,
+			TransportMemory: DefaultIdleTimeout,
+			TransportOther:  DefaultIdleTimeout,
+		},
+		ShutdownDrain:      shutdownDrainTimeout,
+		ListenerCloseGrace: time.Second,
+		UserSwitchReset:    20 * time.Second,
+		IdleExit:           time.Hour,
+	}
+	if got.String() != want.String() {
+		t.Errorf("timeouts = %v; want %v", got, want)
+	}
+
+	d := s.Describe()
+	for _, want := range []string{"write-timeout=3s", "tcp:7s", "unix:1m0s", "idle-exit-timeout=1h0m0s"} {
+		if !strings.Contains(d, want) {
+			t.Errorf("Describe() = %q; missing %q", d, want)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/util/mak"
+)
+
+// DefaultTraceDuration is how long a connection trace started without a
+// duration lasts.
+const DefaultTraceDuration = time.Hour
+
+// TraceUser starts logging the full lifecycle of LocalAPI connections from
+// the user with the given userid (a uid, or a SID on Windows) for d, or
+// DefaultTraceDuration if d isn't positive: each connection's accept and
+// identity extraction time, each request's permissions, path, status and
+// duration, and each connection's close. It replaces any trace already
+// running. An empty userID stops tracing.
+//
+// It's for debugging one user's problems on a shared machine without
+// making the logs noisy for everyone.
+func (s *Server) TraceUser(userID string, d time.Duration) {
+	if d <= 0 {
+		d = DefaultTraceDuration
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if userID == "" {
+		if s.traceUserID != "" {
+			s.logf("trace[%s]: stopped", s.traceUserID)
+		}
+		s.traceUserID, s.traceUntil = "", time.Time{}
+		return
+	}
+	s.traceUserID, s.traceUntil = userID, time.Now().Add(d)
+	s.logf("trace[%s]: tracing LocalAPI connections for %v", userID, d)
+}
+
+// ConnTrace returns the running connection trace, if any.
+func (s *Server) ConnTrace() apitype.ConnTrace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireTraceLocked()
+	return apitype.ConnTrace{UserID: s.traceUserID, Until: s.traceUntil}
+}
+
+// expireTraceLocked stops the trace if it has run its course.
+//
+// s.mu must be held.
+func (s *Server) expireTraceLocked() {
+	if s.traceUserID != "" && !time.Now().Before(s.traceUntil) {
+		s.logf("trace[%s]: expired", s.traceUserID)
+		s.traceUserID, s.traceUntil = "", time.Time{}
+	}
+}
+
+// tracing reports whether connections from ci are being traced.
+func (s *Server) tracing(ci *ipnauth.ConnIdentity) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tracingLocked(ci)
+}
+
+// tracingLocked is like tracing, but s.mu must be held.
+func (s *Server) tracingLocked(ci *ipnauth.ConnIdentity) bool {
+	if s.traceUserID == "" {
+		return false
+	}
+	s.expireTraceLocked()
+	return s.traceUserID != "" && connUserID(ci) == s.traceUserID
+}
+
+// tracef logs a trace message about ci's user, which must be traced.
+func (s *Server) tracef(ci *ipnauth.ConnIdentity, format string, args ...any) {
+	s.logf("trace[%s]: %s", connUserID(ci), fmt.Sprintf(format, args...))
+}
+
+// traceConnAccepted logs c's acceptance, if its peer ci is traced, and
+// remembers to log its close.
+func (s *Server) traceConnAccepted(c net.Conn, ci *ipnauth.ConnIdentity, identityLatency time.Duration) {
+	s.mu.Lock()
+	traced := s.tracingLocked(ci)
+	if traced {
+		mak.Set(&s.tracedConns, c, tracedConn{ci, time.Now()})
+	}
+	s.mu.Unlock()
+	if traced {
+		s.tracef(ci, "accepted %s conn from pid %d; identity took %v", transportOf(c), connPID(ci), identityLatency)
+	}
+}
+
+// tracedConn is an open connection whose close is to be traced.
+type tracedConn struct {
+	ci       *ipnauth.ConnIdentity
+	accepted time.Time
+}
+
+// traceConnClosedLocked logs c's close, if its acceptance was traced.
+//
+// s.mu must be held.
+func (s *Server) traceConnClosedLocked(c net.Conn, state http.ConnState) {
+	tc, ok := s.tracedConns[c]
+	if !ok {
+		return
+	}
+	delete(s.tracedConns, c)
+	s.tracef(tc.ci, "%s conn from pid %d %s after %v", transportOf(c), connPID(tc.ci), state, time.Since(tc.accepted).Round(time.Millisecond))
+}
+
+// traceRequest returns w updated to log r's status and duration once the
+// returned func is called, if r's peer ci is traced.
+func (s *Server) traceRequest(w http.ResponseWriter, r *http.Request, ci *ipnauth.ConnIdentity) (_ http.ResponseWriter, done func()) {
+	if !s.tracing(ci) {
+		return w, func() {}
+	}
+	t0 := time.Now()
+	tw := &traceWriter{responseWriter: responseWriter{w}}
+	return tw, func() {
+		code := tw.code
+		if code == 0 {
+			code = http.StatusOK
+		}
+		s.tracef(ci, "%s %s: %d in %v", r.Method, r.URL.Path, code, time.Since(t0).Round(time.Millisecond))
+	}
+}
+
+// traceWriter is an http.ResponseWriter that records the status it
+// sends.
+type traceWriter struct {
+	responseWriter
+	code int
+}
+
+func (w *traceWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *traceWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *traceWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, brw, err := w.responseWriter.Hijack()
+	if err == nil {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return c, brw, err
+}
+
+// traceUserFromEnv starts a trace of the user named by
+// TS_DEBUG_IPNSERVER_TRACE_USER, if set, for
+// TS_DEBUG_IPNSERVER_TRACE_DURATION or DefaultTraceDuration.
+func (s *Server) traceUserFromEnv() {
+	uid := envknob.String("TS_DEBUG_IPNSERVER_TRACE_USER")
+	if uid == "" {
+		return
+	}
+	d, _ := time.ParseDuration(envknob.String("TS_DEBUG_IPNSERVER_TRACE_DURATION"))
+	s.TraceUser(uid, d)
+}
+
+// serveConnTrace reports the running connection trace (GET), starts one
+// (POST with "user" and optional "duration" parameters), or stops it
+// (DELETE).
+func (s *Server) serveConnTrace(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "conn-trace access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		uid := r.FormValue("user")
+		if uid == "" {
+			http.Error(w, "missing user", http.StatusBadRequest)
+			return
+		}
+		var d time.Duration
+		if v := r.FormValue("duration"); v != "" {
+			var err error
+			if d, err = time.ParseDuration(v); err != nil {
+				http.Error(w, "bad duration", http.StatusBadRequest)
+				return
+			}
+		}
+		s.TraceUser(uid, d)
+	case "DELETE":
+		s.TraceUser("", 0)
+	default:
+		http.Error(w, "use GET, POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.ConnTrace())
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestTraceUser(t *testing.T) {
+	s := newTestServer(t)
+	var mu sync.Mutex
+	var traces []string
+	s.logf = func(format string, args ...any) {
+		if msg := fmt.Sprintf(format, args...); strings.HasPrefix(msg, "trace[") {
+			mu.Lock()
+			defer mu.Unlock()
+			traces = append(traces, msg)
+		}
+	}
+	takeTraces := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		ret := traces
+		traces = nil
+		return ret
+	}
+	uids := map[net.Conn]string{}
+	s.IdentityResolver = func(c net.Conn) (*ipnauth.ConnIdentity, error) {
+		return ipnauth.NewUnixConnIdentity(c, 42, uids[c]), nil
+	}
+	// connect opens a conn from uid, makes a request on it, and closes it.
+	connect := func(uid string) {
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		uids[c1] = uid
+		ctx := s.connContext(context.Background(), c1)
+		r := httptest.NewRequest("GET", "/localapi/v0/status", nil).WithContext(ctx)
+		r.Host = apitype.LocalAPIHost
+		s.serveHTTP(httptest.NewRecorder(), r)
+		s.connState(c1, http.StateClosed)
+	}
+
+	s.TraceUser("1001", time.Minute)
+	if ct := s.ConnTrace(); ct.UserID != "1001" || ct.Until.IsZero() {
+		t.Errorf("ConnTrace = %+v; want 1001 with an expiry", ct)
+	}
+	takeTraces()
+
+	connect("1002")
+	if got := takeTraces(); len(got) != 0 {
+		t.Errorf("traces for untraced user: %q", got)
+	}
+
+	connect("1001")
+	got := takeTraces()
+	for _, want := range []string{"accepted", "permitted read=true", "GET /localapi/v0/status: 200", "closed after"} {
+		var found bool
+		for _, msg := range got {
+			if !strings.HasPrefix(msg, "trace[1001]: ") {
+				t.Errorf("trace for the wrong user: %q", msg)
+			}
+			if strings.Contains(msg, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("no %q in traces %q", want, got)
+		}
+	}
+
+	s.TraceUser("1001", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	takeTraces()
+	connect("1001")
+	if got := takeTraces(); len(got) != 1 || !strings.HasSuffix(got[0], "expired") {
+		t.Errorf("traces after expiry = %q; want just the expiry", got)
+	}
+	if ct := s.ConnTrace(); ct.UserID != "" {
+		t.Errorf("ConnTrace after expiry = %+v; want none", ct)
+	}
+}
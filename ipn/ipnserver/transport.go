@@ -0,0 +1,195 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Transport is the kind of connection a LocalAPI client arrived on.
+type Transport string
+
+const (
+	TransportUnix   Transport = "unix"   // unix domain socket
+	TransportTCP    Transport = "tcp"    // localhost TCP, as used on Windows
+	TransportMemory Transport = "memory" // in-process; see NewMemListener
+	TransportOther  Transport = "other"  // anything else, such as a custom listener's conns
+)
+
+// transportOf returns the Transport of c, as accepted from Run's listener.
+func transportOf(c net.Conn) Transport {
+	switch c := c.(type) {
+	case *net.UnixConn:
+		return TransportUnix
+	case *net.TCPConn:
+		return TransportTCP
+	case memConn:
+		return TransportMemory
+	case *trustedConn:
+		return transportOf(c.Conn)
+	}
+	return TransportOther
+}
+
+// listenerTransport returns the Transport of connections accepted from ln.
+func listenerTransport(ln net.Listener) Transport {
+	switch ln := ln.(type) {
+	case *net.UnixListener:
+		return TransportUnix
+	case *net.TCPListener:
+		return TransportTCP
+	case *memListener:
+		return TransportMemory
+	case *trustedListener:
+		return listenerTransport(ln.Listener)
+	case *retryListener:
+		return listenerTransport(ln.Listener)
+	}
+	return TransportOther
+}
+
+// setListenerTransport records t as the Transport of Run's listener and
+// reports it to the backend, if set.
+func (s *Server) setListenerTransport(t Transport) {
+	s.mu.Lock()
+	s.listenerTransport = t
+	s.mu.Unlock()
+	s.reportListenerTransport()
+}
+
+// reportListenerTransport tells the backend, if set, the Transport of
+// Run's listener, if Run has been called.
+func (s *Server) reportListenerTransport() {
+	s.mu.Lock()
+	t := s.listenerTransport
+	s.mu.Unlock()
+	if lb := s.lb.Load(); lb != nil && t != "" {
+		lb.SetLocalAPITransports([]string{string(t)})
+	}
+}
+
+// DefaultIdleTimeout is how long idle keep-alive connections are kept by
+// default. Localhost connections are cheap, so only do keep-alives for a
+// short period of time, as on Windows these active connections lock the
+// server into only serving that user. If the user has this page open, we
+// don't want another switching user to be locked out for minutes. 5
+// seconds is enough to let browser hit favicon.ico and such.
+const DefaultIdleTimeout = 5 * time.Second
+
+// DefaultUnixIdleTimeout is how long idle keep-alive connections on unix
+// sockets are kept by default. Unix sockets aren't subject to the Windows
+// lockout concern, so they're kept longer to reduce churn for clients
+// making a series of requests.
+const DefaultUnixIdleTimeout = time.Minute
+
+// idleTimeout returns how long idle keep-alive connections on transport t
+// are kept open.
+func (s *Server) idleTimeout(t Transport) time.Duration {
+	if d, ok := s.IdleTimeouts[t]; ok {
+		return d
+	}
+	if t == TransportUnix {
+		return DefaultUnixIdleTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+// connState is the http.Server.ConnState hook. It arranges for connections
+// that stay idle longer than their idle timeout to be closed, which
+// http.Server.IdleTimeout can't do as it applies to all connections alike,
+// and counts connections ending for Server.MaxConns.
+//
+// ConnState hooks run on the connection's own goroutine, so an idle timeout
+// is applied there too, as a read deadline (see idleConn), rather than by
+// closing the connection from elsewhere, which could race with a request
+// arriving on it.
+func (s *Server) connState(c net.Conn, state http.ConnState) {
+	ic, _ := c.(*idleConn)
+	if ic != nil {
+		c = ic.Conn
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		// The http.Server's accept loop sets StateNew itself, so this
+		// shows the loop is alive; see Stats.LastAcceptTime.
+		s.lastAccept = time.Now()
+	case http.StateIdle:
+		if ic != nil {
+			ic.setIdle(s.connIdleTimeoutLocked(c))
+		}
+	case http.StateActive:
+		if ic != nil {
+			ic.setActive()
+		}
+	case http.StateHijacked, http.StateClosed:
+		s.noteConnClosedLocked(c)
+		s.traceConnClosedLocked(c, state)
+		delete(s.connIdleTimeouts, c)
+	}
+}
+
+// idleListener is the listener Run serves on. Its connections are
+// idleConns.
+type idleListener struct {
+	net.Listener
+}
+
+func (ln idleListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &idleConn{Conn: c}, nil
+}
+
+// idleConn is a connection accepted by Run, closed by the http.Server once
+// it's been idle for its idle timeout.
+//
+// While it's idle, the http.Server clears its read deadline to wait for the
+// next request indefinitely (as its IdleTimeout isn't set); idleConn
+// replaces that with the idle timeout, so the wait fails and the
+// http.Server closes the connection itself. The Server only sees the
+// wrapped connection: connContext and connState unwrap it.
+type idleConn struct {
+	net.Conn
+	idleTimeout atomic.Int64 // while idle, the idle timeout; else 0
+}
+
+// setIdle marks c idle, to be closed after d, or never if d <= 0.
+func (c *idleConn) setIdle(d time.Duration) {
+	c.idleTimeout.Store(int64(d))
+}
+
+// setActive marks c as no longer idle, clearing its idle deadline. The
+// http.Server sets no other read deadlines between requests, as its
+// ReadTimeout and ReadHeaderTimeout aren't set.
+func (c *idleConn) setActive() {
+	if c.idleTimeout.Swap(0) > 0 {
+		c.Conn.SetReadDeadline(time.Time{})
+	}
+}
+
+// Read reads from c, marking it active once the next request's first
+// bytes arrive, so that the rest of the request isn't subject to the idle
+// timeout.
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.idleTimeout.Load() > 0 {
+		c.setActive()
+	}
+	return n, err
+}
+
+func (c *idleConn) SetReadDeadline(t time.Time) error {
+	if d := time.Duration(c.idleTimeout.Load()); d > 0 && t.IsZero() {
+		t = time.Now().Add(d)
+	}
+	return c.Conn.SetReadDeadline(t)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestIdleTimeoutByTransport(t *testing.T) {
+	s := New(t.Logf, "logid")
+	if got := s.idleTimeout(TransportUnix); got != DefaultUnixIdleTimeout {
+		t.Errorf("unix idle timeout = %v; want %v", got, DefaultUnixIdleTimeout)
+	}
+	if got := s.idleTimeout(TransportTCP); got != DefaultIdleTimeout {
+		t.Errorf("tcp idle timeout = %v; want %v", got, DefaultIdleTimeout)
+	}
+	s.IdleTimeouts = map[Transport]time.Duration{TransportTCP: time.Second}
+	if got := s.idleTimeout(TransportTCP); got != time.Second {
+		t.Errorf("configured tcp idle timeout = %v; want 1s", got)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	for _, tt := range []struct {
+		c    net.Conn
+		want Transport
+	}{
+		{new(net.UnixConn), TransportUnix},
+		{new(net.TCPConn), TransportTCP},
+		{memConn{c1}, TransportMemory},
+		{c1, TransportOther},
+	} {
+		if got := transportOf(tt.c); got != tt.want {
+			t.Errorf("transportOf(%T) = %q; want %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestIdleConnClosed(t *testing.T) {
+	s := New(t.Logf, "logid")
+	s.IdleTimeouts = map[Transport]time.Duration{TransportMemory: 50 * time.Millisecond}
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	c, err := dial(ctx, "tcp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	io.WriteString(c, "GET /localapi/v0/status HTTP/1.1\r\nHost: local-tailscaled.sock\r\n\r\n")
+	br := bufio.NewReader(c)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("read on idle conn = %v; want io.EOF from server closing it", err)
+	}
+}
+
+// TestIdleTimeoutEndsWithRequest tests that a request arriving on an idle
+// connection isn't cut off by the connection's idle timeout, even if the
+// request is still being read when the timeout would have passed.
+func TestIdleTimeoutEndsWithRequest(t *testing.T) {
+	const idle = 200 * time.Millisecond
+	s := New(t.Logf, "logid")
+	s.IdleTimeouts = map[Transport]time.Duration{TransportMemory: idle}
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	c, err := dial(ctx, "tcp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	const req = "GET /localapi/v0/status HTTP/1.1\r\nHost: local-tailscaled.sock\r\n\r\n"
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			// Start the next request within the idle timeout, but
+			// finish it after the timeout would have passed.
+			time.Sleep(idle / 2)
+			io.WriteString(c, req[:10])
+			time.Sleep(idle)
+			io.WriteString(c, req[10:])
+		} else {
+			io.WriteString(c, req)
+		}
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+}
+
+func TestListenerTransportReported(t *testing.T) {
+	memLn, _ := NewMemListener()
+	defer memLn.Close()
+	if got := listenerTransport(TrustedListener(memLn, nil)); got != TransportMemory {
+		t.Errorf("trusted mem listener transport = %q; want %q", got, TransportMemory)
+	}
+
+	for _, tt := range []struct {
+		network string
+		want    string
+	}{
+		{"unix", "unix"},
+		{"tcp", "tcp"},
+	} {
+		t.Run(tt.network, func(t *testing.T) {
+			addr := "127.0.0.1:0"
+			if tt.network == "unix" {
+				if runtime.GOOS == "windows" {
+					t.Skip("no unix sockets")
+				}
+				addr = filepath.Join(t.TempDir(), "tailscaled.sock")
+			}
+			ln, err := net.Listen(tt.network, addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newTestServer(t)
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error, 1)
+			go func() { errc <- s.Run(ctx, ln) }()
+			defer func() {
+				cancel()
+				<-errc
+			}()
+			lb := s.mustBackend()
+			waitFor(t, "backend to be told the transport", func() bool {
+				return slices.Equal(lb.LocalAPITransports(), []string{tt.want})
+			})
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"net"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+// TrustedListener returns ln wrapped for passing to Server.Run so that the
+// Server doesn't extract the identities of its connections' peers from the
+// OS, which is pointless and may fail for listeners like in-memory ones,
+// but takes them as given.
+//
+// If identity is nil, every connection's peer is granted full access, as
+// with ipnauth.TrustedConnIdentity. Otherwise identity is called once per
+// accepted connection to return its peer's identity, which must not be nil,
+// and the peer gets the access that identity would normally get.
+//
+// The Server can't verify that the listener is trustworthy. Only use a
+// TrustedListener for listeners that no process less trusted than this one
+// can connect to, such as in-memory ones (see NewMemListener, whose
+// connections are already trusted): a TrustedListener on a socket other
+// users can reach gives them control of tailscaled.
+func TrustedListener(ln net.Listener, identity func(net.Conn) *ipnauth.ConnIdentity) net.Listener {
+	return &trustedListener{ln, identity}
+}
+
+type trustedListener struct {
+	net.Listener
+	identity func(net.Conn) *ipnauth.ConnIdentity // or nil for full access
+}
+
+func (ln *trustedListener) Accept() (net.Conn, error) {
+	c, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := &trustedConn{Conn: c}
+	if ln.identity != nil {
+		tc.ci = ln.identity(c)
+	} else {
+		tc.ci = ipnauth.TrustedConnIdentity(tc)
+	}
+	return tc, nil
+}
+
+// trustedConn is a connection accepted from a trustedListener.
+type trustedConn struct {
+	net.Conn
+	ci *ipnauth.ConnIdentity // its peer's identity, as given to TrustedListener
+}
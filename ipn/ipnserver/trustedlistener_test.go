@@ -0,0 +1,64 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/net/nettest"
+)
+
+func TestTrustedListener(t *testing.T) {
+	tests := []struct {
+		name     string
+		identity func(net.Conn) *ipnauth.ConnIdentity
+		want     int
+	}{
+		{"full-access", nil, http.StatusOK},
+		{"given-identity", func(c net.Conn) *ipnauth.ConnIdentity {
+			return ipnauth.NewUnixConnIdentity(c, 1, "4242")
+		}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.IdentityResolver = func(net.Conn) (*ipnauth.ConnIdentity, error) {
+				t.Error("IdentityResolver called for a trusted listener's conn")
+				return nil, nil
+			}
+			ln := nettest.Listen("trusted.mem")
+			ctx, cancel := context.WithCancel(context.Background())
+			errc := make(chan error, 1)
+			go func() { errc <- s.Run(ctx, TrustedListener(ln, tt.identity)) }()
+			defer func() {
+				cancel()
+				<-errc
+			}()
+
+			hc := &http.Client{Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return ln.Dial(ctx, "tcp", "trusted.mem")
+				},
+			}}
+			defer hc.CloseIdleConnections()
+			// Listing watchers requires write access.
+			res, err := hc.Get("http://" + apitype.LocalAPIHost + "/localapi/v0/watchers/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("status %d; want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
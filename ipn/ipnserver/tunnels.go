@@ -0,0 +1,124 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/mak"
+)
+
+var (
+	metricProxyTunnelsActive       = clientmetric.NewGauge("ipnserver_proxy_tunnels_active")
+	metricProxyTunnelBytesSent     = clientmetric.NewCounter("ipnserver_proxy_tunnel_bytes_sent")
+	metricProxyTunnelBytesReceived = clientmetric.NewCounter("ipnserver_proxy_tunnel_bytes_received")
+)
+
+// proxyTunnel is an active CONNECT tunnel; see handleProxyConnectConn.
+type proxyTunnel struct {
+	id      int64
+	target  string
+	ci      *ipnauth.ConnIdentity // or nil if unknown
+	started time.Time
+
+	sent, recv atomic.Int64
+}
+
+// registerTunnel records a new CONNECT tunnel to target opened by ci,
+// returning it and a func to call when it closes.
+func (s *Server) registerTunnel(target string, ci *ipnauth.ConnIdentity) (_ *proxyTunnel, unregister func()) {
+	s.mu.Lock()
+	s.lastTunnelID++
+	t := &proxyTunnel{
+		id:      s.lastTunnelID,
+		target:  target,
+		ci:      ci,
+		started: time.Now(),
+	}
+	mak.Set(&s.tunnels, t.id, t)
+	s.mu.Unlock()
+	metricProxyTunnelsActive.Add(1)
+
+	return t, func() {
+		s.mu.Lock()
+		delete(s.tunnels, t.id)
+		s.noteActivityLocked()
+		s.mu.Unlock()
+		metricProxyTunnelsActive.Add(-1)
+	}
+}
+
+// ProxyTunnels returns the server's active CONNECT tunnels, oldest first.
+// Only Windows uses them; elsewhere it's always empty.
+func (s *Server) ProxyTunnels() []apitype.ProxyTunnel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]apitype.ProxyTunnel, 0, len(s.tunnels))
+	for _, t := range s.tunnels {
+		var username string
+		if t.ci != nil {
+			if u := t.ci.User(); u != nil {
+				username = u.Username
+			}
+		}
+		ret = append(ret, apitype.ProxyTunnel{
+			ID:            t.id,
+			Target:        t.target,
+			UserID:        connUserID(t.ci),
+			Username:      username,
+			PID:           connPID(t.ci),
+			Started:       t.started,
+			BytesSent:     t.sent.Load(),
+			BytesReceived: t.recv.Load(),
+		})
+	}
+	slices.SortFunc(ret, func(a, b apitype.ProxyTunnel) bool { return a.ID < b.ID })
+	return ret
+}
+
+// countingWriter is an io.Writer that adds the number of bytes written
+// through it to n, total and metric.
+type countingWriter struct {
+	w      io.Writer
+	n      *atomic.Int64 // this tunnel's bytes in this direction
+	total  *atomic.Int64 // all tunnels' bytes in this direction
+	metric *clientmetric.Metric
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n.Add(int64(n))
+	cw.total.Add(int64(n))
+	cw.metric.Add(int64(n))
+	return n, err
+}
+
+// serveProxyTunnels serves the active CONNECT tunnels as JSON.
+func (s *Server) serveProxyTunnels(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "proxy-tunnels access denied", http.StatusForbidden)
+		return
+	}
+	if envknob.GOOS() != "windows" {
+		http.Error(w, "CONNECT tunnels are only used on Windows", http.StatusNotImplemented)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.ProxyTunnels())
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os/user"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/logpolicy"
+)
+
+// waitFor polls cond until it's true, failing t if that takes too long.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// fakeExitNode makes the backend's prefs select an exit node, or not, for
+// the rest of t.
+func fakeExitNode(t *testing.T, inUse bool) {
+	old := backendPrefs
+	backendPrefs = func(lb *ipnlocal.LocalBackend) ipn.PrefsView {
+		p := old(lb).AsStruct()
+		if p == nil {
+			p = ipn.NewPrefs()
+		}
+		p.ExitNodeIP = netip.Addr{}
+		p.ExitNodeID = ""
+		if inUse {
+			p.ExitNodeID = "n-exit"
+		}
+		return p.View()
+	}
+	t.Cleanup(func() { backendPrefs = old })
+}
+
+func TestProxyTunnels(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	fakeExitNode(t, true)
+	s := newTestServer(t)
+	backC, logServer := net.Pipe()
+	defer logServer.Close()
+	s.testProxyDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return backC, nil
+	}
+	ts := httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	defer ts.Close()
+
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	target := net.JoinHostPort(logpolicy.LogHost(), "443")
+	fmt.Fprintf(c, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	br := bufio.NewReader(c)
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("CONNECT status = %v; want 200", res.Status)
+	}
+
+	io.WriteString(c, "hello")
+	if _, err := io.ReadFull(logServer, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(logServer, "hi")
+	if _, err := io.ReadFull(br, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "tunnel byte counts", func() bool {
+		tt := s.ProxyTunnels()
+		return len(tt) == 1 && tt[0].BytesSent == 5 && tt[0].BytesReceived == 2
+	})
+	if got := s.ProxyTunnels()[0].Target; got != target {
+		t.Errorf("Target = %q; want %q", got, target)
+	}
+
+	c.Close()
+	waitFor(t, "tunnel to be removed", func() bool { return len(s.ProxyTunnels()) == 0 })
+}
+
+func TestProxyConnectExitNodeBeforeNetmap(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	fakeExitNode(t, true)
+	s := newTestServer(t)
+	// The test backend has no netmap, so its status can't show the exit
+	// node, but the prefs already route traffic through it.
+	if st, err := s.status(s.mustBackend()); err != nil || st.ExitNodeStatus != nil {
+		t.Fatalf("status = %v, %v; want no ExitNodeStatus without a netmap", st, err)
+	}
+	dialed := false
+	s.testProxyDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = true
+		return nil, errors.New("no log server in tests")
+	}
+
+	target := net.JoinHostPort(logpolicy.LogHost(), "443")
+	r := httptest.NewRequest("CONNECT", target, nil)
+	r.RequestURI = target
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	if !dialed || rec.Code != http.StatusBadGateway {
+		t.Errorf("dialed = %v, status %d %q; want a dial attempt and 502", dialed, rec.Code, rec.Body.String())
+	}
+}
+
+func TestProxyConnectNoExitNode(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	fakeExitNode(t, false)
+	s := newTestServer(t)
+	s.testProxyDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		t.Error("unexpected dial with no exit node in use")
+		return nil, errors.New("unexpected dial")
+	}
+
+	target := net.JoinHostPort(logpolicy.LogHost(), "443")
+	r := httptest.NewRequest("CONNECT", target, nil)
+	r.RequestURI = target
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d; want 503", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "no exit node in use") {
+		t.Errorf("body %q doesn't give the reason", body)
+	}
+	if n := len(s.ProxyTunnels()); n != 0 {
+		t.Errorf("%d tunnels open; want 0", n)
+	}
+}
+
+// hijackRecorder is an httptest.ResponseRecorder that can be hijacked,
+// handing over conn.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+func TestProxyTunnelByteTotals(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	fakeExitNode(t, true)
+	s := newTestServer(t)
+	backC, logServer := net.Pipe()
+	defer logServer.Close()
+	s.testProxyDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return backC, nil
+	}
+	sentBefore, recvBefore := metricProxyTunnelBytesSent.Value(), metricProxyTunnelBytesReceived.Value()
+
+	serverC, clientC := net.Pipe()
+	defer clientC.Close()
+	target := net.JoinHostPort(logpolicy.LogHost(), "443")
+	r := httptest.NewRequest("CONNECT", target, nil)
+	r.RequestURI = target
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		s.handleProxyConnectConn(hijackRecorder{httptest.NewRecorder(), serverC}, r)
+	}()
+
+	br := bufio.NewReader(clientC)
+	if res, err := http.ReadResponse(br, nil); err != nil || res.StatusCode != 200 {
+		t.Fatalf("CONNECT response = %v, %v; want 200", res, err)
+	}
+	io.WriteString(clientC, "hello")
+	if _, err := io.ReadFull(logServer, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(logServer, "hi!")
+	if _, err := io.ReadFull(br, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	clientC.Close()
+	<-done
+
+	// The totals outlive the tunnel.
+	if n := len(s.ProxyTunnels()); n != 0 {
+		t.Errorf("%d tunnels open after close; want 0", n)
+	}
+	// The copy to the client may still be counting its last write.
+	waitFor(t, "proxy byte totals", func() bool {
+		st := s.Stats()
+		return st.ProxyBytesSent == 5 && st.ProxyBytesReceived == 3
+	})
+	if got := metricProxyTunnelBytesSent.Value() - sentBefore; got != 5 {
+		t.Errorf("bytes sent metric increased by %d; want 5", got)
+	}
+	if got := metricProxyTunnelBytesReceived.Value() - recvBefore; got != 3 {
+		t.Errorf("bytes received metric increased by %d; want 3", got)
+	}
+}
+
+func TestProxyTunnelAudit(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	fakeExitNode(t, true)
+	s := newTestServer(t)
+	var events []AuditEvent
+	s.AuditSinks = []AuditSink{AuditFunc(func(e AuditEvent) { events = append(events, e) })}
+	backC, logServer := net.Pipe()
+	defer logServer.Close()
+	s.testProxyDial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return backC, nil
+	}
+
+	ci := ipnauth.NewWindowsConnIdentity(nil, 42, "S-1-5-21-alice", &user.User{Uid: "S-1-5-21-alice", Username: `CORP\alice`})
+	serverC, clientC := net.Pipe()
+	defer clientC.Close()
+	target := net.JoinHostPort(logpolicy.LogHost(), "443")
+	r := httptest.NewRequest("CONNECT", target, nil)
+	r.RequestURI = target
+	r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, ci))
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		s.handleProxyConnectConn(hijackRecorder{httptest.NewRecorder(), serverC}, r)
+	}()
+	if res, err := http.ReadResponse(bufio.NewReader(clientC), nil); err != nil || res.StatusCode != 200 {
+		t.Fatalf("CONNECT response = %v, %v; want 200", res, err)
+	}
+
+	waitFor(t, "tunnel", func() bool { return len(s.ProxyTunnels()) == 1 })
+	if tt := s.ProxyTunnels()[0]; tt.Username != `CORP\alice` || tt.PID != 42 {
+		t.Errorf("tunnel opened by %q pid %d; want CORP\\alice pid 42", tt.Username, tt.PID)
+	}
+	clientC.Close()
+	<-done
+
+	if len(events) != 1 {
+		t.Fatalf("got %d audit events; want 1: %v", len(events), events)
+	}
+	e := events[0]
+	if e.Kind != AuditProxyTunnel || e.Target != target {
+		t.Errorf("event = %v %q; want %v %q", e.Kind, e.Target, AuditProxyTunnel, target)
+	}
+	if e.Principal.UserID != "S-1-5-21-alice" || e.Principal.PID != 42 {
+		t.Errorf("event principal = %v; want S-1-5-21-alice pid 42", e.Principal)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"os/user"
+
+	"tailscale.com/util/mak"
+)
+
+// lookupUser looks up a user by username. It's a variable for tests.
+var lookupUser = user.Lookup
+
+// usernameLookupReliable is whether lookupUser sees every user. It doesn't
+// in builds using os/user's pure Go implementation, as in static builds
+// without cgo, which only reads /etc/passwd and so misses users from NSS
+// sources like LDAP. Such builds still resolve users in /etc/passwd; only
+// their failed lookups get a hint. It's a variable for tests.
+var usernameLookupReliable = nativeUserLookup
+
+// errUsernameLookupUnreliable is wrapped by userIDFromString's errors for
+// usernames that couldn't be found in builds where usernameLookupReliable
+// is false.
+var errUsernameLookupUnreliable = errors.New("users from sources like LDAP can't be resolved in this build of tailscaled (os/user without cgo); use a numeric uid instead")
+
+// warnUnresolvedUser logs, once per setting and value, that the username v
+// in the given setting couldn't be resolved, with err from
+// userIDFromString.
+func (s *Server) warnUnresolvedUser(setting, v string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := setting + "=" + v
+	if s.unresolvedUsersWarned[key] {
+		return
+	}
+	mak.Set(&s.unresolvedUsersWarned, key, true)
+	s.logf("warning: %s=%q is ignored: %v", setting, v, err)
+}
@@ -0,0 +1,10 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows && (!cgo || osusergo)
+
+package ipnserver
+
+// nativeUserLookup is whether os/user uses the platform's own user lookup.
+const nativeUserLookup = false
@@ -0,0 +1,76 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"errors"
+	"fmt"
+	"os/user"
+	"strings"
+	"sync"
+	"testing"
+
+	"inet.af/peercred"
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestUserIDFromStringLookupUnavailable(t *testing.T) {
+	oldLookup, oldReliable := lookupUser, usernameLookupReliable
+	t.Cleanup(func() { lookupUser, usernameLookupReliable = oldLookup, oldReliable })
+	lookupUser = func(name string) (*user.User, error) {
+		if name == "www-data" { // as in /etc/passwd
+			return &user.User{Uid: "33", Username: name}, nil
+		}
+		return nil, user.UnknownUserError(name)
+	}
+
+	usernameLookupReliable = true
+	if uid, err := userIDFromString("caddy"); err == nil || errors.Is(err, errUsernameLookupUnreliable) {
+		t.Errorf("failed lookup: userIDFromString = %q, %v; want a plain error", uid, err)
+	}
+
+	// Builds without cgo still resolve the users os/user can see, and
+	// only add a hint when it can't.
+	usernameLookupReliable = false
+	if uid, err := userIDFromString("www-data"); uid != "33" || err != nil {
+		t.Errorf("pure Go lookup: userIDFromString = %q, %v; want 33, nil", uid, err)
+	}
+	if _, err := userIDFromString("caddy"); !errors.Is(err, errUsernameLookupUnreliable) {
+		t.Errorf("pure Go failed lookup: err = %v; want it to wrap %v", err, errUsernameLookupUnreliable)
+	}
+	if uid, err := userIDFromString("998"); uid != "998" || err != nil {
+		t.Errorf("numeric uid: userIDFromString = %q, %v; want 998, nil", uid, err)
+	}
+
+	old := peerCredsUserID
+	peerCredsUserID = func(*peercred.Creds) (string, bool) { return "998", true }
+	t.Cleanup(func() { peerCredsUserID = old })
+	ci := ipnauth.NewUnixCredsConnIdentity(nil, &peercred.Creds{})
+	s := newTestServer(t)
+	var mu sync.Mutex
+	var warnings []string
+	s.logf = func(format string, args ...any) {
+		if msg := fmt.Sprintf(format, args...); strings.HasPrefix(msg, "warning:") {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings = append(warnings, msg)
+		}
+	}
+
+	t.Setenv("TS_PERMIT_CERT_UID", "caddy")
+	for i := 0; i < 2; i++ {
+		if ok, err := s.connCanFetchCerts(ci); ok || err != nil {
+			t.Errorf("by username: connCanFetchCerts = %v, %v; want false, nil", ok, err)
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "TS_PERMIT_CERT_UID") || !strings.Contains(warnings[0], "numeric uid") {
+		t.Errorf("warnings = %q; want one about TS_PERMIT_CERT_UID needing a numeric uid", warnings)
+	}
+
+	t.Setenv("TS_PERMIT_CERT_UID", "998")
+	if ok, err := s.connCanFetchCerts(ci); !ok || err != nil {
+		t.Errorf("by uid: connCanFetchCerts = %v, %v; want true, nil", ok, err)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/localapi"
+)
+
+// maxUserSwitches is how many recent user switches the Server remembers.
+const maxUserSwitches = 32
+
+// noteUserSwitchLocked records that the user of the server changed from
+// prev (empty if none before) to uid.
+//
+// s.mu must be held.
+func (s *Server) noteUserSwitchLocked(prev, uid ipn.WindowsUserID, reset bool) {
+	if len(s.userSwitches) == maxUserSwitches {
+		copy(s.userSwitches, s.userSwitches[1:])
+		s.userSwitches = s.userSwitches[:maxUserSwitches-1]
+	}
+	s.userSwitches = append(s.userSwitches, apitype.UserSwitch{
+		Time:       time.Now(),
+		PrevUserID: string(prev),
+		UserID:     string(uid),
+		Reset:      reset,
+	})
+}
+
+// UserSwitches returns the most recent changes of the user using the
+// server (which only happen on Windows), oldest first. Only userids are
+// recorded.
+func (s *Server) UserSwitches() []apitype.UserSwitch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]apitype.UserSwitch(nil), s.userSwitches...)
+}
+
+// serveUserSwitches serves the Server's UserSwitches as JSON.
+func (s *Server) serveUserSwitches(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "user-switches access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.UserSwitches())
+}
+
+// setUserAttempts is how many times setCurrentUserIDLocked tries to switch
+// the backend to a user, and setUserRetryDelay how long it waits between
+// tries, growing linearly.
+const (
+	setUserAttempts   = 3
+	setUserRetryDelay = 20 * time.Millisecond
+)
+
+// setCurrentUserID is LocalBackend.SetCurrentUserID. It's a variable for
+// tests.
+var setCurrentUserID = (*ipnlocal.LocalBackend).SetCurrentUserID
+
+// setUserError is the error for requests denied because the backend
+// couldn't switch to their user.
+type setUserError struct{ error }
+
+func (e setUserError) Unwrap() error { return e.error }
+
+// setCurrentUserIDLocked tells lb that the server is now serving ci's
+// user, retrying a few times in case of transient failures, such as reading
+// its profile from the state store. If it still fails, it returns a
+// setUserError, and the request must be rejected rather than served with
+// the backend in some other user's state, or none.
+//
+// It releases s.mu while waiting to retry, checking again that ci may
+// connect once it's reacquired; meanwhile, other requests wait in
+// waitForSetUserLocked.
+//
+// s.mu must be held.
+func (s *Server) setCurrentUserIDLocked(lb *ipnlocal.LocalBackend, ci *ipnauth.ConnIdentity) error {
+	uid := ci.WindowsUserID()
+	done := make(chan struct{})
+	s.settingUserDone = done
+	defer func() {
+		s.settingUserDone = nil
+		close(done)
+	}()
+	var err error
+	for i := 0; i < setUserAttempts; i++ {
+		if i > 0 {
+			s.mu.Unlock()
+			time.Sleep(time.Duration(i) * setUserRetryDelay)
+			s.mu.Lock()
+			if err := s.checkConnIdentityLocked(ci); err != nil {
+				return err
+			}
+		}
+		if err = setCurrentUserID(lb, uid); err == nil {
+			return nil
+		}
+		s.logf("switching backend to user %q (attempt %d/%d): %v", uid, i+1, setUserAttempts, err)
+	}
+	return setUserError{fmt.Errorf("tailscaled couldn't switch to your user: %w", err)}
+}
+
+// waitForSetUserLocked waits for any setCurrentUserIDLocked in progress to
+// finish, so that no request is served while the backend may be in another
+// user's state. It returns ctx's error if ctx is done first.
+//
+// s.mu must be held. It's released while waiting.
+func (s *Server) waitForSetUserLocked(ctx context.Context) error {
+	for s.settingUserDone != nil {
+		done := s.settingUserDone
+		s.mu.Unlock()
+		select {
+		case <-done:
+			s.mu.Lock()
+		case <-ctx.Done():
+			s.mu.Lock()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/ipnlocal"
+)
+
+func TestUserSwitches(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	s := newTestServer(t)
+	s.resetOnZero = false
+	use := func(uid ipn.WindowsUserID) {
+		t.Helper()
+		ci := ipnauth.NewWindowsConnIdentity(nil, 1, uid, nil)
+		onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/localapi/v0/status", nil), ci)
+		if err != nil {
+			t.Fatalf("request from %s: %v", uid, err)
+		}
+		onDone()
+	}
+
+	use("S-1-5-21-alice")
+	use("S-1-5-21-alice") // not a switch
+	use("S-1-5-21-bob")
+	use("S-1-5-21-alice")
+	got := s.UserSwitches()
+	want := []struct {
+		prev, uid string
+		reset     bool
+	}{
+		{"", "S-1-5-21-alice", false},
+		{"S-1-5-21-alice", "S-1-5-21-bob", true},
+		{"S-1-5-21-bob", "S-1-5-21-alice", true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d switches %+v; want %d", len(got), got, len(want))
+	}
+	for i, w := range want {
+		g := got[i]
+		if g.PrevUserID != w.prev || g.UserID != w.uid || g.Reset != w.reset || g.Time.IsZero() {
+			t.Errorf("switch %d = %+v; want %+v", i, g, w)
+		}
+	}
+
+	// The history is bounded, keeping the most recent.
+	for i := 0; i < maxUserSwitches+5; i++ {
+		use(ipn.WindowsUserID(fmt.Sprintf("S-1-5-21-%d", i)))
+	}
+	got = s.UserSwitches()
+	if len(got) != maxUserSwitches {
+		t.Fatalf("got %d switches; want %d", len(got), maxUserSwitches)
+	}
+	if last := got[len(got)-1].UserID; last != fmt.Sprintf("S-1-5-21-%d", maxUserSwitches+4) {
+		t.Errorf("most recent switch to %s; want the last user", last)
+	}
+}
+
+func TestSetCurrentUserIDFails(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	var calls, failures int
+	old := setCurrentUserID
+	setCurrentUserID = func(lb *ipnlocal.LocalBackend, uid ipn.WindowsUserID) error {
+		calls++
+		if calls <= failures {
+			return errors.New("state store unavailable")
+		}
+		return old(lb, uid)
+	}
+	defer func() { setCurrentUserID = old }()
+
+	s := newTestServer(t)
+	s.resetOnZero = false
+	alice := ipnauth.NewWindowsConnIdentity(nil, 1, "S-1-5-21-alice", nil)
+
+	// A transient failure is retried.
+	failures = 1
+	onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/localapi/v0/status", nil), alice)
+	if err != nil {
+		t.Fatalf("after a transient failure: %v", err)
+	}
+	onDone()
+	if calls != 2 {
+		t.Errorf("SetCurrentUserID called %d times; want 2", calls)
+	}
+
+	// A persistent one rejects the request.
+	bob := ipnauth.NewWindowsConnIdentity(nil, 2, "S-1-5-21-bob", nil)
+	calls, failures = 0, setUserAttempts
+	before := metricRejectedSetUserFailed.Value()
+	r := httptest.NewRequest("GET", "/localapi/v0/status", nil)
+	r = r.WithContext(context.WithValue(r.Context(), connIdentityContextKey{}, bob))
+	rec := httptest.NewRecorder()
+	s.serveHTTP(rec, r)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "couldn't switch to your user") {
+		t.Errorf("status %d, %q; want 500 explaining the failure", rec.Code, rec.Body.Bytes())
+	}
+	if calls != setUserAttempts {
+		t.Errorf("SetCurrentUserID called %d times; want %d", calls, setUserAttempts)
+	}
+	if got := metricRejectedSetUserFailed.Value() - before; got != 1 {
+		t.Errorf("set_user_failed rejections increased by %d; want 1", got)
+	}
+	if n := s.Stats().ActiveRequests; n != 0 {
+		t.Errorf("%d active requests after rejection; want 0", n)
+	}
+	if sw := s.UserSwitches(); len(sw) != 1 || sw[0].UserID != "S-1-5-21-alice" {
+		t.Errorf("user switches = %+v; want only alice's", sw)
+	}
+}
+
+func TestSetCurrentUserIDRetryReleasesLock(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	s := newTestServer(t)
+	s.resetOnZero = false
+	var calls int
+	lockedAfter := make(chan int, 1) // calls made when another goroutine got s.mu
+	old := setCurrentUserID
+	setCurrentUserID = func(lb *ipnlocal.LocalBackend, uid ipn.WindowsUserID) error {
+		calls++
+		if calls == 1 {
+			go func() {
+				s.mu.Lock()
+				lockedAfter <- calls
+				s.mu.Unlock()
+			}()
+			return errors.New("state store unavailable")
+		}
+		return old(lb, uid)
+	}
+	defer func() { setCurrentUserID = old }()
+
+	alice := ipnauth.NewWindowsConnIdentity(nil, 1, "S-1-5-21-alice", nil)
+	onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/localapi/v0/status", nil), alice)
+	if err != nil {
+		t.Fatalf("after a transient failure: %v", err)
+	}
+	onDone()
+	if n := <-lockedAfter; n != 1 {
+		t.Errorf("server lock acquired after %d attempts; want 1, while waiting to retry", n)
+	}
+}
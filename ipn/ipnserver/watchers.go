@@ -0,0 +1,214 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/localapi"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/strs"
+)
+
+// watcherPaths are the LocalAPI paths whose requests are long-lived
+// subscriptions ("watchers") rather than one-shot calls.
+var watcherPaths = map[string]bool{
+	"/localapi/v0/watch-ipn-bus": true,
+}
+
+// watcher is an active LocalAPI subscription.
+type watcher struct {
+	id      int64
+	path    string
+	ci      *ipnauth.ConnIdentity
+	started time.Time
+	cancel  context.CancelFunc
+	done    chan struct{} // closed once unregistered
+
+	closeReason CloseReason // why the server ended it, if it did; guarded by Server.mu
+}
+
+// registerWatcher records r as an active watcher subscription. It returns
+// a request to use in place of r whose context is canceled if the watcher
+// is terminated, and a func to call when the subscription ends. The
+// unregister func writes a final apitype.StreamClosed message to rw if the
+// server ended the subscription and r asked for one with
+// apitype.StreamClosedHeader. The watcher's ID is sent in the
+// apitype.WatcherIDHeader response header.
+func (s *Server) registerWatcher(rw http.ResponseWriter, r *http.Request, ci *ipnauth.ConnIdentity) (_ *http.Request, unregister func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	s.mu.Lock()
+	s.lastWatcherID++
+	w := &watcher{
+		id:      s.lastWatcherID,
+		path:    r.URL.Path,
+		ci:      ci,
+		started: time.Now(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	mak.Set(&s.watchers, w.id, w)
+	s.mu.Unlock()
+	rw.Header().Set(apitype.WatcherIDHeader, strconv.FormatInt(w.id, 10))
+	wantClosed := r.Header.Get(apitype.StreamClosedHeader) == "1"
+
+	var once sync.Once
+	return r.WithContext(ctx), func() {
+		once.Do(func() {
+			if reason := s.watcherCloseReason(w); reason != "" && wantClosed {
+				writeCloseReason(rw, reason)
+			}
+			s.mu.Lock()
+			delete(s.watchers, w.id)
+			s.mu.Unlock()
+			cancel()
+			close(w.done)
+		})
+	}
+}
+
+// Watchers returns the server's active watcher subscriptions, oldest first.
+func (s *Server) Watchers() []apitype.Watcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]apitype.Watcher, 0, len(s.watchers))
+	for _, w := range s.watchers {
+		ret = append(ret, apitype.Watcher{
+			ID:      w.id,
+			Path:    w.path,
+			UserID:  connUserID(w.ci),
+			PID:     connPID(w.ci),
+			Started: w.started,
+		})
+	}
+	slices.SortFunc(ret, func(a, b apitype.Watcher) bool { return a.ID < b.ID })
+	return ret
+}
+
+// TerminateWatcher ends the watcher subscription with the given ID,
+// reporting whether it was found.
+func (s *Server) TerminateWatcher(id int64) bool {
+	s.mu.Lock()
+	w, ok := s.watchers[id]
+	if ok {
+		w.closeReason = CloseTerminated
+	}
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	s.logf("terminating watcher %d (%s, %s) after %v", id, w.path, describeConnIdentity(w.ci), time.Since(w.started).Round(time.Second))
+	w.cancel()
+	return true
+}
+
+// serveWatchers lists active watchers (GET /localapi/v0/watchers/) or
+// terminates one by ID (DELETE /localapi/v0/watchers/<id>).
+func (s *Server) serveWatchers(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "watchers access denied", http.StatusForbidden)
+		return
+	}
+	suffix, ok := strs.CutPrefix(r.URL.Path, "/localapi/v0/watchers/")
+	if !ok {
+		http.Error(w, "misconfigured", http.StatusInternalServerError)
+		return
+	}
+	if suffix == "" {
+		if r.Method != "GET" {
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Watchers())
+		return
+	}
+	if r.Method != "DELETE" {
+		http.Error(w, "use DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(suffix, 10, 64)
+	if err != nil {
+		http.Error(w, "bad watcher ID", http.StatusBadRequest)
+		return
+	}
+	if !s.TerminateWatcher(id) {
+		http.Error(w, "watcher not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var (
+	errWatcherNotFound = errors.New("watcher not found")
+	errNotWatcherOwner = errors.New("watcher belongs to another user")
+)
+
+// closeWatcher ends the watcher with the given ID at its own request,
+// once it's done sending its current message, and waits until its final
+// apitype.StreamClosed message has been written and it's unregistered.
+// Only the watcher's own user, or one with write access, may close it.
+func (s *Server) closeWatcher(ctx context.Context, id int64, userID string, permitWrite bool) error {
+	s.mu.Lock()
+	w, ok := s.watchers[id]
+	if ok && !permitWrite && (userID == "" || userID != connUserID(w.ci)) {
+		s.mu.Unlock()
+		return errNotWatcherOwner
+	}
+	if ok && w.closeReason == "" {
+		w.closeReason = CloseRequested
+	}
+	s.mu.Unlock()
+	if !ok {
+		return errWatcherNotFound
+	}
+	w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// serveWatcherClose handles POST /localapi/v0/watcher-close?id=<id>, with
+// which a watcher asks to end its own subscription gracefully. The request
+// completes once the watcher's stream has ended with an
+// apitype.StreamClosed message, which the client can read before closing
+// its connection.
+func (s *Server) serveWatcherClose(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "watcher-close access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad watcher ID", http.StatusBadRequest)
+		return
+	}
+	switch err := s.closeWatcher(r.Context(), id, requestPrincipal(r).UserID, h.PermitWrite); err {
+	case nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errWatcherNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errNotWatcherOwner:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	}
+}
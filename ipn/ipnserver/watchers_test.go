@@ -0,0 +1,214 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/localapi"
+)
+
+func TestWatchers(t *testing.T) {
+	s := &Server{logf: t.Logf}
+	h := localapi.NewHandler(nil, t.Logf, "")
+	h.PermitRead, h.PermitWrite = true, true
+
+	list := func() []apitype.Watcher {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.serveWatchers(h, rec, httptest.NewRequest("GET", "/localapi/v0/watchers/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list: status %d, %s", rec.Code, rec.Body.Bytes())
+		}
+		var ws []apitype.Watcher
+		if err := json.Unmarshal(rec.Body.Bytes(), &ws); err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+
+	watchRec := httptest.NewRecorder()
+	r, unregister := s.registerWatcher(watchRec, newWatchRequest(true), &ipnauth.ConnIdentity{})
+	defer unregister()
+	ws := list()
+	if len(ws) != 1 || ws[0].Path != "/localapi/v0/watch-ipn-bus" || ws[0].Started.IsZero() {
+		t.Fatalf("watchers = %+v; want the one registered watcher", ws)
+	}
+
+	rec := httptest.NewRecorder()
+	s.serveWatchers(h, rec, httptest.NewRequest("DELETE", "/localapi/v0/watchers/12345", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("terminate unknown watcher: status %d; want 404", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	s.serveWatchers(h, rec, httptest.NewRequest("DELETE", "/localapi/v0/watchers/"+strconv.FormatInt(ws[0].ID, 10), nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("terminate: status %d, %s", rec.Code, rec.Body.Bytes())
+	}
+	select {
+	case <-r.Context().Done():
+	default:
+		t.Fatal("watcher context not canceled after termination")
+	}
+
+	unregister()
+	if ws := list(); len(ws) != 0 {
+		t.Errorf("watchers after unregister = %+v; want none", ws)
+	}
+	if got, want := watchRec.Body.String(), `{"closed":"terminated"}`+"\n"; got != want {
+		t.Errorf("terminated watcher got %q; want %q", got, want)
+	}
+
+	h.PermitWrite = false
+	rec = httptest.NewRecorder()
+	s.serveWatchers(h, rec, httptest.NewRequest("GET", "/localapi/v0/watchers/", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("read-only list: status %d; want 403", rec.Code)
+	}
+}
+
+func TestWatcherCloseReasonOnShutdown(t *testing.T) {
+	s := newTestServer(t)
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	lc := &tailscale.LocalClient{Dial: dial}
+	bw, err := lc.WatchIPNBus(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("WatchIPNBus: %v", err)
+	}
+	defer bw.Close()
+	waitFor(t, "watcher to register", func() bool { return len(s.Watchers()) == 1 })
+
+	cancel()
+	for {
+		_, err := bw.Next()
+		if err == nil {
+			continue
+		}
+		var ce *tailscale.StreamClosedError
+		if !errors.As(err, &ce) {
+			t.Fatalf("Next = %v; want a StreamClosedError", err)
+		}
+		if ce.Reason != string(CloseShutdown) {
+			t.Errorf("close reason = %q; want %q", ce.Reason, CloseShutdown)
+		}
+		break
+	}
+}
+
+// newWatchRequest returns an IPN bus watch request, which asks for a final
+// apitype.StreamClosed message if wantClosed.
+func newWatchRequest(wantClosed bool) *http.Request {
+	r := httptest.NewRequest("GET", "/localapi/v0/watch-ipn-bus", nil)
+	if wantClosed {
+		r.Header.Set(apitype.StreamClosedHeader, "1")
+	}
+	return r
+}
+
+// TestWatcherStreamClosedOptIn tests that a client that doesn't ask for
+// the final apitype.StreamClosed message, as older clients that decode
+// every message as an ipn.Notify don't, doesn't get one.
+func TestWatcherStreamClosedOptIn(t *testing.T) {
+	s := &Server{logf: t.Logf}
+	watchRec := httptest.NewRecorder()
+	_, unregister := s.registerWatcher(watchRec, newWatchRequest(false), &ipnauth.ConnIdentity{})
+	json.NewEncoder(watchRec).Encode(ipn.Notify{Version: "1.2.3"})
+	if len(s.Watchers()) != 1 || !s.TerminateWatcher(s.Watchers()[0].ID) {
+		t.Fatal("terminating watcher failed")
+	}
+	unregister()
+
+	dec := json.NewDecoder(watchRec.Body)
+	var got []ipn.Notify
+	for {
+		var n ipn.Notify
+		if err := dec.Decode(&n); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		got = append(got, n)
+	}
+	if len(got) != 1 || got[0].Version != "1.2.3" {
+		t.Errorf("old-style decoder got %+v; want just the one notification", got)
+	}
+}
+
+func TestCloseWatcher(t *testing.T) {
+	s := &Server{logf: t.Logf}
+	watchRec := httptest.NewRecorder()
+	r, unregister := s.registerWatcher(watchRec, newWatchRequest(true), ipnauth.NewUnixConnIdentity(nil, 1, "1001"))
+	id, err := strconv.ParseInt(watchRec.Header().Get(apitype.WatcherIDHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("watcher ID header: %v", err)
+	}
+
+	if err := s.closeWatcher(context.Background(), id, "1002", false); err != errNotWatcherOwner {
+		t.Errorf("close by another user = %v; want %v", err, errNotWatcherOwner)
+	}
+	if err := s.closeWatcher(context.Background(), id+1, "1001", false); err != errWatcherNotFound {
+		t.Errorf("close of unknown watcher = %v; want %v", err, errWatcherNotFound)
+	}
+
+	// Act as the watcher's handler, which returns once its context is done.
+	go func() {
+		<-r.Context().Done()
+		unregister()
+	}()
+	if err := s.closeWatcher(context.Background(), id, "1001", false); err != nil {
+		t.Fatalf("close by owner: %v", err)
+	}
+	if ws := s.Watchers(); len(ws) != 0 {
+		t.Errorf("watchers after close = %+v; want none", ws)
+	}
+	if got, want := watchRec.Body.String(), `{"closed":"requested"}`+"\n"; got != want {
+		t.Errorf("closed watcher got %q; want %q", got, want)
+	}
+}
+
+func TestWatcherCloseGracefully(t *testing.T) {
+	s := newTestServer(t)
+	ln, dial := NewMemListener()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx, ln) }()
+	defer func() {
+		cancel()
+		<-errc
+	}()
+
+	lc := &tailscale.LocalClient{Dial: dial}
+	bw, err := lc.WatchIPNBus(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("WatchIPNBus: %v", err)
+	}
+	waitFor(t, "watcher to register", func() bool { return len(s.Watchers()) == 1 })
+	if err := bw.CloseGracefully(context.Background()); err != nil {
+		t.Fatalf("CloseGracefully: %v", err)
+	}
+	if ws := s.Watchers(); len(ws) != 0 {
+		t.Errorf("watchers after graceful close = %+v; want none", ws)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import "tailscale.com/envknob"
+
+// isWindowsService reports whether this process is running as a Windows
+// service. It's a variable for tests.
+var isWindowsService = isWindowsServiceOS
+
+// windowsService reports whether the Server is running as, or on behalf
+// of, a Windows service: per s.WindowsService if set, else as detected.
+// It's always false on other platforms.
+func (s *Server) windowsService() bool {
+	if envknob.GOOS() != "windows" {
+		return false
+	}
+	if v, ok := s.WindowsService.Get(); ok {
+		return v
+	}
+	s.winServiceOnce.Do(func() {
+		v, err := isWindowsService()
+		if err != nil {
+			s.logf("detecting whether running as a Windows service: %v", err)
+		}
+		s.winService = v
+	})
+	return s.winService
+}
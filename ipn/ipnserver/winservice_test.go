@@ -0,0 +1,64 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/ipn/ipnauth"
+)
+
+func TestWindowsService(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	for _, service := range []bool{false, true} {
+		name := "interactive"
+		if service {
+			name = "service"
+		}
+		t.Run(name, func(t *testing.T) {
+			old := isWindowsService
+			isWindowsService = func() (bool, error) { return service, nil }
+			defer func() { isWindowsService = old }()
+
+			s := newTestServer(t)
+			s.ServiceServerMode = true
+			resets := 0
+			s.testResetBackend = func() { resets++ }
+
+			if got := s.Stats().WindowsService; got != service {
+				t.Errorf("Stats().WindowsService = %v; want %v", got, service)
+			}
+			if got := s.Features()["windows-service"]; got != service {
+				t.Errorf("windows-service feature = %v; want %v", got, service)
+			}
+
+			// With ServiceServerMode, the last client disconnecting only
+			// resets the backend when not running as a service.
+			ci := ipnauth.NewWindowsConnIdentity(nil, 1, "S-1-5-21-alice", nil)
+			onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/localapi/v0/status", nil), ci)
+			if err != nil {
+				t.Fatal(err)
+			}
+			onDone()
+			if wantResets := map[bool]int{false: 1, true: 0}[service]; resets != wantResets {
+				t.Errorf("got %d resets on last disconnect; want %d", resets, wantResets)
+			}
+		})
+	}
+}
+
+func TestWindowsServiceOverride(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	old := isWindowsService
+	isWindowsService = func() (bool, error) { return false, nil }
+	defer func() { isWindowsService = old }()
+
+	s := New(t.Logf, "logid")
+	s.WindowsService = "true"
+	if !s.windowsService() {
+		t.Error("windowsService() = false with WindowsService set true")
+	}
+}
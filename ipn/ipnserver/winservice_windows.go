@@ -0,0 +1,11 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import "golang.org/x/sys/windows/svc"
+
+func isWindowsServiceOS() (bool, error) {
+	return svc.IsWindowsService()
+}
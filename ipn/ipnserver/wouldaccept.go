@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/ipn/localapi"
+)
+
+// pidIdentity returns the identity of a connection from process pid, as
+// getConnIdentity would determine it. It's a variable for tests.
+var pidIdentity = ipnauth.ConnIdentityForPID
+
+// WouldAccept reports whether a LocalAPI connection from process pid would
+// currently be accepted, and if not, why. It applies the same checks as
+// connContext and serveHTTP to the identity pid would have, without a
+// connection.
+func (s *Server) WouldAccept(pid int) apitype.WouldAccept {
+	ret := apitype.WouldAccept{PID: pid}
+	ci, err := pidIdentity(s.logf, pid)
+	if err != nil {
+		ret.Reason = err.Error()
+		return ret
+	}
+	if u := ci.User(); u != nil {
+		ret.Username = u.Username
+	}
+	if err := s.checkAllowedUser(ci); err != nil {
+		ret.Reason = err.Error()
+		return ret
+	}
+	if err := s.checkClientLineage(ci); err != nil {
+		ret.Reason = err.Error()
+		return ret
+	}
+	s.mu.Lock()
+	err = s.checkConnIdentityLocked(ci)
+	s.mu.Unlock()
+	if err != nil {
+		ret.Reason = err.Error()
+		var iu inUseOtherUserError
+		if errors.As(err, &iu) {
+			ret.OtherUser, ret.OtherPID = iu.otherUser, iu.otherPID
+		}
+		return ret
+	}
+	ret.Accepted = true
+	return ret
+}
+
+// serveWouldAccept serves WouldAccept for the process given by the "pid"
+// query parameter as JSON.
+func (s *Server) serveWouldAccept(h *localapi.Handler, w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "would-accept access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	pid, err := strconv.Atoi(r.FormValue("pid"))
+	if err != nil || pid <= 0 {
+		http.Error(w, "invalid or missing pid", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.WouldAccept(pid))
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os/user"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnauth"
+	"tailscale.com/types/logger"
+)
+
+func TestWouldAccept(t *testing.T) {
+	t.Setenv("TS_DEBUG_FAKE_GOOS", "windows")
+	owners := map[int]string{
+		123: "alice",
+		456: "bob",
+	}
+	old := pidIdentity
+	pidIdentity = func(_ logger.Logf, pid int) (*ipnauth.ConnIdentity, error) {
+		name, ok := owners[pid]
+		if !ok {
+			return nil, fmt.Errorf("no process %d", pid)
+		}
+		uid := ipn.WindowsUserID("S-1-5-21-" + name)
+		return ipnauth.NewWindowsConnIdentity(nil, pid, uid, &user.User{Uid: string(uid), Username: name}), nil
+	}
+	t.Cleanup(func() { pidIdentity = old })
+
+	s := newTestServer(t)
+	alice, _ := pidIdentity(t.Logf, 123)
+	onDone, err := s.addActiveHTTPRequest(httptest.NewRequest("GET", "/", nil), alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer onDone()
+
+	if got := s.WouldAccept(123); !got.Accepted || got.Username != "alice" {
+		t.Errorf("WouldAccept(alice) = %+v; want accepted", got)
+	}
+	got := s.WouldAccept(456)
+	if got.Accepted || got.Reason == "" {
+		t.Errorf("WouldAccept(bob) = %+v; want rejected with reason", got)
+	}
+	if got.OtherUser != "alice" || got.OtherPID != 123 {
+		t.Errorf("WouldAccept(bob) holder = %q, %d; want alice, 123", got.OtherUser, got.OtherPID)
+	}
+	if got := s.WouldAccept(789); got.Accepted || got.Reason == "" {
+		t.Errorf("WouldAccept(unknown) = %+v; want rejected with reason", got)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultWriteTimeout is the default value of Server.WriteTimeout.
+const DefaultWriteTimeout = 30 * time.Second
+
+// withWriteTimeout returns w wrapped such that each write to the response
+// for r must complete within s.WriteTimeout, if set. Streaming (watcher)
+// responses are returned unwrapped, and any deadline left on the
+// connection by an earlier request is cleared for them.
+//
+// Rather than one deadline for the whole response, the deadline is
+// pushed out before each write, so handlers that legitimately take a
+// long time between writes (such as those waiting on a request body)
+// aren't cut off. What's bounded is a client not reading.
+func (s *Server) withWriteTimeout(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	c, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	if c == nil {
+		return w
+	}
+	if s.WriteTimeout <= 0 || watcherPaths[r.URL.Path] {
+		c.SetWriteDeadline(time.Time{})
+		return w
+	}
+	dw := &deadlineWriter{responseWriter: responseWriter{w}, c: c, timeout: s.WriteTimeout}
+	dw.extend()
+	return dw
+}
+
+// deadlineWriter is an http.ResponseWriter that extends its connection's
+// write deadline before each write.
+//
+// The deadline is deliberately left in place after the handler returns,
+// as net/http flushes the final buffered part of the response after that.
+type deadlineWriter struct {
+	responseWriter
+	c       net.Conn
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) extend() {
+	w.c.SetWriteDeadline(time.Now().Add(w.timeout))
+}
+
+func (w *deadlineWriter) WriteHeader(code int) {
+	w.extend()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.extend()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *deadlineWriter) Flush() {
+	if w.canFlush() {
+		w.extend()
+	}
+	w.responseWriter.Flush()
+}
+
+// Hijack hijacks the connection, clearing the write deadline first, as the
+// hijacker's subsequent use of the conn is no longer a response.
+func (w *deadlineWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, brw, err := w.responseWriter.Hijack()
+	if err == nil {
+		c.SetWriteDeadline(time.Time{})
+	}
+	return c, brw, err
+}
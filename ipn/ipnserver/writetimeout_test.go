@@ -0,0 +1,102 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipnserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// oneConnListener is a net.Listener that returns c once and then blocks
+// until closed.
+type oneConnListener struct {
+	c      chan net.Conn
+	closed chan struct{}
+}
+
+func newOneConnListener(c net.Conn) *oneConnListener {
+	ln := &oneConnListener{c: make(chan net.Conn, 1), closed: make(chan struct{})}
+	ln.c <- c
+	return ln
+}
+
+func (ln *oneConnListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.c:
+		return c, nil
+	case <-ln.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (ln *oneConnListener) Close() error {
+	select {
+	case <-ln.closed:
+	default:
+		close(ln.closed)
+	}
+	return nil
+}
+
+func (ln *oneConnListener) Addr() net.Addr { return &net.UnixAddr{Name: "fake", Net: "unix"} }
+
+func TestWriteTimeout(t *testing.T) {
+	s := &Server{logf: t.Logf, WriteTimeout: 100 * time.Millisecond}
+	writeErr := make(chan error, 1)
+	hs := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w = s.withWriteTimeout(w, r)
+			w.WriteHeader(200)
+			// net.Pipe writes block until read, and the client
+			// never reads, so this blocks until the deadline.
+			_, err := w.Write(bytes.Repeat([]byte("x"), 1<<20))
+			writeErr <- err
+		}),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, connContextKey{}, c)
+		},
+	}
+	sc, cc := net.Pipe()
+	defer cc.Close()
+	ln := newOneConnListener(sc)
+	go hs.Serve(ln)
+	defer hs.Close()
+
+	go io.WriteString(cc, "GET /localapi/v0/status HTTP/1.1\r\nHost: local-tailscaled.sock\r\n\r\n")
+
+	select {
+	case err := <-writeErr:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("write error = %v; want deadline exceeded", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("write to non-reading client didn't time out")
+	}
+}
+
+func TestWriteTimeoutExemptsWatchers(t *testing.T) {
+	s := &Server{WriteTimeout: time.Second}
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+	ctx := context.WithValue(context.Background(), connContextKey{}, sc)
+	for path, wantWrapped := range map[string]bool{
+		"/localapi/v0/status":        true,
+		"/localapi/v0/watch-ipn-bus": false,
+	} {
+		r, _ := http.NewRequestWithContext(ctx, "GET", "http://local-tailscaled.sock"+path, nil)
+		_, wrapped := s.withWriteTimeout(nil, r).(*deadlineWriter)
+		if wrapped != wantWrapped {
+			t.Errorf("%s: wrapped = %v; want %v", path, wrapped, wantWrapped)
+		}
+	}
+}
@@ -65,6 +65,41 @@ type Status struct {
 
 	Peer map[key.NodePublic]*PeerStatus
 	User map[tailcfg.UserID]tailcfg.UserProfile
+
+	// PeerCount is the number of peers. It's only populated for LocalAPI
+	// status requests selecting the "peercount" field group, which is
+	// cheaper than fetching Peer.
+	PeerCount int `json:",omitempty"`
+
+	// ClientConn describes the LocalAPI connection of the client that
+	// requested this status, if known.
+	ClientConn *ClientConnStatus `json:",omitempty"`
+
+	// LocalAPIConns is the number of open LocalAPI connections, and
+	// LocalAPIConnsPeak the most there have been at once, as last reported
+	// by the IPN server. Both are zero if the server doesn't report them.
+	LocalAPIConns     int `json:",omitempty"`
+	LocalAPIConnsPeak int `json:",omitempty"`
+}
+
+// ClientConnStatus describes a LocalAPI client's own connection to
+// tailscaled, so that GUIs can show how they're connected and what they
+// may do.
+type ClientConnStatus struct {
+	// Transport is how the client connected: "unix" (socket), "tcp"
+	// (localhost, as on Windows), "memory" (in-process) or "other".
+	Transport string
+
+	// UserID is the client's userid (its SID on Windows, or its uid
+	// elsewhere), if known.
+	UserID string `json:",omitempty"`
+
+	// Username is the client's username, if known.
+	Username string `json:",omitempty"`
+
+	// CanRead and CanWrite are whether the client may use the read-only
+	// and the mutating LocalAPI endpoints, respectively.
+	CanRead, CanWrite bool
 }
 
 // TKAKey describes a key trusted by network lock.
@@ -150,6 +185,60 @@ func (s *Status) Peers() []key.NodePublic {
 	return kk
 }
 
+// StatusSummary is the subset of Status describing only this node and its
+// tailnet. Unlike the full Status, its size doesn't grow with the number of
+// peers, so it's cheap to build and send.
+type StatusSummary struct {
+	// Version is the daemon's long version (see version.Long).
+	Version string
+
+	// BackendState is an ipn.State string value, as in Status.
+	BackendState string
+
+	AuthURL      string       // current URL provided by control to authorize client
+	TailscaleIPs []netip.Addr // Tailscale IP(s) assigned to this node
+	Self         *PeerStatus
+
+	// ExitNodeStatus describes the current exit node.
+	// If nil, an exit node is not in use.
+	ExitNodeStatus *ExitNodeStatus `json:"ExitNodeStatus,omitempty"`
+
+	// Health contains health check problems.
+	Health []string
+
+	// CurrentTailnet is information about the tailnet that the node
+	// is currently connected to. When not connected, this field is nil.
+	CurrentTailnet *TailnetStatus
+
+	// ClientConn describes the LocalAPI connection of the client that
+	// requested this status, if known.
+	ClientConn *ClientConnStatus `json:",omitempty"`
+
+	// LocalAPIConns is the number of open LocalAPI connections, and
+	// LocalAPIConnsPeak the most there have been at once, as last reported
+	// by the IPN server. Both are zero if the server doesn't report them.
+	LocalAPIConns     int `json:",omitempty"`
+	LocalAPIConnsPeak int `json:",omitempty"`
+}
+
+// Summary returns the StatusSummary portion of s.
+func (s *Status) Summary() *StatusSummary {
+	return &StatusSummary{
+		Version:        s.Version,
+		BackendState:   s.BackendState,
+		AuthURL:        s.AuthURL,
+		TailscaleIPs:   s.TailscaleIPs,
+		Self:           s.Self,
+		ExitNodeStatus: s.ExitNodeStatus,
+		Health:         s.Health,
+		CurrentTailnet: s.CurrentTailnet,
+		ClientConn:     s.ClientConn,
+
+		LocalAPIConns:     s.LocalAPIConns,
+		LocalAPIConnsPeak: s.LocalAPIConnsPeak,
+	}
+}
+
 type PeerStatusLite struct {
 	// TxBytes/RxBytes is the total number of bytes transmitted to/received from this peer.
 	TxBytes, RxBytes int64
@@ -410,7 +499,143 @@ type StatusUpdater interface {
 	UpdateStatus(*StatusBuilder)
 }
 
+// StatusPage is the data shown on the HTML status page, separated from its
+// presentation so that it can also be served as JSON. See Status.Page.
+type StatusPage struct {
+	// TailscaleIPs are this node's Tailscale IPs.
+	TailscaleIPs []string
+
+	// Viewer describes who's viewing the page, if known. See
+	// Status.ClientConn.
+	Viewer *StatusPageViewer `json:",omitempty"`
+
+	// Peers are the peers shown, in the order shown. Sharee nodes are
+	// omitted.
+	Peers []StatusPagePeer
+}
+
+// StatusPageViewer describes who's viewing the status page.
+type StatusPageViewer struct {
+	Who       string // username, else userid, else "unknown user"
+	Transport string // as in ClientConnStatus.Transport
+	Access    string // "none", "read-only" or "read, write"
+}
+
+// StatusPagePeer is a row of the status page's peer table.
+type StatusPagePeer struct {
+	PublicKey string // in short form, like "[abcde]"
+	OS        string
+
+	// DNSName is the peer's MagicDNS name without the tailnet suffix.
+	DNSName string
+
+	// HostName is the peer's sanitized hostname, set only for peers of
+	// this node's user whose hostname differs from DNSName.
+	HostName string `json:",omitempty"`
+
+	TailAddr string // first Tailscale IP, if any
+	Owner    string // login name of the peer's user, without its domain
+
+	RxBytes int64
+	TxBytes int64
+
+	// LastWrite is when data was last sent to the peer, if ever.
+	LastWrite time.Time
+
+	// ConnType is how an active peer is reached, "relay" or "direct",
+	// with ConnAddr being the DERP region or endpoint. Both are empty
+	// if the peer isn't active.
+	ConnType string `json:",omitempty"`
+	ConnAddr string `json:",omitempty"`
+}
+
+// Page returns the data shown on the HTML status page for st.
+func (st *Status) Page() *StatusPage {
+	p := &StatusPage{
+		TailscaleIPs: make([]string, 0, len(st.TailscaleIPs)),
+		Peers:        []StatusPagePeer{},
+	}
+	for _, ip := range st.TailscaleIPs {
+		p.TailscaleIPs = append(p.TailscaleIPs, ip.String())
+	}
+
+	if cc := st.ClientConn; cc != nil {
+		who := cc.Username
+		if who == "" {
+			who = cc.UserID
+		}
+		if who == "" {
+			who = "unknown user"
+		}
+		access := "none"
+		switch {
+		case cc.CanWrite:
+			access = "read, write"
+		case cc.CanRead:
+			access = "read-only"
+		}
+		p.Viewer = &StatusPageViewer{Who: who, Transport: cc.Transport, Access: access}
+	}
+
+	var peers []*PeerStatus
+	for _, peer := range st.Peers() {
+		ps := st.Peer[peer]
+		if ps.ShareeNode {
+			continue
+		}
+		peers = append(peers, ps)
+	}
+	SortPeers(peers)
+
+	for _, ps := range peers {
+		var owner string
+		if up, ok := st.User[ps.UserID]; ok {
+			owner = up.LoginName
+			if i := strings.Index(owner, "@"); i != -1 {
+				owner = owner[:i]
+			}
+		}
+
+		hostName := dnsname.SanitizeHostname(ps.HostName)
+		dnsName := dnsname.TrimSuffix(ps.DNSName, st.MagicDNSSuffix)
+		if strings.EqualFold(dnsName, hostName) || ps.UserID != st.Self.UserID {
+			hostName = ""
+		}
+
+		var tailAddr string
+		if len(ps.TailscaleIPs) > 0 {
+			tailAddr = ps.TailscaleIPs[0].String()
+		}
+
+		pp := StatusPagePeer{
+			PublicKey: ps.PublicKey.ShortString(),
+			OS:        ps.OS,
+			DNSName:   dnsName,
+			HostName:  hostName,
+			TailAddr:  tailAddr,
+			Owner:     owner,
+			RxBytes:   ps.RxBytes,
+			TxBytes:   ps.TxBytes,
+			LastWrite: ps.LastWrite,
+		}
+		if ps.Active {
+			if ps.Relay != "" && ps.CurAddr == "" {
+				pp.ConnType, pp.ConnAddr = "relay", ps.Relay
+			} else if ps.CurAddr != "" {
+				pp.ConnType, pp.ConnAddr = "direct", ps.CurAddr
+			}
+		}
+		p.Peers = append(p.Peers, pp)
+	}
+	return p
+}
+
 func (st *Status) WriteHTML(w io.Writer) {
+	st.Page().WriteHTML(w)
+}
+
+// WriteHTML writes p as an HTML page to w.
+func (p *StatusPage) WriteHTML(w io.Writer) {
 	f := func(format string, args ...any) { fmt.Fprintf(w, format, args...) }
 
 	f(`<!DOCTYPE html>
@@ -438,11 +663,11 @@ func (st *Status) WriteHTML(w io.Writer) {
 	//f("<p><b>logid:</b> %s</p>\n", logid)
 	//f("<p><b>opts:</b> <code>%s</code></p>\n", html.EscapeString(fmt.Sprintf("%+v", opts)))
 
-	ips := make([]string, 0, len(st.TailscaleIPs))
-	for _, ip := range st.TailscaleIPs {
-		ips = append(ips, ip.String())
+	f("<p>Tailscale IP: %s", strings.Join(p.TailscaleIPs, ", "))
+
+	if v := p.Viewer; v != nil {
+		f("<p>Viewing as: %s via %s; access: %s</p>\n", html.EscapeString(v.Who), html.EscapeString(v.Transport), v.Access)
 	}
-	f("<p>Tailscale IP: %s", strings.Join(ips, ", "))
 
 	f("<table>\n<thead>\n")
 	f("<tr><th>Peer</th><th>OS</th><th>Node</th><th>Owner</th><th>Rx</th><th>Tx</th><th>Activity</th><th>Connection</th></tr>\n")
@@ -450,67 +675,36 @@ func (st *Status) WriteHTML(w io.Writer) {
 
 	now := time.Now()
 
-	var peers []*PeerStatus
-	for _, peer := range st.Peers() {
-		ps := st.Peer[peer]
-		if ps.ShareeNode {
-			continue
-		}
-		peers = append(peers, ps)
-	}
-	SortPeers(peers)
-
-	for _, ps := range peers {
+	for _, pp := range p.Peers {
 		var actAgo string
-		if !ps.LastWrite.IsZero() {
-			ago := now.Sub(ps.LastWrite)
+		if !pp.LastWrite.IsZero() {
+			ago := now.Sub(pp.LastWrite)
 			actAgo = ago.Round(time.Second).String() + " ago"
 			if ago < 5*time.Minute {
 				actAgo = "<b>" + actAgo + "</b>"
 			}
 		}
-		var owner string
-		if up, ok := st.User[ps.UserID]; ok {
-			owner = up.LoginName
-			if i := strings.Index(owner, "@"); i != -1 {
-				owner = owner[:i]
-			}
-		}
-
-		hostName := dnsname.SanitizeHostname(ps.HostName)
-		dnsName := dnsname.TrimSuffix(ps.DNSName, st.MagicDNSSuffix)
-		if strings.EqualFold(dnsName, hostName) || ps.UserID != st.Self.UserID {
-			hostName = ""
-		}
 		var hostNameHTML string
-		if hostName != "" {
-			hostNameHTML = "<br>" + html.EscapeString(hostName)
+		if pp.HostName != "" {
+			hostNameHTML = "<br>" + html.EscapeString(pp.HostName)
 		}
 
-		var tailAddr string
-		if len(ps.TailscaleIPs) > 0 {
-			tailAddr = ps.TailscaleIPs[0].String()
-		}
 		f("<tr><td>%s</td><td class=acenter>%s</td>"+
 			"<td><b>%s</b>%s<div class=\"tailaddr\">%s</div></td><td class=\"acenter owner\">%s</td><td class=\"aright\">%v</td><td class=\"aright\">%v</td><td class=\"aright\">%v</td>",
-			ps.PublicKey.ShortString(),
-			osEmoji(ps.OS),
-			html.EscapeString(dnsName),
+			pp.PublicKey,
+			osEmoji(pp.OS),
+			html.EscapeString(pp.DNSName),
 			hostNameHTML,
-			tailAddr,
-			html.EscapeString(owner),
-			ps.RxBytes,
-			ps.TxBytes,
+			pp.TailAddr,
+			html.EscapeString(pp.Owner),
+			pp.RxBytes,
+			pp.TxBytes,
 			actAgo,
 		)
 		f("<td>")
 
-		if ps.Active {
-			if ps.Relay != "" && ps.CurAddr == "" {
-				f("relay <b>%s</b>", html.EscapeString(ps.Relay))
-			} else if ps.CurAddr != "" {
-				f("direct <b>%s</b>", html.EscapeString(ps.CurAddr))
-			}
+		if pp.ConnType != "" {
+			f("%s <b>%s</b>", pp.ConnType, html.EscapeString(pp.ConnAddr))
 		}
 
 		f("</td>") // end Addrs
@@ -0,0 +1,112 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/mak"
+)
+
+var (
+	metricBufferedResponseBytes    = clientmetric.NewGauge("localapi_buffered_response_bytes")
+	metricBufferedResponseRejected = clientmetric.NewCounter("localapi_buffered_response_rejected")
+)
+
+// ResponseBuffers accounts for the memory held by LocalAPI responses that
+// are buffered in full before being written, such as those served with an
+// ETag. It's shared by the Handlers of one server so a burst of concurrent
+// large responses can't grow memory without bound.
+//
+// Memory is reserved before a response is encoded, sized by the last
+// response to the same path, so that at the limit no more is allocated.
+// Responses too large to ever fit are written without being buffered.
+//
+// A nil *ResponseBuffers accounts for memory in metrics but never refuses
+// a response.
+type ResponseBuffers struct {
+	// Max, if positive, is the most bytes that may be held in buffered
+	// responses at once. Responses that would exceed it are refused with
+	// 503 Service Unavailable until memory is freed.
+	// It must not be changed once the ResponseBuffers is in use.
+	Max int64
+
+	inUse atomic.Int64
+
+	mu       sync.Mutex
+	lastSize map[string]int64 // by request path; see sizeHint
+}
+
+// minResponseReservation is the least reserved for a response before it's
+// encoded.
+const minResponseReservation = 4 << 10
+
+// sizeHint returns how many bytes to reserve for a response to path before
+// encoding it: the size of the last one, or minResponseReservation.
+func (b *ResponseBuffers) sizeHint(path string) int64 {
+	if b == nil {
+		return minResponseReservation
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n := b.lastSize[path]; n > minResponseReservation {
+		return n
+	}
+	return minResponseReservation
+}
+
+// noteSize records that a response to path was n bytes.
+func (b *ResponseBuffers) noteSize(path string, n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	mak.Set(&b.lastSize, path, n)
+}
+
+// tooBig reports whether a response of n bytes could never be buffered
+// within Max.
+func (b *ResponseBuffers) tooBig(n int64) bool {
+	return b != nil && b.Max > 0 && n > b.Max
+}
+
+// InUse returns the number of bytes currently held in buffered responses.
+func (b *ResponseBuffers) InUse() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.inUse.Load()
+}
+
+// acquire reserves n bytes, reporting whether doing so kept within Max.
+// If it returns true, the caller must call release(n) once the bytes are
+// no longer held.
+func (b *ResponseBuffers) acquire(n int64) bool {
+	if b != nil {
+		for {
+			cur := b.inUse.Load()
+			if b.Max > 0 && cur+n > b.Max {
+				metricBufferedResponseRejected.Add(1)
+				return false
+			}
+			if b.inUse.CompareAndSwap(cur, cur+n) {
+				break
+			}
+		}
+	}
+	metricBufferedResponseBytes.Add(n)
+	return true
+}
+
+// release returns n bytes reserved by acquire.
+func (b *ResponseBuffers) release(n int64) {
+	if b != nil {
+		b.inUse.Add(-n)
+	}
+	metricBufferedResponseBytes.Add(-n)
+}
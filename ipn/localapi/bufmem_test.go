@@ -0,0 +1,132 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// blockingWriter is a ResponseWriter whose body writes block until
+// unblock is closed, keeping its buffered response held in memory.
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	writing chan bool     // sent to when a body write starts
+	unblock chan struct{} // closed to let writes proceed
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.writing <- true
+	<-w.unblock
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestResponseBuffersLimit(t *testing.T) {
+	big := map[string]string{"data": strings.Repeat("x", 10<<10)}
+	rb := &ResponseBuffers{Max: 25 << 10}
+	h := &Handler{ResponseBuffers: rb}
+	req := func() *http.Request { return httptest.NewRequest("GET", "/localapi/v0/status", nil) }
+
+	// Hold two large responses mid-write; together they're within the
+	// ceiling, but a third isn't.
+	const held = 2
+	unblock := make(chan struct{})
+	var wg sync.WaitGroup
+	recs := make([]*blockingWriter, held)
+	for i := range recs {
+		recs[i] = &blockingWriter{httptest.NewRecorder(), make(chan bool, 1), unblock}
+		wg.Add(1)
+		go func(w *blockingWriter) {
+			defer wg.Done()
+			h.serveJSONWithETag(w, req(), big)
+		}(recs[i])
+	}
+	for _, w := range recs {
+		<-w.writing
+	}
+	if got := rb.InUse(); got <= 20<<10 || got > rb.Max {
+		t.Errorf("InUse with %d held = %d; want in (20KiB, %d]", held, got, rb.Max)
+	}
+
+	rec := httptest.NewRecorder()
+	h.serveJSONWithETag(rec, req(), big)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("over ceiling: status = %d; want 503", rec.Code)
+	}
+
+	close(unblock)
+	wg.Wait()
+	for i, w := range recs {
+		if w.Code != http.StatusOK {
+			t.Errorf("held response %d: status = %d; want 200", i, w.Code)
+		}
+	}
+	if got := rb.InUse(); got != 0 {
+		t.Errorf("InUse after responses finished = %d; want 0", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.serveJSONWithETag(rec, req(), big)
+	if rec.Code != http.StatusOK {
+		t.Errorf("after memory freed: status = %d; want 200", rec.Code)
+	}
+}
+
+// countingMarshaler counts how many times it's encoded.
+type countingMarshaler struct{ calls *int }
+
+func (m countingMarshaler) MarshalJSON() ([]byte, error) {
+	*m.calls++
+	return []byte(`"x"`), nil
+}
+
+func TestResponseBuffersReserveBeforeEncoding(t *testing.T) {
+	rb := &ResponseBuffers{Max: 8 << 10}
+	h := &Handler{ResponseBuffers: rb}
+	if !rb.acquire(rb.Max) {
+		t.Fatal("acquire of Max failed")
+	}
+	var calls int
+	rec := httptest.NewRecorder()
+	h.serveJSONWithETag(rec, httptest.NewRequest("GET", "/localapi/v0/status", nil), countingMarshaler{&calls})
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("at ceiling: status = %d; want 503", rec.Code)
+	}
+	if calls != 0 {
+		t.Errorf("at ceiling: response encoded %d times; want 0", calls)
+	}
+
+	rb.release(rb.Max)
+	rec = httptest.NewRecorder()
+	h.serveJSONWithETag(rec, httptest.NewRequest("GET", "/localapi/v0/status", nil), countingMarshaler{&calls})
+	if rec.Code != http.StatusOK || calls != 1 {
+		t.Errorf("below ceiling: status = %d, encoded %d times; want 200, 1", rec.Code, calls)
+	}
+}
+
+func TestResponseBuffersOversize(t *testing.T) {
+	big := map[string]string{"data": strings.Repeat("x", 10<<10)}
+	rb := &ResponseBuffers{Max: 8 << 10}
+	h := &Handler{ResponseBuffers: rb}
+
+	// A response larger than the ceiling is never refused, but can't be
+	// buffered for an ETag, the first time or after.
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.serveJSONWithETag(rec, httptest.NewRequest("GET", "/localapi/v0/status", nil), big)
+		if rec.Code != http.StatusOK || rec.Body.Len() <= 10<<10 {
+			t.Errorf("attempt %d: status = %d with %d bytes; want 200 with the whole response", i, rec.Code, rec.Body.Len())
+		}
+		if etag := rec.Header().Get("ETag"); etag != "" {
+			t.Errorf("attempt %d: ETag = %q; want none", i, etag)
+		}
+	}
+	if got := rb.InUse(); got != 0 {
+		t.Errorf("InUse after oversize responses = %d; want 0", got)
+	}
+}
@@ -7,16 +7,31 @@
 package localapi
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/util/strs"
 )
 
-func (h *Handler) serveCert(w http.ResponseWriter, r *http.Request) {
-	if !h.PermitWrite && !h.PermitCert {
+// permitCert reports whether the client may fetch certs, writing an error
+// response to w if not.
+func (h *Handler) permitCert(w http.ResponseWriter) bool {
+	switch {
+	case h.PermitWrite || h.PermitCert:
+		return true
+	case h.CertDenied != nil:
+		http.Error(w, "cert access: "+h.CertDenied.Error(), http.StatusInternalServerError)
+	default:
 		http.Error(w, "cert access denied", http.StatusForbidden)
+	}
+	return false
+}
+
+func (h *Handler) serveCert(w http.ResponseWriter, r *http.Request) {
+	if !h.permitCert(w) {
 		return
 	}
 	domain, ok := strs.CutPrefix(r.URL.Path, "/localapi/v0/cert/")
@@ -37,6 +52,46 @@ func (h *Handler) serveCert(w http.ResponseWriter, r *http.Request) {
 	serveKeyPair(w, r, pair)
 }
 
+func (h *Handler) serveCertDomains(w http.ResponseWriter, r *http.Request) {
+	if !h.permitCert(w) {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	domains := h.b.CertDomains()
+	if domains == nil {
+		domains = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domains)
+}
+
+// serveCertStatus serves whether certs can be fetched at all, separately
+// from whether the caller may fetch them, so clients can tell the two
+// apart.
+func (h *Handler) serveCertStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "cert-status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	st := apitype.CertStatus{
+		Available: true,
+		Permitted: h.PermitWrite || h.PermitCert,
+	}
+	if err := h.b.CheckCertServing(); err != nil {
+		st.Available = false
+		st.Reason = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
 func serveKeyPair(w http.ResponseWriter, r *http.Request, p *ipnlocal.TLSCertKeyPair) {
 	w.Header().Set("Content-Type", "text/plain")
 	switch r.URL.Query().Get("type") {
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !ios && !android && !js
+
+package localapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestServeCertDomains(t *testing.T) {
+	h := newTestHandler(t)
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.serveCertDomains(rec, httptest.NewRequest("GET", "/localapi/v0/cert-domains", nil))
+		return rec
+	}
+
+	if rec := get(); rec.Code != http.StatusForbidden {
+		t.Errorf("read-only caller: status %d; want 403", rec.Code)
+	}
+
+	h.PermitCert = true
+	rec := get()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cert caller: status %d; body: %s", rec.Code, rec.Body.Bytes())
+	}
+	var domains []string
+	if err := json.Unmarshal(rec.Body.Bytes(), &domains); err != nil {
+		t.Fatal(err)
+	}
+	// The test backend has no netmap, so no domains.
+	if domains == nil || len(domains) != 0 {
+		t.Errorf("domains = %q; want an empty list", domains)
+	}
+}
+
+func TestServeCertStatus(t *testing.T) {
+	h := newTestHandler(t)
+	get := func() apitype.CertStatus {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.serveCertStatus(rec, httptest.NewRequest("GET", "/localapi/v0/cert-status", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d; body: %s", rec.Code, rec.Body.Bytes())
+		}
+		var st apitype.CertStatus
+		if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	// The test backend has no netmap, so certs are unavailable no matter
+	// who asks.
+	h.b.SetVarRoot(t.TempDir())
+	if st := get(); st.Available || st.Reason == "" || st.Permitted {
+		t.Errorf("read-only caller = %+v; want unavailable with reason, not permitted", st)
+	}
+	h.PermitCert = true
+	if st := get(); st.Available || !st.Permitted {
+		t.Errorf("cert caller = %+v; want unavailable, permitted", st)
+	}
+
+	h.PermitRead = false
+	rec := httptest.NewRecorder()
+	h.serveCertStatus(rec, httptest.NewRequest("GET", "/localapi/v0/cert-status", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("without read: status %d; want 403", rec.Code)
+	}
+}
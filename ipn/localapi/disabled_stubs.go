@@ -14,3 +14,11 @@
 func (h *Handler) serveCert(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "disabled on "+runtime.GOOS, http.StatusNotFound)
 }
+
+func (h *Handler) serveCertStatus(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "disabled on "+runtime.GOOS, http.StatusNotFound)
+}
+
+func (h *Handler) serveCertDomains(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "disabled on "+runtime.GOOS, http.StatusNotFound)
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+)
+
+// verboseErrorsEnv, if set, makes verbose error detail the default for
+// callers permitted to receive it, without their needing to send
+// apitype.VerboseErrorsHeader.
+var verboseErrorsEnv = envknob.RegisterBool("TS_DEBUG_LOCALAPI_VERBOSE_ERRORS")
+
+// wantVerboseErrors reports whether error responses to r should include
+// the full error chain. Error messages can reveal details about the
+// machine (paths, addresses, internal state), so it's only ever done for
+// callers with write access, and only when asked for via header or
+// envknob.
+func (h *Handler) wantVerboseErrors(r *http.Request) bool {
+	if !h.PermitWrite {
+		return false
+	}
+	return r.Header.Get(apitype.VerboseErrorsHeader) == "1" || verboseErrorsEnv()
+}
+
+// errorText returns the text of err to send in a response to r: its
+// message by default, or its message followed by each error in its Unwrap
+// chain with its type if the caller wants verbose errors.
+func (h *Handler) errorText(r *http.Request, err error) string {
+	if !h.wantVerboseErrors(r) {
+		return err.Error()
+	}
+	return errorChain(err)
+}
+
+// errorChain formats err and the errors it wraps, one per line, each
+// with its Go type.
+func errorChain(err error) string {
+	var sb strings.Builder
+	sb.WriteString(err.Error())
+	sb.WriteString("\nerror chain:")
+	for ; err != nil; err = errors.Unwrap(err) {
+		fmt.Fprintf(&sb, "\n\t%T: %v", err, err)
+	}
+	return sb.String()
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestVerboseErrors(t *testing.T) {
+	_, statErr := os.Stat("/does/not/exist")
+	err := fmt.Errorf("loading state: %w", statErr)
+
+	tests := []struct {
+		name        string
+		permitWrite bool
+		header      string
+		wantVerbose bool
+	}{
+		{"write-no-header", true, "", false},
+		{"write-header", true, "1", true},
+		{"read-only-header", false, "1", false},
+		{"write-header-other-value", true, "yes", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{PermitRead: true, PermitWrite: tt.permitWrite}
+			r := httptest.NewRequest("GET", "/localapi/v0/status", nil)
+			if tt.header != "" {
+				r.Header.Set(apitype.VerboseErrorsHeader, tt.header)
+			}
+			got := h.errorText(r, err)
+			if !strings.HasPrefix(got, err.Error()) {
+				t.Errorf("errorText = %q; want prefix %q", got, err.Error())
+			}
+			if verbose := strings.Contains(got, "*fs.PathError"); verbose != tt.wantVerbose {
+				t.Errorf("errorText = %q; verbose = %v, want %v", got, verbose, tt.wantVerbose)
+			}
+		})
+	}
+}
+
+func TestVerboseErrorsResponse(t *testing.T) {
+	h := &Handler{PermitRead: true, PermitWrite: true}
+	for _, verbose := range []bool{false, true} {
+		r := httptest.NewRequest("POST", "/localapi/v0/start", strings.NewReader("{not json"))
+		if verbose {
+			r.Header.Set(apitype.VerboseErrorsHeader, "1")
+		}
+		rec := httptest.NewRecorder()
+		h.serveStart(rec, r)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d; want 400", rec.Code)
+		}
+		if got := strings.Contains(rec.Body.String(), "*json.SyntaxError"); got != verbose {
+			t.Errorf("verbose=%v: body = %q", verbose, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// serveJSONWithETag writes v as indented JSON with an ETag derived from
+// its encoding. If r's If-None-Match header already has that ETag, it
+// replies 304 Not Modified without a body instead, so clients polling for
+// changes don't re-read and re-parse identical responses.
+//
+// The response is held in memory until written, accounted for in
+// h.ResponseBuffers. Memory is reserved before encoding; if that's at its
+// limit, it replies 503 instead, without encoding v. A response too large
+// to ever fit within the limit is written without an ETag, and later ones
+// for the same path are encoded straight to w.
+func (h *Handler) serveJSONWithETag(w http.ResponseWriter, r *http.Request, v any) {
+	rb := h.ResponseBuffers
+	reserved := rb.sizeHint(r.URL.Path)
+	if rb.tooBig(reserved) {
+		w.Header().Set("Content-Type", "application/json")
+		e := json.NewEncoder(w)
+		e.SetIndent("", "\t")
+		e.Encode(v)
+		return
+	}
+	if !rb.acquire(reserved) {
+		http.Error(w, msgTooManyBufferedResponses, http.StatusServiceUnavailable)
+		return
+	}
+	defer func() { rb.release(reserved) }()
+
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetIndent("", "\t")
+	if err := e.Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	n := int64(buf.Len())
+	rb.noteSize(r.URL.Path, n)
+	if rb.tooBig(n) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(buf.Bytes())
+		return
+	}
+	if n > reserved {
+		if !rb.acquire(n - reserved) {
+			http.Error(w, msgTooManyBufferedResponses, http.StatusServiceUnavailable)
+			return
+		}
+		reserved = n
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+const msgTooManyBufferedResponses = "too many buffered responses in progress; try again later"
+
+// etagMatches reports whether the If-None-Match header value inm matches
+// etag, using weak comparison as RFC 9110 requires for If-None-Match.
+func etagMatches(inm, etag string) bool {
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+func TestServeJSONWithETag(t *testing.T) {
+	st := &ipnstate.Status{BackendState: "Running", Version: "1.2.3"}
+	serve := func(inm string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/localapi/v0/status", nil)
+		if inm != "" {
+			r.Header.Set("If-None-Match", inm)
+		}
+		rec := httptest.NewRecorder()
+		new(Handler).serveJSONWithETag(rec, r, st)
+		return rec
+	}
+
+	rec := serve("")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	if rec.Body.Len() == 0 {
+		t.Fatal("empty body")
+	}
+
+	for _, inm := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		rec = serve(inm)
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("If-None-Match %s: status = %d; want 304", inm, rec.Code)
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: 304 has body %q", inm, rec.Body.String())
+		}
+	}
+
+	if rec = serve(`"stale"`); rec.Code != http.StatusOK {
+		t.Errorf("stale ETag: status = %d; want 200", rec.Code)
+	}
+
+	st.BackendState = "Stopped"
+	rec = serve(etag)
+	if rec.Code != http.StatusOK {
+		t.Errorf("after change: status = %d; want 200", rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got == etag {
+		t.Errorf("ETag unchanged after status changed")
+	}
+}
@@ -46,12 +46,13 @@
 	"tailscale.com/version"
 )
 
-type localAPIHandler func(*Handler, http.ResponseWriter, *http.Request)
+// HandlerFunc is the type of a LocalAPI handler.
+type HandlerFunc func(*Handler, http.ResponseWriter, *http.Request)
 
 // handler is the set of LocalAPI handlers, keyed by the part of the
 // Request.URL.Path after "/localapi/v0/". If the key ends with a trailing slash
 // then it's a prefix match.
-var handler = map[string]localAPIHandler{
+var handler = map[string]HandlerFunc{
 	// The prefix match handlers end with a slash:
 	"cert/":     (*Handler).serveCert,
 	"file-put/": (*Handler).serveFilePut,
@@ -61,6 +62,8 @@
 	// The other /localapi/v0/NAME handlers are exact matches and contain only NAME
 	// without a trailing slash:
 	"bugreport":               (*Handler).serveBugReport,
+	"cert-domains":            (*Handler).serveCertDomains,
+	"cert-status":             (*Handler).serveCertStatus,
 	"check-ip-forwarding":     (*Handler).serveCheckIPForwarding,
 	"check-prefs":             (*Handler).serveCheckPrefs,
 	"component-debug-logging": (*Handler).serveComponentDebugLogging,
@@ -78,6 +81,7 @@
 	"ping":                    (*Handler).servePing,
 	"prefs":                   (*Handler).servePrefs,
 	"pprof":                   (*Handler).servePprof,
+	"schema":                  (*Handler).serveSchema,
 	"serve-config":            (*Handler).serveServeConfig,
 	"set-dns":                 (*Handler).serveSetDNS,
 	"set-expiry-sooner":       (*Handler).serveSetExpirySooner,
@@ -90,6 +94,7 @@
 	"tka/status":              (*Handler).serveTKAStatus,
 	"tka/disable":             (*Handler).serveTKADisable,
 	"upload-client-metrics":   (*Handler).serveUploadClientMetrics,
+	"version":                 (*Handler).serveVersion,
 	"watch-ipn-bus":           (*Handler).serveWatchIPNBus,
 	"whois":                   (*Handler).serveWhoIs,
 }
@@ -133,6 +138,35 @@ type Handler struct {
 	// cert fetching access.
 	PermitCert bool
 
+	// CertDenied, if non-nil, is why the server couldn't decide whether to
+	// grant PermitCert. It's returned as an internal error to cert requests
+	// not otherwise permitted, in place of a plain access denied error.
+	CertDenied error
+
+	// ExtraHandlers are additional handlers for endpoints implemented
+	// outside this package, such as those reporting on state owned by
+	// ipnserver. They're keyed like the built-in handlers, by the part of
+	// the path after "/localapi/v0/", and are only consulted for paths
+	// without a built-in handler.
+	ExtraHandlers map[string]HandlerFunc
+
+	// ExtraRoutes describe ExtraHandlers, for the schema endpoint.
+	ExtraRoutes []apitype.LocalAPIRoute
+
+	// ExtraMetrics, if non-nil, writes additional metrics in the
+	// Prometheus text exposition format, following the clientmetrics, in
+	// responses of the metrics endpoint.
+	ExtraMetrics func(io.Writer)
+
+	// ResponseBuffers, if non-nil, accounts for and limits the memory of
+	// responses buffered before being written. It's typically shared by
+	// all of a server's Handlers.
+	ResponseBuffers *ResponseBuffers
+
+	// ClientConn, if non-nil, describes the connection the handled
+	// requests arrived on, for inclusion in status responses.
+	ClientConn *ipnstate.ClientConnStatus
+
 	b            *ipnlocal.LocalBackend
 	logf         logger.Logf
 	backendLogID string
@@ -162,7 +196,9 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	if fn, ok := handlerForPath(r.URL.Path); ok {
+	if fn, ok := handlerForPath(handler, r.URL.Path); ok {
+		fn(h, w, r)
+	} else if fn, ok := handlerForPath(h.ExtraHandlers, r.URL.Path); ok {
 		fn(h, w, r)
 	} else {
 		http.NotFound(w, r)
@@ -198,9 +234,9 @@ func validHost(h string) bool {
 	return addr.IsLoopback()
 }
 
-// handlerForPath returns the LocalAPI handler for the provided Request.URI.Path.
-// (the path doesn't include any query parameters)
-func handlerForPath(urlPath string) (h localAPIHandler, ok bool) {
+// handlerForPath returns the LocalAPI handler in m for the provided
+// Request.URI.Path. (the path doesn't include any query parameters)
+func handlerForPath(m map[string]HandlerFunc, urlPath string) (h HandlerFunc, ok bool) {
 	if urlPath == "/" {
 		return (*Handler).serveLocalAPIRoot, true
 	}
@@ -212,7 +248,7 @@ func handlerForPath(urlPath string) (h localAPIHandler, ok bool) {
 		// them as an internal implementation detail.
 		return nil, false
 	}
-	if fn, ok := handler[suff]; ok {
+	if fn, ok := m[suff]; ok {
 		// Here we match exact handler suffixes like "status" or ones with a
 		// slash already in their name, like "tka/status".
 		return fn, true
@@ -221,7 +257,7 @@ func handlerForPath(urlPath string) (h localAPIHandler, ok bool) {
 	// by the prefix including first trailing slash.
 	if i := strings.IndexByte(suff, '/'); i != -1 {
 		suff = suff[:i+1]
-		if fn, ok := handler[suff]; ok {
+		if fn, ok := m[suff]; ok {
 			return fn, true
 		}
 	}
@@ -255,23 +291,23 @@ func (h *Handler) serveIDToken(w http.ResponseWriter, r *http.Request) {
 	}
 	b, err := json.Marshal(req)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, h.errorText(r, err), 500)
 		return
 	}
 	httpReq, err := http.NewRequest("POST", "https://unused/machine/id-token", bytes.NewReader(b))
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, h.errorText(r, err), 500)
 		return
 	}
 	resp, err := h.b.DoNoiseRequest(httpReq)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, h.errorText(r, err), 500)
 		return
 	}
 	defer resp.Body.Close()
 	w.WriteHeader(resp.StatusCode)
 	if _, err := io.Copy(w, resp.Body); err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, h.errorText(r, err), 500)
 		return
 	}
 }
@@ -422,6 +458,9 @@ func (h *Handler) serveMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "text/plain")
 	clientmetric.WritePrometheusExpositionFormat(w)
+	if h.ExtraMetrics != nil {
+		h.ExtraMetrics(w)
+	}
 }
 
 func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
@@ -460,7 +499,7 @@ func (h *Handler) serveDebug(w http.ResponseWriter, r *http.Request) {
 		err = fmt.Errorf("unknown action %q", action)
 	}
 	if err != nil {
-		http.Error(w, err.Error(), 400)
+		http.Error(w, h.errorText(r, err), 400)
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain")
@@ -477,7 +516,7 @@ func (h *Handler) serveDevSetStateStore(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if err := h.b.SetDevStateStore(r.FormValue("key"), r.FormValue("value")); err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, h.errorText(r, err), 500)
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain")
@@ -577,16 +616,41 @@ func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "status access denied", http.StatusForbidden)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
+	if defBool(r.FormValue("summary"), false) {
+		// Only the fields about this node, for clients that don't
+		// want to wait for the peers of a large tailnet.
+		st := h.b.StatusWithoutPeers()
+		st.ClientConn = h.ClientConn
+		h.serveJSONWithETag(w, r, st.Summary())
+		return
+	}
+	if fields := r.FormValue("fields"); fields != "" {
+		h.serveStatusFields(w, r, fields)
+		return
+	}
 	var st *ipnstate.Status
 	if defBool(r.FormValue("peers"), true) {
 		st = h.b.Status()
 	} else {
 		st = h.b.StatusWithoutPeers()
 	}
-	e := json.NewEncoder(w)
-	e.SetIndent("", "\t")
-	e.Encode(st)
+	st.ClientConn = h.ClientConn
+	h.serveJSONWithETag(w, r, st)
+}
+
+func (h *Handler) serveVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apitype.VersionResponse{
+		Short:                       version.Short,
+		Long:                        version.Long,
+		GitCommit:                   version.GitCommit,
+		MinRecommendedClientVersion: version.MinRecommendedClientVersion,
+		BuildFeatures:               version.BuildFeatures(),
+	})
 }
 
 func (h *Handler) serveWatchIPNBus(w http.ResponseWriter, r *http.Request) {
@@ -635,7 +699,7 @@ func (h *Handler) serveLoginInteractive(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "want POST", 400)
 		return
 	}
-	h.b.StartLoginInteractive()
+	h.b.StartLoginInteractiveContext(r.Context())
 	w.WriteHeader(http.StatusNoContent)
 	return
 }
@@ -651,13 +715,13 @@ func (h *Handler) serveStart(w http.ResponseWriter, r *http.Request) {
 	}
 	var o ipn.Options
 	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, h.errorText(r, err), http.StatusBadRequest)
 		return
 	}
-	err := h.b.Start(o)
+	err := h.b.StartContext(r.Context(), o)
 	if err != nil {
 		// TODO(bradfitz): map error to a good HTTP error
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, h.errorText(r, err), http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -677,7 +741,7 @@ func (h *Handler) serveLogout(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	http.Error(w, err.Error(), 500)
+	http.Error(w, h.errorText(r, err), 500)
 }
 
 func (h *Handler) servePrefs(w http.ResponseWriter, r *http.Request) {
@@ -694,11 +758,11 @@ func (h *Handler) servePrefs(w http.ResponseWriter, r *http.Request) {
 		}
 		mp := new(ipn.MaskedPrefs)
 		if err := json.NewDecoder(r.Body).Decode(mp); err != nil {
-			http.Error(w, err.Error(), 400)
+			http.Error(w, h.errorText(r, err), 400)
 			return
 		}
 		var err error
-		prefs, err = h.b.EditPrefs(mp)
+		prefs, err = h.b.EditPrefsContext(r.Context(), mp)
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
@@ -773,7 +837,7 @@ func (h *Handler) serveFiles(w http.ResponseWriter, r *http.Request) {
 		}
 		wfs, err := h.b.AwaitWaitingFiles(ctx)
 		if err != nil && ctx.Err() == nil {
-			http.Error(w, err.Error(), 500)
+			http.Error(w, h.errorText(r, err), 500)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
@@ -787,7 +851,7 @@ func (h *Handler) serveFiles(w http.ResponseWriter, r *http.Request) {
 	}
 	if r.Method == "DELETE" {
 		if err := h.b.DeleteFile(name); err != nil {
-			http.Error(w, err.Error(), 500)
+			http.Error(w, h.errorText(r, err), 500)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
@@ -795,7 +859,7 @@ func (h *Handler) serveFiles(w http.ResponseWriter, r *http.Request) {
 	}
 	rc, size, err := h.b.OpenFile(name)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, h.errorText(r, err), 500)
 		return
 	}
 	defer rc.Close()
@@ -804,7 +868,7 @@ func (h *Handler) serveFiles(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, rc)
 }
 
-func writeErrorJSON(w http.ResponseWriter, err error) {
+func (h *Handler) writeErrorJSON(w http.ResponseWriter, r *http.Request, err error) {
 	if err == nil {
 		err = errors.New("unexpected nil error")
 	}
@@ -813,7 +877,7 @@ func writeErrorJSON(w http.ResponseWriter, err error) {
 	type E struct {
 		Error string `json:"error"`
 	}
-	json.NewEncoder(w).Encode(E{err.Error()})
+	json.NewEncoder(w).Encode(E{h.errorText(r, err)})
 }
 
 func (h *Handler) serveFileTargets(w http.ResponseWriter, r *http.Request) {
@@ -827,7 +891,7 @@ func (h *Handler) serveFileTargets(w http.ResponseWriter, r *http.Request) {
 	}
 	fts, err := h.b.FileTargets()
 	if err != nil {
-		writeErrorJSON(w, err)
+		h.writeErrorJSON(w, r, err)
 		return
 	}
 	mak.NonNilSliceForJSON(&fts)
@@ -865,7 +929,7 @@ func (h *Handler) serveFilePut(w http.ResponseWriter, r *http.Request) {
 	}
 	fts, err := h.b.FileTargets()
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, h.errorText(r, err), 500)
 		return
 	}
 
@@ -921,7 +985,7 @@ func (h *Handler) serveSetDNS(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	err := h.b.SetDNS(ctx, r.FormValue("name"), r.FormValue("value"))
 	if err != nil {
-		writeErrorJSON(w, err)
+		h.writeErrorJSON(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -961,7 +1025,7 @@ func (h *Handler) serveSetExpirySooner(w http.ResponseWriter, r *http.Request) {
 	}
 	err := h.b.SetExpirySooner(r.Context(), expiryTime)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, h.errorText(r, err), http.StatusBadRequest)
 		return
 	}
 	w.Header().Set("Content-Type", "text/plain")
@@ -991,7 +1055,7 @@ func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
 	}
 	res, err := h.b.Ping(ctx, ip, tailcfg.PingType(pingTypeStr))
 	if err != nil {
-		writeErrorJSON(w, err)
+		h.writeErrorJSON(w, r, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -1299,9 +1363,9 @@ func (h *Handler) serveProfiles(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(h.b.ListProfiles())
 		case http.MethodPut:
-			err := h.b.NewProfile()
+			err := h.b.NewProfileContext(r.Context())
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				http.Error(w, h.errorText(r, err), http.StatusInternalServerError)
 				return
 			}
 			w.WriteHeader(http.StatusCreated)
@@ -1340,16 +1404,16 @@ func (h *Handler) serveProfiles(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(profiles[profileIndex])
 	case http.MethodPost:
-		err := h.b.SwitchProfile(profileID)
+		err := h.b.SwitchProfileContext(r.Context(), profileID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, h.errorText(r, err), http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 	case http.MethodDelete:
-		err := h.b.DeleteProfile(profileID)
+		err := h.b.DeleteProfileContext(r.Context(), profileID)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			http.Error(w, h.errorText(r, err), http.StatusInternalServerError)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,141 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tstest"
+	"tailscale.com/version"
+	"tailscale.com/wgengine"
+)
+
+func TestServeVersion(t *testing.T) {
+	h := &Handler{}
+	rec := httptest.NewRecorder()
+	h.serveVersion(rec, httptest.NewRequest("GET", "/localapi/v0/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; want 200", rec.Code)
+	}
+	var got apitype.VersionResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Long != version.Long {
+		t.Errorf("Long = %q; want %q", got.Long, version.Long)
+	}
+	min := got.MinRecommendedClientVersion
+	if min == "" {
+		t.Fatal("empty MinRecommendedClientVersion")
+	}
+	// AtLeast only reports true for versions it can parse.
+	if !version.AtLeast(min, min) {
+		t.Errorf("MinRecommendedClientVersion %q isn't a parseable version", min)
+	}
+}
+
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	logf := tstest.WhileTestRunningLogger(t)
+	eng, err := wgengine.NewFakeUserspaceEngine(logf, 0)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	t.Cleanup(eng.Close)
+	lb, err := ipnlocal.NewLocalBackend(logf, "logid", new(mem.Store), "", nil, eng, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(lb.Shutdown)
+	h := NewHandler(lb, logf, "logid")
+	h.PermitRead = true
+	return h
+}
+
+func TestServeStatusSummary(t *testing.T) {
+	h := newTestHandler(t)
+	get := func(query string) map[string]any {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.serveStatus(rec, httptest.NewRequest("GET", "/localapi/v0/status"+query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status%s: code = %d; body: %s", query, rec.Code, rec.Body.Bytes())
+		}
+		var m map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	full := get("")
+	for _, k := range []string{"Version", "BackendState", "Self", "Peer", "User"} {
+		if _, ok := full[k]; !ok {
+			t.Errorf("full status missing %q", k)
+		}
+	}
+
+	summary := get("?summary=1")
+	for _, k := range []string{"Version", "BackendState", "Self", "TailscaleIPs"} {
+		if _, ok := summary[k]; !ok {
+			t.Errorf("summary missing %q", k)
+		}
+	}
+	for _, k := range []string{"Peer", "User", "CertDomains"} {
+		if _, ok := summary[k]; ok {
+			t.Errorf("summary has per-tailnet field %q", k)
+		}
+	}
+	if summary["Version"] != full["Version"] || summary["BackendState"] != full["BackendState"] {
+		t.Errorf("summary = %v; inconsistent with full status %v", summary, full)
+	}
+}
+
+func TestServeStatusFields(t *testing.T) {
+	h := newTestHandler(t)
+	get := func(query string, wantCode int) map[string]any {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.serveStatus(rec, httptest.NewRequest("GET", "/localapi/v0/status"+query, nil))
+		if rec.Code != wantCode {
+			t.Fatalf("status%s: code = %d, want %d; body: %s", query, rec.Code, wantCode, rec.Body.Bytes())
+		}
+		if wantCode != http.StatusOK {
+			return nil
+		}
+		var m map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	self := get("?fields=self", http.StatusOK)
+	if self["Version"] == nil || self["BackendState"] == nil {
+		t.Errorf("fields=self missing always-included fields: %v", self)
+	}
+	if self["Self"] == nil {
+		t.Errorf("fields=self missing Self: %v", self)
+	}
+	if self["Health"] != nil {
+		t.Errorf("fields=self has Health: %v", self)
+	}
+
+	hc := get("?fields=health,peercount", http.StatusOK)
+	if hc["Self"] != nil {
+		t.Errorf("fields=health,peercount has Self: %v", hc)
+	}
+	if hc["Version"] != self["Version"] {
+		t.Errorf("Version = %v; want %v", hc["Version"], self["Version"])
+	}
+
+	get("?fields=self,bogus", http.StatusBadRequest)
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// Permission values for apitype.LocalAPIRoute.Permission.
+const (
+	PermNone  = "none"
+	PermRead  = "read"
+	PermWrite = "write"
+	PermCert  = "cert"
+)
+
+// routeDoc is the hand-maintained description of a LocalAPI handler.
+type routeDoc struct {
+	methods []string
+	perm    string
+	desc    string
+}
+
+// routeDocs describes the handlers in the handler map, by the same key.
+// TestRouteDocs verifies that it's kept in sync.
+var routeDocs = map[string]routeDoc{
+	"cert/":                   {[]string{"GET"}, PermCert, "Fetches a TLS certificate and key for the given domain."},
+	"file-put/":               {[]string{"PUT"}, PermWrite, "Sends a file to a Taildrop target."},
+	"files/":                  {[]string{"GET", "DELETE"}, PermWrite, "Lists, fetches, or deletes received Taildrop files."},
+	"profiles/":               {[]string{"GET", "PUT", "POST", "DELETE"}, PermWrite, "Lists, creates, switches, or deletes login profiles."},
+	"bugreport":               {[]string{"POST"}, PermRead, "Logs a bug report marker and returns it."},
+	"cert-domains":            {[]string{"GET"}, PermCert, "Lists the domains that TLS certificates can currently be fetched for."},
+	"cert-status":             {[]string{"GET"}, PermRead, "Reports whether TLS certificates can be fetched at all, and whether the caller may fetch them."},
+	"check-ip-forwarding":     {[]string{"GET"}, PermRead, "Reports whether IP forwarding is set up for subnet routing."},
+	"check-prefs":             {[]string{"POST"}, PermWrite, "Checks whether the given prefs are valid."},
+	"component-debug-logging": {[]string{"POST"}, PermWrite, "Enables debug logging for a component for a time."},
+	"debug":                   {[]string{"POST"}, PermWrite, "Runs a debug action, such as rebind or restun."},
+	"debug-derp-region":       {[]string{"POST"}, PermWrite, "Runs connectivity diagnostics against a DERP region."},
+	"derpmap":                 {[]string{"GET"}, PermNone, "Returns the current DERP map."},
+	"dev-set-state-store":     {[]string{"POST"}, PermWrite, "Sets a key in the state store (development only)."},
+	"dial":                    {[]string{"POST"}, PermNone, "Dials a host and port via tailscaled, upgrading the connection."},
+	"file-targets":            {[]string{"GET"}, PermRead, "Lists the nodes that files can be sent to."},
+	"goroutines":              {[]string{"GET"}, PermWrite, "Returns a dump of all goroutines."},
+	"id-token":                {[]string{"GET"}, PermWrite, "Fetches an OIDC ID token for the given audience."},
+	"login-interactive":       {[]string{"POST"}, PermWrite, "Starts an interactive login."},
+	"logout":                  {[]string{"POST"}, PermWrite, "Logs out the current node."},
+	"metrics":                 {[]string{"GET"}, PermWrite, "Returns client metrics in Prometheus exposition format."},
+	"ping":                    {[]string{"POST"}, PermNone, "Pings a Tailscale IP."},
+	"prefs":                   {[]string{"GET", "PATCH"}, PermRead, "Returns or edits (with write permission) the prefs."},
+	"pprof":                   {[]string{"GET"}, PermWrite, "Returns a pprof profile."},
+	"serve-config":            {[]string{"GET", "POST"}, PermWrite, "Returns or sets the serve config."},
+	"set-dns":                 {[]string{"POST"}, PermWrite, "Sets a DNS TXT record for ACME challenges."},
+	"set-expiry-sooner":       {[]string{"POST"}, PermNone, "Moves the node key expiry earlier."},
+	"start":                   {[]string{"POST"}, PermWrite, "Starts the backend with the given options."},
+	"status":                  {[]string{"GET"}, PermRead, "Returns the node's status, or with ?summary=1 just the fields about this node, or with ?fields= just the listed field groups (self, health, tailnet, exitnode, peers, peercount)."},
+	"tka/init":                {[]string{"POST"}, PermWrite, "Initializes tailnet lock."},
+	"tka/log":                 {[]string{"GET"}, PermNone, "Returns the tailnet lock log."},
+	"tka/modify":              {[]string{"POST"}, PermWrite, "Modifies tailnet lock keys."},
+	"tka/sign":                {[]string{"POST"}, PermRead, "Signs a node key with tailnet lock."},
+	"tka/status":              {[]string{"GET"}, PermRead, "Returns the tailnet lock status."},
+	"tka/disable":             {[]string{"POST"}, PermWrite, "Disables tailnet lock."},
+	"upload-client-metrics":   {[]string{"POST"}, PermNone, "Records client-side metrics."},
+	"version":                 {[]string{"GET"}, PermNone, "Returns the daemon's version and the minimum recommended client version."},
+	"watch-ipn-bus":           {[]string{"GET"}, PermWrite, "Streams IPN bus notifications."},
+	"whois":                   {[]string{"GET"}, PermRead, "Returns the node and user owning a Tailscale IP:port."},
+	"schema":                  {[]string{"GET"}, PermNone, "Returns this description of the LocalAPI endpoints."},
+}
+
+// Routes returns descriptions of the LocalAPI endpoints served by h,
+// including h.ExtraRoutes, sorted by path.
+func (h *Handler) Routes() []apitype.LocalAPIRoute {
+	ret := make([]apitype.LocalAPIRoute, 0, len(routeDocs)+len(h.ExtraRoutes))
+	for name, d := range routeDocs {
+		ret = append(ret, apitype.LocalAPIRoute{
+			Path:        "/localapi/v0/" + name,
+			Methods:     d.methods,
+			Permission:  d.perm,
+			Description: d.desc,
+		})
+	}
+	ret = append(ret, h.ExtraRoutes...)
+	slices.SortFunc(ret, func(a, b apitype.LocalAPIRoute) bool { return a.Path < b.Path })
+	return ret
+}
+
+// serveSchema returns a JSON description of the available LocalAPI
+// endpoints, for client generators and documentation.
+func (h *Handler) serveSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.Routes())
+}
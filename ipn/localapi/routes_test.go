@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestRouteDocs(t *testing.T) {
+	for name := range handler {
+		if _, ok := routeDocs[name]; !ok {
+			t.Errorf("handler %q has no routeDocs entry", name)
+		}
+	}
+	for name, d := range routeDocs {
+		if _, ok := handler[name]; !ok {
+			t.Errorf("routeDocs entry %q has no handler", name)
+		}
+		switch d.perm {
+		case PermNone, PermRead, PermWrite, PermCert:
+		default:
+			t.Errorf("routeDocs[%q] has unknown permission %q", name, d.perm)
+		}
+		if len(d.methods) == 0 || d.desc == "" {
+			t.Errorf("routeDocs[%q] is incomplete", name)
+		}
+	}
+}
+
+func TestServeSchema(t *testing.T) {
+	h := &Handler{
+		ExtraRoutes: []apitype.LocalAPIRoute{{
+			Path:        "/localapi/v0/extra",
+			Methods:     []string{"GET"},
+			Permission:  PermRead,
+			Description: "An extra route.",
+		}},
+	}
+	rec := httptest.NewRecorder()
+	h.serveSchema(rec, httptest.NewRequest("GET", "/localapi/v0/schema", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	var routes []apitype.LocalAPIRoute
+	if err := json.Unmarshal(rec.Body.Bytes(), &routes); err != nil {
+		t.Fatal(err)
+	}
+	find := func(path string) apitype.LocalAPIRoute {
+		i := slices.IndexFunc(routes, func(r apitype.LocalAPIRoute) bool { return r.Path == path })
+		if i == -1 {
+			t.Fatalf("schema lacks %s", path)
+		}
+		return routes[i]
+	}
+	if st := find("/localapi/v0/status"); !slices.Equal(st.Methods, []string{"GET"}) || st.Permission != PermRead {
+		t.Errorf("status route = %+v", st)
+	}
+	if lo := find("/localapi/v0/logout"); !slices.Equal(lo.Methods, []string{"POST"}) || lo.Permission != PermWrite {
+		t.Errorf("logout route = %+v", lo)
+	}
+	find("/localapi/v0/extra")
+}
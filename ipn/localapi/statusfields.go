@@ -0,0 +1,79 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package localapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// statusFieldGroups are the groups of Status fields that clients may
+// select with the status endpoint's fields parameter, each with a func to
+// copy the group's fields from src to dst. Version, BackendState and
+// ClientConn are always included.
+var statusFieldGroups = map[string]func(dst, src *ipnstate.Status){
+	"self": func(dst, src *ipnstate.Status) {
+		dst.Self = src.Self
+		dst.TailscaleIPs = src.TailscaleIPs
+		dst.AuthURL = src.AuthURL
+	},
+	"health": func(dst, src *ipnstate.Status) {
+		dst.Health = src.Health
+	},
+	"tailnet": func(dst, src *ipnstate.Status) {
+		dst.CurrentTailnet = src.CurrentTailnet
+		dst.MagicDNSSuffix = src.MagicDNSSuffix
+		dst.CertDomains = src.CertDomains
+	},
+	"exitnode": func(dst, src *ipnstate.Status) {
+		dst.ExitNodeStatus = src.ExitNodeStatus
+	},
+	"peers": func(dst, src *ipnstate.Status) {
+		dst.Peer = src.Peer
+		dst.User = src.User
+	},
+	"peercount": func(dst, src *ipnstate.Status) {
+		dst.PeerCount = src.PeerCount
+	},
+}
+
+// serveStatusFields serves a status with only the comma-separated field
+// groups in fields (see statusFieldGroups). Peer details, the expensive
+// part of a status on large tailnets, are only computed if requested.
+func (h *Handler) serveStatusFields(w http.ResponseWriter, r *http.Request, fields string) {
+	groups := strings.Split(fields, ",")
+	wantPeers := false
+	for _, g := range groups {
+		if _, ok := statusFieldGroups[g]; !ok {
+			http.Error(w, fmt.Sprintf("unknown status field group %q", g), http.StatusBadRequest)
+			return
+		}
+		if g == "peers" {
+			wantPeers = true
+		}
+	}
+	var full *ipnstate.Status
+	if wantPeers {
+		full = h.b.Status()
+		full.PeerCount = len(full.Peer)
+	} else {
+		full = h.b.StatusWithoutPeers()
+		if nm := h.b.NetMap(); nm != nil {
+			full.PeerCount = len(nm.Peers)
+		}
+	}
+	st := &ipnstate.Status{
+		Version:      full.Version,
+		BackendState: full.BackendState,
+		ClientConn:   h.ClientConn,
+	}
+	for _, g := range groups {
+		statusFieldGroups[g](st, full)
+	}
+	h.serveJSONWithETag(w, r, st)
+}
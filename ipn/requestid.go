@@ -0,0 +1,26 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ipn
+
+import "context"
+
+// RequestID identifies a LocalAPI request, so that backend log lines about
+// the operations it triggers can be correlated with it.
+type RequestID string
+
+// requestIDContextKey is the context.Value key for a RequestID.
+type requestIDContextKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id RequestID) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, or the empty
+// string if none.
+func RequestIDFromContext(ctx context.Context) RequestID {
+	id, _ := ctx.Value(requestIDContextKey{}).(RequestID)
+	return id
+}
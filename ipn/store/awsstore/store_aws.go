@@ -21,8 +21,13 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/types/logger"
+	"tailscale.com/version"
 )
 
+func init() {
+	version.RegisterBuildFeature("aws-store")
+}
+
 const (
 	parameterNameRxStr = `^parameter(/.*)`
 )
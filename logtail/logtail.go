@@ -201,6 +201,60 @@ type Logger struct {
 
 	shutdownStart chan struct{} // closed when shutdown begins
 	shutdownDone  chan struct{} // closed when shutdown complete
+
+	statusMu     sync.Mutex   // guards uploadStatus
+	uploadStatus UploadStatus // see UploadStatus
+}
+
+// UploadStatus describes the Logger's uploads to the log server, as
+// returned by Logger.UploadStatus.
+type UploadStatus struct {
+	// Uploading is whether an upload is in progress.
+	Uploading bool
+
+	// LastSuccess is when an upload last succeeded, or the zero value if
+	// none has.
+	LastSuccess time.Time
+
+	// LastFailure is when an upload last failed, or the zero value if
+	// none has, and LastError why.
+	LastFailure time.Time
+	LastError   string
+
+	// BytesUploaded is the number of bytes, as sent (so after any
+	// compression), successfully uploaded.
+	BytesUploaded int64
+}
+
+// UploadStatus returns the status of l's uploads.
+func (l *Logger) UploadStatus() UploadStatus {
+	l.statusMu.Lock()
+	defer l.statusMu.Unlock()
+	return l.uploadStatus
+}
+
+// noteUploadStart records that an upload is starting, for UploadStatus.
+func (l *Logger) noteUploadStart() {
+	l.statusMu.Lock()
+	defer l.statusMu.Unlock()
+	l.uploadStatus.Uploading = true
+}
+
+// noteUploadDone records the result of an upload of n bytes, for
+// UploadStatus.
+func (l *Logger) noteUploadDone(n int, uploaded bool, err error) {
+	l.statusMu.Lock()
+	defer l.statusMu.Unlock()
+	st := &l.uploadStatus
+	st.Uploading = false
+	if uploaded {
+		st.LastSuccess = l.timeNow()
+		st.BytesUploaded += int64(n)
+	}
+	if err != nil {
+		st.LastFailure = l.timeNow()
+		st.LastError = err.Error()
+	}
 }
 
 // SetVerbosityLevel controls the verbosity level that should be
@@ -361,7 +415,9 @@ func (l *Logger) uploading(ctx context.Context) {
 				return
 			default:
 			}
+			l.noteUploadStart()
 			uploaded, err := l.upload(ctx, body, origlen)
+			l.noteUploadDone(len(body), uploaded, err)
 			if err != nil {
 				if !l.internetUp() {
 					fmt.Fprintf(l.stderr, "logtail: internet down; waiting\n")
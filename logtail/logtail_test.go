@@ -95,6 +95,29 @@ func TestDrainPendingMessages(t *testing.T) {
 	}
 }
 
+func TestUploadStatus(t *testing.T) {
+	_, l := NewLogtailTestHarness(t)
+	defer l.Shutdown(context.Background())
+
+	// The harness has received the initial upload, but its result may not
+	// have been recorded yet.
+	deadline := time.Now().Add(5 * time.Second)
+	st := l.UploadStatus()
+	for st.LastSuccess.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatalf("no successful upload recorded: %+v", st)
+		}
+		time.Sleep(5 * time.Millisecond)
+		st = l.UploadStatus()
+	}
+	if st.BytesUploaded <= 0 {
+		t.Errorf("BytesUploaded = %d; want positive", st.BytesUploaded)
+	}
+	if !st.LastFailure.IsZero() || st.LastError != "" {
+		t.Errorf("failure recorded: %v, %q", st.LastFailure, st.LastError)
+	}
+}
+
 func TestEncodeAndUploadMessages(t *testing.T) {
 	ts, l := NewLogtailTestHarness(t)
 
@@ -0,0 +1,104 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package safesocket
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// MaxBufferSize is the largest socket buffer size accepted by
+// ListenWithBufferSizes and ConnectionStrategy.UseBufferSizes.
+//
+// The OS may cap sizes further: Linux limits them to the
+// net.core.rmem_max and net.core.wmem_max sysctls (for unprivileged
+// processes), macOS to the kern.ipc.maxsockbuf sysctl, and on Windows
+// they only affect TCP, which is what safesocket uses there. A size above
+// the OS cap is silently clamped rather than rejected.
+const MaxBufferSize = 16 << 20
+
+// bufSizes are requested socket buffer sizes. A zero size leaves the OS
+// default.
+type bufSizes struct {
+	recv, send int
+}
+
+func (b bufSizes) isZero() bool { return b == bufSizes{} }
+
+func (b bufSizes) check() error {
+	for _, n := range []int{b.recv, b.send} {
+		if n < 0 || n > MaxBufferSize {
+			return fmt.Errorf("safesocket: invalid socket buffer size %d; want 0 to %d", n, MaxBufferSize)
+		}
+	}
+	return nil
+}
+
+// control returns a net.ListenConfig or net.Dialer Control func that sets
+// the socket buffer sizes of b, followed by calling next if non-nil.
+func (b bufSizes) control(next func(network, address string, c syscall.RawConn) error) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if next != nil {
+			if err := next(network, address, c); err != nil {
+				return err
+			}
+		}
+		if b.isZero() {
+			return nil
+		}
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = setBufferSizes(fd, b)
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// ListenWithBufferSizes is like Listen but also sets the kernel receive
+// and send buffer sizes (SO_RCVBUF and SO_SNDBUF) of the listening socket,
+// which sockets accepted from it inherit on most platforms. A zero size
+// leaves the OS default. See MaxBufferSize for limits.
+//
+// Larger buffers help clients streaming large LocalAPI responses.
+func ListenWithBufferSizes(path string, port uint16, recv, send int) (_ net.Listener, gotPort uint16, _ error) {
+	b := bufSizes{recv, send}
+	if err := b.check(); err != nil {
+		return nil, 0, err
+	}
+	return listen(path, port, b)
+}
+
+// UseBufferSizes modifies s to set the kernel receive and send buffer
+// sizes (SO_RCVBUF and SO_SNDBUF) of the connection's socket. A zero size
+// leaves the OS default. Connect fails if either size is negative or
+// above MaxBufferSize.
+func (s *ConnectionStrategy) UseBufferSizes(recv, send int) {
+	s.bufs = bufSizes{recv, send}
+}
+
+// BufferSizes returns the kernel receive and send buffer sizes
+// (SO_RCVBUF and SO_SNDBUF) of the socket underlying c, which is typically
+// a listener returned by Listen or a connection accepted from one.
+//
+// The values are as reported by the OS, which may differ from what was
+// requested; Linux, for instance, reports double the requested size to
+// account for bookkeeping overhead.
+func BufferSizes(c syscall.Conn) (recv, send int, err error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		recv, send, sockErr = getBufferSizes(fd)
+	})
+	if err == nil {
+		err = sockErr
+	}
+	return recv, send, err
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package safesocket
+
+import "errors"
+
+func getBufferSizes(fd uintptr) (recv, send int, err error) {
+	return 0, 0, errors.New("socket buffer sizes not supported on js")
+}
+
+func setBufferSizes(fd uintptr, b bufSizes) error {
+	return errors.New("socket buffer sizes not supported on js")
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package safesocket
+
+import (
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+)
+
+func TestBufferSizes(t *testing.T) {
+	ln, _, err := Listen(filepath.Join(t.TempDir(), "test"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		t.Fatalf("listener %T doesn't implement syscall.Conn", ln)
+	}
+	recv, send, err := BufferSizes(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recv <= 0 || send <= 0 {
+		t.Errorf("BufferSizes = %d, %d; want positive", recv, send)
+	}
+}
+
+func TestListenWithBufferSizes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("test relies on Linux reporting at least the requested sizes")
+	}
+	const recv, send = 64 << 10, 96 << 10
+	path := filepath.Join(t.TempDir(), "test")
+	ln, _, err := ListenWithBufferSizes(path, 0, recv, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	check := func(what string, c syscall.Conn) {
+		t.Helper()
+		gotRecv, gotSend, err := BufferSizes(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Linux doubles the requested sizes for bookkeeping.
+		if gotRecv < recv || gotSend < send {
+			t.Errorf("%s BufferSizes = %d, %d; want at least %d, %d", what, gotRecv, gotSend, recv, send)
+		}
+	}
+	check("listener", ln.(syscall.Conn))
+
+	s := ExactPath(path)
+	s.UseBufferSizes(recv, send)
+	c, err := Connect(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	check("client conn", c.(syscall.Conn))
+}
+
+func TestBufferSizesValidation(t *testing.T) {
+	for _, n := range []int{-1, MaxBufferSize + 1} {
+		if _, _, err := ListenWithBufferSizes(filepath.Join(t.TempDir(), "test"), 0, n, 0); err == nil {
+			t.Errorf("ListenWithBufferSizes with recv size %d succeeded; want error", n)
+		}
+		s := ExactPath(filepath.Join(t.TempDir(), "test"))
+		s.UseBufferSizes(0, n)
+		if _, err := Connect(s); err == nil {
+			t.Errorf("Connect with send size %d succeeded; want error", n)
+		}
+	}
+}
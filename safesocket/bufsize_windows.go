@@ -0,0 +1,33 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package safesocket
+
+import "syscall"
+
+func getBufferSizes(fd uintptr) (recv, send int, err error) {
+	recv, err = syscall.GetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	if err != nil {
+		return 0, 0, err
+	}
+	send, err = syscall.GetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	if err != nil {
+		return 0, 0, err
+	}
+	return recv, send, nil
+}
+
+func setBufferSizes(fd uintptr, b bufSizes) error {
+	if b.recv > 0 {
+		if err := syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, b.recv); err != nil {
+			return err
+		}
+	}
+	if b.send > 0 {
+		if err := syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, b.send); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -12,7 +12,8 @@
 )
 
 func connect(s *ConnectionStrategy) (net.Conn, error) {
-	pipe, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", s.port))
+	d := net.Dialer{Control: s.bufs.control(nil)}
+	pipe, err := d.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", s.port))
 	if err != nil {
 		return nil, err
 	}
@@ -33,9 +34,9 @@ func setFlags(network, address string, c syscall.RawConn) error {
 //	just always using a TCP session on a fixed port on localhost. As a
 //	result, on Windows we ignore the vendor and name strings.
 //	NOTE(bradfitz): Jason did a new pipe package: https://go-review.googlesource.com/c/sys/+/299009
-func listen(path string, port uint16) (_ net.Listener, gotPort uint16, _ error) {
+func listen(path string, port uint16, bufs bufSizes) (_ net.Listener, gotPort uint16, _ error) {
 	lc := net.ListenConfig{
-		Control: setFlags,
+		Control: bufs.control(setFlags),
 	}
 	pipe, err := lc.Listen(context.Background(), "tcp", fmt.Sprintf("127.0.0.1:%d", port))
 	if err != nil {
@@ -64,6 +64,7 @@ type ConnectionStrategy struct {
 	path     string
 	port     uint16
 	fallback bool
+	bufs     bufSizes // see UseBufferSizes
 	// Longer term, a ConnectionStrategy should be an ordered list of things to attempt,
 	// with just the information required to connection for each.
 	//
@@ -114,6 +115,9 @@ func ExactPath(path string) *ConnectionStrategy {
 
 // Connect connects to tailscaled using s
 func Connect(s *ConnectionStrategy) (net.Conn, error) {
+	if err := s.bufs.check(); err != nil {
+		return nil, err
+	}
 	for {
 		c, err := connect(s)
 		if err != nil && tailscaledStillStarting() {
@@ -128,7 +132,7 @@ func Connect(s *ConnectionStrategy) (net.Conn, error) {
 // the localhost port (on Windows).
 // If port is 0, the returned gotPort says which port was selected on Windows.
 func Listen(path string, port uint16) (_ net.Listener, gotPort uint16, _ error) {
-	return listen(path, port)
+	return listen(path, port, bufSizes{})
 }
 
 var (
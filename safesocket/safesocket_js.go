@@ -12,7 +12,7 @@
 
 const memName = "Tailscale-IPN"
 
-func listen(path string, port uint16) (_ net.Listener, gotPort uint16, _ error) {
+func listen(path string, port uint16, _ bufSizes) (_ net.Listener, gotPort uint16, _ error) {
 	ln, err := memconn.Listen("memu", memName)
 	return ln, 1, err
 }
@@ -7,6 +7,7 @@
 package safesocket
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -27,7 +28,8 @@ func connect(s *ConnectionStrategy) (net.Conn, error) {
 	if runtime.GOOS == "darwin" && s.fallback && s.path == "" && s.port == 0 {
 		return connectMacOSAppSandbox()
 	}
-	pipe, err := net.Dial("unix", s.path)
+	d := net.Dialer{Control: s.bufs.control(nil)}
+	pipe, err := d.Dial("unix", s.path)
 	if err != nil {
 		if runtime.GOOS == "darwin" && s.fallback {
 			extConn, extErr := connectMacOSAppSandbox()
@@ -42,7 +44,7 @@ func connect(s *ConnectionStrategy) (net.Conn, error) {
 }
 
 // TODO(apenwarr): handle magic cookie auth
-func listen(path string, port uint16) (ln net.Listener, _ uint16, err error) {
+func listen(path string, port uint16, bufs bufSizes) (ln net.Listener, _ uint16, err error) {
 	// Unix sockets hang around in the filesystem even after nobody
 	// is listening on them. (Which is really unfortunate but long-
 	// entrenched semantics.) Try connecting first; if it works, then
@@ -83,7 +85,8 @@ func listen(path string, port uint16) (ln net.Listener, _ uint16, err error) {
 			}
 		}
 	}
-	pipe, err := net.Listen("unix", path)
+	lc := net.ListenConfig{Control: bufs.control(nil)}
+	pipe, err := lc.Listen(context.Background(), "unix", path)
 	if err != nil {
 		return nil, 0, err
 	}
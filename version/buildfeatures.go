@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package version
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	buildFeaturesMu sync.Mutex
+	buildFeatures   = map[string]bool{}
+)
+
+// RegisterBuildFeature records that name, an optional subsystem gated by
+// a build tag, is compiled into this binary. It's meant to be called from
+// init funcs in the build-tag-guarded files that implement the subsystem,
+// so that support can tell whether a feature is available before trying
+// to enable it.
+func RegisterBuildFeature(name string) {
+	buildFeaturesMu.Lock()
+	defer buildFeaturesMu.Unlock()
+	buildFeatures[name] = true
+}
+
+// BuildFeatures returns the names of the optional subsystems compiled into
+// this binary, as registered with RegisterBuildFeature, sorted.
+func BuildFeatures() []string {
+	buildFeaturesMu.Lock()
+	defer buildFeaturesMu.Unlock()
+	ret := make([]string, 0, len(buildFeatures))
+	for name := range buildFeatures {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
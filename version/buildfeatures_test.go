@@ -0,0 +1,28 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package version
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestBuildFeatures(t *testing.T) {
+	RegisterBuildFeature("test-feature-b")
+	RegisterBuildFeature("test-feature-a")
+	RegisterBuildFeature("test-feature-a")
+	got := BuildFeatures()
+	ia, ib := slices.Index(got, "test-feature-a"), slices.Index(got, "test-feature-b")
+	if ia < 0 || ib < 0 {
+		t.Fatalf("BuildFeatures() = %q; missing registered features", got)
+	}
+	if ia > ib || !slices.IsSorted(got) {
+		t.Errorf("BuildFeatures() = %q; not sorted", got)
+	}
+	if n := len(slices.Compact(slices.Clone(got))); n != len(got) {
+		t.Errorf("BuildFeatures() = %q; has duplicates", got)
+	}
+}
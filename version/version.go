@@ -67,6 +67,12 @@ func init() {
 	Long = Short + "-t" + commitHashAbbrev + dirty
 }
 
+// MinRecommendedClientVersion is the oldest client (CLI or GUI) version
+// that this version of tailscaled recommends talking to it. Older clients
+// still work as far as the LocalAPI allows, but may lack support for newer
+// daemon features, so clients can use it to suggest upgrading.
+const MinRecommendedClientVersion = "1.32.0"
+
 // GitCommit, if non-empty, is the git commit of the
 // github.com/tailscale/tailscale repository at which Tailscale was
 // built. Its format is the one returned by `git describe --always